@@ -0,0 +1,494 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/privacy"
+	"github.com/imdawon/personalos/storage"
+)
+
+const (
+	// awEventStep is the spacing of the raw events written to cover a span
+	// reported by a watcher. It must stay below the processor's session gap.
+	awEventStep = 15 * time.Second
+	// awDefaultPulsetime is used when a heartbeat does not set pulsetime.
+	awDefaultPulsetime = 5 * time.Second
+)
+
+// awEvent is an event in the ActivityWatch wire format.
+type awEvent struct {
+	ID        int64                  `json:"id,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Duration  float64                `json:"duration"` // seconds
+	Data      map[string]interface{} `json:"data"`
+}
+
+func (e awEvent) end() time.Time {
+	return e.Timestamp.Add(time.Duration(e.Duration * float64(time.Second)))
+}
+
+// awBucket is a bucket in the ActivityWatch wire format.
+type awBucket struct {
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name"`
+	Type     string                 `json:"type"`
+	Client   string                 `json:"client"`
+	Hostname string                 `json:"hostname"`
+	Created  time.Time              `json:"created"`
+	Data     map[string]interface{} `json:"data"`
+}
+
+func toAWBucket(b models.Bucket) awBucket {
+	return awBucket{ID: b.ID, Name: b.ID, Type: b.Type, Client: b.Client, Hostname: b.Hostname,
+		Created: b.Created, Data: map[string]interface{}{}}
+}
+
+// awSpan is the activity a watcher is currently reporting.
+type awSpan struct {
+	app, title string
+	url, cwd   string
+	afk        bool
+	start      time.Time
+	written    time.Time // last raw event written for the span
+	seen       time.Time // end of the last heartbeat
+}
+
+// activityWatch implements the subset of the ActivityWatch server API that
+// watchers use. Heartbeats are turned into raw events with the bucket ID as
+// their source, and AFK reports into away periods.
+type activityWatch struct {
+	server *Server
+	store  storage.Store
+	filter *privacy.Filter
+
+	mu    sync.Mutex
+	spans map[string]*awSpan // by bucket ID
+}
+
+// WithActivityWatchAPI exposes an ActivityWatch-compatible API under /api/0/,
+// so existing watchers (afk, web, editors) can report to PersonalOS. Their
+// events pass through filter like the built-in tracker's.
+func WithActivityWatchAPI(filter *privacy.Filter) Option {
+	return func(s *Server) {
+		s.aw = &activityWatch{
+			server: s,
+			store:  s.store,
+			filter: filter,
+			spans:  make(map[string]*awSpan),
+		}
+	}
+}
+
+func (a *activityWatch) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/0/info", a.handleInfo)
+	mux.HandleFunc("GET /api/0/buckets", a.handleGetBuckets)
+	mux.HandleFunc("GET /api/0/buckets/", a.handleGetBuckets)
+	mux.HandleFunc("GET /api/0/buckets/{id}", a.handleGetBucket)
+	mux.HandleFunc("POST /api/0/buckets/{id}", a.handleCreateBucket)
+	mux.HandleFunc("GET /api/0/buckets/{id}/events", a.handleGetEvents)
+	mux.HandleFunc("POST /api/0/buckets/{id}/events", a.handleInsertEvents)
+	mux.HandleFunc("POST /api/0/buckets/{id}/heartbeat", a.handleHeartbeat)
+	mux.HandleFunc("POST /api/0/query", a.handleQuery)
+	mux.HandleFunc("POST /api/0/query/", a.handleQuery)
+}
+
+func (a *activityWatch) handleInfo(w http.ResponseWriter, r *http.Request) {
+	hostname, _ := os.Hostname()
+	a.server.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"hostname": hostname,
+		"version":  "personalos",
+		"testing":  false,
+	})
+}
+
+func (a *activityWatch) handleGetBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := a.store.GetBuckets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := make(map[string]awBucket, len(buckets))
+	for _, b := range buckets {
+		resp[b.ID] = toAWBucket(b)
+	}
+	a.server.respondJSON(w, http.StatusOK, resp)
+}
+
+func (a *activityWatch) handleGetBucket(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := a.bucket(w, r)
+	if !ok {
+		return
+	}
+	a.server.respondJSON(w, http.StatusOK, toAWBucket(bucket))
+}
+
+func (a *activityWatch) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
+	var req awBucket
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Type == "" {
+		http.Error(w, "bucket type is required", http.StatusBadRequest)
+		return
+	}
+
+	created, err := a.store.CreateBucket(models.Bucket{
+		ID:       r.PathValue("id"),
+		Type:     req.Type,
+		Client:   req.Client,
+		Hostname: req.Hostname,
+		Created:  time.Now(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		// ActivityWatch answers 304 for a bucket that already exists.
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	a.server.respondJSON(w, http.StatusOK, map[string]bool{"created": true})
+}
+
+func (a *activityWatch) handleGetEvents(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := a.bucket(w, r)
+	if !ok {
+		return
+	}
+	start, end, err := awRange(r.URL.Query().Get("start"), r.URL.Query().Get("end"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := a.bucketEvents(bucket, start, end)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// ActivityWatch lists the newest events first.
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.After(events[j].Timestamp) })
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit >= 0 && limit < len(events) {
+		events = events[:limit]
+	}
+	a.server.respondJSON(w, http.StatusOK, events)
+}
+
+// handleInsertEvents stores complete events, e.g. from a watcher replaying
+// its offline queue. The body is one event or a list of events.
+func (a *activityWatch) handleInsertEvents(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := a.bucket(w, r)
+	if !ok {
+		return
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var events []awEvent
+	if err := json.Unmarshal(raw, &events); err != nil {
+		var single awEvent
+		if err := json.Unmarshal(raw, &single); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events = []awEvent{single}
+	}
+
+	if !a.paused() {
+		for _, e := range events {
+			if err := a.insertEvent(bucket, e); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	a.server.respondJSON(w, http.StatusOK, events)
+}
+
+func (a *activityWatch) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := a.bucket(w, r)
+	if !ok {
+		return
+	}
+
+	var event awEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pulsetime := awDefaultPulsetime
+	if value := r.URL.Query().Get("pulsetime"); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(w, "invalid pulsetime", http.StatusBadRequest)
+			return
+		}
+		pulsetime = time.Duration(seconds * float64(time.Second))
+	}
+
+	// Heartbeats sent while paused are accepted and dropped, so watchers do
+	// not queue them up for later.
+	if !a.paused() {
+		if err := a.heartbeat(bucket, event, pulsetime); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	a.server.respondJSON(w, http.StatusOK, event)
+}
+
+// paused reports whether tracking is paused, in which case watcher reports
+// are dropped like the built-in tracker's.
+func (a *activityWatch) paused() bool {
+	return a.server.pauses != nil && a.server.pauses.Paused(time.Now())
+}
+
+// bucket loads the bucket named in the path, writing a 404 if it is unknown.
+func (a *activityWatch) bucket(w http.ResponseWriter, r *http.Request) (models.Bucket, bool) {
+	bucket, err := a.store.GetBucket(r.PathValue("id"))
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		http.Error(w, fmt.Sprintf("There's no bucket named %s", r.PathValue("id")), http.StatusNotFound)
+		return bucket, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return bucket, false
+	}
+	return bucket, true
+}
+
+// heartbeat merges a heartbeat into the span its bucket is reporting. Like
+// ActivityWatch, a heartbeat with the same data arriving within pulsetime of
+// the previous one extends it; anything else starts a new span.
+func (a *activityWatch) heartbeat(bucket models.Bucket, e awEvent, pulsetime time.Duration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	next, ok := a.spanOf(bucket, e)
+	prev := a.spans[bucket.ID]
+	inPulse := prev != nil && !e.Timestamp.After(prev.seen.Add(pulsetime))
+	if inPulse && ok && prev.app == next.app && prev.title == next.title &&
+		prev.url == next.url && prev.cwd == next.cwd {
+		if e.end().After(prev.seen) {
+			prev.seen = e.end()
+		}
+		if isAFKBucket(bucket) {
+			return nil
+		}
+		// Cover the span with raw events, one per step.
+		var events []models.RawEvent
+		for !prev.seen.Before(prev.written.Add(awEventStep)) {
+			prev.written = prev.written.Add(awEventStep)
+			events = append(events, a.rawEvent(bucket, prev, prev.written))
+		}
+		return a.store.InsertRawEvents(events)
+	}
+
+	if prev != nil {
+		end := prev.seen
+		if inPulse && e.Timestamp.After(end) {
+			end = e.Timestamp
+		}
+		delete(a.spans, bucket.ID)
+		if err := a.closeSpan(bucket, prev, end); err != nil {
+			return err
+		}
+	}
+	if !ok {
+		return nil
+	}
+	a.spans[bucket.ID] = next
+	if isAFKBucket(bucket) {
+		return nil
+	}
+	return a.store.InsertRawEvent(a.rawEvent(bucket, next, next.start))
+}
+
+// closeSpan finishes a span at end: the last sighting of an activity is
+// written so its session ends on time, and an AFK span becomes an away period.
+func (a *activityWatch) closeSpan(bucket models.Bucket, span *awSpan, end time.Time) error {
+	if isAFKBucket(bucket) {
+		if !span.afk || !end.After(span.start) {
+			return nil
+		}
+		return a.store.RecordAwayPeriod(models.AwayPeriod{StartTime: span.start, EndTime: end, Reason: models.AwayAFK})
+	}
+	if end.After(span.written) {
+		return a.store.InsertRawEvent(a.rawEvent(bucket, span, end))
+	}
+	return nil
+}
+
+// insertEvent stores a complete event as raw events spanning its duration,
+// or as an away period for AFK buckets.
+func (a *activityWatch) insertEvent(bucket models.Bucket, e awEvent) error {
+	span, ok := a.spanOf(bucket, e)
+	if !ok {
+		return nil
+	}
+	if isAFKBucket(bucket) {
+		return a.closeSpan(bucket, span, span.seen)
+	}
+
+	var events []models.RawEvent
+	for t := span.start; t.Before(span.seen); t = t.Add(awEventStep) {
+		events = append(events, a.rawEvent(bucket, span, t))
+	}
+	events = append(events, a.rawEvent(bucket, span, span.seen))
+	return a.store.InsertRawEvents(events)
+}
+
+// spanOf maps an event onto an activity. ok is false for events that carry
+// nothing to record, including ones the privacy filter drops.
+func (a *activityWatch) spanOf(bucket models.Bucket, e awEvent) (*awSpan, bool) {
+	span := &awSpan{start: e.Timestamp, written: e.Timestamp, seen: e.end()}
+
+	if isAFKBucket(bucket) {
+		status, _ := e.Data["status"].(string)
+		span.afk = status == "afk"
+		span.app = status
+		return span, status != ""
+	}
+
+	span.app, span.title = awActivity(bucket, e.Data)
+	if span.app == "" {
+		return nil, false
+	}
+	span.url, _ = e.Data["url"].(string)
+	span.cwd = awCwd(e.Data)
+	filtered, ok := a.filter.Apply(models.RawEvent{AppName: span.app, WindowTitle: span.title, URL: span.url, Cwd: span.cwd})
+	if !ok {
+		return nil, false
+	}
+	span.title, span.url, span.cwd = filtered.WindowTitle, filtered.URL, filtered.Cwd
+	return span, true
+}
+
+// isAFKBucket reports whether a bucket holds aw-watcher-afk status events.
+func isAFKBucket(bucket models.Bucket) bool {
+	return bucket.Type == "afkstatus"
+}
+
+func (a *activityWatch) rawEvent(bucket models.Bucket, span *awSpan, at time.Time) models.RawEvent {
+	return models.RawEvent{Timestamp: at, AppName: span.app, WindowTitle: span.title, URL: span.url, Cwd: span.cwd, Source: bucket.ID}
+}
+
+// awCwd returns the working directory an event reports: a terminal's cwd or
+// the project an editor has open.
+func awCwd(data map[string]interface{}) string {
+	if cwd, _ := data["cwd"].(string); cwd != "" {
+		return cwd
+	}
+	project, _ := data["project"].(string)
+	return project
+}
+
+// awActivity derives an app name and title from the data of the common
+// watcher types: windows, browser tabs and editors.
+func awActivity(bucket models.Bucket, data map[string]interface{}) (app, title string) {
+	str := func(key string) string {
+		value, _ := data[key].(string)
+		return value
+	}
+
+	switch {
+	case str("app") != "":
+		return str("app"), str("title")
+	case str("url") != "":
+		// Browser tabs are named after the site so rules can match on it.
+		title = str("title")
+		if title == "" {
+			title = str("url")
+		}
+		if u, err := url.Parse(str("url")); err == nil && u.Host != "" {
+			return u.Host, title
+		}
+		return "Web", title
+	case str("file") != "":
+		// Editor watchers: the editor is the app and the file the title.
+		editor := strings.TrimPrefix(bucket.Client, "aw-watcher-")
+		if editor == "" {
+			editor = "Editor"
+		}
+		return editor, str("file")
+	default:
+		return bucket.Client, str("title")
+	}
+}
+
+// bucketEvents returns a bucket's history as ActivityWatch events: sessions
+// recorded from it, or away periods for AFK buckets.
+func (a *activityWatch) bucketEvents(bucket models.Bucket, start, end time.Time) ([]awEvent, error) {
+	events := make([]awEvent, 0)
+
+	if isAFKBucket(bucket) {
+		periods, err := a.store.GetAwayPeriods(start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range periods {
+			if p.Reason != models.AwayAFK {
+				continue
+			}
+			events = append(events, awEvent{
+				ID:        p.ID,
+				Timestamp: p.StartTime.UTC(),
+				Duration:  p.EndTime.Sub(p.StartTime).Seconds(),
+				Data:      map[string]interface{}{"status": "afk"},
+			})
+		}
+		return events, nil
+	}
+
+	err := a.store.EachSession(storage.SessionFilter{Start: start, End: end}, func(s models.ActivitySession) error {
+		if s.Source == bucket.ID {
+			data := map[string]interface{}{"app": s.AppName, "title": s.WindowTitle}
+			if s.URL != "" {
+				data["url"] = s.URL
+			}
+			if s.Cwd != "" {
+				data["cwd"] = s.Cwd
+			}
+			events = append(events, awEvent{
+				ID:        s.ID,
+				Timestamp: s.StartTime.UTC(),
+				Duration:  float64(s.Duration),
+				Data:      data,
+			})
+		}
+		return nil
+	})
+	return events, err
+}
+
+// awRange parses optional ISO 8601 start and end parameters.
+func awRange(start, end string) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if start != "" {
+		if from, err = time.Parse(time.RFC3339Nano, start); err != nil {
+			return from, to, fmt.Errorf("invalid start %q", start)
+		}
+	}
+	if end != "" {
+		if to, err = time.Parse(time.RFC3339Nano, end); err != nil {
+			return from, to, fmt.Errorf("invalid end %q", end)
+		}
+	}
+	return from, to, nil
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/pause"
+	"github.com/imdawon/personalos/privacy"
+	"github.com/imdawon/personalos/storage"
+)
+
+const (
+	windowBucket = "aw-watcher-window_laptop"
+	afkBucket    = "aw-watcher-afk_laptop"
+)
+
+// newAWServer returns a store and a handler serving the ActivityWatch API
+// with the window and AFK buckets created.
+func newAWServer(t *testing.T, opts ...Option) (*storage.MemoryStore, http.Handler) {
+	t.Helper()
+	store := storage.NewMemoryStore()
+	h := NewServer(store, opts...).Handler()
+	decode(t, do(t, h, http.MethodPost, "/api/0/buckets/"+windowBucket, awBucket{Type: "currentwindow", Client: "aw-watcher-window", Hostname: "laptop"}), http.StatusOK, nil)
+	decode(t, do(t, h, http.MethodPost, "/api/0/buckets/"+afkBucket, awBucket{Type: "afkstatus", Client: "aw-watcher-afk", Hostname: "laptop"}), http.StatusOK, nil)
+	return store, h
+}
+
+func heartbeat(t *testing.T, h http.Handler, bucket string, at time.Time, data map[string]interface{}, pulsetime int) {
+	t.Helper()
+	path := fmt.Sprintf("/api/0/buckets/%s/heartbeat?pulsetime=%d", bucket, pulsetime)
+	decode(t, do(t, h, http.MethodPost, path, awEvent{Timestamp: at, Data: data}), http.StatusOK, nil)
+}
+
+func window(app, title string) map[string]interface{} {
+	return map[string]interface{}{"app": app, "title": title}
+}
+
+// sessions processes pending events and returns every session.
+func sessions(t *testing.T, store storage.Store) []models.ActivitySession {
+	t.Helper()
+	if err := store.ProcessRawEvents(); err != nil {
+		t.Fatal(err)
+	}
+	var all []models.ActivitySession
+	err := store.EachSession(storage.SessionFilter{}, func(s models.ActivitySession) error {
+		all = append(all, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return all
+}
+
+func TestActivityWatchBuckets(t *testing.T) {
+	_, h := newAWServer(t, WithActivityWatchAPI(nil))
+
+	// Creating an existing bucket is not an error, as in ActivityWatch.
+	rec := do(t, h, http.MethodPost, "/api/0/buckets/"+windowBucket, awBucket{Type: "currentwindow"})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("recreating a bucket: status %d", rec.Code)
+	}
+	decode(t, do(t, h, http.MethodPost, "/api/0/buckets/untyped", awBucket{}), http.StatusBadRequest, nil)
+
+	var buckets map[string]awBucket
+	decode(t, do(t, h, http.MethodGet, "/api/0/buckets/", nil), http.StatusOK, &buckets)
+	if len(buckets) != 2 || buckets[windowBucket].Type != "currentwindow" || buckets[afkBucket].Hostname != "laptop" {
+		t.Errorf("buckets = %+v", buckets)
+	}
+	var bucket awBucket
+	decode(t, do(t, h, http.MethodGet, "/api/0/buckets/"+windowBucket, nil), http.StatusOK, &bucket)
+	if bucket.ID != windowBucket || bucket.Client != "aw-watcher-window" {
+		t.Errorf("bucket = %+v", bucket)
+	}
+	decode(t, do(t, h, http.MethodGet, "/api/0/buckets/missing", nil), http.StatusNotFound, nil)
+	decode(t, do(t, h, http.MethodPost, "/api/0/buckets/missing/heartbeat", awEvent{}), http.StatusNotFound, nil)
+
+	var info map[string]interface{}
+	decode(t, do(t, h, http.MethodGet, "/api/0/info", nil), http.StatusOK, &info)
+	if info["version"] != "personalos" {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestActivityWatchHeartbeats(t *testing.T) {
+	store, h := newAWServer(t, WithActivityWatchAPI(nil))
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	// Heartbeats with the same data within pulsetime extend one span.
+	for i := 0; i <= 12; i++ {
+		heartbeat(t, h, windowBucket, start.Add(time.Duration(i)*5*time.Second), window("Code", "main.go"), 10)
+	}
+	heartbeat(t, h, windowBucket, start.Add(65*time.Second), window("Code", "main_test.go"), 10)
+	heartbeat(t, h, windowBucket, start.Add(95*time.Second), window("Code", "main_test.go"), 60)
+	// A heartbeat after pulsetime ends the span where it was last seen.
+	heartbeat(t, h, windowBucket, start.Add(10*time.Minute), window("Slack", "general"), 10)
+
+	got := sessions(t, store)
+	if len(got) < 2 {
+		t.Fatalf("sessions = %+v", got)
+	}
+	if s := got[0]; s.WindowTitle != "main.go" || !s.StartTime.Equal(start) || s.Duration != 65 || s.Source != windowBucket {
+		t.Errorf("first session = %+v, want 65s of main.go from the bucket", s)
+	}
+	if s := got[1]; s.WindowTitle != "main_test.go" || s.Duration != 30 {
+		t.Errorf("second session = %+v, want 30s of main_test.go", s)
+	}
+
+	var events []awEvent
+	decode(t, do(t, h, http.MethodGet, "/api/0/buckets/"+windowBucket+"/events?limit=1", nil), http.StatusOK, &events)
+	if len(events) != 1 || events[0].Data["title"] != got[len(got)-1].WindowTitle {
+		t.Errorf("latest event = %+v", events)
+	}
+	decode(t, do(t, h, http.MethodGet, "/api/0/buckets/"+windowBucket+"/events?start=yesterday", nil), http.StatusBadRequest, nil)
+}
+
+func TestActivityWatchAFK(t *testing.T) {
+	store, h := newAWServer(t, WithActivityWatchAPI(nil))
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	status := func(s string) map[string]interface{} { return map[string]interface{}{"status": s} }
+
+	heartbeat(t, h, afkBucket, start, status("not-afk"), 120)
+	// The watcher repeats its status every minute.
+	for m := 10; m < 20; m++ {
+		heartbeat(t, h, afkBucket, start.Add(time.Duration(m)*time.Minute), status("afk"), 120)
+	}
+	heartbeat(t, h, afkBucket, start.Add(20*time.Minute), status("not-afk"), 120)
+	// Once its reports stop, an AFK span ends where it was last seen.
+	heartbeat(t, h, afkBucket, start.Add(30*time.Minute), status("afk"), 120)
+	heartbeat(t, h, afkBucket, start.Add(31*time.Minute), status("afk"), 120)
+	heartbeat(t, h, afkBucket, start.Add(40*time.Minute), status("not-afk"), 120)
+
+	periods, err := store.GetAwayPeriods(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(periods) != 2 || !periods[0].StartTime.Equal(start.Add(10*time.Minute)) || !periods[0].EndTime.Equal(start.Add(20*time.Minute)) ||
+		!periods[1].EndTime.Equal(start.Add(31*time.Minute)) || periods[0].Reason != models.AwayAFK {
+		t.Errorf("away periods = %+v, want the two AFK spans", periods)
+	}
+	if n := len(sessions(t, store)); n != 0 {
+		t.Errorf("AFK reports became %d sessions", n)
+	}
+
+	var events []awEvent
+	decode(t, do(t, h, http.MethodGet, "/api/0/buckets/"+afkBucket+"/events", nil), http.StatusOK, &events)
+	if len(events) != 2 || events[1].Duration != 600 || events[1].Data["status"] != "afk" {
+		t.Errorf("AFK events = %+v", events)
+	}
+}
+
+func TestActivityWatchInsertEvents(t *testing.T) {
+	store, h := newAWServer(t, WithActivityWatchAPI(nil))
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	batch := []awEvent{
+		{Timestamp: start, Duration: 120, Data: map[string]interface{}{"url": "https://go.dev/doc/", "title": "Documentation"}},
+		{Timestamp: start.Add(5 * time.Minute), Duration: 60, Data: map[string]interface{}{"file": "main.go", "project": "/src/app"}},
+	}
+	decode(t, do(t, h, http.MethodPost, "/api/0/buckets/"+windowBucket+"/events", batch), http.StatusOK, nil)
+	// A single event is accepted too.
+	single := awEvent{Timestamp: start.Add(10 * time.Minute), Duration: 30, Data: window("Terminal", "zsh")}
+	decode(t, do(t, h, http.MethodPost, "/api/0/buckets/"+windowBucket+"/events", single), http.StatusOK, nil)
+	decode(t, do(t, h, http.MethodPost, "/api/0/buckets/"+windowBucket+"/events", "not an event"), http.StatusBadRequest, nil)
+
+	got := sessions(t, store)
+	want := []struct {
+		app, title, url, cwd string
+		duration             int64
+	}{
+		{"go.dev", "Documentation", "https://go.dev/doc/", "", 120},
+		{"window", "main.go", "", "/src/app", 60},
+		{"Terminal", "zsh", "", "", 30},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("sessions = %+v", got)
+	}
+	for i, w := range want {
+		s := got[i]
+		if s.AppName != w.app || s.WindowTitle != w.title || s.URL != w.url || s.Cwd != w.cwd || s.Duration != w.duration {
+			t.Errorf("session %d = %+v, want %+v", i, s, w)
+		}
+	}
+}
+
+func TestActivityWatchDropsFilteredAndPausedReports(t *testing.T) {
+	filter, err := privacy.NewFilter(privacy.Rules{DenyApps: []string{"1Password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller := pause.NewController(storage.NewMemoryStore(), nil)
+	store, h := newAWServer(t, WithActivityWatchAPI(filter), WithPauseController(controller))
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	heartbeat(t, h, windowBucket, start, window("1Password", "Vault"), 10)
+	heartbeat(t, h, windowBucket, start.Add(5*time.Second), window("1Password", "Vault"), 10)
+
+	controller.Pause(time.Now(), 0)
+	heartbeat(t, h, windowBucket, start.Add(time.Minute), window("Code", "main.go"), 10)
+	decode(t, do(t, h, http.MethodPost, "/api/0/buckets/"+windowBucket+"/events", []awEvent{{Timestamp: start, Duration: 60, Data: window("Code", "main.go")}}), http.StatusOK, nil)
+
+	if got := sessions(t, store); len(got) != 0 {
+		t.Errorf("sessions = %+v, want nothing recorded", got)
+	}
+}
+
+func TestActivityWatchQuery(t *testing.T) {
+	store, h := newAWServer(t, WithActivityWatchAPI(nil))
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i, e := range []struct {
+		app, title string
+		seconds    float64
+	}{
+		{"Code", "main.go", 120},
+		{"Slack", "general", 60},
+		{"Code", "util.go", 180},
+	} {
+		event := awEvent{Timestamp: start.Add(time.Duration(i) * 10 * time.Minute), Duration: e.seconds, Data: window(e.app, e.title)}
+		decode(t, do(t, h, http.MethodPost, "/api/0/buckets/"+windowBucket+"/events", event), http.StatusOK, nil)
+	}
+	if err := store.ProcessRawEvents(); err != nil {
+		t.Fatal(err)
+	}
+	period := start.Add(-time.Minute).UTC().Format(time.RFC3339) + "/" + time.Now().UTC().Format(time.RFC3339)
+
+	query := func(status int, lines ...string) []interface{} {
+		t.Helper()
+		var results []interface{}
+		var v interface{} = &results
+		if status != http.StatusOK {
+			v = nil
+		}
+		decode(t, do(t, h, http.MethodPost, "/api/0/query/", awQueryRequest{TimePeriods: []string{period}, Query: lines}), status, v)
+		return results
+	}
+
+	results := query(http.StatusOK,
+		`events = query_bucket(find_bucket("aw-watcher-window_"));`,
+		`events = merge_events_by_keys(events, ["app"])`,
+		`RETURN = limit_events(sort_by_duration(events), 1)`,
+	)
+	top, ok := results[0].([]interface{})
+	if len(results) != 1 || !ok || len(top) != 1 {
+		t.Fatalf("results = %+v", results)
+	}
+	if event := top[0].(map[string]interface{}); event["duration"] != 300.0 || event["data"].(map[string]interface{})["app"] != "Code" {
+		t.Errorf("top app = %+v, want 300s of Code", event)
+	}
+
+	results = query(http.StatusOK,
+		`events = query_bucket('`+windowBucket+`'); code = filter_keyvals(events, "app", ["Code"])`,
+		`chat = exclude_keyvals(events, "app", ["Code"])`,
+		`RETURN = [sum_durations(code), sum_durations(chat), sum_durations(concat(code, chat))]`,
+	)
+	if totals := results[0].([]interface{}); totals[0] != 300.0 || totals[1] != 60.0 || totals[2] != 360.0 {
+		t.Errorf("totals = %+v, want 300, 60 and 360", totals)
+	}
+
+	bad := [][]string{
+		{`RETURN = nope`},
+		{`RETURN = flood_events(1)`},
+		{`events = query_bucket("missing"); RETURN = events`},
+		{`RETURN = "unterminated`},
+		{`RETURN = sum_durations(1)`},
+		{`x = 1`},
+	}
+	for _, lines := range bad {
+		query(http.StatusBadRequest, lines...)
+	}
+}
+
+func TestParseAWQuery(t *testing.T) {
+	program, err := parseAWQuery("a = f(\"x\\\"y\", [1, -2.5,\n 'z']);\n\nRETURN = a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program) != 2 || program[0].name != "a" || program[1].name != "RETURN" {
+		t.Fatalf("program = %+v", program)
+	}
+	call := program[0].expr
+	if call.kind != 'c' || call.value != "f" || len(call.args) != 2 || call.args[0].value != `x"y` {
+		t.Fatalf("call = %+v", call)
+	}
+	if list := call.args[1]; list.kind != 'l' || len(list.args) != 3 || list.args[1].num != -2.5 || list.args[2].value != "z" {
+		t.Errorf("list = %+v", list)
+	}
+
+	for _, src := range []string{"= 1", "a 1", "a = f(1 2)", "a = [1,", "a = 1 b = 2"} {
+		if _, err := parseAWQuery(src); err == nil {
+			t.Errorf("parseAWQuery(%q) succeeded", src)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// awQueryRequest is the body of POST /api/0/query.
+type awQueryRequest struct {
+	TimePeriods []string `json:"timeperiods"`
+	Query       []string `json:"query"`
+}
+
+// handleQuery runs a query in a subset of the ActivityWatch query language:
+// assignments, RETURN, string, number and list literals, and the functions in
+// awQueryFuncs. The result holds one RETURN value per time period.
+func (a *activityWatch) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var req awQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	program, err := parseAWQuery(strings.Join(req.Query, "\n"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]interface{}, 0, len(req.TimePeriods))
+	for _, period := range req.TimePeriods {
+		start, end, err := parseTimePeriod(period)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q := &awQuery{aw: a, start: start, end: end, vars: make(map[string]interface{})}
+		result, err := q.run(program)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		results = append(results, result)
+	}
+	a.server.respondJSON(w, http.StatusOK, results)
+}
+
+// parseTimePeriod parses an ISO 8601 interval, "start/end".
+func parseTimePeriod(period string) (time.Time, time.Time, error) {
+	parts := strings.SplitN(period, "/", 2)
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid time period %q, expected start/end", period)
+	}
+	start, end, err := awRange(parts[0], parts[1])
+	if err != nil {
+		return start, end, fmt.Errorf("invalid time period %q: %w", period, err)
+	}
+	return start, end, nil
+}
+
+// awExpr is a parsed query expression.
+type awExpr struct {
+	kind  byte // 's' string, 'n' number, 'v' variable, 'c' call, 'l' list
+	value string
+	num   float64
+	args  []awExpr
+}
+
+// awStatement assigns an expression to a variable; RETURN is a variable too.
+type awStatement struct {
+	name string
+	expr awExpr
+}
+
+// awQuery evaluates a program over one time period.
+type awQuery struct {
+	aw         *activityWatch
+	start, end time.Time
+	vars       map[string]interface{}
+}
+
+func (q *awQuery) run(program []awStatement) (interface{}, error) {
+	for _, st := range program {
+		value, err := q.eval(st.expr)
+		if err != nil {
+			return nil, err
+		}
+		q.vars[st.name] = value
+	}
+	result, ok := q.vars["RETURN"]
+	if !ok {
+		return nil, fmt.Errorf("query does not assign RETURN")
+	}
+	return result, nil
+}
+
+func (q *awQuery) eval(e awExpr) (interface{}, error) {
+	switch e.kind {
+	case 's':
+		return e.value, nil
+	case 'n':
+		return e.num, nil
+	case 'v':
+		value, ok := q.vars[e.value]
+		if !ok {
+			return nil, fmt.Errorf("undefined variable %s", e.value)
+		}
+		return value, nil
+	case 'l':
+		list := make([]interface{}, 0, len(e.args))
+		for _, arg := range e.args {
+			value, err := q.eval(arg)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	}
+
+	fn, ok := awQueryFuncs[e.value]
+	if !ok {
+		return nil, fmt.Errorf("unsupported function %s", e.value)
+	}
+	args := make([]interface{}, 0, len(e.args))
+	for _, arg := range e.args {
+		value, err := q.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+	result, err := fn(q, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.value, err)
+	}
+	return result, nil
+}
+
+// awQueryFuncs are the supported query functions.
+var awQueryFuncs = map[string]func(q *awQuery, args []interface{}) (interface{}, error){
+	"find_bucket": func(q *awQuery, args []interface{}) (interface{}, error) {
+		prefix, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		buckets, err := q.aw.store.GetBuckets()
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range buckets {
+			if strings.HasPrefix(b.ID, prefix) {
+				return b.ID, nil
+			}
+		}
+		return nil, fmt.Errorf("no bucket starts with %q", prefix)
+	},
+	"query_bucket": func(q *awQuery, args []interface{}) (interface{}, error) {
+		id, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		bucket, err := q.aw.store.GetBucket(id)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+		events, err := q.aw.bucketEvents(bucket, q.start, q.end)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.After(events[j].Timestamp) })
+		return events, nil
+	},
+	"merge_events_by_keys": func(q *awQuery, args []interface{}) (interface{}, error) {
+		events, err := eventsArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := stringListArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		merged := make([]awEvent, 0)
+		index := make(map[string]int)
+		for _, e := range events {
+			data := make(map[string]interface{}, len(keys))
+			var id strings.Builder
+			for _, key := range keys {
+				data[key] = e.Data[key]
+				fmt.Fprintf(&id, "%v\x00", e.Data[key])
+			}
+			if i, ok := index[id.String()]; ok {
+				merged[i].Duration += e.Duration
+				if e.Timestamp.Before(merged[i].Timestamp) {
+					merged[i].Timestamp = e.Timestamp
+				}
+				continue
+			}
+			index[id.String()] = len(merged)
+			merged = append(merged, awEvent{Timestamp: e.Timestamp, Duration: e.Duration, Data: data})
+		}
+		return merged, nil
+	},
+	"sort_by_duration": func(q *awQuery, args []interface{}) (interface{}, error) {
+		events, err := eventsArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		sorted := append([]awEvent(nil), events...)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Duration > sorted[j].Duration })
+		return sorted, nil
+	},
+	"sort_by_timestamp": func(q *awQuery, args []interface{}) (interface{}, error) {
+		events, err := eventsArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		sorted := append([]awEvent(nil), events...)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+		return sorted, nil
+	},
+	"limit_events": func(q *awQuery, args []interface{}) (interface{}, error) {
+		events, err := eventsArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) < 2 {
+			return nil, fmt.Errorf("expected a limit")
+		}
+		limit, ok := args[1].(float64)
+		if !ok || limit < 0 {
+			return nil, fmt.Errorf("limit must be a non-negative number")
+		}
+		if int(limit) < len(events) {
+			events = events[:int(limit)]
+		}
+		return events, nil
+	},
+	"filter_keyvals": func(q *awQuery, args []interface{}) (interface{}, error) {
+		return filterKeyvals(args, false)
+	},
+	"exclude_keyvals": func(q *awQuery, args []interface{}) (interface{}, error) {
+		return filterKeyvals(args, true)
+	},
+	"concat": func(q *awQuery, args []interface{}) (interface{}, error) {
+		all := make([]awEvent, 0)
+		for i := range args {
+			events, err := eventsArg(args, i)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, events...)
+		}
+		return all, nil
+	},
+	"sum_durations": func(q *awQuery, args []interface{}) (interface{}, error) {
+		events, err := eventsArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		var total float64
+		for _, e := range events {
+			total += e.Duration
+		}
+		return total, nil
+	},
+}
+
+// filterKeyvals keeps, or with exclude drops, events whose data[key] is one
+// of the given values.
+func filterKeyvals(args []interface{}, exclude bool) (interface{}, error) {
+	events, err := eventsArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	key, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 3 {
+		return nil, fmt.Errorf("expected a list of values")
+	}
+	values, ok := args[2].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("values must be a list")
+	}
+
+	filtered := make([]awEvent, 0)
+	for _, e := range events {
+		match := false
+		for _, v := range values {
+			match = match || fmt.Sprint(e.Data[key]) == fmt.Sprint(v)
+		}
+		if match != exclude {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered, nil
+}
+
+func eventsArg(args []interface{}, i int) ([]awEvent, error) {
+	if i >= len(args) {
+		return nil, fmt.Errorf("missing argument %d", i+1)
+	}
+	events, ok := args[i].([]awEvent)
+	if !ok {
+		return nil, fmt.Errorf("argument %d must be a list of events", i+1)
+	}
+	return events, nil
+}
+
+func stringArg(args []interface{}, i int) (string, error) {
+	if i >= len(args) {
+		return "", fmt.Errorf("missing argument %d", i+1)
+	}
+	s, ok := args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("argument %d must be a string", i+1)
+	}
+	return s, nil
+}
+
+func stringListArg(args []interface{}, i int) ([]string, error) {
+	if i >= len(args) {
+		return nil, fmt.Errorf("missing argument %d", i+1)
+	}
+	list, ok := args[i].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("argument %d must be a list", i+1)
+	}
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("argument %d must be a list of strings", i+1)
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+// awParser is a recursive-descent parser for the query language.
+type awParser struct {
+	src []rune
+	pos int
+}
+
+// parseAWQuery parses statements separated by semicolons or newlines.
+func parseAWQuery(src string) ([]awStatement, error) {
+	p := &awParser{src: []rune(src)}
+	var program []awStatement
+	for {
+		p.skipSpace(true)
+		if p.pos >= len(p.src) {
+			return program, nil
+		}
+		name := p.ident()
+		if name == "" {
+			return nil, p.errorf("expected a variable name")
+		}
+		p.skipSpace(false)
+		if !p.consume('=') {
+			return nil, p.errorf("expected '=' after %s", name)
+		}
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		program = append(program, awStatement{name: name, expr: expr})
+
+		p.skipSpace(false)
+		if p.pos < len(p.src) && !p.consume(';') && !p.consume('\n') {
+			return nil, p.errorf("expected ';' after statement")
+		}
+	}
+}
+
+// skipSpace skips whitespace, and newlines and semicolons when between is set.
+func (p *awParser) skipSpace(between bool) {
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		if r == '\n' || r == ';' {
+			if !between {
+				return
+			}
+		} else if !unicode.IsSpace(r) {
+			return
+		}
+		p.pos++
+	}
+}
+
+// skipAll skips whitespace including newlines, used inside brackets.
+func (p *awParser) skipAll() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *awParser) consume(r rune) bool {
+	if p.pos < len(p.src) && p.src[p.pos] == r {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *awParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(p.src[p.pos]) ||
+		(p.pos > start && unicode.IsDigit(p.src[p.pos]))) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *awParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("query: position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *awParser) expr() (awExpr, error) {
+	p.skipSpace(false)
+	if p.pos >= len(p.src) {
+		return awExpr{}, p.errorf("unexpected end of query")
+	}
+
+	switch r := p.src[p.pos]; {
+	case r == '"' || r == '\'':
+		return p.str(r)
+	case r == '[':
+		p.pos++
+		args, err := p.list(']')
+		return awExpr{kind: 'l', args: args}, err
+	case r == '-' || unicode.IsDigit(r):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && (unicode.IsDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		num, err := strconv.ParseFloat(string(p.src[start:p.pos]), 64)
+		if err != nil {
+			return awExpr{}, p.errorf("invalid number")
+		}
+		return awExpr{kind: 'n', num: num}, nil
+	}
+
+	name := p.ident()
+	if name == "" {
+		return awExpr{}, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	p.skipSpace(false)
+	if !p.consume('(') {
+		return awExpr{kind: 'v', value: name}, nil
+	}
+	args, err := p.list(')')
+	return awExpr{kind: 'c', value: name, args: args}, err
+}
+
+// list parses comma-separated expressions up to the closing rune.
+func (p *awParser) list(closing rune) ([]awExpr, error) {
+	var items []awExpr
+	for {
+		p.skipAll()
+		if p.consume(closing) {
+			return items, nil
+		}
+		if len(items) > 0 {
+			if !p.consume(',') {
+				return nil, p.errorf("expected ',' or %q", closing)
+			}
+			p.skipAll()
+		}
+		item, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func (p *awParser) str(quote rune) (awExpr, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		p.pos++
+		switch {
+		case r == quote:
+			return awExpr{kind: 's', value: b.String()}, nil
+		case r == '\\' && p.pos < len(p.src):
+			b.WriteRune(p.src[p.pos])
+			p.pos++
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return awExpr{}, p.errorf("unterminated string")
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/imdawon/personalos/export"
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+	"github.com/imdawon/personalos/timesheet"
+)
+
+// projectStatus maps project errors to HTTP statuses.
+func projectStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrProjectNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrProjectExists):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrInvalidProject):
+		return http.StatusBadRequest
+	default:
+		return sessionEditStatus(err)
+	}
+}
+
+func (s *Server) handleProjects(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		projects, err := s.store.ListProjects()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, projects)
+	case http.MethodPost:
+		var req models.CreateProjectRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		project, err := s.store.CreateProject(req)
+		if err != nil {
+			http.Error(w, err.Error(), projectStatus(err))
+			return
+		}
+		s.respondJSON(w, http.StatusCreated, project)
+	case http.MethodPut:
+		var req models.UpdateProjectRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		project, err := s.store.UpdateProject(req)
+		if err != nil {
+			http.Error(w, err.Error(), projectStatus(err))
+			return
+		}
+		s.respondJSON(w, http.StatusOK, project)
+	case http.MethodDelete:
+		idStr := r.URL.Query().Get("id")
+		if idStr == "" {
+			http.Error(w, "Missing project ID", http.StatusBadRequest)
+			return
+		}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid project ID", http.StatusBadRequest)
+			return
+		}
+		if err := s.store.DeleteProject(id); err != nil {
+			http.Error(w, err.Error(), projectStatus(err))
+			return
+		}
+		s.respondJSON(w, http.StatusOK, map[string]string{"status": "project deleted"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleProjectRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := s.store.ListProjectRules()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, rules)
+	case http.MethodPost:
+		var req models.CreateProjectRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule, err := s.store.CreateProjectRule(req)
+		if err != nil {
+			http.Error(w, err.Error(), projectStatus(err))
+			return
+		}
+		s.respondJSON(w, http.StatusCreated, rule)
+	case http.MethodDelete:
+		idStr := r.URL.Query().Get("id")
+		if idStr == "" {
+			http.Error(w, "Missing rule ID", http.StatusBadRequest)
+			return
+		}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+			return
+		}
+		if err := s.store.DeleteProjectRule(id); err != nil {
+			http.Error(w, err.Error(), projectStatus(err))
+			return
+		}
+		s.respondJSON(w, http.StatusOK, map[string]string{"status": "rule deleted"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSessionProject attributes sessions to a project, or removes their
+// project when none is given.
+func (s *Server) handleSessionProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.AssignProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, err := s.store.AssignProject(req)
+	if err != nil {
+		http.Error(w, err.Error(), projectStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, map[string]int64{"assigned": n})
+}
+
+// handleTimesheet returns project time per day between start and end,
+// optionally rounded up to 6 or 15 minutes and limited to a client or project.
+func (s *Server) handleTimesheet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	start, end, err := export.ParseRange(query.Get("start"), query.Get("end"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := timesheet.Options{Start: start, End: end, Client: query.Get("client")}
+	switch rounding := query.Get("rounding"); rounding {
+	case "", "0":
+	case "6", "15":
+		opts.RoundingMinutes, _ = strconv.Atoi(rounding)
+	default:
+		http.Error(w, fmt.Sprintf("unknown rounding %q (want 0, 6 or 15 minutes)", rounding), http.StatusBadRequest)
+		return
+	}
+	if project := query.Get("project"); project != "" {
+		if opts.ProjectID, err = strconv.ParseInt(project, 10, 64); err != nil {
+			http.Error(w, "Invalid project ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sheet, err := timesheet.Build(s.store, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, sheet)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+func TestProjectEndpoints(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := NewServer(store).Handler()
+
+	var project models.Project
+	decode(t, do(t, h, http.MethodPost, "/api/v0/projects", models.CreateProjectRequest{Name: "Website", Client: "Acme", HourlyRate: 6000}), http.StatusCreated, &project)
+	decode(t, do(t, h, http.MethodPost, "/api/v0/projects", models.CreateProjectRequest{Name: "Website"}), http.StatusConflict, nil)
+	decode(t, do(t, h, http.MethodPost, "/api/v0/projects", models.CreateProjectRequest{}), http.StatusBadRequest, nil)
+	decode(t, do(t, h, http.MethodPut, "/api/v0/projects", models.UpdateProjectRequest{ID: 99, Name: "Other"}), http.StatusNotFound, nil)
+
+	var rule models.ProjectRule
+	decode(t, do(t, h, http.MethodPost, "/api/v0/project-rules", models.CreateProjectRuleRequest{ProjectID: project.ID, Field: "title", Contains: "acme"}), http.StatusCreated, &rule)
+	decode(t, do(t, h, http.MethodPost, "/api/v0/project-rules", models.CreateProjectRuleRequest{ProjectID: project.ID, Field: "app", Contains: "x"}), http.StatusBadRequest, nil)
+	decode(t, do(t, h, http.MethodDelete, "/api/v0/project-rules?id=99", nil), http.StatusNotFound, nil)
+
+	// Two rule-attributed minutes, one of them assigned away by hand.
+	track(t, store, time.Now().Add(-time.Hour), "Browser", "acme.com")
+	track(t, store, time.Now().Add(-30*time.Minute), "Browser", "acme.com admin")
+	var sessions []models.ActivitySession
+	decode(t, do(t, h, http.MethodGet, "/api/v0/unclassified-sessions", nil), http.StatusOK, &sessions)
+	var assigned map[string]int64
+	decode(t, do(t, h, http.MethodPost, "/api/v0/session-project", models.AssignProjectRequest{SessionIDs: []int64{sessions[0].ID}}), http.StatusOK, &assigned)
+	if assigned["assigned"] != 1 {
+		t.Errorf("assign = %v, want 1 assigned", assigned)
+	}
+
+	var sheet models.Timesheet
+	decode(t, do(t, h, http.MethodGet, "/api/v0/timesheet?rounding=15&client=Acme", nil), http.StatusOK, &sheet)
+	if len(sheet.Totals) != 1 || sheet.Totals[0].Seconds != 60 || sheet.Totals[0].RoundedSeconds != 900 || sheet.Totals[0].Amount != 1500 {
+		t.Errorf("timesheet totals = %+v, want 15 minutes of Website", sheet.Totals)
+	}
+	decode(t, do(t, h, http.MethodGet, "/api/v0/timesheet?rounding=10", nil), http.StatusBadRequest, nil)
+	decode(t, do(t, h, http.MethodGet, "/api/v0/timesheet?project=x", nil), http.StatusBadRequest, nil)
+
+	decode(t, do(t, h, http.MethodDelete, "/api/v0/projects?id="+strconv.FormatInt(project.ID, 10), nil), http.StatusOK, nil)
+	var rules []models.ProjectRule
+	decode(t, do(t, h, http.MethodGet, "/api/v0/project-rules", nil), http.StatusOK, &rules)
+	if len(rules) != 0 {
+		t.Errorf("rules = %+v after deleting their project", rules)
+	}
+	decode(t, do(t, h, http.MethodGet, "/api/v0/session-project", nil), http.StatusMethodNotAllowed, nil)
+}
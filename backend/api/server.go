@@ -2,42 +2,106 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/imdawon/personalos/export"
 	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/pause"
 	"github.com/imdawon/personalos/storage"
 )
 
 // Server is the API server.
 type Server struct {
-	store *storage.DBStore
+	store  storage.Store
+	pauses *pause.Controller
+	aw     *activityWatch
+
+	sync      storage.SyncStore
+	syncToken string
+}
+
+// Option configures optional parts of the Server.
+type Option func(*Server)
+
+// WithPauseController enables the pause, resume and status endpoints.
+func WithPauseController(c *pause.Controller) Option {
+	return func(s *Server) {
+		s.pauses = c
+	}
 }
 
 // NewServer creates a new API server.
-func NewServer(store *storage.DBStore) *Server {
-	return &Server{store: store}
+func NewServer(store storage.Store, opts ...Option) *Server {
+	s := &Server{store: store}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Start runs the HTTP server.
 func (s *Server) Start(addr string) {
+	log.Printf("API server listening on %s", addr)
+	if err := http.ListenAndServe(addr, s.Handler()); err != nil {
+		log.Fatalf("API server failed: %v", err)
+	}
+}
+
+// Handler returns the routes of every enabled endpoint.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/v0/unclassified-sessions", s.handleGetUnclassified)
 	mux.HandleFunc("/api/v0/classify", s.handleClassify)
 	mux.HandleFunc("/api/v0/classify-batch", s.handleClassifyBatch)
 	mux.HandleFunc("/api/v0/reclassify", s.handleReclassify)
 	mux.HandleFunc("/api/v0/delete-session", s.handleDeleteSession)
-	mux.HandleFunc("/api/v0/classifications", s.handleGetClassifications)
+	mux.HandleFunc("/api/v0/delete-sessions", s.handleDeleteSessions)
+	mux.HandleFunc("/api/v0/trash", s.handleGetTrash)
+	mux.HandleFunc("/api/v0/restore-sessions", s.handleRestoreSessions)
+	mux.HandleFunc("/api/v0/adjust-session", s.handleAdjustSession)
+	mux.HandleFunc("/api/v0/split-session", s.handleSplitSession)
+	mux.HandleFunc("/api/v0/merge-sessions", s.handleMergeSessions)
+	mux.HandleFunc("/api/v0/manual-entry", s.handleManualEntry)
+	mux.HandleFunc("/api/v0/session-tags", s.handleSessionTags)
+	mux.HandleFunc("/api/v0/session-note", s.handleSessionNote)
+	mux.HandleFunc("/api/v0/search", s.handleSearch)
+	mux.HandleFunc("/api/v0/audit", s.handleAuditLog)
+	mux.HandleFunc("/api/v0/undo", s.handleUndo)
+	mux.HandleFunc("/api/v0/session-history", s.handleSessionHistory)
+	mux.HandleFunc("/api/v0/classifications", s.handleClassifications)
+	mux.HandleFunc("/api/v0/merge-classifications", s.handleMergeClassifications)
+	mux.HandleFunc("/api/v0/classification-tree", s.handleClassificationTree)
+	mux.HandleFunc("/api/v0/projects", s.handleProjects)
+	mux.HandleFunc("/api/v0/project-rules", s.handleProjectRules)
+	mux.HandleFunc("/api/v0/session-project", s.handleSessionProject)
+	mux.HandleFunc("/api/v0/timesheet", s.handleTimesheet)
 	mux.HandleFunc("/api/v0/today-summary", s.handleGetTodaySummary)
 	mux.HandleFunc("/api/v0/rules", s.handleRules)
 	mux.HandleFunc("/api/v0/recent-activity", s.handleGetRecentActivity)
 	mux.HandleFunc("/api/v0/skills", s.handleGetSkills)
-
-	log.Printf("API server listening on %s", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatalf("API server failed: %v", err)
+	mux.HandleFunc("/api/v0/purge", s.handlePurge)
+	mux.HandleFunc("/api/v0/export", s.handleExport)
+	if s.aw != nil {
+		s.aw.register(mux)
+	}
+	if s.pauses != nil {
+		mux.HandleFunc("/api/v0/pause", s.handlePause)
+		mux.HandleFunc("/api/v0/resume", s.handleResume)
+		mux.HandleFunc("/api/v0/status", s.handleStatus)
 	}
+	// Sync exposes the whole history, so it is never served without a token.
+	if s.sync != nil && s.syncToken != "" {
+		mux.HandleFunc("/api/v0/sync/changes", s.handleSyncChanges)
+	} else if s.sync != nil {
+		log.Println("Sync endpoints disabled: no sync token is configured")
+	}
+	return mux
 }
 
 func (s *Server) handleGetUnclassified(w http.ResponseWriter, r *http.Request) {
@@ -62,7 +126,7 @@ func (s *Server) handleClassify(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := s.store.ApplyClassification(req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), classificationStatus(err))
 		return
 	}
 
@@ -81,7 +145,7 @@ func (s *Server) handleClassifyBatch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := s.store.ApplyClassificationBatch(req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), classificationStatus(err))
 		return
 	}
 	s.respondJSON(w, http.StatusOK, map[string]string{"status": "success"})
@@ -100,7 +164,7 @@ func (s *Server) handleReclassify(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := s.store.ReclassifySession(req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), classificationStatus(err))
 		return
 	}
 
@@ -129,7 +193,326 @@ func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.respondJSON(w, http.StatusOK, map[string]string{"status": "session deleted"})
+	s.respondJSON(w, http.StatusOK, map[string]string{"status": "session moved to trash"})
+}
+
+func (s *Server) handleDeleteSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.BulkDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	trashed, err := s.store.TrashSessions(req)
+	if errors.Is(err, storage.ErrEmptyBulkDelete) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, map[string]int64{"trashed": trashed})
+}
+
+func (s *Server) handleGetTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessions, err := s.store.GetTrash()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, sessions)
+}
+
+func (s *Server) handleRestoreSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.RestoreSessionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restored, err := s.store.RestoreSessions(req.SessionIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, map[string]int64{"restored": restored})
+}
+
+func (s *Server) handleAdjustSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.AdjustSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.store.AdjustSession(req)
+	if err != nil {
+		http.Error(w, err.Error(), sessionEditStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleSplitSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.SplitSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parts, err := s.store.SplitSession(req)
+	if err != nil {
+		http.Error(w, err.Error(), sessionEditStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, parts)
+}
+
+func (s *Server) handleMergeSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.MergeSessionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.store.MergeSessions(req)
+	if err != nil {
+		http.Error(w, err.Error(), sessionEditStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleSessionTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.TagSessionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tagged, err := s.store.TagSessions(req)
+	if err != nil {
+		http.Error(w, err.Error(), sessionEditStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, map[string]int64{"tagged": tagged})
+}
+
+func (s *Server) handleSessionNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.SessionNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.store.SetSessionNote(req)
+	if err != nil {
+		http.Error(w, err.Error(), sessionEditStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, session)
+}
+
+// handleSearch finds sessions by text in their app, title or note, and by
+// tag and start date.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	start, end, err := export.ParseRange(query.Get("start"), query.Get("end"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	search := storage.SessionSearch{
+		SessionFilter: storage.SessionFilter{Start: start, End: end, Tag: query.Get("tag")},
+		Text:          query.Get("q"),
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		search.Limit = n
+	}
+
+	sessions, err := s.store.SearchSessions(search)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, sessions)
+}
+
+func (s *Server) handleManualEntry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ManualEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.store.CreateManualSession(req, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), sessionEditStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusCreated, session)
+}
+
+func (s *Server) handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ops, err := s.store.GetAuditLog(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, ops)
+}
+
+func (s *Server) handleUndo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.UndoRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ops, err := s.store.Undo(req.Count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, ops)
+}
+
+func (s *Server) handleSessionHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	history, err := s.store.GetSessionHistory(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, history)
+}
+
+// sessionEditStatus maps a session edit error to an HTTP status.
+func sessionEditStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrSessionOverlap):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrInvalidSessionEdit), errors.Is(err, storage.ErrInvalidManualEntry):
+		return http.StatusBadRequest
+	default:
+		return classificationStatus(err)
+	}
+}
+
+// classificationStatus maps a classification error to an HTTP status.
+func classificationStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrClassificationNotFound), errors.Is(err, storage.ErrRuleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrClassificationExists), errors.Is(err, storage.ErrClassificationConflict):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrInvalidClassification), errors.Is(err, storage.ErrInvalidRule):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (s *Server) handleClassifications(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetClassifications(w, r)
+	case http.MethodPost:
+		s.handleCreateClassification(w, r)
+	case http.MethodPut:
+		s.handleUpdateClassification(w, r)
+	case http.MethodDelete:
+		s.handleDeleteClassification(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
 }
 
 func (s *Server) handleGetClassifications(w http.ResponseWriter, r *http.Request) {
@@ -141,12 +524,99 @@ func (s *Server) handleGetClassifications(w http.ResponseWriter, r *http.Request
 	s.respondJSON(w, http.StatusOK, classifications)
 }
 
+func (s *Server) handleCreateClassification(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateClassificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	classification, err := s.store.CreateClassification(req)
+	if err != nil {
+		http.Error(w, err.Error(), classificationStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusCreated, classification)
+}
+
+func (s *Server) handleUpdateClassification(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateClassificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	classification, err := s.store.UpdateClassification(req)
+	if err != nil {
+		http.Error(w, err.Error(), classificationStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, classification)
+}
+
+func (s *Server) handleDeleteClassification(w http.ResponseWriter, r *http.Request) {
+	idStr := r.URL.Query().Get("id")
+	if idStr == "" {
+		http.Error(w, "Missing classification ID", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid classification ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.DeleteClassification(id); err != nil {
+		http.Error(w, err.Error(), classificationStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, map[string]string{"status": "classification deleted"})
+}
+
+func (s *Server) handleMergeClassifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.MergeClassificationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	classification, err := s.store.MergeClassifications(req)
+	if err != nil {
+		http.Error(w, err.Error(), classificationStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, classification)
+}
+
+// handleClassificationTree returns the classifications nested under their
+// parents.
+func (s *Server) handleClassificationTree(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	classifications, err := s.store.ListClassifications()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, storage.ClassificationTree(classifications))
+}
+
 func (s *Server) handleRules(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		s.handleGetRules(w, r)
 	case http.MethodPost:
 		s.handleCreateRule(w, r)
+	case http.MethodPut:
+		s.handleUpdateRule(w, r)
 	case http.MethodDelete:
 		s.handleDeleteRule(w, r)
 	default:
@@ -193,15 +663,53 @@ func (s *Server) handleCreateRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.store.CreateClassificationRule(req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	result, err := s.store.CreateClassificationRule(req)
+	if err != nil {
+		http.Error(w, err.Error(), classificationStatus(err))
 		return
 	}
-	s.respondJSON(w, http.StatusCreated, map[string]string{"status": "rule created"})
+	status := http.StatusCreated
+	if result.DryRun {
+		status = http.StatusOK
+	}
+	s.respondJSON(w, status, result)
+}
+
+// handleUpdateRule replaces a rule. With apply_to_history it also classifies
+// matching sessions recorded earlier; dry_run previews how many sessions and
+// how much time that would change without saving anything.
+func (s *Server) handleUpdateRule(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateClassificationRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.store.UpdateClassificationRule(req)
+	if err != nil {
+		http.Error(w, err.Error(), classificationStatus(err))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, result)
 }
 
 func (s *Server) handleGetTodaySummary(w http.ResponseWriter, r *http.Request) {
-	summary, err := s.store.GetTodaySummary()
+	switch groupBy := r.URL.Query().Get("group_by"); groupBy {
+	case "", "classification":
+	case "tag":
+		summary, err := s.store.GetTagSummary(reportFilter(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, summary)
+		return
+	default:
+		http.Error(w, fmt.Sprintf("unknown group_by %q (want classification or tag)", groupBy), http.StatusBadRequest)
+		return
+	}
+
+	summary, err := s.store.GetTodaySummary(reportFilter(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -219,7 +727,7 @@ func (s *Server) handleGetRecentActivity(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *Server) handleGetSkills(w http.ResponseWriter, r *http.Request) {
-	skills, err := s.store.GetSkillProgress()
+	skills, err := s.store.GetSkillProgress(reportFilter(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -227,6 +735,124 @@ func (s *Server) handleGetSkills(w http.ResponseWriter, r *http.Request) {
 	s.respondJSON(w, http.StatusOK, skills)
 }
 
+func (s *Server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.PurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.store.PurgeHistory(req)
+	if errors.Is(err, storage.ErrEmptyPurgeFilter) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, result)
+}
+
+// handleExport streams an export. Query parameters: format (json, ndjson or
+// csv), table, start and end; see export.Options.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format, err := export.ParseFormat(query.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	table, err := export.ParseTable(query.Get("table"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	start, end, err := export.ParseRange(query.Get("start"), query.Get("end"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := "personalos-export"
+	if table != "" {
+		name += "-" + string(table)
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+string(format)))
+	w.Header().Set("X-Export-Schema-Version", strconv.Itoa(export.SchemaVersion))
+
+	opts := export.Options{Format: format, Table: table, Start: start, End: end, Tag: query.Get("tag")}
+	if err := export.Write(w, s.store, opts, time.Now()); err != nil {
+		// The status line is already sent; the truncated body is all we can signal.
+		log.Printf("Error writing export: %v", err)
+	}
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The body is optional; without one tracking pauses until resumed.
+	var req models.PauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Minutes < 0 {
+		http.Error(w, "minutes must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	status := s.pauses.Pause(time.Now(), time.Duration(req.Minutes)*time.Minute)
+	s.respondJSON(w, http.StatusOK, trackingStatus(status))
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := s.pauses.Resume(time.Now())
+	s.respondJSON(w, http.StatusOK, trackingStatus(status))
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := s.pauses.Status(time.Now())
+	s.respondJSON(w, http.StatusOK, trackingStatus(status))
+}
+
+// reportFilter reads the report options shared by the dashboard endpoints.
+func reportFilter(r *http.Request) storage.ReportFilter {
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all_devices"))
+	return storage.ReportFilter{AllDevices: all, Tag: r.URL.Query().Get("tag")}
+}
+
+func trackingStatus(status pause.Status) models.TrackingStatus {
+	resp := models.TrackingStatus{Paused: status.Paused, Reason: status.Reason}
+	if !status.Until.IsZero() {
+		until := status.Until.Unix()
+		resp.Until = &until
+	}
+	return resp
+}
+
 func (s *Server) respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 	response, err := json.Marshal(payload)
 	if err != nil {
@@ -0,0 +1,391 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/pause"
+	"github.com/imdawon/personalos/storage"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// do sends a request to h and returns the recorded response. A non-nil body
+// is sent as JSON.
+func do(t *testing.T, h http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, &buf))
+	return rec
+}
+
+// jsonBody encodes v as a request body.
+func jsonBody(v interface{}) *bytes.Buffer {
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(v)
+	return &buf
+}
+
+// decode unmarshals a JSON response, failing the test on an unexpected status.
+func decode(t *testing.T, rec *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body, err)
+		}
+	}
+}
+
+// track records a minute of activity starting at start, one event every ten
+// seconds, and processes it into a session.
+func track(t *testing.T, store storage.Store, start time.Time, app, title string) {
+	t.Helper()
+	var events []models.RawEvent
+	for i := 0; i <= 6; i++ {
+		events = append(events, models.RawEvent{Timestamp: start.Add(time.Duration(i) * 10 * time.Second), AppName: app, WindowTitle: title})
+	}
+	if err := store.InsertRawEvents(events); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ProcessRawEvents(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+func stringPtr(s string) *string { return &s }
+
+func TestClassifyFlow(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := NewServer(store).Handler()
+	today := time.Now().UTC().Truncate(24 * time.Hour).Add(time.Minute)
+	track(t, store, today, "Code", "main.go")
+	track(t, store, today.Add(time.Hour), "Slack", "general")
+
+	var unclassified []models.ActivitySession
+	decode(t, do(t, h, http.MethodGet, "/api/v0/unclassified-sessions", nil), http.StatusOK, &unclassified)
+	if len(unclassified) != 2 {
+		t.Fatalf("%d unclassified sessions, want 2", len(unclassified))
+	}
+
+	req := models.ClassificationRequest{AppName: "Code", WindowTitle: "main.go", UserDefinedName: "Go", IsHelpful: boolPtr(true), GoalContext: stringPtr("Learn")}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/classify", req), http.StatusOK, nil)
+
+	decode(t, do(t, h, http.MethodGet, "/api/v0/unclassified-sessions", nil), http.StatusOK, &unclassified)
+	if len(unclassified) != 1 || unclassified[0].AppName != "Slack" {
+		t.Errorf("unclassified = %+v, want only the Slack session", unclassified)
+	}
+
+	var summary []storage.TodaySummaryItem
+	decode(t, do(t, h, http.MethodGet, "/api/v0/today-summary", nil), http.StatusOK, &summary)
+	if len(summary) != 1 || summary[0].UserDefinedName != "Go" || summary[0].TotalDuration != 60 {
+		t.Errorf("summary = %+v, want 60s of Go", summary)
+	}
+
+	var recent []models.RecentActivityInfo
+	decode(t, do(t, h, http.MethodGet, "/api/v0/recent-activity", nil), http.StatusOK, &recent)
+	if len(recent) != 1 || recent[0].UserDefinedName != "Go" {
+		t.Errorf("recent = %+v", recent)
+	}
+
+	// Naming an existing classification with other attributes conflicts,
+	// but leaving them out does not.
+	req = models.ClassificationRequest{AppName: "Slack", WindowTitle: "general", UserDefinedName: "Go", IsHelpful: boolPtr(false)}
+	if rec := do(t, h, http.MethodPost, "/api/v0/classify", req); rec.Code != http.StatusConflict {
+		t.Errorf("conflicting classify: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	req.IsHelpful = nil
+	decode(t, do(t, h, http.MethodPost, "/api/v0/classify", req), http.StatusOK, nil)
+	decode(t, do(t, h, http.MethodGet, "/api/v0/unclassified-sessions", nil), http.StatusOK, &unclassified)
+	if len(unclassified) != 0 {
+		t.Errorf("unclassified = %+v, want none", unclassified)
+	}
+}
+
+func TestRuleEndpoints(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := NewServer(store).Handler()
+
+	rule := models.CreateClassificationRuleRequest{AppName: "Code", UserDefinedName: "Go"}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/rules", rule), http.StatusCreated, nil)
+
+	var rules []models.RuleInfo
+	decode(t, do(t, h, http.MethodGet, "/api/v0/rules", nil), http.StatusOK, &rules)
+	if len(rules) != 1 || rules[0].UserDefinedName != "Go" {
+		t.Fatalf("rules = %+v", rules)
+	}
+
+	// New sessions are classified by the rule.
+	track(t, store, time.Now().Add(-time.Hour), "Code", "main.go")
+	var unclassified []models.ActivitySession
+	decode(t, do(t, h, http.MethodGet, "/api/v0/unclassified-sessions", nil), http.StatusOK, &unclassified)
+	if len(unclassified) != 0 {
+		t.Errorf("%d unclassified sessions, want the rule to classify them", len(unclassified))
+	}
+
+	bad := models.CreateClassificationRuleRequest{AppName: "Code", AppMatch: "fuzzy", UserDefinedName: "Go"}
+	if rec := do(t, h, http.MethodPost, "/api/v0/rules", bad); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid rule: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	decode(t, do(t, h, http.MethodDelete, "/api/v0/rules?id=1", nil), http.StatusOK, nil)
+	decode(t, do(t, h, http.MethodGet, "/api/v0/rules", nil), http.StatusOK, &rules)
+	if len(rules) != 0 {
+		t.Errorf("rules = %+v after deleting", rules)
+	}
+}
+
+func TestHandlerRejectsBadRequests(t *testing.T) {
+	h := NewServer(storage.NewMemoryStore()).Handler()
+	tests := []struct {
+		name, method, path string
+		body               string
+		want               int
+	}{
+		{"classify with GET", http.MethodGet, "/api/v0/classify", "", http.StatusMethodNotAllowed},
+		{"classify with bad JSON", http.MethodPost, "/api/v0/classify", "{", http.StatusBadRequest},
+		{"delete without ID", http.MethodDelete, "/api/v0/delete-session", "", http.StatusBadRequest},
+		{"delete with bad ID", http.MethodDelete, "/api/v0/delete-session?id=x", "", http.StatusBadRequest},
+		{"rules with PATCH", http.MethodPatch, "/api/v0/rules", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body)))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestPauseEndpoints(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := NewServer(store, WithPauseController(pause.NewController(store, nil))).Handler()
+
+	var status models.TrackingStatus
+	decode(t, do(t, h, http.MethodGet, "/api/v0/status", nil), http.StatusOK, &status)
+	if status.Paused {
+		t.Fatalf("status = %+v before pausing", status)
+	}
+
+	decode(t, do(t, h, http.MethodPost, "/api/v0/pause", models.PauseRequest{Minutes: 15}), http.StatusOK, &status)
+	if !status.Paused || status.Reason != models.AwayUserPaused || status.Until == nil || *status.Until < time.Now().Add(14*time.Minute).Unix() {
+		t.Fatalf("pausing for 15 minutes = %+v", status)
+	}
+	decode(t, do(t, h, http.MethodGet, "/api/v0/status", nil), http.StatusOK, &status)
+	if !status.Paused {
+		t.Errorf("status = %+v while paused", status)
+	}
+
+	decode(t, do(t, h, http.MethodPost, "/api/v0/resume", nil), http.StatusOK, &status)
+	if status.Paused {
+		t.Errorf("resuming = %+v", status)
+	}
+
+	// Without a body, tracking pauses until resumed.
+	var untilResumed models.TrackingStatus
+	decode(t, do(t, h, http.MethodPost, "/api/v0/pause", nil), http.StatusOK, &untilResumed)
+	if !untilResumed.Paused || untilResumed.Until != nil {
+		t.Errorf("pausing until resumed = %+v", untilResumed)
+	}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/pause", models.PauseRequest{Minutes: -1}), http.StatusBadRequest, nil)
+	decode(t, do(t, h, http.MethodGet, "/api/v0/pause", nil), http.StatusMethodNotAllowed, nil)
+	decode(t, do(t, h, http.MethodPost, "/api/v0/status", nil), http.StatusMethodNotAllowed, nil)
+}
+
+func TestExportEndpoint(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := NewServer(store).Handler()
+	track(t, store, time.Now().Add(-time.Hour), "Code", "main.go")
+
+	rec := do(t, h, http.MethodGet, "/api/v0/export?format=csv&table=sessions", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="personalos-export-sessions.csv"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "main.go") {
+		t.Errorf("export = %q, want a header and the session", lines)
+	}
+
+	for _, query := range []string{"format=xml", "table=raw_events", "start=yesterday", "start=2026-10-02&end=2026-10-01"} {
+		decode(t, do(t, h, http.MethodGet, "/api/v0/export?"+query, nil), http.StatusBadRequest, nil)
+	}
+}
+
+func TestSessionEditEndpoints(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := NewServer(store).Handler()
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	track(t, store, start, "Code", "main.go")
+	track(t, store, start.Add(10*time.Minute), "Code", "store.go")
+	var sessions []models.ActivitySession
+	decode(t, do(t, h, http.MethodGet, "/api/v0/unclassified-sessions", nil), http.StatusOK, &sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("%d sessions, want 2", len(sessions))
+	}
+	first, second := sessions[0], sessions[1]
+	if first.ID > second.ID {
+		first, second = second, first
+	}
+
+	var parts []models.ActivitySession
+	split := models.SplitSessionRequest{SessionID: first.ID, At: start.Add(30 * time.Second).Unix()}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/split-session", split), http.StatusOK, &parts)
+	if len(parts) != 2 || parts[0].Duration != 30 || parts[1].Duration != 30 {
+		t.Errorf("split = %+v", parts)
+	}
+
+	var merged models.ActivitySession
+	merge := models.MergeSessionsRequest{SessionIDs: []int64{parts[0].ID, parts[1].ID}}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/merge-sessions", merge), http.StatusOK, &merged)
+	if merged.Duration != 60 {
+		t.Errorf("merged = %+v", merged)
+	}
+	// The merged session replaces the parts.
+	first = merged
+	decode(t, do(t, h, http.MethodPost, "/api/v0/merge-sessions", models.MergeSessionsRequest{SessionIDs: []int64{first.ID, second.ID}}),
+		http.StatusBadRequest, nil)
+
+	var adjusted models.ActivitySession
+	adjust := models.AdjustSessionRequest{SessionID: first.ID, StartTime: start.Unix(), EndTime: start.Add(2 * time.Minute).Unix()}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/adjust-session", adjust), http.StatusOK, &adjusted)
+	if adjusted.Duration != 120 {
+		t.Errorf("adjusted = %+v", adjusted)
+	}
+
+	tests := []struct {
+		name, path string
+		body       interface{}
+		status     int
+	}{
+		{"missing session", "/api/v0/adjust-session", models.AdjustSessionRequest{SessionID: 999, StartTime: 1, EndTime: 2}, http.StatusNotFound},
+		{"overlap", "/api/v0/adjust-session", models.AdjustSessionRequest{SessionID: first.ID, StartTime: start.Unix(), EndTime: start.Add(11 * time.Minute).Unix()}, http.StatusConflict},
+		{"split outside", "/api/v0/split-session", models.SplitSessionRequest{SessionID: first.ID, At: start.Unix()}, http.StatusBadRequest},
+		{"merge one", "/api/v0/merge-sessions", models.MergeSessionsRequest{SessionIDs: []int64{first.ID}}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if rec := do(t, h, http.MethodPost, test.path, test.body); rec.Code != test.status {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, rec.Code, test.status, rec.Body)
+		}
+	}
+	decode(t, do(t, h, http.MethodGet, "/api/v0/adjust-session", nil), http.StatusMethodNotAllowed, nil)
+}
+
+func TestManualEntryEndpoint(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := NewServer(store).Handler()
+
+	var session models.ActivitySession
+	entry := models.ManualEntryRequest{DurationSeconds: 900, UserDefinedName: "Reading", Tags: []string{"books"}}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/manual-entry", entry), http.StatusCreated, &session)
+	if session.Source != models.SourceManual || session.Duration != 900 || session.ClassificationID == nil {
+		t.Errorf("manual entry = %+v", session)
+	}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/manual-entry", entry), http.StatusConflict, nil)
+	decode(t, do(t, h, http.MethodPost, "/api/v0/manual-entry", models.ManualEntryRequest{DurationSeconds: 60}), http.StatusBadRequest, nil)
+	decode(t, do(t, h, http.MethodGet, "/api/v0/manual-entry", nil), http.StatusMethodNotAllowed, nil)
+}
+
+func TestTrashEndpoints(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := NewServer(store).Handler()
+	start := time.Now().Add(-time.Hour)
+	track(t, store, start, "Code", "main.go")
+	track(t, store, start.Add(10*time.Minute), "Slack", "general")
+	var sessions []models.ActivitySession
+	decode(t, do(t, h, http.MethodGet, "/api/v0/unclassified-sessions", nil), http.StatusOK, &sessions)
+
+	var trashed map[string]int64
+	decode(t, do(t, h, http.MethodPost, "/api/v0/delete-sessions", models.BulkDeleteRequest{AppName: "Code"}), http.StatusOK, &trashed)
+	if trashed["trashed"] != 1 {
+		t.Errorf("bulk delete = %v, want 1 trashed", trashed)
+	}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/delete-sessions", models.BulkDeleteRequest{}), http.StatusBadRequest, nil)
+	for _, session := range sessions {
+		if session.AppName == "Slack" {
+			decode(t, do(t, h, http.MethodDelete, "/api/v0/delete-session?id="+strconv.FormatInt(session.ID, 10), nil), http.StatusOK, nil)
+		}
+	}
+	decode(t, do(t, h, http.MethodDelete, "/api/v0/delete-session?id=x", nil), http.StatusBadRequest, nil)
+
+	var trash []models.ActivitySession
+	decode(t, do(t, h, http.MethodGet, "/api/v0/trash", nil), http.StatusOK, &trash)
+	if len(trash) != 2 {
+		t.Fatalf("trash = %+v, want both sessions", trash)
+	}
+
+	var restored map[string]int64
+	req := models.RestoreSessionsRequest{SessionIDs: []int64{trash[0].ID, trash[1].ID}}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/restore-sessions", req), http.StatusOK, &restored)
+	if restored["restored"] != 2 {
+		t.Errorf("restore = %v, want 2 restored", restored)
+	}
+	decode(t, do(t, h, http.MethodGet, "/api/v0/trash", nil), http.StatusOK, &trash)
+	if len(trash) != 0 {
+		t.Errorf("trash = %+v after restoring everything", trash)
+	}
+	decode(t, do(t, h, http.MethodGet, "/api/v0/restore-sessions", nil), http.StatusMethodNotAllowed, nil)
+}
+
+func TestTagEndpoints(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := NewServer(store).Handler()
+	track(t, store, time.Now().Add(-time.Hour), "Slack", "acme")
+	var sessions []models.ActivitySession
+	decode(t, do(t, h, http.MethodGet, "/api/v0/unclassified-sessions", nil), http.StatusOK, &sessions)
+	id := sessions[0].ID
+
+	var tagged map[string]int64
+	decode(t, do(t, h, http.MethodPost, "/api/v0/session-tags", models.TagSessionsRequest{SessionIDs: []int64{id}, Add: []string{"billable"}}), http.StatusOK, &tagged)
+	if tagged["tagged"] != 1 {
+		t.Errorf("tagging = %v, want 1 tagged", tagged)
+	}
+	decode(t, do(t, h, http.MethodPost, "/api/v0/session-tags", models.TagSessionsRequest{SessionIDs: []int64{id}}), http.StatusBadRequest, nil)
+	decode(t, do(t, h, http.MethodPost, "/api/v0/session-tags", models.TagSessionsRequest{SessionIDs: []int64{999}, Add: []string{"x"}}), http.StatusNotFound, nil)
+
+	var session models.ActivitySession
+	decode(t, do(t, h, http.MethodPost, "/api/v0/session-note", models.SessionNoteRequest{SessionID: id, Note: "Support call"}), http.StatusOK, &session)
+	if session.Note != "Support call" {
+		t.Errorf("noted session = %+v", session)
+	}
+
+	decode(t, do(t, h, http.MethodGet, "/api/v0/search?q=support&tag=billable", nil), http.StatusOK, &sessions)
+	if len(sessions) != 1 || sessions[0].ID != id {
+		t.Errorf("search = %+v, want the tagged session", sessions)
+	}
+	decode(t, do(t, h, http.MethodGet, "/api/v0/search?q=support&tag=other", nil), http.StatusOK, &sessions)
+	if len(sessions) != 0 {
+		t.Errorf("search with another tag = %+v, want nothing", sessions)
+	}
+	decode(t, do(t, h, http.MethodGet, "/api/v0/search?limit=0", nil), http.StatusBadRequest, nil)
+	decode(t, do(t, h, http.MethodPost, "/api/v0/search", nil), http.StatusMethodNotAllowed, nil)
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+// WithSync enables the endpoints other PersonalOS instances sync through.
+// Requests must carry token as a bearer token; with an empty token the
+// endpoints are not served.
+func WithSync(store storage.SyncStore, token string) Option {
+	return func(s *Server) {
+		s.sync = store
+		s.syncToken = token
+	}
+}
+
+// handleSyncChanges serves this device's changes on GET and merges a peer's
+// changes on POST.
+func (s *Server) handleSyncChanges(w http.ResponseWriter, r *http.Request) {
+	if !s.syncAuthorized(r) {
+		http.Error(w, "Invalid or missing sync token", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		if err != nil && r.URL.Query().Get("since") != "" {
+			http.Error(w, "Invalid since parameter", http.StatusBadRequest)
+			return
+		}
+		batch, err := s.sync.ChangesSince(since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, batch)
+	case http.MethodPost:
+		var batch models.SyncBatch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		result, err := s.sync.MergeChanges(batch)
+		if errors.Is(err, storage.ErrSyncSelf) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, result)
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) syncAuthorized(r *http.Request) bool {
+	if s.syncToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.syncToken)) == 1
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+func newSyncStore(t *testing.T, device string) *storage.DBStore {
+	t.Helper()
+	s, err := storage.NewDBStore(filepath.Join(t.TempDir(), device+".db"), storage.WithDeviceID(device))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// syncRequest sends a sync request with the given bearer token, if any.
+func syncRequest(h http.Handler, method, token string, body *models.SyncBatch) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/v0/sync/changes?since=0", nil)
+	if body != nil {
+		req = httptest.NewRequest(method, "/api/v0/sync/changes", jsonBody(body))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSyncRequiresToken(t *testing.T) {
+	store := newSyncStore(t, "desktop")
+
+	// Without a configured token the endpoints are not served at all.
+	open := NewServer(store, WithSync(store, "")).Handler()
+	for _, token := range []string{"", " "} {
+		if rec := syncRequest(open, http.MethodGet, token, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("sync without a configured token and header %q: status %d", token, rec.Code)
+		}
+	}
+
+	h := NewServer(store, WithSync(store, "secret")).Handler()
+	for _, token := range []string{"", "wrong", "secret "} {
+		if rec := syncRequest(h, http.MethodGet, token, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("sync with token %q: status %d, want 401", token, rec.Code)
+		}
+	}
+	var batch models.SyncBatch
+	decode(t, syncRequest(h, http.MethodGet, "secret", nil), http.StatusOK, &batch)
+	if batch.DeviceID != "desktop" {
+		t.Errorf("batch from device %q", batch.DeviceID)
+	}
+
+	// A peer URL pointing back at this instance is refused.
+	decode(t, syncRequest(h, http.MethodPost, "secret", &batch), http.StatusBadRequest, nil)
+	decode(t, syncRequest(h, http.MethodPut, "secret", nil), http.StatusMethodNotAllowed, nil)
+}
@@ -0,0 +1,277 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imdawon/personalos/storage"
+)
+
+// timestampLayout is embedded in backup file names so they sort chronologically.
+const timestampLayout = "20060102-150405"
+
+// Backupper is a store that can write an online snapshot of itself.
+type Backupper interface {
+	Backup(dest string) error
+}
+
+// Retention describes how many backups survive pruning: the newest backup of
+// each of the last Daily days, Weekly ISO weeks and Monthly months is kept.
+// The most recent backup is always kept.
+type Retention struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Scheduler takes periodic online backups into a directory and prunes old ones.
+type Scheduler struct {
+	store     Backupper
+	dir       string
+	prefix    string
+	interval  time.Duration
+	retention Retention
+	quit      chan struct{}
+}
+
+// NewScheduler creates a Scheduler for the database at dbPath. Backups are
+// named after the database file, e.g. personal_os-20261018-150405.db.
+func NewScheduler(store Backupper, dbPath, dir string, interval time.Duration, retention Retention) *Scheduler {
+	base := filepath.Base(dbPath)
+	return &Scheduler{
+		store:     store,
+		dir:       dir,
+		prefix:    strings.TrimSuffix(base, filepath.Ext(base)) + "-",
+		interval:  interval,
+		retention: retention,
+		quit:      make(chan struct{}),
+	}
+}
+
+// Start runs a backup immediately if the newest one is older than the
+// interval, then keeps backing up on every tick.
+func (s *Scheduler) Start() {
+	log.Printf("Backups enabled: every %v into %s", s.interval, s.dir)
+
+	go func() {
+		if latest, ok := s.latest(); !ok || time.Since(latest) >= s.interval {
+			s.run()
+		}
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.run()
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the scheduler.
+func (s *Scheduler) Stop() {
+	close(s.quit)
+}
+
+func (s *Scheduler) run() {
+	path, err := s.RunOnce()
+	if err != nil {
+		log.Printf("Backup failed: %v", err)
+		return
+	}
+	log.Printf("Backup written to %s", path)
+}
+
+// RunOnce takes a backup now and prunes according to the retention policy.
+// It returns the path of the new backup.
+func (s *Scheduler) RunOnce() (string, error) {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	dest := filepath.Join(s.dir, s.prefix+now.Format(timestampLayout)+".db")
+	// Write under a temporary name so a crash never leaves a truncated file
+	// that looks like a valid backup.
+	partial := dest + ".partial"
+	os.Remove(partial)
+	if err := s.store.Backup(partial); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	if err := os.Rename(partial, dest); err != nil {
+		return "", err
+	}
+
+	removed, err := s.Prune()
+	if err != nil {
+		return dest, fmt.Errorf("backup written but pruning failed: %w", err)
+	}
+	for _, path := range removed {
+		log.Printf("Pruned old backup %s", path)
+	}
+	return dest, nil
+}
+
+// snapshot is a backup file and the time encoded in its name.
+type snapshot struct {
+	path string
+	at   time.Time
+}
+
+// list returns this scheduler's backups, newest first.
+func (s *Scheduler) list() ([]snapshot, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var snapshots []snapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, s.prefix) || !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, s.prefix), ".db")
+		at, err := time.ParseInLocation(timestampLayout, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{path: filepath.Join(s.dir, name), at: at})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].at.After(snapshots[j].at) })
+	return snapshots, nil
+}
+
+func (s *Scheduler) latest() (time.Time, bool) {
+	snapshots, err := s.list()
+	if err != nil || len(snapshots) == 0 {
+		return time.Time{}, false
+	}
+	return snapshots[0].at, true
+}
+
+// Prune deletes backups not covered by the retention policy and returns the
+// paths it removed.
+func (s *Scheduler) Prune() ([]string, error) {
+	snapshots, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool)
+	if len(snapshots) > 0 {
+		keep[snapshots[0].path] = true
+	}
+
+	// keepNewestPer walks snapshots newest first and keeps the first one seen
+	// in each of the most recent n periods.
+	keepNewestPer := func(n int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for _, snap := range snapshots {
+			if len(seen) >= n {
+				return
+			}
+			key := period(snap.at)
+			if !seen[key] {
+				seen[key] = true
+				keep[snap.path] = true
+			}
+		}
+	}
+	keepNewestPer(s.retention.Daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepNewestPer(s.retention.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepNewestPer(s.retention.Monthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	var removed []string
+	for _, snap := range snapshots {
+		if keep[snap.path] {
+			continue
+		}
+		if err := os.Remove(snap.path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, snap.path)
+	}
+	return removed, nil
+}
+
+// Restore replaces the database at dbPath with the backup at backupPath. The
+// backup is integrity-checked before anything is touched, and the current
+// database (with its WAL files) is kept next to it as
+// <dbPath>.before-restore-<timestamp>. The backend must not be running.
+func Restore(backupPath, dbPath string) (string, error) {
+	if err := storage.VerifyIntegrity(backupPath); err != nil {
+		return "", err
+	}
+
+	// Copy next to the target first so the final swap is an atomic rename on
+	// the same filesystem.
+	staged := dbPath + ".restoring"
+	if err := copyFile(backupPath, staged); err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+	if err := storage.VerifyIntegrity(staged); err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+
+	var aside string
+	if _, err := os.Stat(dbPath); err == nil {
+		aside = dbPath + ".before-restore-" + time.Now().Format(timestampLayout)
+		if err := os.Rename(dbPath, aside); err != nil {
+			os.Remove(staged)
+			return "", err
+		}
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if _, err := os.Stat(dbPath + suffix); err == nil {
+				if err := os.Rename(dbPath+suffix, aside+suffix); err != nil {
+					return aside, err
+				}
+			}
+		}
+	}
+
+	if err := os.Rename(staged, dbPath); err != nil {
+		return aside, err
+	}
+	return aside, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+func TestPruneKeepsNewestPerPeriod(t *testing.T) {
+	dir := t.TempDir()
+	s := NewScheduler(nil, "/data/personal_os.db", dir, time.Hour, Retention{Daily: 2, Weekly: 2, Monthly: 2})
+
+	stamps := []string{
+		"20261018-120000", // newest; also this week and month
+		"20261018-080000", // same day as a newer one
+		"20261017-120000", // second day
+		"20261016-120000", // beyond the daily window
+		"20261010-120000", // previous ISO week
+		"20261003-120000", // beyond the weekly window
+		"20260920-120000", // previous month
+		"20260815-120000", // beyond the monthly window
+	}
+	for _, stamp := range stamps {
+		touch(t, filepath.Join(dir, "personal_os-"+stamp+".db"))
+	}
+	// Files that are not this scheduler's backups are never pruned.
+	for _, name := range []string{"other-20260101-120000.db", "personal_os-latest.db", "personal_os-20260101-120000.db.partial"} {
+		touch(t, filepath.Join(dir, name))
+	}
+
+	removed, err := s.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var removedNames []string
+	for _, path := range removed {
+		removedNames = append(removedNames, filepath.Base(path))
+	}
+	slices.Sort(removedNames)
+	want := []string{
+		"personal_os-20260815-120000.db",
+		"personal_os-20261003-120000.db",
+		"personal_os-20261016-120000.db",
+		"personal_os-20261018-080000.db",
+	}
+	if !slices.Equal(removedNames, want) {
+		t.Fatalf("removed %v, want %v", removedNames, want)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(stamps)-len(want)+3 {
+		t.Fatalf("%d files left, want %d", len(entries), len(stamps)-len(want)+3)
+	}
+}
+
+func TestPruneAlwaysKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	s := NewScheduler(nil, "personal_os.db", dir, time.Hour, Retention{})
+	touch(t, filepath.Join(dir, "personal_os-20261018-120000.db"))
+	touch(t, filepath.Join(dir, "personal_os-20261017-120000.db"))
+
+	removed, err := s.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != "personal_os-20261017-120000.db" {
+		t.Fatalf("removed %v, want only the older backup", removed)
+	}
+}
+
+func TestRunOnceThenRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "personal_os.db")
+	store := openStore(t, dbPath)
+	addClassification(t, store, "Backed up")
+
+	s := NewScheduler(store, dbPath, filepath.Join(dir, "backups"), time.Hour, Retention{Daily: 1})
+	path, err := s.RunOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != filepath.Join(dir, "backups") || !strings.HasPrefix(filepath.Base(path), "personal_os-") {
+		t.Fatalf("backup written to %s", path)
+	}
+	if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+		t.Fatalf("partial backup left behind: %v", err)
+	}
+	if latest, ok := s.latest(); !ok || time.Since(latest) > time.Minute {
+		t.Fatalf("latest backup = %v, %v", latest, ok)
+	}
+
+	// Changes after the backup are lost by restoring it.
+	addClassification(t, store, "After backup")
+	store.Close()
+
+	aside, err := Restore(path, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(aside); err != nil {
+		t.Fatalf("previous database not kept aside: %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".restoring"); !os.IsNotExist(err) {
+		t.Fatalf("staged copy left behind: %v", err)
+	}
+
+	restored := openStore(t, dbPath)
+	if got := classificationNames(t, restored); !slices.Equal(got, []string{"Backed up"}) {
+		t.Fatalf("restored classifications = %v", got)
+	}
+	previous := openStore(t, aside)
+	if got := classificationNames(t, previous); len(got) != 2 {
+		t.Fatalf("set-aside classifications = %v, want both", got)
+	}
+}
+
+func TestRestoreRejectsCorruptBackup(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "personal_os.db")
+	store := openStore(t, dbPath)
+	addClassification(t, store, "Kept")
+	store.Close()
+
+	corrupt := filepath.Join(dir, "corrupt.db")
+	if err := os.WriteFile(corrupt, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(corrupt, dbPath); err == nil {
+		t.Fatal("restoring a corrupt backup succeeded")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); name != "personal_os.db" && name != "corrupt.db" && name != "personal_os.db-wal" && name != "personal_os.db-shm" {
+			t.Errorf("unexpected file %s after a rejected restore", name)
+		}
+	}
+	if got := classificationNames(t, openStore(t, dbPath)); !slices.Equal(got, []string{"Kept"}) {
+		t.Fatalf("classifications after a rejected restore = %v", got)
+	}
+}
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openStore(t *testing.T, path string) *storage.DBStore {
+	t.Helper()
+	store, err := storage.NewDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func addClassification(t *testing.T, store *storage.DBStore, name string) {
+	t.Helper()
+	if err := store.ApplyClassification(models.ClassificationRequest{AppName: "Editor", WindowTitle: "notes", UserDefinedName: name}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func classificationNames(t *testing.T, store *storage.DBStore) []string {
+	t.Helper()
+	classifications, err := store.ListClassifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range classifications {
+		names = append(names, c.UserDefinedName)
+	}
+	slices.Sort(names)
+	return names
+}
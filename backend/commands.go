@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/imdawon/personalos/backup"
+	"github.com/imdawon/personalos/config"
+	"github.com/imdawon/personalos/encryption"
+	"github.com/imdawon/personalos/export"
+	"github.com/imdawon/personalos/importer"
+	"github.com/imdawon/personalos/invoice"
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/peersync"
+	"github.com/imdawon/personalos/storage"
+)
+
+// usage prints the top-level help, including the available subcommands.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-config file] [command] [args]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Without a command, the tracking backend is started.")
+	fmt.Fprintln(out, "\nCommands:")
+	fmt.Fprintln(out, "  backup              take a backup of the SQLite database now")
+	fmt.Fprintln(out, "  restore <file>      verify a backup and restore it over the database")
+	fmt.Fprintln(out, "  purge               permanently delete history for an app or title pattern")
+	fmt.Fprintln(out, "  rotate-key          re-encrypt window titles with a new key")
+	fmt.Fprintln(out, "  export              write history as JSON, NDJSON or CSV")
+	fmt.Fprintln(out, "  import <file>       import ActivityWatch, RescueTime or Toggl history")
+	fmt.Fprintln(out, "  sync                exchange changes with other PersonalOS instances now")
+	fmt.Fprintln(out, "  invoice             write a client's monthly invoice as HTML and PDF")
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// runCommand executes a one-off subcommand.
+func runCommand(cfg config.Config, args []string) error {
+	switch args[0] {
+	case "backup":
+		return runBackup(cfg)
+	case "restore":
+		return runRestore(cfg, args[1:])
+	case "purge":
+		return runPurge(cfg, args[1:])
+	case "rotate-key":
+		return runRotateKey(cfg, args[1:])
+	case "export":
+		return runExport(cfg, args[1:])
+	case "import":
+		return runImport(cfg, args[1:])
+	case "sync":
+		return runSync(cfg, args[1:])
+	case "invoice":
+		return runInvoice(cfg, args[1:])
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func runBackup(cfg config.Config) error {
+	if cfg.DatabaseURL != "" {
+		return errors.New("backups are only supported for SQLite; use pg_dump for PostgreSQL")
+	}
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	path, err := newBackupScheduler(cfg, store).RunOnce()
+	if err != nil {
+		return err
+	}
+	log.Printf("Backup written to %s", path)
+	return nil
+}
+
+func runRestore(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: restore <backup-file>")
+		fmt.Fprintln(fs.Output(), "Stop the backend before restoring.")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one backup file")
+	}
+	if cfg.DatabaseURL != "" {
+		return errors.New("restore is only supported for SQLite; use pg_restore for PostgreSQL")
+	}
+
+	aside, err := backup.Restore(fs.Arg(0), cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	if aside != "" {
+		log.Printf("Previous database kept at %s", aside)
+	}
+	log.Printf("Restored %s from %s", cfg.DBPath, fs.Arg(0))
+	return nil
+}
+
+func runPurge(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	var req models.PurgeRequest
+	fs.StringVar(&req.AppName, "app", "", "purge history of this app")
+	fs.StringVar(&req.TitlePattern, "title", "", "purge history whose window title matches this LIKE pattern, e.g. %bank%")
+	fs.BoolVar(&req.DryRun, "dry-run", false, "only count what would be deleted")
+	fs.Parse(args)
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	result, err := store.PurgeHistory(req)
+	if err != nil {
+		return err
+	}
+	verb := "Deleted"
+	if result.DryRun {
+		verb = "Would delete"
+	}
+	log.Printf("%s %d sessions (%v) and %d pending raw events", verb, result.Sessions,
+		time.Duration(result.TotalDuration)*time.Second, result.RawEvents)
+	return nil
+}
+
+func runRotateKey(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	newKeyFile := fs.String("new-key-file", "", "re-encrypt with the key in this file")
+	generate := fs.Bool("generate", false, "write a new random key to -new-key-file first")
+	newPassphraseEnv := fs.String("new-passphrase-env", "", "re-encrypt with a passphrase read from this environment variable")
+	disable := fs.Bool("disable", false, "decrypt all titles and store them in plaintext")
+	fs.Parse(args)
+
+	var next *encryption.KeySource
+	switch {
+	case *disable:
+	case *newKeyFile != "":
+		if *generate {
+			key, err := encryption.GenerateKey()
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(*newKeyFile, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+				return err
+			}
+			log.Printf("Generated a new key in %s", *newKeyFile)
+		}
+		fallthrough
+	case *newPassphraseEnv != "":
+		key, err := titleKeySource(*newKeyFile, *newPassphraseEnv)
+		if err != nil {
+			return err
+		}
+		next = &key
+	default:
+		fs.Usage()
+		return errors.New("choose -new-key-file, -new-passphrase-env or -disable")
+	}
+
+	// The store opens with the current key from the config.
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.RotateTitleKey(next); err != nil {
+		return err
+	}
+	if next == nil {
+		log.Println("Window titles are now stored in plaintext; remove the encryption settings from the config.")
+	} else {
+		log.Println("Window titles re-encrypted; point the encryption settings in the config at the new key.")
+	}
+	return nil
+}
+
+func runExport(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := fs.String("format", "json", "json, ndjson or csv")
+	tableName := fs.String("table", "", "only export sessions, classifications, rules, projects, project_rules or away_periods (csv defaults to sessions)")
+	startDate := fs.String("start", "", "first day to export, YYYY-MM-DD or RFC 3339")
+	endDate := fs.String("end", "", "last day to export (inclusive), YYYY-MM-DD or RFC 3339")
+	tag := fs.String("tag", "", "only export sessions carrying this tag")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	fs.Parse(args)
+
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	table, err := export.ParseTable(*tableName)
+	if err != nil {
+		return err
+	}
+	start, end, err := export.ParseRange(*startDate, *endDate)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := bufio.NewWriter(out)
+	opts := export.Options{Format: format, Table: table, Start: start, End: end, Tag: *tag}
+	if err := export.Write(w, store, opts, time.Now()); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func runImport(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	source := fs.String("source", "", "format of the file: activitywatch, rescuetime or toggl")
+	var opts storage.ImportOptions
+	fs.BoolVar(&opts.ApplyRules, "apply-rules", false, "classify imported sessions with the classification rules")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "only report what would be imported")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: import -source activitywatch|rescuetime|toggl [-apply-rules] [-dry-run] <file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one export file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sessions, err := importer.Parse(*source, f)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	result, err := store.ImportSessions(sessions, opts)
+	if err != nil {
+		return err
+	}
+	verb := "Imported"
+	if opts.DryRun {
+		verb = "Would import"
+	}
+	log.Printf("%s %d sessions from %d %s entries (%d classified); %d trimmed and %d skipped as already recorded",
+		verb, result.Imported, len(sessions), *source, result.Classified, result.Trimmed, result.Duplicates)
+	return nil
+}
+
+func runSync(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	peer := fs.String("peer", "", "base URL of the instance to sync with (default: the configured peers)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sync [-peer http://host:port]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	peers := cfg.Sync.Peers
+	if *peer != "" {
+		peers = []string{*peer}
+	}
+	if len(peers) == 0 {
+		fs.Usage()
+		return errors.New("no peer given and none configured")
+	}
+	token, err := syncToken(cfg.Sync)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	client := peersync.NewClient(store, token)
+	for _, p := range peers {
+		result, err := client.Sync(p)
+		if err != nil {
+			return err
+		}
+		log.Printf("Synced with %s: %s", p, peersync.Summary(result))
+	}
+	return nil
+}
+
+func runInvoice(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("invoice", flag.ExitOnError)
+	client := fs.String("client", "", "the client to invoice, as set on its projects")
+	month := fs.String("month", time.Now().AddDate(0, -1, 0).Format("2006-01"), "the month to invoice, YYYY-MM")
+	rounding := fs.Int("rounding", cfg.Invoice.RoundingMinutes, "round time per project and day up to 0, 6 or 15 minutes")
+	htmlTemplate := fs.String("template", cfg.Invoice.HTMLTemplate, "html/template file for the HTML invoice (default: built in)")
+	pdfTemplate := fs.String("pdf-template", cfg.Invoice.PDFTemplate, "text/template file laid out in the PDF invoice (default: built in)")
+	output := fs.String("o", "", "output path without extension (default: invoice-<client>-<month>)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: invoice -client name [-month YYYY-MM] [-rounding 0|6|15] [-o path]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *client == "" {
+		fs.Usage()
+		return errors.New("a client is required")
+	}
+	switch *rounding {
+	case 0, 6, 15:
+	default:
+		return fmt.Errorf("unknown rounding %d (want 0, 6 or 15 minutes)", *rounding)
+	}
+	templates, err := invoice.LoadTemplates(*htmlTemplate, *pdfTemplate)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	inv, err := invoice.Build(store, *client, *month, *rounding, time.Now())
+	if err != nil {
+		return err
+	}
+	base := *output
+	if base == "" {
+		base = fmt.Sprintf("invoice-%s-%s", *client, *month)
+	}
+	if err := writeInvoice(base+".html", inv, templates.HTML); err != nil {
+		return err
+	}
+	if err := writeInvoice(base+".pdf", inv, templates.PDF); err != nil {
+		return err
+	}
+	log.Printf("Wrote %s.html and %s.pdf: %s hours, %s %s", base, base, invoice.Hours(inv.RoundedSeconds), invoice.Money(inv.Amount), inv.Currency)
+	return nil
+}
+
+// writeInvoice renders an invoice into a new file.
+func writeInvoice(path string, inv invoice.Invoice, render func(io.Writer, invoice.Invoice) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := render(f, inv); err != nil {
+		f.Close()
+		return fmt.Errorf("rendering %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,177 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// KeySize is the length in bytes of a master key.
+const KeySize = 32
+
+// sealedPrefix marks values encrypted by a Cipher. Values without it are
+// treated as plaintext, so databases can hold rows written before encryption
+// was enabled.
+const sealedPrefix = "enc:v1:"
+
+// ErrKeyRequired is returned when opening an encrypted value without a key.
+var ErrKeyRequired = errors.New("value is encrypted but no title key is configured")
+
+// KeySource describes where the master key comes from: a key file, or a
+// passphrase stretched with Argon2id.
+type KeySource struct {
+	KeyFile    string
+	Passphrase string
+}
+
+// NewCipher builds a Cipher from the key source. salt is only used for
+// passphrases and must be stored alongside the data.
+func (k KeySource) NewCipher(salt []byte) (*Cipher, error) {
+	switch {
+	case k.KeyFile != "":
+		key, err := LoadKeyFile(k.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewCipher(key)
+	case k.Passphrase != "":
+		return NewCipher(DeriveKey(k.Passphrase, salt))
+	default:
+		return nil, errors.New("key source needs a key file or a passphrase")
+	}
+}
+
+// LoadKeyFile reads a master key stored as 32 raw bytes, 64 hex characters or
+// standard base64.
+func LoadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == KeySize {
+		return data, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key file %s must contain a %d-byte key (raw, hex or base64)", path, KeySize)
+}
+
+// GenerateKey returns a new random master key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// DeriveKey stretches a passphrase into a master key with Argon2id.
+func DeriveKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, 3, 64*1024, 4, KeySize)
+}
+
+// Cipher encrypts values with AES-256-GCM and derives deterministic HMAC
+// lookup hashes, so equal plaintexts can still be matched and grouped.
+//
+// A nil *Cipher is valid and means encryption is disabled: Seal and Open pass
+// values through and Hash falls back to an unkeyed SHA-256.
+type Cipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewCipher creates a Cipher from a 32-byte master key. Separate subkeys are
+// derived for encryption and hashing.
+func NewCipher(masterKey []byte) (*Cipher, error) {
+	if len(masterKey) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(masterKey))
+	}
+
+	block, err := aes.NewCipher(subkey(masterKey, "personalos title encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead, macKey: subkey(masterKey, "personalos title lookup")}, nil
+}
+
+func subkey(masterKey []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Seal encrypts plain. Empty strings stay empty so blanked titles need no key.
+func (c *Cipher) Seal(plain string) (string, error) {
+	if c == nil || plain == "" {
+		return plain, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal. Plaintext values are returned as is.
+func (c *Cipher) Open(stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return stored, nil
+	}
+	if c == nil {
+		return "", ErrKeyRequired
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("malformed encrypted value: too short")
+	}
+	plain, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt value, wrong key?: %w", err)
+	}
+	return string(plain), nil
+}
+
+// Hash returns the deterministic lookup hash of plain.
+func (c *Cipher) Hash(plain string) string {
+	if c == nil {
+		sum := sha256.Sum256([]byte(plain))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(plain))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// KeyCheck returns a value that identifies the key without revealing it. It is
+// stored with the data to reject a wrong key before anything is written.
+func (c *Cipher) KeyCheck() string {
+	if c == nil {
+		return ""
+	}
+	return c.Hash("personalos key check")
+}
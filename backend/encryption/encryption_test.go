@@ -0,0 +1,187 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestCipher(t *testing.T) *Cipher {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSealOpen(t *testing.T) {
+	c := newTestCipher(t)
+
+	sealed, err := c.Seal("Quarterly report.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, sealedPrefix) || strings.Contains(sealed, "Quarterly") {
+		t.Fatalf("sealed value %q", sealed)
+	}
+	again, err := c.Seal("Quarterly report.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Error("sealing twice produced the same ciphertext")
+	}
+	for _, value := range []string{sealed, again} {
+		if plain, err := c.Open(value); err != nil || plain != "Quarterly report.xlsx" {
+			t.Errorf("Open(%q) = %q, %v", value, plain, err)
+		}
+	}
+
+	// Blank titles and rows written before encryption need no key.
+	if sealed, err := c.Seal(""); err != nil || sealed != "" {
+		t.Errorf("Seal(\"\") = %q, %v", sealed, err)
+	}
+	if plain, err := c.Open("written before encryption"); err != nil || plain != "written before encryption" {
+		t.Errorf("Open(plaintext) = %q, %v", plain, err)
+	}
+}
+
+func TestOpenFailures(t *testing.T) {
+	c := newTestCipher(t)
+	sealed, err := c.Seal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var disabled *Cipher
+	if _, err := disabled.Open(sealed); !errors.Is(err, ErrKeyRequired) {
+		t.Errorf("opening without a key: %v, want ErrKeyRequired", err)
+	}
+	if _, err := newTestCipher(t).Open(sealed); err == nil {
+		t.Error("opening with the wrong key succeeded")
+	}
+	raw, _ := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	raw[len(raw)-1] ^= 1
+	if _, err := c.Open(sealedPrefix + base64.RawStdEncoding.EncodeToString(raw)); err == nil {
+		t.Error("opening a tampered value succeeded")
+	}
+	for _, malformed := range []string{sealedPrefix + "!!!", sealedPrefix + "AAAA"} {
+		if _, err := c.Open(malformed); err == nil {
+			t.Errorf("opening %q succeeded", malformed)
+		}
+	}
+}
+
+func TestDisabledCipherPassesThrough(t *testing.T) {
+	var c *Cipher
+	if sealed, err := c.Seal("plain"); err != nil || sealed != "plain" {
+		t.Errorf("Seal = %q, %v", sealed, err)
+	}
+	if c.KeyCheck() != "" {
+		t.Error("a disabled cipher has a key check")
+	}
+	if c.Hash("plain") != c.Hash("plain") || c.Hash("plain") == c.Hash("other") {
+		t.Error("unkeyed hashes are not a deterministic lookup")
+	}
+}
+
+func TestHashIsKeyed(t *testing.T) {
+	a, b := newTestCipher(t), newTestCipher(t)
+	var none *Cipher
+
+	if a.Hash("title") != a.Hash("title") {
+		t.Error("hash is not deterministic")
+	}
+	if a.Hash("title") == b.Hash("title") || a.Hash("title") == none.Hash("title") {
+		t.Error("hashes under different keys match")
+	}
+	if a.KeyCheck() == b.KeyCheck() || a.KeyCheck() == "" {
+		t.Errorf("key checks %q and %q", a.KeyCheck(), b.KeyCheck())
+	}
+}
+
+func TestNewCipherRejectsShortKeys(t *testing.T) {
+	if _, err := NewCipher(make([]byte, 16)); err == nil {
+		t.Error("NewCipher accepted a 16-byte key")
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{"raw", key, false},
+		{"hex", []byte(hex.EncodeToString(key) + "\n"), false},
+		{"base64", []byte(base64.StdEncoding.EncodeToString(key) + "\n"), false},
+		{"short", key[:16], true},
+		{"short hex", []byte(hex.EncodeToString(key[:16]) + "\n"), true},
+		{"garbage", []byte("not a key"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "title.key")
+			if err := os.WriteFile(path, tt.content, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadKeyFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("LoadKeyFile succeeded with %x", got)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(got, key) {
+				t.Fatalf("LoadKeyFile = %x, %v, want %x", got, err, key)
+			}
+		})
+	}
+
+	if _, err := LoadKeyFile(filepath.Join(t.TempDir(), "missing.key")); err == nil {
+		t.Error("LoadKeyFile succeeded for a missing file")
+	}
+}
+
+func TestKeySourcePassphrase(t *testing.T) {
+	source := KeySource{Passphrase: "correct horse battery staple"}
+	salt := []byte("0123456789abcdef")
+
+	a, err := source.NewCipher(salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := source.NewCipher(salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.KeyCheck() != b.KeyCheck() {
+		t.Error("the same passphrase and salt derived different keys")
+	}
+	c, err := source.NewCipher([]byte("fedcba9876543210"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.KeyCheck() == c.KeyCheck() {
+		t.Error("different salts derived the same key")
+	}
+
+	if _, err := (KeySource{}).NewCipher(salt); err == nil {
+		t.Error("an empty key source built a cipher")
+	}
+}
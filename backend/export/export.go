@@ -0,0 +1,375 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+// SchemaVersion is bumped whenever a field is renamed, removed or changes
+// meaning. Adding fields does not bump it. See docs/export-schema.md at the
+// repository root.
+const SchemaVersion = 1
+
+// Format is an export file format.
+type Format string
+
+const (
+	JSON   Format = "json"
+	NDJSON Format = "ndjson"
+	CSV    Format = "csv"
+)
+
+// ParseFormat validates a format name. An empty name means JSON.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case "":
+		return JSON, nil
+	case JSON, NDJSON, CSV:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown export format %q (want json, ndjson or csv)", name)
+	}
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case NDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/json"
+	}
+}
+
+// Table is one kind of exported record.
+type Table string
+
+const (
+	Sessions        Table = "sessions"
+	Classifications Table = "classifications"
+	Rules           Table = "rules"
+	AwayPeriods     Table = "away_periods"
+	Projects        Table = "projects"
+	ProjectRules    Table = "project_rules"
+)
+
+// allTables is the order tables are written in.
+var allTables = []Table{Classifications, Rules, Projects, ProjectRules, AwayPeriods, Sessions}
+
+// ParseTable validates a table name. An empty name selects every table.
+func ParseTable(name string) (Table, error) {
+	switch t := Table(name); t {
+	case "", Sessions, Classifications, Rules, AwayPeriods, Projects, ProjectRules:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown export table %q (want sessions, classifications, rules, projects, project_rules or away_periods)", name)
+	}
+}
+
+// Options select what is exported.
+type Options struct {
+	Format Format
+	// Table limits the export to one table. CSV holds a single table and
+	// defaults to sessions; the other formats default to all of them.
+	Table Table
+	// Start and End bound sessions by start time and away periods by
+	// overlap. Zero leaves that side open. Classifications, projects and
+	// rules are always exported in full.
+	Start, End time.Time
+	// Tag limits sessions to those carrying it. Empty exports every session.
+	Tag string
+}
+
+// ParseRange parses the start and end of a date range. Each may be empty, a
+// date (YYYY-MM-DD, local time) or an RFC 3339 timestamp. An end date is
+// inclusive, so 2024-01-01..2024-01-31 covers all of January.
+func ParseRange(start, end string) (time.Time, time.Time, error) {
+	from, err := parseBound(start, false)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := parseBound(end, true)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end %s is not after start %s", end, start)
+	}
+	return from, to, nil
+}
+
+func parseBound(value string, isEnd bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if isEnd {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or RFC 3339", value)
+	}
+	return t, nil
+}
+
+// header describes the export as a whole.
+type header struct {
+	SchemaVersion int    `json:"schema_version"`
+	ExportedAt    int64  `json:"exported_at"`
+	Start         *int64 `json:"start"`
+	End           *int64 `json:"end"`
+	Tag           string `json:"tag,omitempty"`
+}
+
+func newHeader(opts Options, now time.Time) header {
+	h := header{SchemaVersion: SchemaVersion, ExportedAt: now.Unix(), Tag: opts.Tag}
+	if !opts.Start.IsZero() {
+		start := opts.Start.Unix()
+		h.Start = &start
+	}
+	if !opts.End.IsZero() {
+		end := opts.End.Unix()
+		h.End = &end
+	}
+	return h
+}
+
+// Write streams an export from src to w.
+func Write(w io.Writer, src storage.ExportReader, opts Options, now time.Time) error {
+	tables := allTables
+	if opts.Table != "" {
+		tables = []Table{opts.Table}
+	}
+
+	switch opts.Format {
+	case CSV:
+		table := opts.Table
+		if table == "" {
+			table = Sessions
+		}
+		return writeCSV(w, src, opts, table)
+	case NDJSON:
+		return writeNDJSON(w, src, opts, tables, now)
+	default:
+		return writeJSON(w, src, opts, tables, now)
+	}
+}
+
+// each calls fn with every record of a table.
+func each(src storage.ExportReader, opts Options, table Table, fn func(record interface{}) error) error {
+	switch table {
+	case Sessions:
+		filter := storage.SessionFilter{Start: opts.Start, End: opts.End, Tag: opts.Tag}
+		return src.EachSession(filter, func(s models.ActivitySession) error {
+			return fn(s)
+		})
+	case Classifications:
+		classifications, err := src.ListClassifications()
+		if err != nil {
+			return err
+		}
+		for _, c := range classifications {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+	case Rules:
+		rules, err := src.ListRules()
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+	case Projects:
+		projects, err := src.ListProjects()
+		if err != nil {
+			return err
+		}
+		for _, p := range projects {
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+	case ProjectRules:
+		rules, err := src.ListProjectRules()
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+	case AwayPeriods:
+		periods, err := src.GetAwayPeriods(opts.Start, opts.End)
+		if err != nil {
+			return err
+		}
+		for _, p := range periods {
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeJSON writes a single document holding the header fields and one array
+// per table. Records are encoded one at a time so sessions are never all in
+// memory.
+func writeJSON(w io.Writer, src storage.ExportReader, opts Options, tables []Table, now time.Time) error {
+	h, err := json.Marshal(newHeader(opts, now))
+	if err != nil {
+		return err
+	}
+	// Reopen the header object to append the tables to it.
+	if _, err := w.Write(h[:len(h)-1]); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err := fmt.Fprintf(w, ",%q:[", table); err != nil {
+			return err
+		}
+		first := true
+		err := each(src, opts, table, func(record interface{}) error {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+			_, err = w.Write(data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "]"); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "}\n")
+	return err
+}
+
+// recordTypes names each table's records in NDJSON.
+var recordTypes = map[Table]string{
+	Sessions:        "session",
+	Classifications: "classification",
+	Rules:           "rule",
+	AwayPeriods:     "away_period",
+	Projects:        "project",
+	ProjectRules:    "project_rule",
+}
+
+// writeNDJSON writes a header line followed by one line per record, each
+// tagged with its type.
+func writeNDJSON(w io.Writer, src storage.ExportReader, opts Options, tables []Table, now time.Time) error {
+	enc := json.NewEncoder(w)
+	err := enc.Encode(struct {
+		Type string `json:"type"`
+		header
+	}{"header", newHeader(opts, now)})
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		recordType := recordTypes[table]
+		err := each(src, opts, table, func(record interface{}) error {
+			return enc.Encode(struct {
+				Type string      `json:"type"`
+				Data interface{} `json:"data"`
+			}{recordType, record})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvColumns lists the header row of each table.
+var csvColumns = map[Table][]string{
+	Sessions:        {"id", "app_name", "window_title", "start_time", "end_time", "duration_seconds", "classification_id", "source", "note", "tags", "url", "cwd", "project_id"},
+	Classifications: {"id", "user_defined_name", "is_helpful", "goal_context", "parent_id"},
+	Rules:           {"id", "app_name", "window_title_contains", "classification_id", "priority", "tags", "note", "app_match", "title_match", "condition"},
+	AwayPeriods:     {"id", "start_time", "end_time", "reason"},
+	Projects:        {"id", "name", "client", "hourly_rate_cents", "currency"},
+	ProjectRules:    {"id", "project_id", "field", "contains"},
+}
+
+// writeCSV writes one table with a header row.
+func writeCSV(w io.Writer, src storage.ExportReader, opts Options, table Table) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvColumns[table]); err != nil {
+		return err
+	}
+
+	err := each(src, opts, table, func(record interface{}) error {
+		return cw.Write(csvRow(record))
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvRow(record interface{}) []string {
+	itoa := func(v int64) string { return strconv.FormatInt(v, 10) }
+
+	switch r := record.(type) {
+	case models.ActivitySession:
+		classificationID := ""
+		if r.ClassificationID != nil {
+			classificationID = itoa(*r.ClassificationID)
+		}
+		projectID := ""
+		if r.ProjectID != nil {
+			projectID = itoa(*r.ProjectID)
+		}
+		return []string{itoa(r.ID), r.AppName, r.WindowTitle, itoa(r.StartTime.Unix()), itoa(r.EndTime.Unix()), itoa(r.Duration), classificationID, r.Source, r.Note, strings.Join(r.Tags, ";"), r.URL, r.Cwd, projectID}
+	case models.Classification:
+		parentID := ""
+		if r.ParentID != nil {
+			parentID = itoa(*r.ParentID)
+		}
+		return []string{itoa(r.ID), r.UserDefinedName, strconv.FormatBool(r.IsHelpful), r.GoalContext, parentID}
+	case models.ClassificationRule:
+		condition := ""
+		if r.Condition != nil {
+			data, _ := json.Marshal(r.Condition)
+			condition = string(data)
+		}
+		return []string{itoa(r.ID), r.AppName, r.WindowTitleContains, itoa(r.ClassificationID), strconv.Itoa(r.Priority), strings.Join(r.Tags, ";"), r.Note, r.AppMatch, r.TitleMatch, condition}
+	case models.Project:
+		return []string{itoa(r.ID), r.Name, r.Client, itoa(r.HourlyRate), r.Currency}
+	case models.ProjectRule:
+		return []string{itoa(r.ID), itoa(r.ProjectID), r.Field, r.Contains}
+	case models.AwayPeriod:
+		return []string{itoa(r.ID), itoa(r.StartTime.Unix()), itoa(r.EndTime.Unix()), r.Reason}
+	}
+	return nil
+}
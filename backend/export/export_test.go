@@ -0,0 +1,280 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+// day returns 10:00 local time on the given day of October 2026.
+func day(d int) time.Time {
+	return time.Date(2026, time.October, d, 10, 0, 0, 0, time.Local)
+}
+
+// newHistory fills a store with three sessions, two classifications, a rule,
+// a tag and an away period.
+func newHistory(t *testing.T, s storage.Store) {
+	t.Helper()
+	for _, session := range []struct {
+		start      time.Time
+		app, title string
+	}{
+		{day(1), "Code", "main.go"},
+		{day(2), "Slack", "general"},
+		{day(5), "Code", "main.go"},
+	} {
+		var events []models.RawEvent
+		for i := 0; i <= 6; i++ {
+			events = append(events, models.RawEvent{Timestamp: session.start.Add(time.Duration(i) * 10 * time.Second), AppName: session.app, WindowTitle: session.title})
+		}
+		if err := s.InsertRawEvents(events); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ProcessRawEvents(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.ApplyClassification(models.ClassificationRequest{AppName: "Code", WindowTitle: "main.go", UserDefinedName: "Go, \"mostly\""}); err != nil {
+		t.Fatal(err)
+	}
+	rule := models.CreateClassificationRuleRequest{AppName: "Slack", UserDefinedName: "Chat", ApplyRuleOptions: models.ApplyRuleOptions{ApplyToHistory: true}}
+	if _, err := s.CreateClassificationRule(rule); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := s.SearchSessions(storage.SessionSearch{Text: "Slack"})
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("finding the Slack session: %+v, %v", sessions, err)
+	}
+	if _, err := s.TagSessions(models.TagSessionsRequest{SessionIDs: []int64{sessions[0].ID}, Add: []string{"team"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordAwayPeriod(models.AwayPeriod{StartTime: day(3), EndTime: day(3).Add(time.Hour), Reason: models.AwayScheduled}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eachStore runs a test against an in-memory and a SQLite store holding the
+// same history.
+func eachStore(t *testing.T, test func(t *testing.T, s storage.Store)) {
+	t.Run("memory", func(t *testing.T) {
+		s := storage.NewMemoryStore()
+		newHistory(t, s)
+		test(t, s)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		s, err := storage.NewDBStore(filepath.Join(t.TempDir(), "export.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		newHistory(t, s)
+		test(t, s)
+	})
+}
+
+func TestParseFormatAndTable(t *testing.T) {
+	for name, want := range map[string]Format{"": JSON, "json": JSON, "ndjson": NDJSON, "csv": CSV} {
+		if got, err := ParseFormat(name); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("ParseFormat accepted xml")
+	}
+	for _, name := range []string{"", "sessions", "classifications", "rules", "projects", "project_rules", "away_periods"} {
+		if _, err := ParseTable(name); err != nil {
+			t.Errorf("ParseTable(%q): %v", name, err)
+		}
+	}
+	if _, err := ParseTable("raw_events"); err == nil {
+		t.Error("ParseTable accepted raw_events")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end string
+		from, to   time.Time
+		wantErr    bool
+	}{
+		{"open", "", "", time.Time{}, time.Time{}, false},
+		{"dates include the end day", "2026-10-01", "2026-10-31",
+			time.Date(2026, time.October, 1, 0, 0, 0, 0, time.Local), time.Date(2026, time.November, 1, 0, 0, 0, 0, time.Local), false},
+		{"single day", "2026-10-01", "2026-10-01",
+			time.Date(2026, time.October, 1, 0, 0, 0, 0, time.Local), time.Date(2026, time.October, 2, 0, 0, 0, 0, time.Local), false},
+		{"timestamps", "2026-10-01T08:00:00Z", "2026-10-01T09:00:00Z",
+			time.Date(2026, time.October, 1, 8, 0, 0, 0, time.UTC), time.Date(2026, time.October, 1, 9, 0, 0, 0, time.UTC), false},
+		{"invalid date", "10/01/2026", "", time.Time{}, time.Time{}, true},
+		{"end before start", "2026-10-02", "2026-10-01T00:00:00Z", time.Time{}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := ParseRange(tt.start, tt.end)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRange error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !from.Equal(tt.from) || !to.Equal(tt.to) {
+				t.Errorf("ParseRange = %v, %v, want %v, %v", from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	eachStore(t, func(t *testing.T, s storage.Store) {
+		now := day(10)
+		var buf bytes.Buffer
+		if err := Write(&buf, s, Options{Format: JSON}, now); err != nil {
+			t.Fatal(err)
+		}
+		var doc struct {
+			SchemaVersion   int                      `json:"schema_version"`
+			ExportedAt      int64                    `json:"exported_at"`
+			Start           *int64                   `json:"start"`
+			Sessions        []models.ActivitySession `json:"sessions"`
+			Classifications []models.Classification  `json:"classifications"`
+			Rules           []json.RawMessage        `json:"rules"`
+			Projects        []json.RawMessage        `json:"projects"`
+			ProjectRules    []json.RawMessage        `json:"project_rules"`
+			AwayPeriods     []json.RawMessage        `json:"away_periods"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatalf("export is not valid JSON: %v\n%s", err, buf.String())
+		}
+		if doc.SchemaVersion != SchemaVersion || doc.ExportedAt != now.Unix() || doc.Start != nil {
+			t.Errorf("header = %d, %d, %v", doc.SchemaVersion, doc.ExportedAt, doc.Start)
+		}
+		if len(doc.Sessions) != 3 || len(doc.Classifications) != 2 || len(doc.Rules) != 1 || len(doc.AwayPeriods) != 1 {
+			t.Errorf("exported %d sessions, %d classifications, %d rules, %d away periods",
+				len(doc.Sessions), len(doc.Classifications), len(doc.Rules), len(doc.AwayPeriods))
+		}
+		if doc.Projects == nil || doc.ProjectRules == nil {
+			t.Error("empty tables are missing instead of empty arrays")
+		}
+		if doc.Sessions[1].AppName != "Slack" || !slices.Equal(doc.Sessions[1].Tags, []string{"team"}) {
+			t.Errorf("second session = %+v, want the tagged Slack session", doc.Sessions[1])
+		}
+	})
+}
+
+func TestWriteFiltersSessions(t *testing.T) {
+	eachStore(t, func(t *testing.T, s storage.Store) {
+		tests := []struct {
+			name string
+			opts Options
+			want []string
+		}{
+			{"start", Options{Start: day(2)}, []string{"Slack", "Code"}},
+			{"end", Options{End: day(2)}, []string{"Code"}},
+			{"range", Options{Start: day(2), End: day(3)}, []string{"Slack"}},
+			{"tag", Options{Tag: "team"}, []string{"Slack"}},
+		}
+		for _, tt := range tests {
+			tt.opts.Format, tt.opts.Table = NDJSON, Sessions
+			var buf bytes.Buffer
+			if err := Write(&buf, s, tt.opts, day(10)); err != nil {
+				t.Fatal(err)
+			}
+			var apps []string
+			for _, line := range ndjsonLines(t, &buf)[1:] {
+				var session models.ActivitySession
+				if err := json.Unmarshal(line.Data, &session); err != nil {
+					t.Fatal(err)
+				}
+				apps = append(apps, session.AppName)
+			}
+			if !slices.Equal(apps, tt.want) {
+				t.Errorf("%s: exported %v, want %v", tt.name, apps, tt.want)
+			}
+		}
+	})
+}
+
+type ndjsonLine struct {
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	Data          json.RawMessage `json:"data"`
+}
+
+func ndjsonLines(t *testing.T, buf *bytes.Buffer) []ndjsonLine {
+	t.Helper()
+	var lines []ndjsonLine
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var line ndjsonLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 || lines[0].Type != "header" || lines[0].SchemaVersion != SchemaVersion {
+		t.Fatalf("export does not start with a header: %+v", lines)
+	}
+	return lines
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	eachStore(t, func(t *testing.T, s storage.Store) {
+		var buf bytes.Buffer
+		if err := Write(&buf, s, Options{Format: NDJSON}, day(10)); err != nil {
+			t.Fatal(err)
+		}
+		counts := make(map[string]int)
+		for _, line := range ndjsonLines(t, &buf)[1:] {
+			counts[line.Type]++
+		}
+		want := map[string]int{"session": 3, "classification": 2, "rule": 1, "away_period": 1}
+		for recordType, n := range want {
+			if counts[recordType] != n {
+				t.Errorf("%d %s records, want %d", counts[recordType], recordType, n)
+			}
+		}
+	})
+}
+
+func TestWriteCSV(t *testing.T) {
+	eachStore(t, func(t *testing.T, s storage.Store) {
+		// CSV holds one table, sessions unless another is named.
+		var buf bytes.Buffer
+		if err := Write(&buf, s, Options{Format: CSV}, day(10)); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 4 || !slices.Equal(rows[0], csvColumns[Sessions]) {
+			t.Fatalf("sessions CSV = %q", rows)
+		}
+		slack := rows[2]
+		if slack[1] != "Slack" || slack[5] != "60" || slack[6] == "" || slack[9] != "team" {
+			t.Errorf("Slack row = %q", slack)
+		}
+
+		buf.Reset()
+		if err := Write(&buf, s, Options{Format: CSV, Table: Classifications}, day(10)); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), `"Go, ""mostly"""`) {
+			t.Errorf("classification names are not quoted:\n%s", buf.String())
+		}
+		rows, err = csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 3 || !slices.Equal(rows[0], csvColumns[Classifications]) {
+			t.Errorf("classifications CSV = %q", rows)
+		}
+	})
+}
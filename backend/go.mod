@@ -2,6 +2,12 @@ module github.com/imdawon/personalos
 
 go 1.24.3
 
+require (
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.37.0
+	modernc.org/sqlite v1.38.0
+)
+
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/google/uuid v1.6.0 // indirect
@@ -13,5 +19,4 @@ require (
 	modernc.org/libc v1.65.10 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
 	modernc.org/memory v1.11.0 // indirect
-	modernc.org/sqlite v1.38.0 // indirect
 )
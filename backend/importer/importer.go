@@ -0,0 +1,227 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// mergeGap is the longest gap between two events of the same window that are
+// still joined into one session.
+const mergeGap = 30 * time.Second
+
+// Parse reads an export of the given source: models.SourceActivityWatch,
+// models.SourceRescueTime or models.SourceToggl.
+func Parse(source string, r io.Reader) ([]models.ActivitySession, error) {
+	switch source {
+	case models.SourceActivityWatch:
+		return ActivityWatch(r)
+	case models.SourceRescueTime:
+		return RescueTime(r)
+	case models.SourceToggl:
+		return Toggl(r)
+	default:
+		return nil, fmt.Errorf("unknown import source %q (want activitywatch, rescuetime or toggl)", source)
+	}
+}
+
+// awEvent is an event in an ActivityWatch bucket export.
+type awEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Duration  float64   `json:"duration"` // seconds
+	Data      struct {
+		App   string `json:"app"`
+		Title string `json:"title"`
+	} `json:"data"`
+}
+
+type awBucket struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Events []awEvent `json:"events"`
+}
+
+// ActivityWatch reads an ActivityWatch export. Both the full export
+// ({"buckets": {...}}) and a single bucket are accepted; only window watcher
+// buckets (type "currentwindow") are imported.
+func ActivityWatch(r io.Reader) ([]models.ActivitySession, error) {
+	var export struct {
+		Buckets map[string]awBucket `json:"buckets"`
+		awBucket
+	}
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("invalid ActivityWatch export: %w", err)
+	}
+
+	buckets := export.Buckets
+	if buckets == nil {
+		buckets = map[string]awBucket{export.ID: export.awBucket}
+	}
+
+	var sessions []models.ActivitySession
+	for id, bucket := range buckets {
+		if bucket.Type != "currentwindow" && !strings.HasPrefix(id, "aw-watcher-window") {
+			continue
+		}
+		for _, e := range bucket.Events {
+			if e.Data.App == "" || e.Duration <= 0 {
+				continue
+			}
+			start := e.Timestamp.Local()
+			end := start.Add(time.Duration(e.Duration * float64(time.Second)))
+			sessions = append(sessions, session(models.SourceActivityWatch, e.Data.App, e.Data.Title, start, end))
+		}
+	}
+	return coalesce(sessions), nil
+}
+
+// RescueTime reads a RescueTime activity CSV export, which reports the
+// seconds spent per activity in each hour. Activities of the same hour are
+// laid out back to back from the start of the hour, since RescueTime does not
+// record when within the hour they happened.
+func RescueTime(r io.Reader) ([]models.ActivitySession, error) {
+	rows, err := readCSV(r, "Date", "Time Spent (seconds)", "Activity")
+	if err != nil {
+		return nil, fmt.Errorf("invalid RescueTime export: %w", err)
+	}
+
+	offsets := make(map[time.Time]time.Duration) // time used so far in each hour
+	var sessions []models.ActivitySession
+	for _, row := range rows {
+		hour, err := parseLocal(row["Date"], "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02")
+		if err != nil {
+			return nil, err
+		}
+		seconds, err := strconv.ParseFloat(row["Time Spent (seconds)"], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time spent %q", row["Time Spent (seconds)"])
+		}
+		if seconds <= 0 || row["Activity"] == "" {
+			continue
+		}
+
+		start := hour.Add(offsets[hour])
+		spent := time.Duration(seconds) * time.Second
+		offsets[hour] += spent
+		// RescueTime has no window titles; the document, when exported, is
+		// the closest equivalent.
+		sessions = append(sessions, session(models.SourceRescueTime, row["Activity"], row["Document"], start, start.Add(spent)))
+	}
+	return coalesce(sessions), nil
+}
+
+// Toggl reads a Toggl Track detailed CSV export. Entries become sessions named
+// after their project, with the description as the title.
+func Toggl(r io.Reader) ([]models.ActivitySession, error) {
+	rows, err := readCSV(r, "Start date", "Start time", "End date", "End time")
+	if err != nil {
+		return nil, fmt.Errorf("invalid Toggl export: %w", err)
+	}
+
+	var sessions []models.ActivitySession
+	for _, row := range rows {
+		start, err := parseLocal(row["Start date"]+" "+row["Start time"], "2006-01-02 15:04:05")
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseLocal(row["End date"]+" "+row["End time"], "2006-01-02 15:04:05")
+		if err != nil {
+			return nil, err
+		}
+		app := row["Project"]
+		if app == "" {
+			app = "Toggl"
+		}
+		sessions = append(sessions, session(models.SourceToggl, app, row["Description"], start, end))
+	}
+	return sessions, nil
+}
+
+func session(source, app, title string, start, end time.Time) models.ActivitySession {
+	return models.ActivitySession{
+		AppName:     app,
+		WindowTitle: title,
+		StartTime:   start,
+		EndTime:     end,
+		Duration:    int64(end.Sub(start).Seconds()),
+		Source:      source,
+	}
+}
+
+// coalesce sorts sessions and joins consecutive ones of the same window, as
+// the trackers that produced them record many short events per window.
+func coalesce(sessions []models.ActivitySession) []models.ActivitySession {
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].StartTime.Before(sessions[j].StartTime) })
+
+	var merged []models.ActivitySession
+	for _, s := range sessions {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.AppName == s.AppName && last.WindowTitle == s.WindowTitle && s.StartTime.Sub(last.EndTime) <= mergeGap {
+				if s.EndTime.After(last.EndTime) {
+					last.EndTime = s.EndTime
+					last.Duration = int64(last.EndTime.Sub(last.StartTime).Seconds())
+				}
+				continue
+			}
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// readCSV reads a CSV with a header row into maps keyed by column name,
+// checking that the required columns are present.
+func readCSV(r io.Reader, required ...string) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+	for _, name := range required {
+		found := false
+		for _, column := range header {
+			found = found || column == name
+		}
+		if !found {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	var rows []map[string]string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(header))
+		for i, value := range record {
+			if i < len(header) {
+				row[header[i]] = strings.TrimSpace(value)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func parseLocal(value string, layouts ...string) (time.Time, error) {
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
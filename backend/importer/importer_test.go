@@ -0,0 +1,127 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// local returns a time on 16 October 2026 in the local time zone.
+func local(hour, min, sec int) time.Time {
+	return time.Date(2026, time.October, 16, hour, min, sec, 0, time.Local)
+}
+
+type want struct {
+	app, title string
+	start, end time.Time
+}
+
+func assertSessions(t *testing.T, got []models.ActivitySession, source string, wants ...want) {
+	t.Helper()
+	if len(got) != len(wants) {
+		t.Fatalf("got %d sessions, want %d: %+v", len(got), len(wants), got)
+	}
+	for i, w := range wants {
+		s := got[i]
+		if s.AppName != w.app || s.WindowTitle != w.title || !s.StartTime.Equal(w.start) || !s.EndTime.Equal(w.end) ||
+			s.Duration != int64(w.end.Sub(w.start).Seconds()) || s.Source != source {
+			t.Errorf("session %d = %+v, want %+v from %s", i, s, w, source)
+		}
+	}
+}
+
+func TestActivityWatch(t *testing.T) {
+	stamp := func(h, m, s int) string { return local(h, m, s).UTC().Format(time.RFC3339) }
+	export := `{"buckets": {
+		"aw-watcher-window_laptop": {"id": "aw-watcher-window_laptop", "type": "currentwindow", "events": [
+			{"timestamp": "` + stamp(9, 0, 30) + `", "duration": 30, "data": {"app": "Code", "title": "main.go"}},
+			{"timestamp": "` + stamp(9, 0, 0) + `", "duration": 20, "data": {"app": "Code", "title": "main.go"}},
+			{"timestamp": "` + stamp(9, 1, 0) + `", "duration": 60, "data": {"app": "Slack", "title": "general"}},
+			{"timestamp": "` + stamp(9, 2, 0) + `", "duration": 0, "data": {"app": "Slack", "title": "random"}},
+			{"timestamp": "` + stamp(9, 3, 0) + `", "duration": 45, "data": {"app": "Code", "title": "main.go"}}
+		]},
+		"aw-watcher-afk_laptop": {"id": "aw-watcher-afk_laptop", "type": "afkstatus", "events": [
+			{"timestamp": "` + stamp(9, 0, 0) + `", "duration": 600, "data": {"status": "not-afk"}}
+		]}
+	}}`
+	sessions, err := Parse(models.SourceActivityWatch, strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Events of the same window within mergeGap join; the AFK bucket and
+	// empty events are skipped.
+	assertSessions(t, sessions, models.SourceActivityWatch,
+		want{"Code", "main.go", local(9, 0, 0), local(9, 1, 0)},
+		want{"Slack", "general", local(9, 1, 0), local(9, 2, 0)},
+		want{"Code", "main.go", local(9, 3, 0), local(9, 3, 45)},
+	)
+
+	// A single exported bucket works too.
+	bucket := `{"id": "aw-watcher-window_laptop", "type": "currentwindow", "events": [
+		{"timestamp": "` + stamp(9, 0, 0) + `", "duration": 90, "data": {"app": "Code", "title": "main.go"}}
+	]}`
+	sessions, err = ActivityWatch(strings.NewReader(bucket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSessions(t, sessions, models.SourceActivityWatch, want{"Code", "main.go", local(9, 0, 0), local(9, 1, 30)})
+
+	if _, err := ActivityWatch(strings.NewReader("[1, 2")); err == nil {
+		t.Error("a malformed export parsed")
+	}
+}
+
+func TestRescueTime(t *testing.T) {
+	export := "\ufeffDate,Time Spent (seconds),Number of People,Activity,Document,Category,Productivity\n" +
+		"2026-10-16T09:00:00,600,1,Code,main.go,Software Development,2\n" +
+		"2026-10-16T09:00:00,300,1,slack,,Communication,0\n" +
+		"2026-10-16T10:00:00,120,1,Code,main.go,Software Development,2\n" +
+		"2026-10-16T10:00:00,0,1,idle,,,0\n"
+	sessions, err := Parse(models.SourceRescueTime, strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Activities of an hour are laid out back to back from its start.
+	assertSessions(t, sessions, models.SourceRescueTime,
+		want{"Code", "main.go", local(9, 0, 0), local(9, 10, 0)},
+		want{"slack", "", local(9, 10, 0), local(9, 15, 0)},
+		want{"Code", "main.go", local(10, 0, 0), local(10, 2, 0)},
+	)
+
+	bad := []string{
+		"Date,Activity\n2026-10-16T09:00:00,Code\n",
+		"Date,Time Spent (seconds),Activity\nyesterday,60,Code\n",
+		"Date,Time Spent (seconds),Activity\n2026-10-16T09:00:00,a minute,Code\n",
+	}
+	for _, export := range bad {
+		if _, err := RescueTime(strings.NewReader(export)); err == nil {
+			t.Errorf("RescueTime accepted %q", export)
+		}
+	}
+}
+
+func TestToggl(t *testing.T) {
+	export := "User,Email,Client,Project,Task,Description,Billable,Start date,Start time,End date,End time,Duration\n" +
+		"Ada,ada@example.com,Acme,Website,,Landing page,Yes,2026-10-16,09:00:00,2026-10-16,10:30:00,01:30:00\n" +
+		"Ada,ada@example.com,,,,Email,No,2026-10-16,23:30:00,2026-10-17,00:15:00,00:45:00\n"
+	sessions, err := Parse(models.SourceToggl, strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSessions(t, sessions, models.SourceToggl,
+		want{"Website", "Landing page", local(9, 0, 0), local(10, 30, 0)},
+		want{"Toggl", "Email", local(23, 30, 0), local(24, 15, 0)},
+	)
+
+	if _, err := Toggl(strings.NewReader("Start date,Start time\n")); err == nil {
+		t.Error("Toggl accepted an export without end columns")
+	}
+}
+
+func TestParseUnknownSource(t *testing.T) {
+	if _, err := Parse("clockify", strings.NewReader("")); err == nil {
+		t.Error("Parse accepted an unknown source")
+	}
+}
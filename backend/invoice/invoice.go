@@ -0,0 +1,189 @@
+package invoice
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+	"github.com/imdawon/personalos/timesheet"
+)
+
+// The built-in templates. Copy them from the templates directory to start a
+// custom one.
+//
+//go:embed templates/invoice.html templates/invoice.txt
+var builtins embed.FS
+
+// Line is one project's time on one day.
+type Line struct {
+	models.TimesheetEntry
+	HourlyRate int64
+}
+
+// Invoice is the billable time of one client over one month, as handed to
+// the templates.
+type Invoice struct {
+	Client string
+	// Month is the billed month as YYYY-MM. Start and End bound it in local
+	// time; End is exclusive.
+	Month      string
+	Start, End time.Time
+	Issued     time.Time
+	// Currency is shared by all of the client's projects.
+	Currency        string
+	RoundingMinutes int
+	Lines           []Line
+	Projects        []models.TimesheetTotal
+	// Seconds, RoundedSeconds and Amount sum the projects.
+	Seconds, RoundedSeconds, Amount int64
+}
+
+// ParseMonth parses a YYYY-MM month into its first instant and the first
+// instant of the next month, in local time.
+func ParseMonth(month string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation("2006-01", month, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// Build gathers a client's project time for a month. It fails when the
+// client has no time in the month or its projects bill in different
+// currencies, since one invoice cannot total those.
+func Build(src storage.ExportReader, client, month string, roundingMinutes int, issued time.Time) (Invoice, error) {
+	start, end, err := ParseMonth(month)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if client == "" {
+		return Invoice{}, fmt.Errorf("a client is required")
+	}
+	sheet, err := timesheet.Build(src, timesheet.Options{Start: start, End: end, RoundingMinutes: roundingMinutes, Client: client})
+	if err != nil {
+		return Invoice{}, err
+	}
+	if len(sheet.Totals) == 0 {
+		return Invoice{}, fmt.Errorf("no project time recorded for client %q in %s", client, month)
+	}
+
+	inv := Invoice{
+		Client:          client,
+		Month:           month,
+		Start:           start,
+		End:             end,
+		Issued:          issued,
+		Currency:        sheet.Totals[0].Currency,
+		RoundingMinutes: roundingMinutes,
+		Projects:        sheet.Totals,
+	}
+	rates := make(map[int64]int64)
+	for _, total := range sheet.Totals {
+		if total.Currency != inv.Currency {
+			return Invoice{}, fmt.Errorf("the projects of client %q bill in different currencies (%s and %s)", client, inv.Currency, total.Currency)
+		}
+		rates[total.ProjectID] = total.HourlyRate
+		inv.Seconds += total.Seconds
+		inv.RoundedSeconds += total.RoundedSeconds
+		inv.Amount += total.Amount
+	}
+	for _, entry := range sheet.Entries {
+		inv.Lines = append(inv.Lines, Line{TimesheetEntry: entry, HourlyRate: rates[entry.ProjectID]})
+	}
+	return inv, nil
+}
+
+// funcs are available to both templates.
+var funcs = map[string]interface{}{
+	"money": Money,
+	"hours": Hours,
+	"date":  func(t time.Time) string { return t.Format("2006-01-02") },
+	// last is the day before t, to show an exclusive end as inclusive.
+	"last": func(t time.Time) string { return t.AddDate(0, 0, -1).Format("2006-01-02") },
+}
+
+// Money formats an amount in minor units with two decimals and thousands
+// separators, e.g. 123456 as "1,234.56".
+func Money(minor int64) string {
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	units := fmt.Sprint(minor / 100)
+	var b strings.Builder
+	for i, r := range units {
+		if i > 0 && (len(units)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	return fmt.Sprintf("%s%s.%02d", sign, b.String(), minor%100)
+}
+
+// Hours formats seconds as decimal hours, e.g. 5400 as "1.50".
+func Hours(seconds int64) string {
+	return fmt.Sprintf("%.2f", float64(seconds)/3600)
+}
+
+// Templates render invoices as HTML and as the text laid out in the PDF.
+type Templates struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// LoadTemplates parses the HTML and PDF text templates at the given paths.
+// An empty path selects the built-in template.
+func LoadTemplates(htmlPath, textPath string) (*Templates, error) {
+	htmlSrc, err := templateSource(htmlPath, "templates/invoice.html")
+	if err != nil {
+		return nil, err
+	}
+	textSrc, err := templateSource(textPath, "templates/invoice.txt")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{}
+	if t.html, err = htmltemplate.New("invoice.html").Funcs(funcs).Parse(htmlSrc); err != nil {
+		return nil, fmt.Errorf("invalid HTML invoice template: %w", err)
+	}
+	if t.text, err = texttemplate.New("invoice.txt").Funcs(funcs).Parse(textSrc); err != nil {
+		return nil, fmt.Errorf("invalid PDF invoice template: %w", err)
+	}
+	return t, nil
+}
+
+func templateSource(path, builtin string) (string, error) {
+	var data []byte
+	var err error
+	if path == "" {
+		data, err = builtins.ReadFile(builtin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	return string(data), err
+}
+
+// HTML writes the invoice as an HTML document.
+func (t *Templates) HTML(w io.Writer, inv Invoice) error {
+	return t.html.Execute(w, inv)
+}
+
+// PDF writes the invoice as a PDF document, setting each line of the text
+// template in a fixed-width font.
+func (t *Templates) PDF(w io.Writer, inv Invoice) error {
+	var buf bytes.Buffer
+	if err := t.text.Execute(&buf, inv); err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	return writePDF(w, fmt.Sprintf("Invoice %s %s", inv.Client, inv.Month), lines)
+}
@@ -0,0 +1,288 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+// history is an ExportReader over fixed projects and sessions.
+type history struct {
+	projects []models.Project
+	sessions []models.ActivitySession
+}
+
+func (h history) EachSession(filter storage.SessionFilter, fn func(models.ActivitySession) error) error {
+	for _, s := range h.sessions {
+		if (!filter.Start.IsZero() && s.StartTime.Before(filter.Start)) || (!filter.End.IsZero() && !s.StartTime.Before(filter.End)) {
+			continue
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h history) ListProjects() ([]models.Project, error) { return h.projects, nil }
+
+func (history) ListClassifications() ([]models.Classification, error) { return nil, nil }
+func (history) ListRules() ([]models.ClassificationRule, error)       { return nil, nil }
+func (history) ListProjectRules() ([]models.ProjectRule, error)       { return nil, nil }
+func (history) GetAwayPeriods(start, end time.Time) ([]models.AwayPeriod, error) {
+	return nil, nil
+}
+
+// newHistory bills Acme for two projects in October 2026, with a session
+// on the last evening of September that the invoice leaves out, and a
+// project of another client.
+func newHistory() history {
+	session := func(projectID int64, start time.Time, seconds int64) models.ActivitySession {
+		return models.ActivitySession{StartTime: start, EndTime: start.Add(time.Duration(seconds) * time.Second), Duration: seconds, ProjectID: &projectID}
+	}
+	day := func(month time.Month, d, hour int) time.Time {
+		return time.Date(2026, month, d, hour, 0, 0, 0, time.Local)
+	}
+	return history{
+		projects: []models.Project{
+			{ID: 1, Name: "Website", Client: "Acme", HourlyRate: 150000, Currency: "EUR"},
+			{ID: 2, Name: "API (v2)", Client: "Acme", HourlyRate: 9000, Currency: "EUR"},
+			{ID: 3, Name: "Support", Client: "Initech", HourlyRate: 5000, Currency: "USD"},
+		},
+		sessions: []models.ActivitySession{
+			session(1, day(time.September, 30, 23), 3600),
+			session(1, day(time.October, 1, 9), 5400),
+			session(2, day(time.October, 2, 9), 600),
+			session(3, day(time.October, 2, 10), 3600),
+		},
+	}
+}
+
+func TestParseMonth(t *testing.T) {
+	start, end, err := ParseMonth("2026-12")
+	if err != nil || !start.Equal(time.Date(2026, time.December, 1, 0, 0, 0, 0, time.Local)) || !end.Equal(time.Date(2027, time.January, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("ParseMonth = %v, %v, %v", start, end, err)
+	}
+	for _, month := range []string{"", "2026-13", "2026-1-1", "October"} {
+		if _, _, err := ParseMonth(month); err == nil {
+			t.Errorf("ParseMonth(%q) succeeded", month)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	issued := time.Date(2026, time.November, 2, 12, 0, 0, 0, time.Local)
+	inv, err := Build(newHistory(), "Acme", "2026-10", 15, issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Currency != "EUR" || len(inv.Lines) != 2 || len(inv.Projects) != 2 {
+		t.Fatalf("invoice = %+v, want two EUR lines and projects", inv)
+	}
+	// 1.5h at 1,500.00 and 15 minutes (rounded up from 10) at 90.00.
+	if inv.Seconds != 6000 || inv.RoundedSeconds != 6300 || inv.Amount != 225000+2250 {
+		t.Errorf("totals = %ds, %ds, %d; want 6000s, 6300s, 227250", inv.Seconds, inv.RoundedSeconds, inv.Amount)
+	}
+	if line := inv.Lines[1]; line.Project != "API (v2)" || line.HourlyRate != 9000 || line.Amount != 2250 {
+		t.Errorf("API line = %+v", line)
+	}
+
+	failures := []struct {
+		name, client, month string
+	}{
+		{"no client", "", "2026-10"},
+		{"bad month", "Acme", "10/2026"},
+		{"no time", "Acme", "2026-11"},
+		{"unknown client", "Globex", "2026-10"},
+	}
+	for _, test := range failures {
+		if _, err := Build(newHistory(), test.client, test.month, 0, issued); err == nil {
+			t.Errorf("%s: Build succeeded", test.name)
+		}
+	}
+
+	mixed := newHistory()
+	mixed.projects[1].Currency = "USD"
+	if _, err := Build(mixed, "Acme", "2026-10", 0, issued); err == nil || !strings.Contains(err.Error(), "different currencies") {
+		t.Errorf("mixed currencies: %v, want an error", err)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	for minor, want := range map[int64]string{0: "0.00", 5: "0.05", 123456: "1,234.56", 100000000: "1,000,000.00", -99999: "-999.99"} {
+		if got := Money(minor); got != want {
+			t.Errorf("Money(%d) = %q, want %q", minor, got, want)
+		}
+	}
+	for seconds, want := range map[int64]string{0: "0.00", 5400: "1.50", 900: "0.25", 7199: "2.00"} {
+		if got := Hours(seconds); got != want {
+			t.Errorf("Hours(%d) = %q, want %q", seconds, got, want)
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	tmpl, err := LoadTemplates("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHistory()
+	h.projects[0].Client, h.projects[1].Client = "Acme & Sons", "Acme & Sons"
+	inv, err := Build(h, "Acme & Sons", "2026-10", 0, time.Date(2026, time.November, 2, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.HTML(&buf, inv); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	for _, want := range []string{
+		"Client: Acme &amp; Sons",
+		"Period: 2026-10-01 to 2026-10-31",
+		"Issued: 2026-11-02",
+		"<td>2026-10-01</td><td>Website</td><td class=\"num\">1.50</td><td class=\"num\">1,500.00</td><td class=\"num\">2,250.00</td>",
+		"2,265.00 EUR",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML invoice lacks %q:\n%s", want, html)
+		}
+	}
+	if strings.Contains(html, "rounded up") {
+		t.Error("HTML invoice mentions rounding it did not do")
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "custom.txt")
+	if err := os.WriteFile(custom, []byte("{{.Client}} owes {{money .Amount}} {{.Currency}}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.html")
+	if err := os.WriteFile(broken, []byte("{{.Client"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := LoadTemplates("", custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.PDF(&buf, Invoice{Client: "Acme", Amount: 123456, Currency: "EUR"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "(Acme owes 1,234.56 EUR) '") {
+		t.Errorf("PDF does not set the custom template's line:\n%s", buf.String())
+	}
+
+	if _, err := LoadTemplates(broken, ""); err == nil {
+		t.Error("a broken HTML template was accepted")
+	}
+	if _, err := LoadTemplates("", filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("a missing text template was accepted")
+	}
+}
+
+// checkPDF checks the structure of a PDF written by writePDF: the header,
+// that every cross-reference offset points at its object, and the trailer.
+// It returns the number of pages.
+func checkPDF(t *testing.T, pdf []byte) int {
+	t.Helper()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatalf("PDF lacks its header or end marker")
+	}
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("PDF lacks startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the cross-reference table", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(pdf[xref:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(pdf[offset:], []byte(want)) {
+			t.Errorf("object %d is not at offset %d", i+1, offset)
+		}
+	}
+	if !bytes.Contains(pdf, []byte(fmt.Sprintf("/Size %d /Root 1 0 R", len(entries)+1))) {
+		t.Errorf("trailer size does not match %d objects", len(entries))
+	}
+	m = regexp.MustCompile(`/Type /Pages /Kids \[[^]]*\] /Count (\d+)`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("PDF lacks a page tree")
+	}
+	pages, _ := strconv.Atoi(string(m[1]))
+	return pages
+}
+
+func TestPDF(t *testing.T) {
+	tmpl, err := LoadTemplates("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := Build(newHistory(), "Acme", "2026-10", 6, time.Date(2026, time.November, 2, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.PDF(&buf, inv); err != nil {
+		t.Fatal(err)
+	}
+	if pages := checkPDF(t, buf.Bytes()); pages != 1 {
+		t.Errorf("invoice PDF has %d pages, want 1", pages)
+	}
+	for _, want := range []string{
+		"/Title (Invoice Acme 2026-10)",
+		"(Time is rounded up to 6 minutes per project and day.) '",
+		"API \\(v2\\)",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("PDF lacks %q", want)
+		}
+	}
+}
+
+func TestWritePDFPages(t *testing.T) {
+	lines := make([]string, linesPerPage+1)
+	lines = append(lines, "\fnew page", "last line")
+	var buf bytes.Buffer
+	if err := writePDF(&buf, "Pages", lines); err != nil {
+		t.Fatal(err)
+	}
+	// A full page, the overflowing line, then the form feed's page.
+	if pages := checkPDF(t, buf.Bytes()); pages != 3 {
+		t.Errorf("%d pages, want 3", pages)
+	}
+	if strings.Contains(buf.String(), "\f") {
+		t.Error("the form feed was written into the page")
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	tests := map[string]string{
+		"plain":         "(plain)",
+		`a (b) \c`:      `(a \(b\) \\c)`,
+		"tab\there":     "(tab    here)",
+		"café":          `(caf\351)`,
+		"5 €, “quoted”": `(5 \200, \223quoted\224)`,
+		"日本":            "(??)",
+	}
+	for in, want := range tests {
+		if got := pdfString(in); got != want {
+			t.Errorf("pdfString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
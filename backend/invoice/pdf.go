@@ -0,0 +1,133 @@
+package invoice
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Page layout of the PDF, in points: A4 with a 50pt margin, set in 9pt
+// Courier, which fits 91 characters per line.
+const (
+	pageWidth     = 595
+	pageHeight    = 842
+	pageMargin    = 50
+	fontSize      = 9
+	lineHeight    = 12
+	linesPerPage  = (pageHeight - 2*pageMargin) / lineHeight
+	firstObjectID = 4 // after the catalog, page tree and font
+)
+
+// writePDF writes a minimal PDF 1.4 document that sets lines of text top to
+// bottom in the standard Courier font, starting a new page when one is full
+// or a line holds a form feed. Only the standard fonts are used, so nothing
+// needs embedding; text outside Windows-1252 is replaced by '?'.
+func writePDF(w io.Writer, title string, lines []string) error {
+	var pages [][]string
+	page := []string{}
+	for _, line := range lines {
+		if (strings.HasPrefix(line, "\f") && len(page) > 0) || len(page) == linesPerPage {
+			pages = append(pages, page)
+			page = []string{}
+		}
+		page = append(page, strings.TrimPrefix(line, "\f"))
+	}
+	pages = append(pages, page)
+
+	pw := &pdfWriter{w: bufio.NewWriter(w)}
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-3 are fixed; each page then takes a page object followed by
+	// its content stream, and the info dictionary comes last.
+	infoID := firstObjectID + 2*len(pages)
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstObjectID+2*i)
+	}
+	pw.object("<< /Type /Catalog /Pages 2 0 R >>")
+	pw.object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	pw.object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, lines := range pages {
+		pw.object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, firstObjectID+2*i+1))
+
+		var content strings.Builder
+		// Each line is set with ', which moves down a line before showing it.
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", fontSize, lineHeight, pageMargin, pageHeight-pageMargin)
+		for _, line := range lines {
+			fmt.Fprintf(&content, "%s '\n", pdfString(line))
+		}
+		content.WriteString("ET\n")
+		pw.object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+	pw.object(fmt.Sprintf("<< /Title %s /Producer (PersonalOS) >>", pdfString(title)))
+
+	xref := pw.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", infoID+1)
+	for _, offset := range pw.offsets {
+		pw.printf("%010d 00000 n \n", offset)
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", infoID+1, infoID, xref)
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+// pdfWriter numbers objects in the order they are written and remembers
+// their offsets for the cross-reference table. The first error is kept and
+// later writes are skipped.
+type pdfWriter struct {
+	w       *bufio.Writer
+	n       int
+	offsets []int
+	err     error
+}
+
+func (pw *pdfWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(pw.w, format, args...)
+	pw.n += n
+	pw.err = err
+}
+
+func (pw *pdfWriter) object(body string) {
+	pw.offsets = append(pw.offsets, pw.n)
+	pw.printf("%d 0 obj\n%s\nendobj\n", len(pw.offsets), body)
+}
+
+// winAnsi maps the characters Windows-1252 places in 0x80-0x9F.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// pdfString encodes text as a PDF literal string in WinAnsiEncoding.
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case r >= 0x20 && r < 0x7F:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case winAnsi[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", winAnsi[r])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
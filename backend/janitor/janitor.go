@@ -0,0 +1,65 @@
+package janitor
+
+import (
+	"log"
+	"time"
+
+	"github.com/imdawon/personalos/storage"
+)
+
+// Janitor periodically enforces the retention policy in the background,
+// alongside the processor.
+type Janitor struct {
+	store    storage.RetentionStore
+	policy   storage.RetentionPolicy
+	interval time.Duration
+	quit     chan struct{}
+}
+
+// NewJanitor creates a new Janitor instance.
+func NewJanitor(store storage.RetentionStore, policy storage.RetentionPolicy, interval time.Duration) *Janitor {
+	return &Janitor{
+		store:    store,
+		policy:   policy,
+		interval: interval,
+		quit:     make(chan struct{}),
+	}
+}
+
+// Start runs a first pass immediately and then one on every tick.
+func (j *Janitor) Start() {
+	log.Println("Janitor started...")
+
+	go func() {
+		j.run()
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.run()
+			case <-j.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the janitor.
+func (j *Janitor) Stop() {
+	close(j.quit)
+	log.Println("Janitor stopped.")
+}
+
+func (j *Janitor) run() {
+	result, err := j.store.ApplyRetention(j.policy, time.Now())
+	if err != nil {
+		log.Printf("Error applying retention policy: %v", err)
+		return
+	}
+	if result.TitlesDropped > 0 || result.UnclassifiedDeleted > 0 || result.ClassifiedDeleted > 0 || result.TrashEmptied > 0 {
+		log.Printf("Retention: dropped %d titles, deleted %d unclassified and %d classified sessions, emptied %d from the trash",
+			result.TitlesDropped, result.UnclassifiedDeleted, result.ClassifiedDeleted, result.TrashEmptied)
+	}
+}
@@ -0,0 +1,42 @@
+package janitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+// fakeStore records the policies it is asked to apply.
+type fakeStore struct {
+	applied chan storage.RetentionPolicy
+}
+
+func (f *fakeStore) ApplyRetention(policy storage.RetentionPolicy, now time.Time) (storage.RetentionResult, error) {
+	f.applied <- policy
+	return storage.RetentionResult{TitlesDropped: 1}, nil
+}
+
+func (f *fakeStore) PurgeHistory(req models.PurgeRequest) (models.PurgeResult, error) {
+	return models.PurgeResult{}, nil
+}
+
+func TestJanitorAppliesPolicyOnStartAndEveryTick(t *testing.T) {
+	store := &fakeStore{applied: make(chan storage.RetentionPolicy, 10)}
+	policy := storage.RetentionPolicy{DropTitlesAfter: time.Hour}
+	j := NewJanitor(store, policy, 10*time.Millisecond)
+	j.Start()
+	defer j.Stop()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case got := <-store.applied:
+			if got != policy {
+				t.Fatalf("applied %+v, want %+v", got, policy)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("pass %d never ran", i+1)
+		}
+	}
+}
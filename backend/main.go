@@ -1,6 +1,9 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -9,25 +12,49 @@ import (
 	"time"
 
 	"github.com/imdawon/personalos/api"
+	"github.com/imdawon/personalos/backup"
+	"github.com/imdawon/personalos/config"
+	"github.com/imdawon/personalos/encryption"
+	"github.com/imdawon/personalos/janitor"
 	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/pause"
+	"github.com/imdawon/personalos/peersync"
+	"github.com/imdawon/personalos/privacy"
 	"github.com/imdawon/personalos/processor"
 	"github.com/imdawon/personalos/storage"
 	"github.com/imdawon/personalos/tracker"
 )
 
 const (
-	dbPath                 = "personal_os.db"
 	activeTrackingInterval = 5 * time.Second  // Fast polling when active
 	pausedTrackingInterval = 30 * time.Second // Slow polling when locked/sleeping (battery conservation)
 	processingInterval     = 1 * time.Minute
-	apiAddr                = "localhost:8085"
+	eventBatchSize         = 12               // Write raw events roughly once a minute when active
+	eventFlushInterval     = 30 * time.Second // Upper bound on how long an event stays buffered
 )
 
 func main() {
+	configPath := flag.String("config", "personal_os.json", "path to the JSON config file")
+	flag.Usage = usage
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Subcommands run once and exit instead of starting the backend.
+	if flag.NArg() > 0 {
+		if err := runCommand(cfg, flag.Args()); err != nil {
+			log.Fatalf("%s: %v", flag.Arg(0), err)
+		}
+		return
+	}
+
 	log.Println("Starting Personal OS Backend...")
 
 	// 1. Initialize Storage
-	store, err := storage.NewDBStore(dbPath)
+	store, err := openStore(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
@@ -41,23 +68,158 @@ func main() {
 	log.Println("Activity tracker initialized.")
 
 	// 3. Start the continuous logger (the "eye")
+	filter, err := privacyFilter(cfg.Privacy)
+	if err != nil {
+		log.Fatalf("Invalid privacy settings: %v", err)
+	}
+	schedules, err := pauseSchedules(cfg.PauseSchedules)
+	if err != nil {
+		log.Fatalf("Invalid pause schedule: %v", err)
+	}
+	pauses := pause.NewController(store, schedules)
 	proc := processor.NewProcessor(store, processingInterval)
-	go startLogger(activityTracker, store, proc)
+	events := storage.NewBufferedEventWriter(store, eventBatchSize, eventFlushInterval)
+	go startLogger(activityTracker, filter, pauses, events, proc)
 
-	// 4. Start the Processor
+	// 4. Start the Processor and the retention janitor
 	proc.Start()
+	jan := janitor.NewJanitor(store, retentionPolicy(cfg.Retention),
+		time.Duration(cfg.Retention.IntervalHours)*time.Hour)
+	jan.Start()
 
 	// 5. Start the API Server
-	apiServer := api.NewServer(store)
-	go apiServer.Start(apiAddr)
+	apiOpts := []api.Option{api.WithPauseController(pauses)}
+	if cfg.ActivityWatchAPI {
+		apiOpts = append(apiOpts, api.WithActivityWatchAPI(filter))
+	}
+	var syncs *peersync.Scheduler
+	if cfg.Sync.Enabled {
+		token, err := syncToken(cfg.Sync)
+		if err != nil {
+			log.Fatalf("Invalid sync settings: %v", err)
+		}
+		apiOpts = append(apiOpts, api.WithSync(store, token))
+		if len(cfg.Sync.Peers) > 0 {
+			syncs = peersync.NewScheduler(peersync.NewClient(store, token), cfg.Sync.Peers,
+				time.Duration(cfg.Sync.IntervalMinutes)*time.Minute)
+		}
+	}
+	apiServer := api.NewServer(store, apiOpts...)
+	go apiServer.Start(cfg.APIAddr)
+	if syncs != nil {
+		syncs.Start()
+	}
+
+	// 6. Start scheduled backups (SQLite only)
+	var backups *backup.Scheduler
+	if cfg.Backup.Enabled && cfg.DatabaseURL == "" {
+		backups = newBackupScheduler(cfg, store)
+		backups.Start()
+	}
 
 	// Wait for shutdown signal
-	waitForShutdown(proc)
+	waitForShutdown(proc, jan, pauses, events, backups, syncs)
 	log.Println("Personal OS Backend shut down gracefully.")
 }
 
+// openStore opens PostgreSQL when a DSN is configured and the local SQLite file otherwise.
+func openStore(cfg config.Config) (*storage.DBStore, error) {
+	var opts []storage.Option
+	if cfg.Encryption.Enabled() {
+		key, err := titleKeySource(cfg.Encryption.KeyFile, cfg.Encryption.PassphraseEnv)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, storage.WithTitleKey(key))
+	}
+
+	if cfg.DatabaseURL != "" {
+		log.Printf("Using PostgreSQL storage as device %q", cfg.DeviceID)
+		return storage.NewPostgresStore(cfg.DatabaseURL, cfg.DeviceID, opts...)
+	}
+	opts = append(opts, storage.WithDeviceID(cfg.DeviceID))
+	return storage.NewDBStore(cfg.DBPath, opts...)
+}
+
+// syncToken reads the shared sync secret. Sync exchanges the complete
+// history, so it is an error not to configure one.
+func syncToken(cfg config.SyncConfig) (string, error) {
+	if cfg.TokenEnv == "" {
+		return "", errors.New("sync.token_env must name an environment variable holding the sync token")
+	}
+	token := os.Getenv(cfg.TokenEnv)
+	if token == "" {
+		return "", fmt.Errorf("environment variable %s holding the sync token is empty", cfg.TokenEnv)
+	}
+	return token, nil
+}
+
+// titleKeySource resolves a key file or passphrase environment variable into
+// an encryption key source.
+func titleKeySource(keyFile, passphraseEnv string) (encryption.KeySource, error) {
+	if keyFile != "" {
+		return encryption.KeySource{KeyFile: keyFile}, nil
+	}
+	passphrase := os.Getenv(passphraseEnv)
+	if passphrase == "" {
+		return encryption.KeySource{}, fmt.Errorf("environment variable %s holding the title passphrase is empty", passphraseEnv)
+	}
+	return encryption.KeySource{Passphrase: passphrase}, nil
+}
+
+// newBackupScheduler builds the backup scheduler described by the config.
+func newBackupScheduler(cfg config.Config, store *storage.DBStore) *backup.Scheduler {
+	return backup.NewScheduler(store, cfg.DBPath, cfg.Backup.Dir,
+		time.Duration(cfg.Backup.IntervalHours)*time.Hour,
+		backup.Retention{
+			Daily:   cfg.Backup.KeepDaily,
+			Weekly:  cfg.Backup.KeepWeekly,
+			Monthly: cfg.Backup.KeepMonthly,
+		})
+}
+
+// retentionPolicy converts the configured retention days into a storage policy.
+func retentionPolicy(cfg config.RetentionConfig) storage.RetentionPolicy {
+	const day = 24 * time.Hour
+	return storage.RetentionPolicy{
+		DropTitlesAfter:         time.Duration(cfg.DropTitlesAfterDays) * day,
+		DeleteUnclassifiedAfter: time.Duration(cfg.DeleteUnclassifiedAfterDays) * day,
+		DeleteClassifiedAfter:   time.Duration(cfg.DeleteClassifiedAfterDays) * day,
+		EmptyTrashAfter:         time.Duration(cfg.EmptyTrashAfterDays) * day,
+	}
+}
+
+// privacyFilter compiles the configured privacy settings.
+func privacyFilter(cfg config.PrivacyConfig) (*privacy.Filter, error) {
+	rules := privacy.Rules{
+		DenyApps:           cfg.DenyApps,
+		AppOnlyApps:        cfg.AppOnlyApps,
+		Builtins:           cfg.ScrubBuiltins,
+		DropPrivateWindows: cfg.DropPrivateWindows,
+	}
+	for _, scrub := range cfg.Scrub {
+		rules.Scrub = append(rules.Scrub, privacy.ScrubRule{Pattern: scrub.Pattern, Replacement: scrub.Replacement})
+	}
+	return privacy.NewFilter(rules)
+}
+
+// pauseSchedules parses the configured "off the clock" windows.
+func pauseSchedules(cfg []config.PauseScheduleConfig) ([]pause.Schedule, error) {
+	var schedules []pause.Schedule
+	for _, c := range cfg {
+		sched, err := pause.ParseSchedule(c.Days, c.Start, c.End)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, sched)
+	}
+	return schedules, nil
+}
+
 // startLogger is the core loop that gets activity and logs it with dynamic polling intervals.
-func startLogger(t tracker.Tracker, s *storage.DBStore, proc *processor.Processor) {
+// Events pass through the privacy filter before they are written, and nothing
+// is captured while the pause controller reports tracking as paused.
+func startLogger(t tracker.Tracker, filter *privacy.Filter, pauses *pause.Controller, s storage.EventWriter, proc *processor.Processor) {
 	log.Println("Logger started. Tracking activity...")
 
 	var lastPowerStateLog time.Time
@@ -90,6 +252,10 @@ func startLogger(t tracker.Tracker, s *storage.DBStore, proc *processor.Processo
 	}()
 
 	for range tickerChan {
+		if pauses.Paused(time.Now()) {
+			continue
+		}
+
 		activity, err := t.GetActivity()
 		if err != nil {
 			// Check if this is a power state related error (tracking paused)
@@ -133,15 +299,17 @@ func startLogger(t tracker.Tracker, s *storage.DBStore, proc *processor.Processo
 		}
 
 		if activity.AppName != "" {
-			event := models.RawEvent{
+			event, ok := filter.Apply(models.RawEvent{
 				Timestamp:   time.Now(),
 				AppName:     activity.AppName,
 				WindowTitle: activity.WindowTitle,
+			})
+			if ok {
+				if err := s.InsertRawEvent(event); err != nil {
+					log.Printf("Error inserting raw event: %v", err)
+				}
+				log.Printf("Logged Raw Event: %s - %s", event.AppName, event.WindowTitle)
 			}
-			if err := s.InsertRawEvent(event); err != nil {
-				log.Printf("Error inserting raw event: %v", err)
-			}
-			log.Printf("Logged Raw Event: %s - %s", activity.AppName, activity.WindowTitle)
 		}
 
 		// Log power state information periodically (every 5 minutes)
@@ -165,11 +333,22 @@ func isPowerStateError(err error) bool {
 }
 
 // waitForShutdown handles graceful shutdown on interrupt signals.
-func waitForShutdown(proc *processor.Processor) {
+func waitForShutdown(proc *processor.Processor, jan *janitor.Janitor, pauses *pause.Controller, events *storage.BufferedEventWriter, backups *backup.Scheduler, syncs *peersync.Scheduler) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutdown signal received...")
 	proc.Stop()
+	jan.Stop()
+	pauses.Close(time.Now())
+	if backups != nil {
+		backups.Stop()
+	}
+	if syncs != nil {
+		syncs.Stop()
+	}
+	if err := events.Close(); err != nil {
+		log.Printf("Error flushing buffered raw events: %v", err)
+	}
 	// In a real app, you would also gracefully shut down the API server.
 }
@@ -10,6 +10,13 @@ type RawEvent struct {
 	Timestamp   time.Time `json:"timestamp"`
 	AppName     string    `json:"app_name"`
 	WindowTitle string    `json:"window_title"`
+	// Source is the watcher that captured the event; empty means the
+	// built-in tracker.
+	Source string `json:"source,omitempty"`
+	// URL and Cwd are the page a browser showed and the directory a
+	// terminal or editor worked in, when the watcher reports them.
+	URL string `json:"url,omitempty"`
+	Cwd string `json:"cwd,omitempty"`
 }
 
 // ActivitySession represents a consolidated block of time spent on a single activity.
@@ -21,18 +28,51 @@ type ActivitySession struct {
 	EndTime          time.Time `json:"-"`
 	Duration         int64     `json:"duration_seconds"` // Duration in seconds
 	ClassificationID *int64    `json:"classification_id,omitempty"`
+	// Source is where the session came from: the tracker, an importer or a
+	// manual entry.
+	Source string `json:"source"`
+	// Note is free text attached to the session, e.g. to a manual entry.
+	Note string `json:"note,omitempty"`
+	// Tags are free-form labels such as "billable" or "client:acme". A
+	// session can carry any number of them, unlike its one classification.
+	Tags []string `json:"tags,omitempty"`
+	URL  string   `json:"url,omitempty"`
+	Cwd  string   `json:"cwd,omitempty"`
+	// ProjectID attributes the session to a project for billing.
+	ProjectID *int64 `json:"project_id,omitempty"`
+	// TrashedAt is set while the session is in the trash.
+	TrashedAt *time.Time `json:"-"`
+	// AutoClassified is set when a rule, rather than the user, classified the
+	// session. Applying a rule to history may only override these.
+	AutoClassified bool `json:"-"`
 }
 
-// MarshalJSON ensures StartTime and EndTime are sent as Unix timestamps (int)
+// Session sources.
+const (
+	SourceTracker       = "tracker"
+	SourceActivityWatch = "activitywatch"
+	SourceRescueTime    = "rescuetime"
+	SourceToggl         = "toggl"
+	SourceManual        = "manual"
+)
+
+// MarshalJSON ensures StartTime, EndTime and TrashedAt are sent as Unix timestamps (int)
 func (s ActivitySession) MarshalJSON() ([]byte, error) {
 	type Alias ActivitySession
+	var trashedAt *int64
+	if s.TrashedAt != nil {
+		t := s.TrashedAt.Unix()
+		trashedAt = &t
+	}
 	return json.Marshal(&struct {
-		StartTime int64 `json:"start_time"`
-		EndTime   int64 `json:"end_time"`
+		StartTime int64  `json:"start_time"`
+		EndTime   int64  `json:"end_time"`
+		TrashedAt *int64 `json:"trashed_at,omitempty"`
 		*Alias
 	}{
 		StartTime: s.StartTime.Unix(),
 		EndTime:   s.EndTime.Unix(),
+		TrashedAt: trashedAt,
 		Alias:     (*Alias)(&s),
 	})
 }
@@ -43,15 +83,30 @@ type Classification struct {
 	UserDefinedName string `json:"user_defined_name"`
 	IsHelpful       bool   `json:"is_helpful"`
 	GoalContext     string `json:"goal_context"` // e.g., "Work", "Relax", "Learn"
+	// ParentID nests the classification under a broader one, e.g. "Go
+	// Programming" under "Programming". Nil for a top-level classification.
+	ParentID *int64 `json:"parent_id,omitempty"`
+}
+
+// ClassificationNode is a classification with the ones nested under it.
+type ClassificationNode struct {
+	Classification
+	Children []ClassificationNode `json:"children,omitempty"`
 }
 
 // ClassificationRequest is used by the API to classify a set of activities.
+//
+// Requests that name a classification create it if it does not exist, with
+// IsHelpful and GoalContext, or not helpful and no goal context when they
+// are left out. For an existing classification they may be left out; if
+// given, they must match its settings, which only
+// UpdateClassificationRequest changes.
 type ClassificationRequest struct {
-	AppName         string `json:"app_name"`
-	WindowTitle     string `json:"window_title"`
-	UserDefinedName string `json:"user_defined_name"`
-	IsHelpful       bool   `json:"is_helpful"`
-	GoalContext     string `json:"goal_context"`
+	AppName         string  `json:"app_name"`
+	WindowTitle     string  `json:"window_title"`
+	UserDefinedName string  `json:"user_defined_name"`
+	IsHelpful       *bool   `json:"is_helpful,omitempty"`
+	GoalContext     *string `json:"goal_context,omitempty"`
 }
 
 // SessionIdentifier is used to uniquely identify a session for batch classification.
@@ -64,34 +119,176 @@ type SessionIdentifier struct {
 type BatchClassificationRequest struct {
 	Sessions        []SessionIdentifier `json:"sessions"`
 	UserDefinedName string              `json:"user_defined_name"`
-	IsHelpful       bool                `json:"is_helpful"`
-	GoalContext     string              `json:"goal_context"`
+	IsHelpful       *bool               `json:"is_helpful,omitempty"`
+	GoalContext     *string             `json:"goal_context,omitempty"`
 }
 
-// ClassificationRule defines a rule for automatic classification.
+// How a rule pattern is compared with an app name or window title. Exact
+// compares whole values case-sensitively, as app names are recorded, and
+// iexact ignores case; contains, prefix and glob ignore case too. Glob
+// patterns use * for any run of characters and ? for a single one, and must
+// match the whole value. Regular expressions use Go syntax and match anywhere
+// unless anchored; (?i) makes them ignore case.
+const (
+	MatchExact     = "exact"
+	MatchExactFold = "iexact"
+	MatchContains  = "contains"
+	MatchPrefix    = "prefix"
+	MatchGlob      = "glob"
+	MatchRegex     = "regex"
+)
+
+// Fields a RuleCondition can test.
+const (
+	ConditionFieldApp    = "app"
+	ConditionFieldTitle  = "title"
+	ConditionFieldURL    = "url"
+	ConditionFieldDomain = "domain"
+	ConditionFieldCwd    = "cwd"
+)
+
+// RuleCondition is a condition on a session, written in JSON. Each condition
+// is exactly one of: a combination of others (All, Any or Not), a pattern
+// test on a field, a set of weekdays, a time-of-day window or a duration
+// range. Weekdays and times are those of the session's start in local time.
+// For example, YouTube on weekdays from 9 to 5:
+//
+//	{"all": [{"field": "domain", "value": "youtube.com"},
+//	         {"weekdays": ["mon", "tue", "wed", "thu", "fri"]},
+//	         {"from": "09:00", "to": "17:00"}]}
+type RuleCondition struct {
+	All []RuleCondition `json:"all,omitempty"`
+	Any []RuleCondition `json:"any,omitempty"`
+	Not *RuleCondition  `json:"not,omitempty"`
+
+	// Field is app, title, url, domain or cwd, compared with Value as Match
+	// says. Match defaults to exact for app, contains for title and url, and
+	// prefix for cwd; a domain matches its subdomains too unless Match is set.
+	// Value must not be empty.
+	Field string `json:"field,omitempty"`
+	Match string `json:"match,omitempty"`
+	Value string `json:"value,omitempty"`
+
+	// Weekdays are day names such as "mon" or "Tuesday".
+	Weekdays []string `json:"weekdays,omitempty"`
+
+	// From and To are HH:MM. A window whose To is not after its From runs
+	// past midnight, so 20:00 to 00:00 is the rest of the evening.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	// MinSeconds and MaxSeconds bound the session's duration; zero leaves
+	// that side open.
+	MinSeconds int64 `json:"min_seconds,omitempty"`
+	MaxSeconds int64 `json:"max_seconds,omitempty"`
+}
+
+// ClassificationRule defines a rule for automatic classification. AppName and
+// WindowTitleContains are patterns compared as AppMatch and TitleMatch say,
+// by default an exact app name and a title substring. An empty pattern leaves
+// that field unconstrained, so a rule can match on the app or the title
+// alone, but a rule needs at least one pattern or a Condition. A rule with a
+// Condition also requires it to hold.
 type ClassificationRule struct {
-	ID                  int64  `json:"id"`
-	AppName             string `json:"app_name"`
-	WindowTitleContains string `json:"window_title_contains"`
-	ClassificationID    int64  `json:"classification_id"`
-	Priority            int    `json:"priority"`
+	ID                  int64          `json:"id"`
+	AppName             string         `json:"app_name"`
+	AppMatch            string         `json:"app_match"`
+	WindowTitleContains string         `json:"window_title_contains"`
+	TitleMatch          string         `json:"title_match"`
+	ClassificationID    int64          `json:"classification_id"`
+	Priority            int            `json:"priority"`
+	Condition           *RuleCondition `json:"condition,omitempty"`
+	// Tags and Note are added to the sessions the rule classifies. The note
+	// only fills in an empty one.
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
 }
 
-// CreateClassificationRuleRequest is the model for the API request to create a rule.
+// CreateClassificationRuleRequest is the model for the API request to create a
+// rule. An empty AppMatch or TitleMatch means exact or contains respectively.
 type CreateClassificationRuleRequest struct {
-	AppName             string `json:"app_name"`
-	WindowTitleContains string `json:"window_title_contains"`
-	UserDefinedName     string `json:"user_defined_name"`
-	IsHelpful           bool   `json:"is_helpful"`
-	GoalContext         string `json:"goal_context"`
+	AppName             string         `json:"app_name"`
+	AppMatch            string         `json:"app_match"`
+	WindowTitleContains string         `json:"window_title_contains"`
+	TitleMatch          string         `json:"title_match"`
+	Condition           *RuleCondition `json:"condition"`
+	UserDefinedName     string         `json:"user_defined_name"`
+	IsHelpful           *bool          `json:"is_helpful,omitempty"`
+	GoalContext         *string        `json:"goal_context,omitempty"`
+	Tags                []string       `json:"tags"`
+	Note                string         `json:"note"`
+	ApplyRuleOptions
+}
+
+// UpdateClassificationRuleRequest replaces the patterns, classification,
+// tags and note of a rule. Sessions it classified before keep their
+// classification unless ApplyToHistory is set.
+type UpdateClassificationRuleRequest struct {
+	ID int64 `json:"id"`
+	CreateClassificationRuleRequest
+}
+
+// ApplyRuleOptions control whether saving a rule also classifies sessions
+// recorded before it existed. Only sessions the rule is the first to match
+// are changed, and sessions classified by hand never are.
+type ApplyRuleOptions struct {
+	// ApplyToHistory classifies the existing unclassified sessions the rule
+	// matches.
+	ApplyToHistory bool `json:"apply_to_history"`
+	// OverrideAutomatic also reclassifies sessions another rule classified.
+	OverrideAutomatic bool `json:"override_automatic"`
+	// DryRun reports what would change without saving anything.
+	DryRun bool `json:"dry_run"`
+}
+
+// RuleApplyResult reports the sessions a rule classified, or would classify
+// on a dry run, when applied to history.
+type RuleApplyResult struct {
+	// RuleID is the saved rule; it is 0 on a dry run that creates one.
+	RuleID   int64 `json:"rule_id,omitempty"`
+	Sessions int   `json:"sessions"`
+	Seconds  int64 `json:"seconds"`
+	DryRun   bool  `json:"dry_run,omitempty"`
+}
+
+// CreateClassificationRequest creates a classification without classifying
+// anything yet.
+type CreateClassificationRequest struct {
+	UserDefinedName string `json:"user_defined_name"`
+	IsHelpful       bool   `json:"is_helpful"`
+	GoalContext     string `json:"goal_context"`
+	ParentID        *int64 `json:"parent_id"`
+}
+
+// UpdateClassificationRequest renames a classification or changes its
+// attributes. Sessions and rules using it follow along. A nil ParentID moves
+// it to the top level.
+type UpdateClassificationRequest struct {
+	ID              int64  `json:"id"`
+	UserDefinedName string `json:"user_defined_name"`
+	IsHelpful       bool   `json:"is_helpful"`
+	GoalContext     string `json:"goal_context"`
+	ParentID        *int64 `json:"parent_id"`
+}
+
+// MergeClassificationsRequest moves every session and rule of the source
+// classification to the target and deletes the source.
+type MergeClassificationsRequest struct {
+	SourceID int64 `json:"source_id"`
+	TargetID int64 `json:"target_id"`
 }
 
 // RuleInfo is a model for returning a rule joined with its classification name.
 type RuleInfo struct {
-	ID                  int64  `json:"id"`
-	AppName             string `json:"app_name"`
-	WindowTitleContains string `json:"window_title_contains"`
-	UserDefinedName     string `json:"user_defined_name"`
+	ID                  int64          `json:"id"`
+	AppName             string         `json:"app_name"`
+	AppMatch            string         `json:"app_match"`
+	WindowTitleContains string         `json:"window_title_contains"`
+	TitleMatch          string         `json:"title_match"`
+	Condition           *RuleCondition `json:"condition,omitempty"`
+	UserDefinedName     string         `json:"user_defined_name"`
+	Tags                []string       `json:"tags,omitempty"`
+	Note                string         `json:"note,omitempty"`
 }
 
 // RecentActivityInfo is a model for a recently classified session.
@@ -101,29 +298,431 @@ type RecentActivityInfo struct {
 	WindowTitle     string `json:"window_title"`
 	UserDefinedName string `json:"user_defined_name"`
 	StartTime       int64  `json:"start_time"`
-	IsAuto          bool   `json:"is_auto"`
+	// IsAuto is set when a rule classified the session rather than the user.
+	IsAuto bool `json:"is_auto"`
 }
 
 // ReclassifyRequest is used to update the classification of an existing session.
 type ReclassifyRequest struct {
-	SessionID       int64  `json:"session_id"`
-	UserDefinedName string `json:"user_defined_name"`
-	IsHelpful       bool   `json:"is_helpful"`
-	GoalContext     string `json:"goal_context"`
+	SessionID       int64   `json:"session_id"`
+	UserDefinedName string  `json:"user_defined_name"`
+	IsHelpful       *bool   `json:"is_helpful,omitempty"`
+	GoalContext     *string `json:"goal_context,omitempty"`
+}
+
+// AdjustSessionRequest moves the start and end of a session, as Unix times.
+type AdjustSessionRequest struct {
+	SessionID int64 `json:"session_id"`
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+}
+
+// SplitSessionRequest splits a session in two at At, a Unix time strictly
+// inside it. Both parts keep the session's classification.
+type SplitSessionRequest struct {
+	SessionID int64 `json:"session_id"`
+	At        int64 `json:"at"`
+}
+
+// ManualEntryRequest records an activity the tracker cannot see, such as
+// reading a paper book. Give StartTime and EndTime as Unix times, or a
+// DurationSeconds with either StartTime or neither, which means "just ended".
+type ManualEntryRequest struct {
+	// AppName labels the entry in place of an app; it defaults to "Manual".
+	AppName         string   `json:"app_name"`
+	StartTime       int64    `json:"start_time"`
+	EndTime         int64    `json:"end_time"`
+	DurationSeconds int64    `json:"duration_seconds"`
+	UserDefinedName string   `json:"user_defined_name"`
+	IsHelpful       *bool    `json:"is_helpful,omitempty"`
+	GoalContext     *string  `json:"goal_context,omitempty"`
+	Note            string   `json:"note"`
+	Tags            []string `json:"tags"`
+	// Force records the entry even if it overlaps recorded sessions.
+	Force bool `json:"force"`
+}
+
+// TagSessionsRequest adds and removes tags on sessions.
+type TagSessionsRequest struct {
+	SessionIDs []int64  `json:"session_ids"`
+	Add        []string `json:"add"`
+	Remove     []string `json:"remove"`
+}
+
+// SessionNoteRequest replaces the note of a session. An empty note removes it.
+type SessionNoteRequest struct {
+	SessionID int64  `json:"session_id"`
+	Note      string `json:"note"`
+}
+
+// MergeSessionsRequest merges adjacent sessions of the same app into a new
+// session spanning them all. The merged sessions move to the trash.
+type MergeSessionsRequest struct {
+	SessionIDs []int64 `json:"session_ids"`
 }
 
 // ExistingClassification represents an existing classification for dropdown options.
 type ExistingClassification struct {
+	ID              int64  `json:"id"`
 	UserDefinedName string `json:"user_defined_name"`
 	GoalContext     string `json:"goal_context"`
 	IsHelpful       bool   `json:"is_helpful"`
+	ParentID        *int64 `json:"parent_id,omitempty"`
 }
 
 // SkillProgress represents XP and level information for a user-defined classification (skill).
+// A parent skill levels up from the time of every skill nested under it:
+// TotalXP includes its descendants, OwnXP only time classified as the skill
+// itself.
 type SkillProgress struct {
+	ID              int64           `json:"id"`
+	UserDefinedName string          `json:"user_defined_name"`
+	Level           int             `json:"level"`
+	CurrentXP       int64           `json:"current_xp"`
+	XPForNextLevel  int64           `json:"xp_for_next_level"`
+	TotalXP         int64           `json:"total_xp"`
+	OwnXP           int64           `json:"own_xp"`
+	Children        []SkillProgress `json:"children,omitempty"`
+}
+
+// Project is paid work for a client that sessions are attributed to.
+type Project struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Client string `json:"client,omitempty"`
+	// HourlyRate is in the minor unit of Currency, e.g. cents. Zero means
+	// the project is not billable.
+	HourlyRate int64  `json:"hourly_rate_cents"`
+	Currency   string `json:"currency,omitempty"`
+}
+
+// CreateProjectRequest adds a project.
+type CreateProjectRequest struct {
+	Name       string `json:"name"`
+	Client     string `json:"client"`
+	HourlyRate int64  `json:"hourly_rate_cents"`
+	Currency   string `json:"currency"`
+}
+
+// UpdateProjectRequest renames a project or changes its client or rate.
+type UpdateProjectRequest struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	Client     string `json:"client"`
+	HourlyRate int64  `json:"hourly_rate_cents"`
+	Currency   string `json:"currency"`
+}
+
+// Fields a project rule can match.
+const (
+	ProjectFieldTitle = "title"
+	ProjectFieldCwd   = "cwd"
+	ProjectFieldURL   = "url"
+)
+
+// ProjectRule attributes new sessions to a project when one of their fields
+// contains a pattern, case-insensitively. The newest matching rule wins.
+type ProjectRule struct {
+	ID        int64  `json:"id"`
+	ProjectID int64  `json:"project_id"`
+	Field     string `json:"field"`
+	Contains  string `json:"contains"`
+}
+
+// CreateProjectRuleRequest adds a project rule.
+type CreateProjectRuleRequest struct {
+	ProjectID int64  `json:"project_id"`
+	Field     string `json:"field"`
+	Contains  string `json:"contains"`
+}
+
+// AssignProjectRequest attributes sessions to a project, or removes their
+// project when ProjectID is nil.
+type AssignProjectRequest struct {
+	SessionIDs []int64 `json:"session_ids"`
+	ProjectID  *int64  `json:"project_id"`
+}
+
+// TimesheetEntry is the time attributed to one project on one day.
+type TimesheetEntry struct {
+	Date      string `json:"date"` // YYYY-MM-DD
+	ProjectID int64  `json:"project_id"`
+	Project   string `json:"project"`
+	Client    string `json:"client,omitempty"`
+	Seconds   int64  `json:"seconds"`
+	// RoundedSeconds is Seconds rounded up to the timesheet's increment.
+	RoundedSeconds int64 `json:"rounded_seconds"`
+	// Amount is the rounded time at the project's rate, in minor units.
+	Amount int64 `json:"amount_cents"`
+}
+
+// TimesheetTotal sums the entries of one project.
+type TimesheetTotal struct {
+	ProjectID      int64  `json:"project_id"`
+	Project        string `json:"project"`
+	Client         string `json:"client,omitempty"`
+	HourlyRate     int64  `json:"hourly_rate_cents"`
+	Currency       string `json:"currency,omitempty"`
+	Seconds        int64  `json:"seconds"`
+	RoundedSeconds int64  `json:"rounded_seconds"`
+	Amount         int64  `json:"amount_cents"`
+}
+
+// Timesheet is the project time of a date range, per project and day. Start
+// and End are Unix seconds, zero when the range is open.
+type Timesheet struct {
+	Start           int64            `json:"start"`
+	End             int64            `json:"end"`
+	RoundingMinutes int              `json:"rounding_minutes"`
+	Entries         []TimesheetEntry `json:"entries"`
+	Totals          []TimesheetTotal `json:"totals"`
+}
+
+// PurgeRequest selects history to permanently delete. At least one of AppName
+// and TitlePattern must be set. TitlePattern uses SQL LIKE syntax ('%' matches
+// any run of characters, '_' a single character) and is case-insensitive.
+type PurgeRequest struct {
+	AppName      string `json:"app_name"`
+	TitlePattern string `json:"title_pattern"`
+	DryRun       bool   `json:"dry_run"`
+}
+
+// BulkDeleteRequest moves every session of an app that starts in
+// [StartTime, EndTime) to the trash. A zero StartTime or EndTime leaves that
+// end of the range open.
+type BulkDeleteRequest struct {
+	AppName   string `json:"app_name"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+}
+
+// RestoreSessionsRequest takes sessions back out of the trash.
+type RestoreSessionsRequest struct {
+	SessionIDs []int64 `json:"session_ids"`
+}
+
+// PurgeResult reports what a purge removed, or would remove for a dry run.
+type PurgeResult struct {
+	Sessions      int64 `json:"sessions"`
+	RawEvents     int64 `json:"raw_events"`
+	TotalDuration int64 `json:"total_duration_seconds"`
+	DryRun        bool  `json:"dry_run"`
+}
+
+// Reasons recorded on away periods.
+const (
+	AwayUserPaused = "user_paused"
+	AwayScheduled  = "scheduled"
+	AwayAFK        = "afk"
+)
+
+// AwayPeriod is a span of time in which tracking was deliberately off, so it
+// can be told apart from time the tracker simply missed.
+type AwayPeriod struct {
+	ID        int64     `json:"id"`
+	StartTime time.Time `json:"-"`
+	EndTime   time.Time `json:"-"`
+	Reason    string    `json:"reason"`
+}
+
+// MarshalJSON sends StartTime and EndTime as Unix timestamps.
+func (p AwayPeriod) MarshalJSON() ([]byte, error) {
+	type Alias AwayPeriod
+	return json.Marshal(&struct {
+		StartTime int64 `json:"start_time"`
+		EndTime   int64 `json:"end_time"`
+		*Alias
+	}{
+		StartTime: p.StartTime.Unix(),
+		EndTime:   p.EndTime.Unix(),
+		Alias:     (*Alias)(&p),
+	})
+}
+
+// PauseRequest pauses tracking. A zero Minutes pauses until resumed.
+type PauseRequest struct {
+	Minutes int `json:"minutes"`
+}
+
+// TrackingStatus reports whether the logger is currently recording.
+type TrackingStatus struct {
+	Paused bool   `json:"paused"`
+	Reason string `json:"reason,omitempty"`
+	// Until is the Unix time the pause ends, if known.
+	Until *int64 `json:"until,omitempty"`
+}
+
+// Bucket is an ActivityWatch bucket registered by an external watcher. Events
+// sent to it are stored with the bucket ID as their source.
+type Bucket struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Client   string    `json:"client"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+}
+
+// SyncBatch carries every change a device has made after a given sequence
+// number. Rows are matched by natural key: classifications and projects by
+// name, rules by app and title pattern, project rules by field and pattern,
+// sessions by UID.
+type SyncBatch struct {
+	DeviceID string `json:"device_id"`
+	// Seq is the sender's sequence number the batch is complete up to; the
+	// receiver passes it back as since on the next pull.
+	Seq             int64                `json:"seq"`
+	Classifications []SyncClassification `json:"classifications"`
+	Rules           []SyncRule           `json:"rules"`
+	Projects        []SyncProject        `json:"projects"`
+	ProjectRules    []SyncProjectRule    `json:"project_rules"`
+	Sessions        []SyncSession        `json:"sessions"`
+}
+
+// SyncClassification is a classification in a SyncBatch. UpdatedAt is in
+// Unix milliseconds; the later write wins. A deleted, renamed or merged
+// classification is sent as a tombstone with only its old name and UpdatedAt
+// set. Parents are referred to by name, like classifications everywhere else
+// in a batch.
+type SyncClassification struct {
 	UserDefinedName string `json:"user_defined_name"`
-	Level           int    `json:"level"`
-	CurrentXP       int64  `json:"current_xp"`
-	XPForNextLevel  int64  `json:"xp_for_next_level"`
-	TotalXP         int64  `json:"total_xp"`
+	IsHelpful       bool   `json:"is_helpful"`
+	GoalContext     string `json:"goal_context"`
+	ParentName      string `json:"parent_name,omitempty"`
+	UpdatedAt       int64  `json:"updated_at"`
+	Deleted         bool   `json:"deleted,omitempty"`
+}
+
+// SyncRule is a classification rule in a SyncBatch. A deleted rule is sent as
+// a tombstone with only its key and UpdatedAt set.
+type SyncRule struct {
+	AppName             string         `json:"app_name"`
+	AppMatch            string         `json:"app_match,omitempty"`
+	WindowTitleContains string         `json:"window_title_contains"`
+	TitleMatch          string         `json:"title_match,omitempty"`
+	Condition           *RuleCondition `json:"condition,omitempty"`
+	Classification      string         `json:"classification,omitempty"`
+	Priority            int64          `json:"priority"`
+	Tags                []string       `json:"tags,omitempty"`
+	Note                string         `json:"note,omitempty"`
+	UpdatedAt           int64          `json:"updated_at"`
+	Deleted             bool           `json:"deleted,omitempty"`
+}
+
+// SyncProject is a project in a SyncBatch. Like a classification, a deleted
+// or renamed project is sent as a tombstone with only its old name and
+// UpdatedAt set.
+type SyncProject struct {
+	Name       string `json:"name"`
+	Client     string `json:"client,omitempty"`
+	HourlyRate int64  `json:"hourly_rate_cents,omitempty"`
+	Currency   string `json:"currency,omitempty"`
+	UpdatedAt  int64  `json:"updated_at"`
+	Deleted    bool   `json:"deleted,omitempty"`
+}
+
+// SyncProjectRule is a project rule in a SyncBatch. A deleted rule is sent as
+// a tombstone with only its key and UpdatedAt set.
+type SyncProjectRule struct {
+	Field     string `json:"field"`
+	Contains  string `json:"contains"`
+	Project   string `json:"project,omitempty"`
+	UpdatedAt int64  `json:"updated_at"`
+	Deleted   bool   `json:"deleted,omitempty"`
+}
+
+// SyncSession is a session in a SyncBatch. A deleted session is sent as a
+// tombstone with only its UID and UpdatedAt set.
+type SyncSession struct {
+	UID            string   `json:"uid"`
+	DeviceID       string   `json:"device_id,omitempty"`
+	AppName        string   `json:"app_name,omitempty"`
+	WindowTitle    string   `json:"window_title,omitempty"`
+	StartTime      int64    `json:"start_time,omitempty"`
+	EndTime        int64    `json:"end_time,omitempty"`
+	Duration       int64    `json:"duration_seconds,omitempty"`
+	Classification string   `json:"classification,omitempty"`
+	Source         string   `json:"source,omitempty"`
+	Note           string   `json:"note,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+	URL            string   `json:"url,omitempty"`
+	Cwd            string   `json:"cwd,omitempty"`
+	Project        string   `json:"project,omitempty"`
+	AutoClassified bool     `json:"auto_classified,omitempty"`
+	TrashedAt      *int64   `json:"trashed_at,omitempty"`
+	UpdatedAt      int64    `json:"updated_at"`
+	Deleted        bool     `json:"deleted,omitempty"`
+}
+
+// SyncResult counts the changes applied by one merge. Changes that lost to a
+// newer local write are skipped.
+type SyncResult struct {
+	Classifications int `json:"classifications"`
+	Rules           int `json:"rules"`
+	Projects        int `json:"projects"`
+	Sessions        int `json:"sessions"`
+	Deleted         int `json:"deleted"`
+	Skipped         int `json:"skipped"`
+}
+
+// Operations recorded in the audit log.
+const (
+	AuditClassify       = "classify"
+	AuditClassifyBatch  = "classify_batch"
+	AuditReclassify     = "reclassify"
+	AuditDeleteSession  = "delete_session"
+	AuditDeleteSessions = "delete_sessions"
+	AuditRestore        = "restore"
+	AuditDeleteRule     = "delete_rule"
+	AuditApplyRule      = "apply_rule"
+	// AuditMergeSessions logs the merged sessions moving to the trash and
+	// the session replacing them coming out of it.
+	AuditMergeSessions = "merge_sessions"
+	// AuditUpdateClassification, AuditDeleteClassification and
+	// AuditMergeClassifications also log the sessions, rules and nested
+	// classifications they move.
+	AuditUpdateClassification = "update_classification"
+	AuditDeleteClassification = "delete_classification"
+	AuditMergeClassifications = "merge_classifications"
+	AuditUndo                 = "undo"
+)
+
+// AuditOperation is one entry of the append-only change log. Undoing an
+// operation appends an AuditUndo entry that reverts it.
+type AuditOperation struct {
+	ID        int64  `json:"id"`
+	Operation string `json:"operation"`
+	CreatedAt int64  `json:"created_at"`
+	// Reverts is the operation an undo entry reverted.
+	Reverts *int64 `json:"reverts,omitempty"`
+	Undone  bool   `json:"undone"`
+	// Changes is the number of rows the operation changed.
+	Changes int `json:"changes"`
+	// Skipped is only set on operations returned by Undo: the number of
+	// changes that could no longer be reverted, because the session or the
+	// classification they restore has been deleted since.
+	Skipped int `json:"skipped,omitempty"`
+}
+
+// UndoRequest reverts the most recent Count operations that have not been
+// undone yet, newest first. A zero Count undoes one.
+type UndoRequest struct {
+	Count int `json:"count"`
+}
+
+// SessionState is the state of a session before or after an operation.
+type SessionState struct {
+	ClassificationID *int64 `json:"classification_id"`
+	UserDefinedName  string `json:"user_defined_name,omitempty"`
+	AutoClassified   bool   `json:"auto_classified,omitempty"`
+	Trashed          bool   `json:"trashed,omitempty"`
+}
+
+// SessionHistoryEntry is an operation that changed a session.
+type SessionHistoryEntry struct {
+	LogID     int64        `json:"log_id"`
+	Operation string       `json:"operation"`
+	CreatedAt int64        `json:"created_at"`
+	Undone    bool         `json:"undone"`
+	Before    SessionState `json:"before"`
+	After     SessionState `json:"after"`
 }
@@ -0,0 +1,249 @@
+package pause
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+// Schedule is a recurring "off the clock" window, e.g. evenings or weekends.
+// A window whose End is not after its Start runs past midnight into the next
+// day.
+type Schedule struct {
+	// Days the window starts on. Empty means every day.
+	Days []time.Weekday
+	// Start and End are offsets from local midnight.
+	Start, End time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseSchedule builds a Schedule from day names ("mon", "Tuesday", ...) and
+// "HH:MM" times.
+func ParseSchedule(days []string, start, end string) (Schedule, error) {
+	var sched Schedule
+	for _, day := range days {
+		name := strings.ToLower(day)
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		wd, ok := weekdays[name]
+		if !ok {
+			return Schedule{}, fmt.Errorf("unknown day %q", day)
+		}
+		sched.Days = append(sched.Days, wd)
+	}
+
+	var err error
+	if sched.Start, err = parseClock(start); err != nil {
+		return Schedule{}, err
+	}
+	if sched.End, err = parseClock(end); err != nil {
+		return Schedule{}, err
+	}
+	return sched, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// activeAt reports whether now falls inside the window and, if so, when the
+// window ends.
+func (s Schedule) activeAt(now time.Time) (bool, time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	// A window that started yesterday may still be running past midnight.
+	for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		if !s.startsOn(day.Weekday()) {
+			continue
+		}
+		start := day.Add(s.Start)
+		end := day.Add(s.End)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !now.Before(start) && now.Before(end) {
+			return true, end
+		}
+	}
+	return false, time.Time{}
+}
+
+func (s Schedule) startsOn(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Status describes the current pause state.
+type Status struct {
+	Paused bool
+	Reason string
+	// Until is when the pause ends; zero when it lasts until resumed.
+	Until time.Time
+}
+
+// Controller decides whether the logger should record, combining manual
+// pauses from the API with the configured schedules. Every pause is recorded
+// as an away period once it ends. It is safe for concurrent use.
+type Controller struct {
+	mu        sync.Mutex
+	store     storage.AwayStore
+	schedules []Schedule
+
+	manual      bool
+	manualUntil time.Time // zero means until resumed
+	// skipUntil suppresses a scheduled pause the user resumed early.
+	skipUntil time.Time
+
+	current *Status // the pause in progress, if any
+	since   time.Time
+}
+
+// NewController creates a Controller that records away periods in store.
+func NewController(store storage.AwayStore, schedules []Schedule) *Controller {
+	return &Controller{store: store, schedules: schedules}
+}
+
+// Pause stops tracking for d, or until Resume when d is zero.
+func (c *Controller) Pause(now time.Time, d time.Duration) Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.manual = true
+	c.manualUntil = time.Time{}
+	if d > 0 {
+		c.manualUntil = now.Add(d)
+	}
+	return c.update(now)
+}
+
+// Resume restarts tracking. A scheduled pause in progress is skipped until
+// its window ends.
+func (c *Controller) Resume(now time.Time) Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.manual = false
+	if end := c.windowEnd(now); end.After(now) {
+		c.skipUntil = end
+	}
+	return c.update(now)
+}
+
+// Status returns the state at now, starting or ending pauses as needed. The
+// logger calls it on every tick.
+func (c *Controller) Status(now time.Time) Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.update(now)
+}
+
+// Paused reports whether tracking is paused at now.
+func (c *Controller) Paused(now time.Time) bool {
+	return c.Status(now).Paused
+}
+
+// Close records the pause in progress, if any. Call it on shutdown.
+func (c *Controller) Close(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.end(now)
+}
+
+func (c *Controller) update(now time.Time) Status {
+	if c.manual && !c.manualUntil.IsZero() && !now.Before(c.manualUntil) {
+		c.manual = false
+		c.end(c.manualUntil)
+	}
+
+	want := Status{}
+	if c.manual {
+		want = Status{Paused: true, Reason: models.AwayUserPaused, Until: c.manualUntil}
+	} else if active, end := c.scheduled(now); active && !now.Before(c.skipUntil) {
+		want = Status{Paused: true, Reason: models.AwayScheduled, Until: end}
+	}
+
+	if c.current != nil && (!want.Paused || c.current.Reason != want.Reason) {
+		// The logger may not have ticked while the machine slept, so end
+		// a scheduled pause when its window closed rather than now.
+		at := now
+		if c.current.Reason == models.AwayScheduled && !c.current.Until.IsZero() && c.current.Until.Before(now) {
+			at = c.current.Until
+		}
+		c.end(at)
+	}
+	if want.Paused && c.current == nil {
+		log.Printf("Tracking paused (%s)", want.Reason)
+		c.current = &want
+		c.since = now
+	}
+	if c.current != nil {
+		c.current.Until = want.Until
+	}
+	return want
+}
+
+// end records the pause in progress as finishing at the given time.
+func (c *Controller) end(at time.Time) {
+	if c.current == nil {
+		return
+	}
+	period := models.AwayPeriod{StartTime: c.since, EndTime: at, Reason: c.current.Reason}
+	c.current = nil
+	log.Println("Tracking resumed")
+
+	if !at.After(period.StartTime) {
+		return
+	}
+	if err := c.store.RecordAwayPeriod(period); err != nil {
+		log.Printf("Error recording away period: %v", err)
+	}
+}
+
+// windowEnd returns when the schedule windows active at t end, or t itself
+// when none is active.
+func (c *Controller) windowEnd(t time.Time) time.Time {
+	until := t
+	for _, s := range c.schedules {
+		if ok, end := s.activeAt(t); ok && end.After(until) {
+			until = end
+		}
+	}
+	return until
+}
+
+// scheduled reports whether a schedule is active at now and when scheduled
+// time ends, following back-to-back windows such as Saturday into Sunday.
+func (c *Controller) scheduled(now time.Time) (bool, time.Time) {
+	until := now
+	// Bounded so that schedules covering the whole week cannot loop forever.
+	for i := 0; i < 14; i++ {
+		next := c.windowEnd(until)
+		if !next.After(until) {
+			break
+		}
+		until = next
+	}
+	return until.After(now), until
+}
@@ -0,0 +1,207 @@
+package pause
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// awayRecorder keeps the away periods a Controller records.
+type awayRecorder struct {
+	periods []models.AwayPeriod
+}
+
+func (r *awayRecorder) RecordAwayPeriod(period models.AwayPeriod) error {
+	r.periods = append(r.periods, period)
+	return nil
+}
+
+func (r *awayRecorder) GetAwayPeriods(start, end time.Time) ([]models.AwayPeriod, error) {
+	return r.periods, nil
+}
+
+// at returns a time on Friday 16 October 2026 plus the given number of days.
+func at(days int, clock string) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2026, time.October, 16+days, t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func mustSchedule(t *testing.T, days []string, start, end string) Schedule {
+	t.Helper()
+	s, err := ParseSchedule(days, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func assertPeriods(t *testing.T, got []models.AwayPeriod, want ...models.AwayPeriod) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("away periods = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if !got[i].StartTime.Equal(want[i].StartTime) || !got[i].EndTime.Equal(want[i].EndTime) || got[i].Reason != want[i].Reason {
+			t.Errorf("away period %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule([]string{"mon", "Tuesday", "SAT"}, "22:00", "07:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Schedule{Days: []time.Weekday{time.Monday, time.Tuesday, time.Saturday}, Start: 22 * time.Hour, End: 7*time.Hour + 30*time.Minute}
+	if len(s.Days) != 3 || s.Days[0] != want.Days[0] || s.Days[1] != want.Days[1] || s.Days[2] != want.Days[2] || s.Start != want.Start || s.End != want.End {
+		t.Errorf("ParseSchedule = %+v, want %+v", s, want)
+	}
+
+	bad := []struct {
+		name       string
+		days       []string
+		start, end string
+	}{
+		{"unknown day", []string{"someday"}, "09:00", "17:00"},
+		{"hour out of range", nil, "25:00", "17:00"},
+		{"not a clock time", nil, "09:00", "5pm"},
+	}
+	for _, tt := range bad {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSchedule(tt.days, tt.start, tt.end); err == nil {
+				t.Error("ParseSchedule succeeded")
+			}
+		})
+	}
+}
+
+func TestScheduleActiveAt(t *testing.T) {
+	// Friday nights, running into Saturday morning.
+	s := mustSchedule(t, []string{"fri"}, "22:00", "07:00")
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		active  bool
+		wantEnd time.Time
+	}{
+		{"before the window", at(0, "21:59"), false, time.Time{}},
+		{"start of the window", at(0, "22:00"), true, at(1, "07:00")},
+		{"after midnight", at(1, "06:59"), true, at(1, "07:00")},
+		{"end of the window", at(1, "07:00"), false, time.Time{}},
+		{"another weekday", at(-1, "23:00"), false, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			active, end := s.activeAt(tt.now)
+			if active != tt.active || !end.Equal(tt.wantEnd) {
+				t.Errorf("activeAt(%v) = %v, %v, want %v, %v", tt.now, active, end, tt.active, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestManualPause(t *testing.T) {
+	store := &awayRecorder{}
+	c := NewController(store, nil)
+
+	status := c.Pause(at(0, "10:00"), 30*time.Minute)
+	if !status.Paused || status.Reason != models.AwayUserPaused || !status.Until.Equal(at(0, "10:30")) {
+		t.Fatalf("Pause = %+v", status)
+	}
+	if !c.Paused(at(0, "10:29")) {
+		t.Error("not paused before the pause ran out")
+	}
+	if c.Paused(at(0, "10:45")) {
+		t.Error("still paused after the pause ran out")
+	}
+	// A timed pause ends when it ran out, not when it was next checked.
+	assertPeriods(t, store.periods, models.AwayPeriod{StartTime: at(0, "10:00"), EndTime: at(0, "10:30"), Reason: models.AwayUserPaused})
+
+	if status := c.Pause(at(0, "11:00"), 0); !status.Paused || !status.Until.IsZero() {
+		t.Fatalf("Pause until resumed = %+v", status)
+	}
+	if !c.Paused(at(3, "11:00")) {
+		t.Error("an open-ended pause ran out")
+	}
+	if status := c.Resume(at(3, "11:00")); status.Paused {
+		t.Fatalf("Resume = %+v", status)
+	}
+	assertPeriods(t, store.periods[1:], models.AwayPeriod{StartTime: at(0, "11:00"), EndTime: at(3, "11:00"), Reason: models.AwayUserPaused})
+}
+
+func TestScheduledPause(t *testing.T) {
+	store := &awayRecorder{}
+	c := NewController(store, []Schedule{mustSchedule(t, nil, "12:00", "13:00")})
+
+	if c.Paused(at(0, "11:59")) {
+		t.Error("paused before the schedule")
+	}
+	status := c.Status(at(0, "12:10"))
+	if !status.Paused || status.Reason != models.AwayScheduled || !status.Until.Equal(at(0, "13:00")) {
+		t.Fatalf("Status = %+v during the schedule", status)
+	}
+
+	// Resuming skips the rest of the window only.
+	if status := c.Resume(at(0, "12:20")); status.Paused {
+		t.Fatalf("Resume = %+v", status)
+	}
+	if c.Paused(at(0, "12:50")) {
+		t.Error("paused again in the window that was resumed")
+	}
+	if !c.Paused(at(1, "12:10")) {
+		t.Error("the next day's window did not pause")
+	}
+
+	// After sleeping through the end of a window, the pause ends with it.
+	if c.Paused(at(1, "18:00")) {
+		t.Error("still paused after the window")
+	}
+	assertPeriods(t, store.periods,
+		models.AwayPeriod{StartTime: at(0, "12:10"), EndTime: at(0, "12:20"), Reason: models.AwayScheduled},
+		models.AwayPeriod{StartTime: at(1, "12:10"), EndTime: at(1, "13:00"), Reason: models.AwayScheduled},
+	)
+}
+
+func TestBackToBackSchedules(t *testing.T) {
+	c := NewController(&awayRecorder{}, []Schedule{
+		mustSchedule(t, []string{"sat", "sun"}, "00:00", "00:00"),
+		mustSchedule(t, nil, "20:00", "08:00"),
+	})
+	// Friday evening runs through the weekend into Monday morning.
+	status := c.Status(at(0, "21:00"))
+	if !status.Paused || !status.Until.Equal(at(3, "08:00")) {
+		t.Errorf("Status = %+v, want paused until Monday 08:00", status)
+	}
+}
+
+func TestManualPauseOverridesSchedule(t *testing.T) {
+	store := &awayRecorder{}
+	c := NewController(store, []Schedule{mustSchedule(t, nil, "12:00", "13:00")})
+
+	c.Pause(at(0, "11:30"), 0)
+	if status := c.Status(at(0, "12:30")); status.Reason != models.AwayUserPaused {
+		t.Errorf("Status = %+v, want the manual pause", status)
+	}
+	c.Close(at(0, "14:00"))
+	assertPeriods(t, store.periods, models.AwayPeriod{StartTime: at(0, "11:30"), EndTime: at(0, "14:00"), Reason: models.AwayUserPaused})
+
+	// Pauses that end as they start are not recorded.
+	c.Pause(at(0, "15:00"), 0)
+	c.Resume(at(0, "15:00"))
+	if len(store.periods) != 1 {
+		t.Errorf("an empty pause was recorded: %+v", store.periods)
+	}
+}
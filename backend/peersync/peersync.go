@@ -0,0 +1,186 @@
+package peersync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+// changesPath is the sync endpoint served by api.Server.
+const changesPath = "/api/v0/sync/changes"
+
+// Result reports what one sync with a peer changed on each side.
+type Result struct {
+	Pulled models.SyncResult
+	Pushed models.SyncResult
+}
+
+// Client syncs a local store with other PersonalOS instances over HTTP.
+type Client struct {
+	store storage.SyncStore
+	token string
+	http  *http.Client
+}
+
+// NewClient creates a Client that authenticates with the shared sync token.
+func NewClient(store storage.SyncStore, token string) *Client {
+	return &Client{
+		store: store,
+		token: token,
+		http:  &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Sync pulls the peer's changes since the last sync and merges them, then
+// pushes this device's changes. The first sync with a peer exchanges the
+// complete history. peer is a base URL such as "http://desktop:8085".
+func (c *Client) Sync(peer string) (Result, error) {
+	var result Result
+	peer = strings.TrimRight(peer, "/")
+	pulled, pushed, err := c.store.SyncCursor(peer)
+	if err != nil {
+		return result, err
+	}
+
+	remote, err := c.pull(peer, pulled)
+	if err != nil {
+		return result, fmt.Errorf("pull from %s: %w", peer, err)
+	}
+	// A peer behind our cursor has been reset or replaced; start over.
+	if remote.Seq < pulled {
+		log.Printf("Sync peer %s is behind the last sync; pulling its full history", peer)
+		if remote, err = c.pull(peer, 0); err != nil {
+			return result, fmt.Errorf("pull from %s: %w", peer, err)
+		}
+	}
+	if result.Pulled, err = c.store.MergeChanges(remote); err != nil {
+		return result, fmt.Errorf("merge changes from %s: %w", peer, err)
+	}
+	if err := c.store.SetSyncCursor(peer, remote.Seq, pushed); err != nil {
+		return result, err
+	}
+
+	local, err := c.store.ChangesSince(pushed)
+	if err != nil {
+		return result, err
+	}
+	if result.Pushed, err = c.push(peer, local); err != nil {
+		return result, fmt.Errorf("push to %s: %w", peer, err)
+	}
+	return result, c.store.SetSyncCursor(peer, remote.Seq, local.Seq)
+}
+
+func (c *Client) pull(peer string, since int64) (models.SyncBatch, error) {
+	var batch models.SyncBatch
+	req, err := http.NewRequest(http.MethodGet, peer+changesPath+"?since="+url.QueryEscape(strconv.FormatInt(since, 10)), nil)
+	if err != nil {
+		return batch, err
+	}
+	err = c.do(req, &batch)
+	return batch, err
+}
+
+func (c *Client) push(peer string, batch models.SyncBatch) (models.SyncResult, error) {
+	var result models.SyncResult
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return result, err
+	}
+	req, err := http.NewRequest(http.MethodPost, peer+changesPath, bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = c.do(req, &result)
+	return result, err
+}
+
+// do sends req and decodes a successful JSON response into out.
+func (c *Client) do(req *http.Request, out interface{}) error {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Scheduler syncs with a fixed set of peers periodically.
+type Scheduler struct {
+	client   *Client
+	peers    []string
+	interval time.Duration
+	quit     chan struct{}
+}
+
+// NewScheduler creates a Scheduler that syncs with peers every interval.
+func NewScheduler(client *Client, peers []string, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		client:   client,
+		peers:    peers,
+		interval: interval,
+		quit:     make(chan struct{}),
+	}
+}
+
+// Start syncs immediately and then on every tick.
+func (s *Scheduler) Start() {
+	log.Printf("Sync enabled: every %v with %s", s.interval, strings.Join(s.peers, ", "))
+
+	go func() {
+		s.run()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.run()
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the scheduler.
+func (s *Scheduler) Stop() {
+	close(s.quit)
+}
+
+func (s *Scheduler) run() {
+	for _, peer := range s.peers {
+		result, err := s.client.Sync(peer)
+		if err != nil {
+			// Peers are often asleep or off the network; try again next tick.
+			log.Printf("Sync with %s failed: %v", peer, err)
+			continue
+		}
+		log.Printf("Synced with %s: %s", peer, Summary(result))
+	}
+}
+
+// Summary describes a sync result in one line.
+func Summary(r Result) string {
+	return fmt.Sprintf("pulled %d sessions, %d classifications, %d rules, %d deletions; pushed %d sessions, %d classifications, %d rules, %d deletions",
+		r.Pulled.Sessions, r.Pulled.Classifications, r.Pulled.Rules, r.Pulled.Deleted,
+		r.Pushed.Sessions, r.Pushed.Classifications, r.Pushed.Rules, r.Pushed.Deleted)
+}
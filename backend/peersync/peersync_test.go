@@ -0,0 +1,225 @@
+package peersync
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/api"
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+const token = "shared secret"
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// device is a store served over the sync API.
+type device struct {
+	store *storage.DBStore
+	url   string
+}
+
+func newDevice(t *testing.T, name string) device {
+	t.Helper()
+	store, err := storage.NewDBStore(filepath.Join(t.TempDir(), name+".db"), storage.WithDeviceID(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	srv := httptest.NewServer(api.NewServer(store, api.WithSync(store, token)).Handler())
+	t.Cleanup(srv.Close)
+	return device{store: store, url: srv.URL}
+}
+
+func track(t *testing.T, s storage.Store, start time.Time, app, title string) {
+	t.Helper()
+	var events []models.RawEvent
+	for i := 0; i <= 6; i++ {
+		events = append(events, models.RawEvent{Timestamp: start.Add(time.Duration(i) * 10 * time.Second), AppName: app, WindowTitle: title})
+	}
+	if err := s.InsertRawEvents(events); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ProcessRawEvents(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// state describes a store's sessions as "app:classification" and its
+// classification names, for comparing devices.
+func state(t *testing.T, s storage.Store) (sessions, classifications string) {
+	t.Helper()
+	names := make(map[int64]string)
+	all, err := s.ListClassifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cs []string
+	for _, c := range all {
+		names[c.ID] = c.UserDefinedName
+		cs = append(cs, c.UserDefinedName)
+	}
+	var ss []string
+	err = s.EachSession(storage.SessionFilter{}, func(session models.ActivitySession) error {
+		name := ""
+		if session.ClassificationID != nil {
+			name = names[*session.ClassificationID]
+		}
+		ss = append(ss, session.AppName+":"+name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(cs)
+	return strings.Join(ss, " "), strings.Join(cs, " ")
+}
+
+func assertState(t *testing.T, d device, sessions, classifications string) {
+	t.Helper()
+	gotSessions, gotClassifications := state(t, d.store)
+	if gotSessions != sessions || gotClassifications != classifications {
+		t.Errorf("%s: sessions %q, classifications %q; want %q, %q", d.store.DeviceID(), gotSessions, gotClassifications, sessions, classifications)
+	}
+}
+
+func sync(t *testing.T, from, to device) Result {
+	t.Helper()
+	result, err := NewClient(from.store, token).Sync(to.url + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func sessionID(t *testing.T, s storage.Store, app string) int64 {
+	t.Helper()
+	var id int64
+	s.EachSession(storage.SessionFilter{}, func(session models.ActivitySession) error {
+		if session.AppName == app {
+			id = session.ID
+		}
+		return nil
+	})
+	if id == 0 {
+		t.Fatalf("no %s session", app)
+	}
+	return id
+}
+
+func TestSyncThroughAPI(t *testing.T) {
+	laptop, desktop := newDevice(t, "laptop"), newDevice(t, "desktop")
+	start := time.Now().Add(-2 * time.Hour)
+
+	track(t, laptop.store, start, "Code", "main.go")
+	if err := laptop.store.ApplyClassification(models.ClassificationRequest{AppName: "Code", WindowTitle: "main.go", UserDefinedName: "Go"}); err != nil {
+		t.Fatal(err)
+	}
+	track(t, desktop.store, start.Add(time.Hour), "Slack", "general")
+	rule := models.CreateClassificationRuleRequest{AppName: "Slack", UserDefinedName: "Chat", ApplyRuleOptions: models.ApplyRuleOptions{ApplyToHistory: true}}
+	if _, err := desktop.store.CreateClassificationRule(rule); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first sync exchanges the complete history both ways.
+	result := sync(t, laptop, desktop)
+	if result.Pulled.Sessions != 1 || result.Pulled.Classifications != 1 || result.Pulled.Rules != 1 ||
+		result.Pushed.Sessions != 1 || result.Pushed.Classifications != 1 {
+		t.Errorf("first sync: %s", Summary(result))
+	}
+	assertState(t, laptop, "Code:Go Slack:Chat", "Chat Go")
+	assertState(t, desktop, "Code:Go Slack:Chat", "Chat Go")
+	if rules, err := laptop.store.GetClassificationRules(); err != nil || len(rules) != 1 || rules[0].UserDefinedName != "Chat" {
+		t.Errorf("laptop rules = %+v, %v", rules, err)
+	}
+
+	// Merged rows are forwarded to other peers, so the next sync echoes them
+	// back; applying them again changes nothing, and then the devices are
+	// quiet.
+	result = sync(t, laptop, desktop)
+	if result.Pulled.Sessions+result.Pulled.Classifications+result.Pulled.Rules+result.Pulled.Deleted != 0 ||
+		result.Pushed.Sessions+result.Pushed.Classifications+result.Pushed.Rules+result.Pushed.Deleted != 0 {
+		t.Errorf("echo sync applied changes: %s", Summary(result))
+	}
+	if result = sync(t, laptop, desktop); result != (Result{}) {
+		t.Errorf("third sync exchanged %+v", result)
+	}
+
+	// Concurrent edits of a session: the later one wins on both devices.
+	slack := models.ReclassifyRequest{SessionID: sessionID(t, laptop.store, "Slack"), UserDefinedName: "Go"}
+	if err := laptop.store.ReclassifySession(slack); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	slack = models.ReclassifyRequest{SessionID: sessionID(t, desktop.store, "Slack"), UserDefinedName: "Meetings"}
+	if err := desktop.store.ReclassifySession(slack); err != nil {
+		t.Fatal(err)
+	}
+	sync(t, laptop, desktop)
+	assertState(t, laptop, "Code:Go Slack:Meetings", "Chat Go Meetings")
+	assertState(t, desktop, "Code:Go Slack:Meetings", "Chat Go Meetings")
+
+	// Deletions travel as tombstones and are not undone by older copies;
+	// deleting a classification also deletes its rule.
+	if _, err := laptop.store.PurgeHistory(models.PurgeRequest{AppName: "Code"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := desktop.store.DeleteClassification(classificationID(t, desktop.store, "Chat")); err != nil {
+		t.Fatal(err)
+	}
+	result = sync(t, laptop, desktop)
+	if result.Pulled.Deleted != 2 || result.Pushed.Deleted != 1 {
+		t.Errorf("deleting sync: %s", Summary(result))
+	}
+	assertState(t, laptop, "Slack:Meetings", "Go Meetings")
+	assertState(t, desktop, "Slack:Meetings", "Go Meetings")
+	if rules, err := laptop.store.GetClassificationRules(); err != nil || len(rules) != 0 {
+		t.Errorf("laptop rules after deleting Chat = %+v, %v", rules, err)
+	}
+
+	// Syncing from the other side converges on the same state.
+	sync(t, desktop, laptop)
+	sync(t, laptop, desktop)
+	assertState(t, laptop, "Slack:Meetings", "Go Meetings")
+	assertState(t, desktop, "Slack:Meetings", "Go Meetings")
+}
+
+func TestSyncRejectsWrongToken(t *testing.T) {
+	laptop, desktop := newDevice(t, "laptop"), newDevice(t, "desktop")
+	_, err := NewClient(laptop.store, "guess").Sync(desktop.url)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("syncing with the wrong token: %v, want 401", err)
+	}
+}
+
+func TestSyncWithSelfFails(t *testing.T) {
+	laptop := newDevice(t, "laptop")
+	if _, err := NewClient(laptop.store, token).Sync(laptop.url); err == nil {
+		t.Error("a device synced with itself")
+	}
+}
+
+func classificationID(t *testing.T, s storage.Store, name string) int64 {
+	t.Helper()
+	all, err := s.ListClassifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range all {
+		if c.UserDefinedName == name {
+			return c.ID
+		}
+	}
+	t.Fatalf("no classification %q", name)
+	return 0
+}
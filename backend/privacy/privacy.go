@@ -0,0 +1,127 @@
+package privacy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// DefaultReplacement is substituted for scrubbed text when a rule does not set
+// its own replacement.
+const DefaultReplacement = "[redacted]"
+
+// Builtin scrub patterns that can be enabled by name.
+var builtinPatterns = map[string]string{
+	"email": `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	"phone": `(?:\+\d{1,3}[\s.\-]?)?\(?\b\d{3}\)?[\s.\-]?\d{3}[\s.\-]?\d{4}\b`,
+	// Long opaque strings such as API keys, JWTs and session tokens.
+	"token": `\b(?:[A-Za-z0-9_\-]{32,}|eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+)\b`,
+}
+
+// privateWindowPattern matches the window titles browsers use for private
+// windows: Chrome and Brave "Incognito"/"Private", Firefox and Safari
+// "Private Browsing", Edge "InPrivate".
+var privateWindowPattern = regexp.MustCompile(`(?i)\bincognito\b|\bprivate browsing\b|\binprivate\b|\(private\)`)
+
+// ScrubRule replaces every match of Pattern in a window title. Replacement
+// may reference capture groups as $1 or ${name}.
+type ScrubRule struct {
+	Pattern     string
+	Replacement string
+}
+
+// Rules describe what must never reach the database.
+type Rules struct {
+	// DenyApps are dropped entirely, e.g. password managers.
+	DenyApps []string
+	// AppOnlyApps are recorded with their window title, URL and working
+	// directory removed.
+	AppOnlyApps []string
+	// Builtins enables named scrub patterns: "email", "phone" and "token".
+	Builtins []string
+	// Scrub holds custom patterns, applied after the builtins.
+	Scrub []ScrubRule
+	// DropPrivateWindows drops events from private browsing windows.
+	DropPrivateWindows bool
+}
+
+type scrubber struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// Filter redacts raw events between the tracker and storage. It is safe for
+// concurrent use.
+type Filter struct {
+	denyApps           map[string]bool
+	appOnlyApps        map[string]bool
+	scrubbers          []scrubber
+	dropPrivateWindows bool
+}
+
+// NewFilter compiles the rules. Invalid patterns and unknown builtins are
+// reported so a typo cannot silently disable redaction.
+func NewFilter(rules Rules) (*Filter, error) {
+	f := &Filter{
+		denyApps:           appSet(rules.DenyApps),
+		appOnlyApps:        appSet(rules.AppOnlyApps),
+		dropPrivateWindows: rules.DropPrivateWindows,
+	}
+
+	for _, name := range rules.Builtins {
+		pattern, ok := builtinPatterns[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown builtin scrub pattern %q", name)
+		}
+		f.scrubbers = append(f.scrubbers, scrubber{regexp.MustCompile(pattern), DefaultReplacement})
+	}
+	for _, rule := range rules.Scrub {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrub pattern %q: %w", rule.Pattern, err)
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = DefaultReplacement
+		}
+		f.scrubbers = append(f.scrubbers, scrubber{re, replacement})
+	}
+	return f, nil
+}
+
+// Apply returns the event as it may be stored. ok is false when the event must
+// be dropped. A nil Filter passes events through unchanged.
+func (f *Filter) Apply(event models.RawEvent) (redacted models.RawEvent, ok bool) {
+	if f == nil {
+		return event, true
+	}
+
+	app := strings.ToLower(event.AppName)
+	if f.denyApps[app] {
+		return models.RawEvent{}, false
+	}
+	if f.dropPrivateWindows && privateWindowPattern.MatchString(event.WindowTitle) {
+		return models.RawEvent{}, false
+	}
+	if f.appOnlyApps[app] {
+		event.WindowTitle, event.URL, event.Cwd = "", "", ""
+		return event, true
+	}
+
+	for _, s := range f.scrubbers {
+		event.WindowTitle = s.re.ReplaceAllString(event.WindowTitle, s.replacement)
+		event.URL = s.re.ReplaceAllString(event.URL, s.replacement)
+		event.Cwd = s.re.ReplaceAllString(event.Cwd, s.replacement)
+	}
+	return event, true
+}
+
+func appSet(apps []string) map[string]bool {
+	set := make(map[string]bool, len(apps))
+	for _, app := range apps {
+		set[strings.ToLower(app)] = true
+	}
+	return set
+}
@@ -0,0 +1,136 @@
+package privacy
+
+import (
+	"testing"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func TestFilterApply(t *testing.T) {
+	rules := Rules{
+		DenyApps:           []string{"1Password"},
+		AppOnlyApps:        []string{"Messages"},
+		Builtins:           []string{"email", "phone", "Token"},
+		Scrub:              []ScrubRule{{Pattern: `ticket-(\d+)`, Replacement: "ticket-#"}, {Pattern: `Project Falcon`}},
+		DropPrivateWindows: true,
+	}
+	f, err := NewFilter(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		event models.RawEvent
+		want  models.RawEvent
+		drop  bool
+	}{
+		{
+			name:  "allowed app is untouched",
+			event: models.RawEvent{AppName: "Code", WindowTitle: "main.go", URL: "", Cwd: "/src/app"},
+			want:  models.RawEvent{AppName: "Code", WindowTitle: "main.go", Cwd: "/src/app"},
+		},
+		{
+			name:  "denied app is dropped",
+			event: models.RawEvent{AppName: "1Password", WindowTitle: "Vault"},
+			drop:  true,
+		},
+		{
+			name:  "app names match case-insensitively",
+			event: models.RawEvent{AppName: "1password", WindowTitle: "Vault"},
+			drop:  true,
+		},
+		{
+			name:  "app-only app keeps only its name",
+			event: models.RawEvent{AppName: "Messages", WindowTitle: "Alice", URL: "sms://alice", Cwd: "/tmp"},
+			want:  models.RawEvent{AppName: "Messages"},
+		},
+		{
+			name:  "private window is dropped",
+			event: models.RawEvent{AppName: "Firefox", WindowTitle: "Search - Mozilla Firefox Private Browsing"},
+			drop:  true,
+		},
+		{
+			name:  "incognito window is dropped",
+			event: models.RawEvent{AppName: "Google Chrome", WindowTitle: "New Tab - Incognito"},
+			drop:  true,
+		},
+		{
+			name:  "words containing private are kept",
+			event: models.RawEvent{AppName: "Safari", WindowTitle: "Privateer review"},
+			want:  models.RawEvent{AppName: "Safari", WindowTitle: "Privateer review"},
+		},
+		{
+			name:  "email is redacted in title and URL",
+			event: models.RawEvent{AppName: "Mail", WindowTitle: "Re: lunch - bob@example.com", URL: "https://mail.example.com/?to=bob@example.com"},
+			want:  models.RawEvent{AppName: "Mail", WindowTitle: "Re: lunch - [redacted]", URL: "https://mail.example.com/?to=[redacted]"},
+		},
+		{
+			name:  "phone number is redacted",
+			event: models.RawEvent{AppName: "Zoom", WindowTitle: "Call with +1 (555) 123-4567"},
+			want:  models.RawEvent{AppName: "Zoom", WindowTitle: "Call with [redacted]"},
+		},
+		{
+			name:  "token is redacted",
+			event: models.RawEvent{AppName: "Terminal", WindowTitle: "export KEY=abcdefghijklmnopqrstuvwxyz0123456789"},
+			want:  models.RawEvent{AppName: "Terminal", WindowTitle: "export KEY=[redacted]"},
+		},
+		{
+			name:  "custom pattern uses its replacement",
+			event: models.RawEvent{AppName: "Browser", WindowTitle: "ticket-4521: login bug"},
+			want:  models.RawEvent{AppName: "Browser", WindowTitle: "ticket-#: login bug"},
+		},
+		{
+			name:  "custom pattern defaults to the standard replacement",
+			event: models.RawEvent{AppName: "Keynote", WindowTitle: "Project Falcon roadmap", Cwd: "/work/Project Falcon"},
+			want:  models.RawEvent{AppName: "Keynote", WindowTitle: "[redacted] roadmap", Cwd: "/work/[redacted]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := f.Apply(tt.event)
+			if ok == tt.drop {
+				t.Fatalf("Apply(%+v) ok = %v, want %v", tt.event, ok, !tt.drop)
+			}
+			if !tt.drop && got != tt.want {
+				t.Errorf("Apply(%+v) = %+v, want %+v", tt.event, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilFilterPassesEventsThrough(t *testing.T) {
+	var f *Filter
+	event := models.RawEvent{AppName: "1Password", WindowTitle: "bob@example.com"}
+	if got, ok := f.Apply(event); !ok || got != event {
+		t.Errorf("Apply = %+v, %v, want the event unchanged", got, ok)
+	}
+}
+
+func TestPrivateWindowsKeptUnlessEnabled(t *testing.T) {
+	f, err := NewFilter(Rules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := models.RawEvent{AppName: "Edge", WindowTitle: "News - InPrivate"}
+	if got, ok := f.Apply(event); !ok || got != event {
+		t.Errorf("Apply = %+v, %v, want the event kept", got, ok)
+	}
+}
+
+func TestNewFilterRejectsBadRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules Rules
+	}{
+		{"unknown builtin", Rules{Builtins: []string{"ssn"}}},
+		{"invalid pattern", Rules{Scrub: []ScrubRule{{Pattern: "(unclosed"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewFilter(tt.rules); err == nil {
+				t.Error("NewFilter succeeded")
+			}
+		})
+	}
+}
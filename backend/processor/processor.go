@@ -9,7 +9,7 @@ import (
 
 // Processor handles the aggregation of raw events into sessions.
 type Processor struct {
-	store    *storage.DBStore
+	store    storage.SessionStore
 	ticker   *time.Ticker
 	quit     chan struct{}
 	pause    chan struct{}
@@ -19,7 +19,7 @@ type Processor struct {
 }
 
 // NewProcessor creates a new Processor instance.
-func NewProcessor(store *storage.DBStore, interval time.Duration) *Processor {
+func NewProcessor(store storage.SessionStore, interval time.Duration) *Processor {
 	return &Processor{
 		store:    store,
 		interval: interval,
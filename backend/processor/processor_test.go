@@ -0,0 +1,40 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+func TestProcessorAggregatesEvents(t *testing.T) {
+	store := storage.NewMemoryStore()
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i <= 6; i++ {
+		err := store.InsertRawEvent(models.RawEvent{Timestamp: start.Add(time.Duration(i) * 10 * time.Second), AppName: "Code", WindowTitle: "main.go"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := NewProcessor(store, 5*time.Millisecond)
+	p.Start()
+	defer p.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		sessions, err := store.GetUnclassifiedSessions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) > 0 {
+			if len(sessions) != 1 || sessions[0].Duration != 60 {
+				t.Fatalf("sessions = %+v, want one minute of Code", sessions)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("the processor never aggregated the events")
+}
@@ -0,0 +1,836 @@
+package storage
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// Entities recorded in audit_changes.
+const (
+	auditSession        = "session"
+	auditRule           = "rule"
+	auditClassification = "classification"
+)
+
+// sessionSnapshot is the audited state of a session. TrashedAt only differs
+// between before and after for deletions and restores.
+type sessionSnapshot struct {
+	ClassificationID *int64 `json:"classification_id"`
+	AutoClassified   bool   `json:"auto_classified,omitempty"`
+	TrashedAt        *int64 `json:"trashed_at,omitempty"`
+}
+
+// ruleSnapshot is the audited state of a deleted rule.
+type ruleSnapshot struct {
+	AppName             string   `json:"app_name"`
+	AppMatch            string   `json:"app_match,omitempty"`
+	WindowTitleContains string   `json:"window_title_contains"`
+	TitleMatch          string   `json:"title_match,omitempty"`
+	Condition           string   `json:"condition,omitempty"`
+	ClassificationID    int64    `json:"classification_id"`
+	Priority            int64    `json:"priority"`
+	Tags                []string `json:"tags,omitempty"`
+	Note                string   `json:"note,omitempty"`
+}
+
+// loadRuleSnapshot reads the audited state of a rule.
+func loadRuleSnapshot(tx *sql.Tx, id int64) (ruleSnapshot, error) {
+	var snap ruleSnapshot
+	err := tx.QueryRow(`
+		SELECT app_name, app_match, window_title_contains, title_match, match_condition, classification_id, priority, note
+		FROM classification_rules WHERE id = $1
+	`, id).Scan(&snap.AppName, &snap.AppMatch, &snap.WindowTitleContains, &snap.TitleMatch, &snap.Condition, &snap.ClassificationID, &snap.Priority, &snap.Note)
+	if err != nil {
+		return snap, err
+	}
+	tags, err := loadTags(tx, "SELECT rule_id, tag FROM rule_tags WHERE rule_id = $1 ORDER BY tag", id)
+	snap.Tags = tags[id]
+	return snap, err
+}
+
+// classificationSnapshot is the audited state of a classification.
+type classificationSnapshot struct {
+	UserDefinedName string `json:"user_defined_name"`
+	IsHelpful       bool   `json:"is_helpful"`
+	GoalContext     string `json:"goal_context"`
+	ParentID        *int64 `json:"parent_id,omitempty"`
+}
+
+func snapshotOf(c models.Classification) classificationSnapshot {
+	return classificationSnapshot{UserDefinedName: c.UserDefinedName, IsHelpful: c.IsHelpful, GoalContext: c.GoalContext, ParentID: c.ParentID}
+}
+
+func (snap classificationSnapshot) equal(other classificationSnapshot) bool {
+	return snap.UserDefinedName == other.UserDefinedName && snap.IsHelpful == other.IsHelpful && snap.GoalContext == other.GoalContext &&
+		sameID(snap.ParentID, other.ParentID)
+}
+
+// classification returns the snapshot as classification id.
+func (snap classificationSnapshot) classification(id int64) models.Classification {
+	return models.Classification{ID: id, UserDefinedName: snap.UserDefinedName, IsHelpful: snap.IsHelpful, GoalContext: snap.GoalContext, ParentID: snap.ParentID}
+}
+
+// sameID reports whether two optional IDs are equal.
+func sameID(a, b *int64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// audit records the changes of one operation in the change log, inside the
+// operation's transaction. The operation is only logged once it records a
+// change, so operations that turn out to change nothing leave no entry.
+type audit struct {
+	tx        *sql.Tx
+	operation string
+	reverts   *int64
+	id        int64
+}
+
+func startAudit(tx *sql.Tx, operation string, reverts *int64) *audit {
+	return &audit{tx: tx, operation: operation, reverts: reverts}
+}
+
+// open logs the operation if it has not been logged yet.
+func (a *audit) open() error {
+	if a.id != 0 {
+		return nil
+	}
+	return a.tx.QueryRow("INSERT INTO audit_log (operation, created_at, reverts) VALUES ($1, $2, $3) RETURNING id",
+		a.operation, time.Now().Unix(), a.reverts).Scan(&a.id)
+}
+
+// record adds a change. A nil before means the row was created, a nil after
+// that it was deleted.
+func (a *audit) record(entity string, id int64, before, after interface{}) error {
+	if err := a.open(); err != nil {
+		return err
+	}
+	b, err := snapshotJSON(before)
+	if err != nil {
+		return err
+	}
+	c, err := snapshotJSON(after)
+	if err != nil {
+		return err
+	}
+	_, err = a.tx.Exec("INSERT INTO audit_changes (log_id, entity, entity_id, before_state, after_state) VALUES ($1, $2, $3, $4, $5)",
+		a.id, entity, id, b, c)
+	return err
+}
+
+// classificationCreated records a classification created by the operation,
+// so undoing it can remove the classification again.
+func (a *audit) classificationCreated(id int64, c models.Classification) error {
+	return a.record(auditClassification, id, nil, snapshotOf(c))
+}
+
+func snapshotJSON(v interface{}) (sql.NullString, error) {
+	if v == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(v)
+	return sql.NullString{String: string(data), Valid: true}, err
+}
+
+// notUndoneQuery selects operations that can still be undone.
+const notUndoneQuery = `
+	SELECT id FROM audit_log l
+	WHERE operation <> 'undo' AND NOT EXISTS (SELECT 1 FROM audit_log u WHERE u.reverts = l.id)
+	ORDER BY id DESC LIMIT $1
+`
+
+// auditLogQuery selects operations in the shape scanAuditOperation reads.
+const auditLogQuery = `
+	SELECT l.id, l.operation, l.created_at, l.reverts,
+		EXISTS (SELECT 1 FROM audit_log u WHERE u.reverts = l.id),
+		(SELECT COUNT(*) FROM audit_changes c WHERE c.log_id = l.id)
+	FROM audit_log l
+`
+
+func scanAuditOperation(row interface{ Scan(...interface{}) error }) (models.AuditOperation, error) {
+	var op models.AuditOperation
+	err := row.Scan(&op.ID, &op.Operation, &op.CreatedAt, &op.Reverts, &op.Undone, &op.Changes)
+	return op, err
+}
+
+// GetAuditLog returns the most recent operations, newest first.
+func (s *DBStore) GetAuditLog(limit int) ([]models.AuditOperation, error) {
+	rows, err := s.db.Query(auditLogQuery+" ORDER BY l.id DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := make([]models.AuditOperation, 0)
+	for rows.Next() {
+		op, err := scanAuditOperation(rows)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// auditChange is a row of audit_changes.
+type auditChange struct {
+	entity        string
+	entityID      int64
+	before, after sql.NullString
+}
+
+// Undo reverts the most recent count operations that have not been undone,
+// newest first, in a single transaction. Each revert is itself logged. It
+// returns the reverted operations, with the changes that could no longer be
+// reverted counted as skipped.
+func (s *DBStore) Undo(count int) ([]models.AuditOperation, error) {
+	if count <= 0 {
+		count = 1
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(notUndoneQuery, count)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return make([]models.AuditOperation, 0), nil
+	}
+
+	st, err := nextStamp(tx)
+	if err != nil {
+		return nil, err
+	}
+	skipped := make([]int, len(ids))
+	for i, id := range ids {
+		changes, err := loadAuditChanges(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		reverts := id
+		undo := startAudit(tx, models.AuditUndo, &reverts)
+		// The undo is logged even if nothing could be reverted, so the
+		// operation counts as undone.
+		if err := undo.open(); err != nil {
+			return nil, err
+		}
+		// Later changes may depend on earlier ones, e.g. on a classification
+		// the operation created, so they are reverted first.
+		for j := len(changes) - 1; j >= 0; j-- {
+			reverted, err := s.revertChange(tx, undo, changes[j], st)
+			if err != nil {
+				return nil, fmt.Errorf("undo operation %d: %w", id, err)
+			}
+			if !reverted {
+				skipped[i]++
+			}
+		}
+	}
+
+	ops := make([]models.AuditOperation, 0, len(ids))
+	for i, id := range ids {
+		op, err := scanAuditOperation(tx.QueryRow(auditLogQuery+" WHERE l.id = $1", id))
+		if err != nil {
+			return nil, err
+		}
+		op.Skipped = skipped[i]
+		ops = append(ops, op)
+	}
+	return ops, tx.Commit()
+}
+
+func loadAuditChanges(tx *sql.Tx, logID int64) ([]auditChange, error) {
+	rows, err := tx.Query("SELECT entity, entity_id, before_state, after_state FROM audit_changes WHERE log_id = $1 ORDER BY id", logID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []auditChange
+	for rows.Next() {
+		var c auditChange
+		if err := rows.Scan(&c.entity, &c.entityID, &c.before, &c.after); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// revertChange restores the before state of one change and logs the revert.
+// It reports false for changes that can no longer be reverted: changes to
+// sessions, rules and classifications that have been deleted since, changes
+// back to a classification that has been deleted since, and classifications
+// whose name has been taken since. A created classification that is in use
+// again is kept, and a deleted rule that is back is left alone.
+func (s *DBStore) revertChange(tx *sql.Tx, undo *audit, c auditChange, st stamp) (bool, error) {
+	switch {
+	case c.entity == auditSession && c.before.Valid && c.after.Valid:
+		var before, after sessionSnapshot
+		if err := json.Unmarshal([]byte(c.before.String), &before); err != nil {
+			return false, err
+		}
+		if err := json.Unmarshal([]byte(c.after.String), &after); err != nil {
+			return false, err
+		}
+		var current sessionSnapshot
+		err := tx.QueryRow("SELECT classification_id, auto_classified, trashed_at FROM activity_sessions WHERE id = $1", c.entityID).
+			Scan(&current.ClassificationID, &current.AutoClassified, &current.TrashedAt)
+		if err == sql.ErrNoRows {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		if before.TrashedAt != nil || after.TrashedAt != nil {
+			if _, err := tx.Exec("UPDATE activity_sessions SET trashed_at = $1, seq = $2, updated_at = $3 WHERE id = $4",
+				before.TrashedAt, st.seq, st.at, c.entityID); err != nil {
+				return false, err
+			}
+			restored := current
+			restored.TrashedAt = before.TrashedAt
+			return true, undo.record(auditSession, c.entityID, current, restored)
+		}
+		if before.ClassificationID != nil {
+			if _, err := loadClassification(tx, *before.ClassificationID); errors.Is(err, ErrClassificationNotFound) {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+		}
+		if _, err := tx.Exec("UPDATE activity_sessions SET classification_id = $1, auto_classified = $2, seq = $3, updated_at = $4 WHERE id = $5",
+			before.ClassificationID, before.AutoClassified, st.seq, st.at, c.entityID); err != nil {
+			return false, err
+		}
+		current.TrashedAt = nil
+		return true, undo.record(auditSession, c.entityID, current, before)
+
+	case c.entity == auditRule && c.before.Valid && !c.after.Valid:
+		var snap ruleSnapshot
+		if err := json.Unmarshal([]byte(c.before.String), &snap); err != nil {
+			return false, err
+		}
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM classification_rules WHERE id = $1)", c.entityID).Scan(&exists); err != nil || exists {
+			return err == nil, err
+		}
+		if _, err := loadClassification(tx, snap.ClassificationID); errors.Is(err, ErrClassificationNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO classification_rules (id, app_name, app_match, window_title_contains, title_match, match_condition, classification_id,
+				priority, note, seq, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`, c.entityID, snap.AppName, defaultMatch(snap.AppMatch, models.MatchExact), snap.WindowTitleContains,
+			defaultMatch(snap.TitleMatch, models.MatchContains), snap.Condition, snap.ClassificationID, snap.Priority, snap.Note, st.seq, st.at)
+		if err != nil {
+			return false, err
+		}
+		if err := setRuleTags(tx, c.entityID, snap.Tags); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2",
+			tombstoneRule, ruleKey(snap.AppName, snap.WindowTitleContains, snap.Condition)); err != nil {
+			return false, err
+		}
+		return true, undo.record(auditRule, c.entityID, nil, snap)
+
+	case c.entity == auditClassification && !c.before.Valid && c.after.Valid:
+		current, err := loadClassification(tx, c.entityID)
+		if errors.Is(err, ErrClassificationNotFound) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		// The classification only goes away if nothing uses it any more.
+		res, err := tx.Exec(`
+			DELETE FROM classifications WHERE id = $1
+				AND NOT EXISTS (SELECT 1 FROM activity_sessions WHERE classification_id = $1)
+				AND NOT EXISTS (SELECT 1 FROM classification_rules WHERE classification_id = $1)
+				AND NOT EXISTS (SELECT 1 FROM classifications WHERE parent_id = $1)
+		`, c.entityID)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return true, nil
+		}
+		if err := writeTombstone(tx, tombstoneClassification, current.UserDefinedName, st); err != nil {
+			return false, err
+		}
+		return true, undo.record(auditClassification, c.entityID, snapshotOf(current), nil)
+
+	case c.entity == auditRule && c.before.Valid && c.after.Valid:
+		// A rule a merge moved to another classification moves back.
+		var before ruleSnapshot
+		if err := json.Unmarshal([]byte(c.before.String), &before); err != nil {
+			return false, err
+		}
+		current, err := loadRuleSnapshot(tx, c.entityID)
+		if err == sql.ErrNoRows {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		if _, err := loadClassification(tx, before.ClassificationID); errors.Is(err, ErrClassificationNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec("UPDATE classification_rules SET classification_id = $1, seq = $2, updated_at = $3 WHERE id = $4",
+			before.ClassificationID, st.seq, st.at, c.entityID); err != nil {
+			return false, err
+		}
+		restored := current
+		restored.ClassificationID = before.ClassificationID
+		return true, undo.record(auditRule, c.entityID, current, restored)
+
+	case c.entity == auditClassification && c.before.Valid && !c.after.Valid:
+		// A deleted classification comes back with its ID, unless its name
+		// has been taken since. A parent deleted since leaves it at the top.
+		var before classificationSnapshot
+		if err := json.Unmarshal([]byte(c.before.String), &before); err != nil {
+			return false, err
+		}
+		var taken bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM classifications WHERE id = $1 OR user_defined_name = $2)", c.entityID, before.UserDefinedName).
+			Scan(&taken)
+		if err != nil || taken {
+			return false, err
+		}
+		if before.ParentID != nil {
+			if _, err := loadClassification(tx, *before.ParentID); errors.Is(err, ErrClassificationNotFound) {
+				before.ParentID = nil
+			} else if err != nil {
+				return false, err
+			}
+		}
+		if _, err := insertClassification(tx, before.classification(c.entityID), st); err != nil {
+			return false, err
+		}
+		return true, undo.record(auditClassification, c.entityID, nil, before)
+
+	case c.entity == auditClassification && c.before.Valid && c.after.Valid:
+		// An edit or a move to another parent is reverted if the name is
+		// still free and the old parent can still take it.
+		var before classificationSnapshot
+		if err := json.Unmarshal([]byte(c.before.String), &before); err != nil {
+			return false, err
+		}
+		current, err := loadClassification(tx, c.entityID)
+		if errors.Is(err, ErrClassificationNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		if err := checkNameFree(tx, before.UserDefinedName, c.entityID); errors.Is(err, ErrClassificationExists) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		parents, err := loadParents(tx)
+		if err != nil {
+			return false, err
+		}
+		if checkParent(parents, c.entityID, before.ParentID) != nil {
+			return false, nil
+		}
+		if err := updateClassification(tx, current, before.classification(c.entityID), st); err != nil {
+			return false, err
+		}
+		return true, undo.record(auditClassification, c.entityID, snapshotOf(current), before)
+	}
+	return true, nil
+}
+
+// GetSessionHistory returns every logged operation that changed a session,
+// oldest first.
+func (s *DBStore) GetSessionHistory(sessionID int64) ([]models.SessionHistoryEntry, error) {
+	names, err := s.classificationNames()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT l.id, l.operation, l.created_at, EXISTS (SELECT 1 FROM audit_log u WHERE u.reverts = l.id),
+			c.before_state, c.after_state
+		FROM audit_changes c
+		JOIN audit_log l ON c.log_id = l.id
+		WHERE c.entity = $1 AND c.entity_id = $2
+		ORDER BY c.id ASC
+	`, auditSession, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]models.SessionHistoryEntry, 0)
+	for rows.Next() {
+		var entry models.SessionHistoryEntry
+		var before, after string
+		if err := rows.Scan(&entry.LogID, &entry.Operation, &entry.CreatedAt, &entry.Undone, &before, &after); err != nil {
+			return nil, err
+		}
+		if entry.Before, err = sessionState(before, names); err != nil {
+			return nil, err
+		}
+		if entry.After, err = sessionState(after, names); err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+func (s *DBStore) classificationNames() (map[int64]string, error) {
+	classifications, err := s.ListClassifications()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int64]string, len(classifications))
+	for _, c := range classifications {
+		names[c.ID] = c.UserDefinedName
+	}
+	return names, nil
+}
+
+// sessionState converts a stored snapshot for the history view.
+func sessionState(stored string, names map[int64]string) (models.SessionState, error) {
+	var snap sessionSnapshot
+	if err := json.Unmarshal([]byte(stored), &snap); err != nil {
+		return models.SessionState{}, err
+	}
+	return snap.state(names), nil
+}
+
+func (snap sessionSnapshot) state(names map[int64]string) models.SessionState {
+	state := models.SessionState{ClassificationID: snap.ClassificationID, AutoClassified: snap.AutoClassified, Trashed: snap.TrashedAt != nil}
+	if snap.ClassificationID != nil {
+		state.UserDefinedName = names[*snap.ClassificationID]
+	}
+	return state
+}
+
+// classifySessions runs an UPDATE that classifies unclassified sessions as
+// classID and logs each one. It returns the number of sessions updated.
+func (a *audit) classifySessions(update string, args []interface{}, classID int64) (int, error) {
+	rows, err := a.tx.Query(update+" RETURNING id", args...)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := a.record(auditSession, id, sessionSnapshot{}, sessionSnapshot{ClassificationID: &classID}); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// memoryAuditEntry is an operation in a MemoryStore's change log.
+type memoryAuditEntry struct {
+	op      models.AuditOperation
+	changes []memoryChange
+}
+
+// memoryChange mirrors a row of audit_changes. Sessions are logged as a
+// sessionSnapshot; rules and classifications as the whole model. A nil before
+// or after marks a created or deleted row.
+type memoryChange struct {
+	entity        string
+	id            int64
+	before, after interface{}
+}
+
+// memoryAudit records the changes of one operation in a MemoryStore. Like
+// audit, it only logs the operation once it records a change.
+type memoryAudit struct {
+	m         *MemoryStore
+	operation string
+	reverts   *int64
+	index     int
+}
+
+// startAudit starts logging an operation. Callers must hold m.mu.
+func (m *MemoryStore) startAudit(operation string, reverts *int64) *memoryAudit {
+	return &memoryAudit{m: m, operation: operation, reverts: reverts, index: -1}
+}
+
+// open appends the operation to the log if it has not been logged yet.
+func (a *memoryAudit) open() {
+	if a.index >= 0 {
+		return
+	}
+	a.m.nextAuditID++
+	a.m.auditLog = append(a.m.auditLog, memoryAuditEntry{op: models.AuditOperation{
+		ID:        a.m.nextAuditID,
+		Operation: a.operation,
+		CreatedAt: time.Now().Unix(),
+		Reverts:   a.reverts,
+	}})
+	a.index = len(a.m.auditLog) - 1
+}
+
+func (a *memoryAudit) record(entity string, id int64, before, after interface{}) {
+	a.open()
+	entry := &a.m.auditLog[a.index]
+	entry.changes = append(entry.changes, memoryChange{entity: entity, id: id, before: before, after: after})
+}
+
+// startClassifying finds or creates the classification an operation applies
+// and starts the operation's log entry, logging the classification if it was
+// created. Callers must hold m.mu.
+func (m *MemoryStore) startClassifying(operation, name string, isHelpful *bool, goalContext *string) (*memoryAudit, int64, error) {
+	last := m.nextClassificationID
+	id, err := m.findOrCreateClassification(name, isHelpful, goalContext)
+	if err != nil {
+		return nil, 0, err
+	}
+	a := m.startAudit(operation, nil)
+	if m.nextClassificationID != last {
+		c, _ := m.classificationByID(id)
+		a.record(auditClassification, id, nil, c)
+	}
+	return a, id, nil
+}
+
+// auditOperation returns a logged operation with its derived fields filled
+// in. Callers must hold m.mu.
+func (m *MemoryStore) auditOperation(entry memoryAuditEntry) models.AuditOperation {
+	op := entry.op
+	op.Changes = len(entry.changes)
+	for _, other := range m.auditLog {
+		if other.op.Reverts != nil && *other.op.Reverts == op.ID {
+			op.Undone = true
+			break
+		}
+	}
+	return op
+}
+
+// GetAuditLog returns the most recent operations, newest first.
+func (m *MemoryStore) GetAuditLog(limit int) ([]models.AuditOperation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ops := make([]models.AuditOperation, 0)
+	for i := len(m.auditLog) - 1; i >= 0 && len(ops) < limit; i-- {
+		ops = append(ops, m.auditOperation(m.auditLog[i]))
+	}
+	return ops, nil
+}
+
+// Undo reverts the most recent count operations that have not been undone,
+// newest first, counting the changes that could no longer be reverted as
+// skipped.
+func (m *MemoryStore) Undo(count int) ([]models.AuditOperation, error) {
+	if count <= 0 {
+		count = 1
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var indexes []int
+	for i := len(m.auditLog) - 1; i >= 0 && len(indexes) < count; i-- {
+		if op := m.auditOperation(m.auditLog[i]); op.Operation != models.AuditUndo && !op.Undone {
+			indexes = append(indexes, i)
+		}
+	}
+
+	skipped := make([]int, len(indexes))
+	for k, i := range indexes {
+		reverts := m.auditLog[i].op.ID
+		undo := m.startAudit(models.AuditUndo, &reverts)
+		undo.open()
+		changes := m.auditLog[i].changes
+		for j := len(changes) - 1; j >= 0; j-- {
+			if !m.revertChange(undo, changes[j]) {
+				skipped[k]++
+			}
+		}
+	}
+
+	ops := make([]models.AuditOperation, 0, len(indexes))
+	for k, i := range indexes {
+		op := m.auditOperation(m.auditLog[i])
+		op.Skipped = skipped[k]
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// revertChange mirrors DBStore.revertChange. Callers must hold m.mu.
+func (m *MemoryStore) revertChange(undo *memoryAudit, c memoryChange) bool {
+	switch before := c.before.(type) {
+	case sessionSnapshot:
+		i := slices.IndexFunc(m.sessions, func(s models.ActivitySession) bool { return s.ID == c.id })
+		if i < 0 {
+			return false
+		}
+		session := &m.sessions[i]
+		current := sessionSnapshot{ClassificationID: session.ClassificationID, AutoClassified: session.AutoClassified, TrashedAt: unixPtr(session.TrashedAt)}
+		if after := c.after.(sessionSnapshot); before.TrashedAt != nil || after.TrashedAt != nil {
+			session.TrashedAt = timePtr(before.TrashedAt)
+			restored := current
+			restored.TrashedAt = before.TrashedAt
+			undo.record(auditSession, c.id, current, restored)
+			return true
+		}
+		if before.ClassificationID != nil {
+			if _, ok := m.classificationByID(*before.ClassificationID); !ok {
+				return false
+			}
+		}
+		current.TrashedAt = nil
+		session.ClassificationID = before.ClassificationID
+		session.AutoClassified = before.AutoClassified
+		undo.record(auditSession, c.id, current, before)
+
+	case models.ClassificationRule:
+		k := slices.IndexFunc(m.rules, func(rule models.ClassificationRule) bool { return rule.ID == c.id })
+		if c.after == nil {
+			if k >= 0 {
+				return true
+			}
+			if _, ok := m.classificationByID(before.ClassificationID); !ok {
+				return false
+			}
+			m.rules = append(m.rules, before)
+			slices.SortFunc(m.rules, func(a, b models.ClassificationRule) int { return cmp.Compare(a.ID, b.ID) })
+			undo.record(auditRule, c.id, nil, before)
+			return true
+		}
+		if k < 0 {
+			return false
+		}
+		if _, ok := m.classificationByID(before.ClassificationID); !ok {
+			return false
+		}
+		current := m.rules[k]
+		m.rules[k].ClassificationID = before.ClassificationID
+		undo.record(auditRule, c.id, current, m.rules[k])
+
+	case models.Classification:
+		k, err := m.classificationIndex(c.id)
+		if c.after == nil {
+			if err == nil || m.checkNameFree(before.UserDefinedName, c.id) != nil {
+				return false
+			}
+			if before.ParentID != nil {
+				if _, ok := m.classificationByID(*before.ParentID); !ok {
+					before.ParentID = nil
+				}
+			}
+			m.classifications = append(m.classifications, before)
+			slices.SortFunc(m.classifications, func(a, b models.Classification) int { return cmp.Compare(a.ID, b.ID) })
+			undo.record(auditClassification, c.id, nil, before)
+			return true
+		}
+		if err != nil || m.checkNameFree(before.UserDefinedName, c.id) != nil ||
+			checkParent(parentsOf(m.classifications), c.id, before.ParentID) != nil {
+			return false
+		}
+		current := m.classifications[k]
+		m.classifications[k] = before
+		undo.record(auditClassification, c.id, current, before)
+
+	case nil:
+		if c.entity != auditClassification {
+			return true
+		}
+		i, err := m.classificationIndex(c.id)
+		if err != nil {
+			return true
+		}
+		current := m.classifications[i]
+		for _, session := range m.sessions {
+			if session.ClassificationID != nil && *session.ClassificationID == c.id {
+				return true
+			}
+		}
+		for _, rule := range m.rules {
+			if rule.ClassificationID == c.id {
+				return true
+			}
+		}
+		for _, child := range m.classifications {
+			if child.ParentID != nil && *child.ParentID == c.id {
+				return true
+			}
+		}
+		m.classifications = append(m.classifications[:i], m.classifications[i+1:]...)
+		undo.record(auditClassification, c.id, current, nil)
+	}
+	return true
+}
+
+// GetSessionHistory returns every logged operation that changed a session,
+// oldest first.
+func (m *MemoryStore) GetSessionHistory(sessionID int64) ([]models.SessionHistoryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make(map[int64]string, len(m.classifications))
+	for _, c := range m.classifications {
+		names[c.ID] = c.UserDefinedName
+	}
+
+	history := make([]models.SessionHistoryEntry, 0)
+	for _, entry := range m.auditLog {
+		op := m.auditOperation(entry)
+		for _, c := range entry.changes {
+			if c.entity != auditSession || c.id != sessionID {
+				continue
+			}
+			history = append(history, models.SessionHistoryEntry{
+				LogID:     op.ID,
+				Operation: op.Operation,
+				CreatedAt: op.CreatedAt,
+				Undone:    op.Undone,
+				Before:    c.before.(sessionSnapshot).state(names),
+				After:     c.after.(sessionSnapshot).state(names),
+			})
+		}
+	}
+	return history, nil
+}
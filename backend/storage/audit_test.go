@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// operations returns the logged operation names, oldest first.
+func operations(t *testing.T, s Store) []string {
+	t.Helper()
+	log, err := s.GetAuditLog(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(log))
+	for i, op := range log {
+		names[len(log)-1-i] = op.Operation
+	}
+	return names
+}
+
+// undo undoes count operations, failing the test on error.
+func undo(t *testing.T, s Store, count int) []models.AuditOperation {
+	t.Helper()
+	ops, err := s.Undo(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ops
+}
+
+func TestAuditSkipsOperationsWithoutChanges(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		track(t, s, time.Now().Add(-time.Hour), "Code", "main.go")
+		classify := models.ClassificationRequest{AppName: "Code", WindowTitle: "main.go", UserDefinedName: "Go"}
+		if err := s.ApplyClassification(classify); err != nil {
+			t.Fatal(err)
+		}
+		if ops := operations(t, s); len(ops) != 1 || ops[0] != models.AuditClassify {
+			t.Fatalf("log = %q, want one classify", ops)
+		}
+
+		// Nothing left to classify, restore or delete.
+		if err := s.ApplyClassification(classify); err != nil {
+			t.Fatal(err)
+		}
+		batch := models.BatchClassificationRequest{UserDefinedName: "Go", Sessions: []models.SessionIdentifier{{AppName: "Slack", WindowTitle: "general"}}}
+		if err := s.ApplyClassificationBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ReclassifySession(models.ReclassifyRequest{SessionID: 999, UserDefinedName: "Go"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.RestoreSessions([]int64{allSessions(t, s)[0].ID}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.TrashSessions(models.BulkDeleteRequest{AppName: "Slack"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteClassificationRule(999); err != nil {
+			t.Fatal(err)
+		}
+		if ops := operations(t, s); len(ops) != 1 {
+			t.Errorf("log = %q, want only the first classify", ops)
+		}
+
+		// An undo is always logged, so the classify counts as undone.
+		if ops := undo(t, s, 1); len(ops) != 1 || !ops[0].Undone || ops[0].Skipped != 0 {
+			t.Errorf("undo = %+v", ops)
+		}
+		if ops := undo(t, s, 1); len(ops) != 0 {
+			t.Errorf("second undo reverted %+v, want nothing left", ops)
+		}
+	})
+}
+
+func TestUndoRestoresAutomaticClassification(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		rule := models.CreateClassificationRuleRequest{AppName: "Code", UserDefinedName: "Go"}
+		if _, err := s.CreateClassificationRule(rule); err != nil {
+			t.Fatal(err)
+		}
+		track(t, s, time.Now().Add(-time.Hour), "Code", "main.go")
+		session := allSessions(t, s)[0]
+		if err := s.ReclassifySession(models.ReclassifyRequest{SessionID: session.ID, UserDefinedName: "Review"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteSession(session.ID); err != nil {
+			t.Fatal(err)
+		}
+		undo(t, s, 2)
+
+		history, err := s.GetSessionHistory(session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 4 {
+			t.Fatalf("history = %+v, want reclassify, delete and two undos", history)
+		}
+		if reclassify := history[0]; !reclassify.Before.AutoClassified || reclassify.After.AutoClassified {
+			t.Errorf("reclassify = %+v, want it to record the rule's classification as automatic", reclassify)
+		}
+		if restore := history[2]; restore.After.Trashed || restore.After.AutoClassified {
+			t.Errorf("undoing the delete = %+v", restore)
+		}
+		if revert := history[3]; revert.After.UserDefinedName != "Go" || !revert.After.AutoClassified {
+			t.Errorf("undoing the reclassify = %+v, want the automatic Go classification back", revert)
+		}
+
+		// Automatic again, so a newer rule may override it.
+		override := models.CreateClassificationRuleRequest{
+			AppName: "Code", UserDefinedName: "Coding",
+			ApplyRuleOptions: models.ApplyRuleOptions{ApplyToHistory: true, OverrideAutomatic: true},
+		}
+		result, err := s.CreateClassificationRule(override)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Sessions != 1 {
+			t.Errorf("overriding rule classified %d sessions, want 1", result.Sessions)
+		}
+	})
+}
+
+func TestUndoReportsSkippedChanges(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-time.Hour)
+		track(t, s, start, "Code", "main.go")
+		track(t, s, start.Add(10*time.Minute), "Slack", "general")
+		batch := models.BatchClassificationRequest{UserDefinedName: "Work", Sessions: []models.SessionIdentifier{
+			{AppName: "Code", WindowTitle: "main.go"}, {AppName: "Slack", WindowTitle: "general"},
+		}}
+		if err := s.ApplyClassificationBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.PurgeHistory(models.PurgeRequest{AppName: "Slack"}); err != nil {
+			t.Fatal(err)
+		}
+
+		ops := undo(t, s, 1)
+		if len(ops) != 1 || !ops[0].Undone || ops[0].Changes != 3 || ops[0].Skipped != 1 {
+			t.Fatalf("undo = %+v, want the batch undone with the purged session skipped", ops)
+		}
+		if n := len(unclassified(t, s)); n != 1 {
+			t.Errorf("%d unclassified sessions after the undo, want 1", n)
+		}
+	})
+}
+
+// classificationState describes a store's classifications with their
+// parents, its sessions with their classifications and its rules with theirs
+// and their tags, so undos can be checked against an earlier state.
+func classificationState(t *testing.T, s Store) string {
+	t.Helper()
+	all, err := s.ListClassifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[int64]string, len(all))
+	for _, c := range all {
+		names[c.ID] = c.UserDefinedName
+	}
+	var state []string
+	for _, c := range all {
+		entry := fmt.Sprintf("%d:%s", c.ID, c.UserDefinedName)
+		if c.ParentID != nil {
+			entry += "<" + names[*c.ParentID]
+		}
+		state = append(state, entry)
+	}
+	for _, session := range allSessions(t, s) {
+		name := "-"
+		if session.ClassificationID != nil {
+			name = names[*session.ClassificationID]
+		}
+		state = append(state, fmt.Sprintf("%s=%s%v", session.WindowTitle, name, session.Tags))
+	}
+	rules, err := s.GetClassificationRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range rules {
+		state = append(state, fmt.Sprintf("rule %d:%s=%s%v", rule.ID, rule.AppName, rule.UserDefinedName, rule.Tags))
+	}
+	return strings.Join(state, " ")
+}
+
+func TestUndoClassificationChanges(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		work, err := s.CreateClassification(models.CreateClassificationRequest{UserDefinedName: "Work", GoalContext: "Job"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		golang, err := s.CreateClassification(models.CreateClassificationRequest{UserDefinedName: "Go", ParentID: &work.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.CreateClassification(models.CreateClassificationRequest{UserDefinedName: "Generics", ParentID: &golang.ID}); err != nil {
+			t.Fatal(err)
+		}
+		rust, err := s.CreateClassification(models.CreateClassificationRequest{UserDefinedName: "Rust"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rule := models.CreateClassificationRuleRequest{AppName: "Code", UserDefinedName: "Go", Tags: []string{"editor"}}
+		if _, err := s.CreateClassificationRule(rule); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now().Add(-time.Hour)
+		track(t, s, start, "Code", "main.go")
+		track(t, s, start.Add(10*time.Minute), "Terminal", "go test")
+		terminal := models.ClassificationRequest{AppName: "Terminal", WindowTitle: "go test", UserDefinedName: "Go"}
+		if err := s.ApplyClassification(terminal); err != nil {
+			t.Fatal(err)
+		}
+		initial := classificationState(t, s)
+		codeID := allSessions(t, s)[0].ID
+
+		changes := []struct {
+			name      string
+			operation string
+			change    func() error
+		}{
+			{"rename and move", models.AuditUpdateClassification, func() error {
+				_, err := s.UpdateClassification(models.UpdateClassificationRequest{ID: golang.ID, UserDefinedName: "Golang", IsHelpful: true})
+				return err
+			}},
+			{"delete", models.AuditDeleteClassification, func() error { return s.DeleteClassification(golang.ID) }},
+			{"merge", models.AuditMergeClassifications, func() error {
+				_, err := s.MergeClassifications(models.MergeClassificationsRequest{SourceID: golang.ID, TargetID: rust.ID})
+				return err
+			}},
+		}
+		for _, c := range changes {
+			if err := c.change(); err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			if changed := classificationState(t, s); changed == initial {
+				t.Fatalf("%s changed nothing", c.name)
+			}
+			ops := undo(t, s, 1)
+			if len(ops) != 1 || ops[0].Operation != c.operation || ops[0].Skipped != 0 {
+				t.Errorf("undoing the %s = %+v", c.name, ops)
+			}
+			if got := classificationState(t, s); got != initial {
+				t.Errorf("after undoing the %s:\n got %s\nwant %s", c.name, got, initial)
+			}
+		}
+
+		// The rule's session is automatic again, the other one is not.
+		history, err := s.GetSessionHistory(codeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := history[len(history)-1]; last.Operation != models.AuditUndo || !last.After.AutoClassified || last.After.UserDefinedName != "Go" {
+			t.Errorf("last change of the rule's session = %+v", last)
+		}
+
+		// Saving a classification unchanged is not logged.
+		before := operations(t, s)
+		_, err = s.UpdateClassification(models.UpdateClassificationRequest{ID: golang.ID, UserDefinedName: "Go", ParentID: &work.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after := operations(t, s); len(after) != len(before) {
+			t.Errorf("unchanged update logged %q", after[len(before):])
+		}
+	})
+}
+
+func TestUndoDeleteAfterNameReused(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		rule := models.CreateClassificationRuleRequest{AppName: "Code", UserDefinedName: "Go"}
+		if _, err := s.CreateClassificationRule(rule); err != nil {
+			t.Fatal(err)
+		}
+		track(t, s, time.Now().Add(-time.Hour), "Code", "main.go")
+		if err := s.DeleteClassification(classificationID(t, s, "Go")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.CreateClassification(models.CreateClassificationRequest{UserDefinedName: "Go"}); err != nil {
+			t.Fatal(err)
+		}
+		want := classificationState(t, s)
+
+		// The old classification cannot come back under a taken name, so
+		// neither can its rule and session.
+		ops := undo(t, s, 1)
+		if len(ops) != 1 || ops[0].Operation != models.AuditDeleteClassification || ops[0].Changes != 3 || ops[0].Skipped != 3 {
+			t.Errorf("undo = %+v, want all three changes skipped", ops)
+		}
+		if got := classificationState(t, s); got != want {
+			t.Errorf("undo changed the store:\n got %s\nwant %s", got, want)
+		}
+	})
+}
+
+func TestApplyRuleLogsOnlyChanges(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-time.Hour)
+		track(t, s, start, "Code", "main.go")
+		track(t, s, start.Add(10*time.Minute), "Code", "notes.md")
+		if err := s.ApplyClassification(models.ClassificationRequest{AppName: "Code", WindowTitle: "main.go", UserDefinedName: "Go"}); err != nil {
+			t.Fatal(err)
+		}
+		apply := models.ApplyRuleOptions{ApplyToHistory: true}
+
+		rules := []struct {
+			name string
+			req  models.CreateClassificationRuleRequest
+			want int
+		}{
+			{"dry run", models.CreateClassificationRuleRequest{AppName: "Code", UserDefinedName: "Go",
+				ApplyRuleOptions: models.ApplyRuleOptions{ApplyToHistory: true, DryRun: true}}, 1},
+			{"classifies notes.md", models.CreateClassificationRuleRequest{AppName: "Code", UserDefinedName: "Go", ApplyRuleOptions: apply}, 1},
+			{"leaves manual classifications alone", models.CreateClassificationRuleRequest{AppName: "Code", WindowTitleContains: "main", UserDefinedName: "Review", ApplyRuleOptions: apply}, 0},
+			{"already classified the same", models.CreateClassificationRuleRequest{AppName: "Code", WindowTitleContains: "notes", UserDefinedName: "Go",
+				ApplyRuleOptions: models.ApplyRuleOptions{ApplyToHistory: true, OverrideAutomatic: true}}, 0},
+			{"matches nothing", models.CreateClassificationRuleRequest{AppName: "Slack", UserDefinedName: "Chat", ApplyRuleOptions: apply}, 0},
+		}
+		for _, rule := range rules {
+			result, err := s.CreateClassificationRule(rule.req)
+			if err != nil {
+				t.Fatalf("%s: %v", rule.name, err)
+			}
+			if result.Sessions != rule.want {
+				t.Errorf("%s: %d sessions changed, want %d", rule.name, result.Sessions, rule.want)
+			}
+		}
+		if ops := operations(t, s); strings.Join(ops, " ") != "classify apply_rule" {
+			t.Errorf("log = %q, want classify and one apply_rule", ops)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// RecordAwayPeriod stores a finished pause for this device.
+func (s *DBStore) RecordAwayPeriod(period models.AwayPeriod) error {
+	_, err := s.db.Exec(
+		"INSERT INTO away_periods (start_time, end_time, reason, device_id) VALUES ($1, $2, $3, $4)",
+		period.StartTime.Unix(), period.EndTime.Unix(), period.Reason, s.deviceID)
+	return err
+}
+
+// GetAwayPeriods returns the away periods overlapping [start, end), oldest
+// first. Zero times leave that side of the range open.
+func (s *DBStore) GetAwayPeriods(start, end time.Time) ([]models.AwayPeriod, error) {
+	from, to := unixRange(start, end)
+	rows, err := s.db.Query(`
+		SELECT id, start_time, end_time, reason FROM away_periods
+		WHERE end_time > $1 AND start_time < $2
+		ORDER BY start_time ASC
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	periods := make([]models.AwayPeriod, 0)
+	for rows.Next() {
+		var p models.AwayPeriod
+		var startUnix, endUnix int64
+		if err := rows.Scan(&p.ID, &startUnix, &endUnix, &p.Reason); err != nil {
+			return nil, err
+		}
+		p.StartTime = time.Unix(startUnix, 0)
+		p.EndTime = time.Unix(endUnix, 0)
+		periods = append(periods, p)
+	}
+	return periods, rows.Err()
+}
+
+// RecordAwayPeriod stores a finished pause.
+func (m *MemoryStore) RecordAwayPeriod(period models.AwayPeriod) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextAwayID++
+	period.ID = m.nextAwayID
+	period.StartTime = time.Unix(period.StartTime.Unix(), 0)
+	period.EndTime = time.Unix(period.EndTime.Unix(), 0)
+	m.awayPeriods = append(m.awayPeriods, period)
+	return nil
+}
+
+// GetAwayPeriods returns the away periods overlapping [start, end), oldest first.
+func (m *MemoryStore) GetAwayPeriods(start, end time.Time) ([]models.AwayPeriod, error) {
+	from, to := unixRange(start, end)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	periods := make([]models.AwayPeriod, 0)
+	for _, p := range m.awayPeriods {
+		if p.EndTime.Unix() > from && p.StartTime.Unix() < to {
+			periods = append(periods, p)
+		}
+	}
+	sort.SliceStable(periods, func(i, j int) bool { return periods[i].StartTime.Before(periods[j].StartTime) })
+	return periods, nil
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func TestAwayPeriods(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		base := time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC)
+		periods := []models.AwayPeriod{
+			{StartTime: base.Add(3 * time.Hour), EndTime: base.Add(4 * time.Hour), Reason: models.AwayScheduled},
+			{StartTime: base, EndTime: base.Add(time.Hour), Reason: models.AwayUserPaused},
+		}
+		for _, p := range periods {
+			if err := s.RecordAwayPeriod(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		all, err := s.GetAwayPeriods(time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 2 || !all[0].StartTime.Equal(base) || all[0].Reason != models.AwayUserPaused || all[0].ID == 0 {
+			t.Fatalf("all away periods = %+v, want both oldest first", all)
+		}
+
+		// Ranges select the periods overlapping them.
+		tests := []struct {
+			name       string
+			start, end time.Time
+			want       int
+		}{
+			{"overlapping the end of one", base.Add(30 * time.Minute), base.Add(2 * time.Hour), 1},
+			{"touching the end of one", base.Add(time.Hour), base.Add(2 * time.Hour), 0},
+			{"spanning both", base.Add(-time.Hour), base.Add(5 * time.Hour), 2},
+			{"open start", time.Time{}, base.Add(time.Minute), 1},
+		}
+		for _, tt := range tests {
+			got, err := s.GetAwayPeriods(tt.start, tt.end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("%s: %d periods, want %d", tt.name, len(got), tt.want)
+			}
+		}
+	})
+}
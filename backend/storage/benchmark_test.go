@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// yearIndexes are the indexes migration 3 added for the dashboard queries.
+var yearIndexes = []string{
+	"idx_activity_sessions_start_time",
+	"idx_activity_sessions_app_title",
+	"idx_activity_sessions_classification",
+}
+
+// newYearDB returns a store holding a generated year of history ending now:
+// 120 sessions a day across 20 apps, about a third of them unclassified.
+// Without indexes, the indexes the dashboard queries rely on are dropped.
+func newYearDB(b *testing.B, indexes bool) *DBStore {
+	b.Helper()
+	path := b.TempDir() + "/year.db"
+	s, err := NewDBStore(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	const classifications = 10
+	for i := 1; i <= classifications; i++ {
+		_, err := tx.Exec("INSERT INTO classifications (id, user_defined_name, is_helpful, goal_context) VALUES ($1, $2, $3, 'Work')",
+			i, fmt.Sprintf("Class %d", i), i%2 == 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	insert, err := tx.Prepare(`
+		INSERT INTO activity_sessions (app_name, window_title, title_hash, start_time, end_time, duration_seconds, classification_id,
+			device_id, source, uid, seq, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, '', 'tracker', $8, 1, 0)
+	`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	end := time.Now().Unix()
+	const perDay, days = 120, 365
+	for i := 0; i < perDay*days; i++ {
+		start := end - int64(days*86400) + int64(i)*86400/perDay
+		app := fmt.Sprintf("App %d", i%20)
+		title := fmt.Sprintf("Document %d", i%500)
+		var classID *int64
+		if i%3 != 0 {
+			id := int64(i%classifications + 1)
+			classID = &id
+		}
+		if _, err := insert.Exec(app, title, s.titleHash(title), start, start+300, 300, classID, fmt.Sprintf("bench-%d", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	insert.Close()
+	if !indexes {
+		for _, index := range yearIndexes {
+			if _, err := tx.Exec("DROP INDEX " + index); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	// Reopen, as the app would on its next start, so the planner has
+	// statistics for the generated rows.
+	s.Close()
+	if s, err = NewDBStore(path); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { s.Close() })
+	return s
+}
+
+// benchmarkYear runs query against a generated year with and without the
+// indexes, to show what they save.
+func benchmarkYear(b *testing.B, query func(s *DBStore) error) {
+	for _, indexes := range []bool{true, false} {
+		name := "indexed"
+		if !indexes {
+			name = "unindexed"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := newYearDB(b, indexes)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := query(s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetTodaySummaryYear(b *testing.B) {
+	benchmarkYear(b, func(s *DBStore) error {
+		_, err := s.GetTodaySummary(ReportFilter{})
+		return err
+	})
+}
+
+func BenchmarkGetSkillProgressYear(b *testing.B) {
+	benchmarkYear(b, func(s *DBStore) error {
+		_, err := s.GetSkillProgress(ReportFilter{})
+		return err
+	})
+}
+
+func BenchmarkGetUnclassifiedSessionsYear(b *testing.B) {
+	benchmarkYear(b, func(s *DBStore) error {
+		_, err := s.GetUnclassifiedSessions()
+		return err
+	})
+}
+
+func BenchmarkApplyClassificationYear(b *testing.B) {
+	benchmarkYear(b, func(s *DBStore) error {
+		// Matches no unclassified session after the first run, so every
+		// iteration measures the lookup.
+		return s.ApplyClassification(models.ClassificationRequest{AppName: "App 3", WindowTitle: "Document 3", UserDefinedName: "Class 2"})
+	})
+}
+
+// BenchmarkInsertRawEvent writes events one transaction at a time, as the
+// logger did before events were buffered.
+func BenchmarkInsertRawEvent(b *testing.B) {
+	s := newTestDBB(b)
+	start := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.InsertRawEvent(models.RawEvent{Timestamp: start.Add(time.Duration(i) * time.Second), AppName: "Code", WindowTitle: "main.go"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBufferedEventWriter writes events through a BufferedEventWriter
+// with the logger's batch size.
+func BenchmarkBufferedEventWriter(b *testing.B) {
+	s := newTestDBB(b)
+	w := NewBufferedEventWriter(s, 12, time.Hour)
+	start := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := w.InsertRawEvent(models.RawEvent{Timestamp: start.Add(time.Duration(i) * time.Second), AppName: "Code", WindowTitle: "main.go"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkProcessRawEvents aggregates a working day of events, one every
+// five seconds across a few alternating windows, with a handful of rules.
+func BenchmarkProcessRawEvents(b *testing.B) {
+	s := newTestDBB(b)
+	for i := 0; i < 10; i++ {
+		_, err := s.CreateClassificationRule(models.CreateClassificationRuleRequest{
+			AppName: fmt.Sprintf("App %d", i), UserDefinedName: fmt.Sprintf("Class %d", i),
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	day := time.Now().Add(-24 * time.Hour)
+	events := make([]models.RawEvent, 0, 8*720)
+	for i := 0; i < 8*720; i++ {
+		window := i / 60 % 20
+		events = append(events, models.RawEvent{
+			Timestamp: day.Add(time.Duration(i) * 5 * time.Second), AppName: fmt.Sprintf("App %d", window), WindowTitle: "document",
+		})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if err := s.InsertRawEvents(events); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if err := s.ProcessRawEvents(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// newTestDBB opens a migrated SQLite store for a benchmark.
+func newTestDBB(b *testing.B) *DBStore {
+	b.Helper()
+	s, err := NewDBStore(b.TempDir() + "/bench.db")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { s.Close() })
+	return s
+}
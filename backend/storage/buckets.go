@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// ErrBucketNotFound is returned for an unknown ActivityWatch bucket.
+var ErrBucketNotFound = errors.New("bucket not found")
+
+// CreateBucket registers a bucket. It reports false when the bucket already
+// existed, which watchers treat as success.
+func (s *DBStore) CreateBucket(bucket models.Bucket) (bool, error) {
+	res, err := s.db.Exec(`
+		INSERT INTO aw_buckets (id, type, client, hostname, created) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO NOTHING
+	`, bucket.ID, bucket.Type, bucket.Client, bucket.Hostname, bucket.Created.Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetBuckets returns all registered buckets ordered by ID.
+func (s *DBStore) GetBuckets() ([]models.Bucket, error) {
+	rows, err := s.db.Query("SELECT id, type, client, hostname, created FROM aw_buckets ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make([]models.Bucket, 0)
+	for rows.Next() {
+		var b models.Bucket
+		var created int64
+		if err := rows.Scan(&b.ID, &b.Type, &b.Client, &b.Hostname, &created); err != nil {
+			return nil, err
+		}
+		b.Created = time.Unix(created, 0)
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// GetBucket returns a single bucket or ErrBucketNotFound.
+func (s *DBStore) GetBucket(id string) (models.Bucket, error) {
+	var b models.Bucket
+	var created int64
+	err := s.db.QueryRow("SELECT id, type, client, hostname, created FROM aw_buckets WHERE id = $1", id).
+		Scan(&b.ID, &b.Type, &b.Client, &b.Hostname, &created)
+	if err == sql.ErrNoRows {
+		return b, ErrBucketNotFound
+	} else if err != nil {
+		return b, err
+	}
+	b.Created = time.Unix(created, 0)
+	return b, nil
+}
+
+// CreateBucket registers a bucket, reporting false if it already existed.
+func (m *MemoryStore) CreateBucket(bucket models.Bucket) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.buckets == nil {
+		m.buckets = make(map[string]models.Bucket)
+	}
+	if _, ok := m.buckets[bucket.ID]; ok {
+		return false, nil
+	}
+	bucket.Created = time.Unix(bucket.Created.Unix(), 0)
+	m.buckets[bucket.ID] = bucket
+	return true, nil
+}
+
+// GetBuckets returns all registered buckets ordered by ID.
+func (m *MemoryStore) GetBuckets() ([]models.Bucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buckets := make([]models.Bucket, 0, len(m.buckets))
+	for _, b := range m.buckets {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].ID < buckets[j].ID })
+	return buckets, nil
+}
+
+// GetBucket returns a single bucket or ErrBucketNotFound.
+func (m *MemoryStore) GetBucket(id string) (models.Bucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[id]
+	if !ok {
+		return b, ErrBucketNotFound
+	}
+	return b, nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func TestBuckets(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		created := time.Now().Truncate(time.Second)
+		for _, id := range []string{"aw-watcher-window_laptop", "aw-watcher-afk_laptop"} {
+			ok, err := s.CreateBucket(models.Bucket{ID: id, Type: "currentwindow", Client: "test", Hostname: "laptop", Created: created})
+			if err != nil || !ok {
+				t.Fatalf("CreateBucket(%s) = %v, %v", id, ok, err)
+			}
+		}
+		if ok, err := s.CreateBucket(models.Bucket{ID: "aw-watcher-afk_laptop", Type: "afkstatus"}); err != nil || ok {
+			t.Errorf("recreating a bucket = %v, %v, want it left alone", ok, err)
+		}
+
+		buckets, err := s.GetBuckets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(buckets) != 2 || buckets[0].ID != "aw-watcher-afk_laptop" || buckets[0].Type != "currentwindow" {
+			t.Errorf("buckets = %+v, want both sorted by ID and unchanged", buckets)
+		}
+		bucket, err := s.GetBucket("aw-watcher-window_laptop")
+		if err != nil || bucket.Hostname != "laptop" || !bucket.Created.Equal(created) {
+			t.Errorf("GetBucket = %+v, %v", bucket, err)
+		}
+		if _, err := s.GetBucket("missing"); !errors.Is(err, ErrBucketNotFound) {
+			t.Errorf("GetBucket(missing): %v, want ErrBucketNotFound", err)
+		}
+	})
+}
+
+func TestProcessRawEventsSeparatesSources(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-time.Hour)
+		var events []models.RawEvent
+		for i := 0; i <= 6; i++ {
+			at := start.Add(time.Duration(i) * 10 * time.Second)
+			events = append(events,
+				models.RawEvent{Timestamp: at, AppName: "Code", WindowTitle: "main.go"},
+				models.RawEvent{Timestamp: at.Add(time.Second), AppName: "Firefox", WindowTitle: "Docs", Source: "aw-watcher-web"},
+			)
+		}
+		if err := s.InsertRawEvents(events); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ProcessRawEvents(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Interleaved reports from a watcher do not split the tracker's session.
+		sessions := allSessions(t, s)
+		if len(sessions) != 2 {
+			t.Fatalf("sessions = %+v, want one per source", sessions)
+		}
+		for _, session := range sessions {
+			if session.Duration != 60 {
+				t.Errorf("%s session lasted %ds, want 60s", session.Source, session.Duration)
+			}
+		}
+		if sessions[0].Source != models.SourceTracker || sessions[1].Source != "aw-watcher-web" {
+			t.Errorf("sources = %q and %q", sessions[0].Source, sessions[1].Source)
+		}
+	})
+}
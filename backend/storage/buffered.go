@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// BufferedEventWriter collects raw events in memory and writes them to the
+// underlying EventWriter in batches, either when maxBatch events are pending
+// or every flushInterval, whichever comes first. Events that fail to write
+// stay buffered and are retried on the next flush.
+type BufferedEventWriter struct {
+	dest          EventWriter
+	maxBatch      int
+	flushInterval time.Duration
+
+	mu      sync.Mutex
+	pending []models.RawEvent
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewBufferedEventWriter creates a BufferedEventWriter and starts its flush loop.
+func NewBufferedEventWriter(dest EventWriter, maxBatch int, flushInterval time.Duration) *BufferedEventWriter {
+	w := &BufferedEventWriter{
+		dest:          dest,
+		maxBatch:      maxBatch,
+		flushInterval: flushInterval,
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+func (w *BufferedEventWriter) loop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Flush(); err != nil {
+				log.Printf("Error flushing raw events: %v", err)
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// InsertRawEvent buffers a single event.
+func (w *BufferedEventWriter) InsertRawEvent(event models.RawEvent) error {
+	return w.InsertRawEvents([]models.RawEvent{event})
+}
+
+// InsertRawEvents buffers events, flushing immediately once the batch is full.
+func (w *BufferedEventWriter) InsertRawEvents(events []models.RawEvent) error {
+	w.mu.Lock()
+	w.pending = append(w.pending, events...)
+	full := len(w.pending) >= w.maxBatch
+	w.mu.Unlock()
+
+	if full {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes all buffered events in a single batch.
+func (w *BufferedEventWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) == 0 {
+		return nil
+	}
+	if err := w.dest.InsertRawEvents(w.pending); err != nil {
+		return err
+	}
+	w.pending = nil
+	return nil
+}
+
+// Close stops the flush loop and writes any remaining events.
+func (w *BufferedEventWriter) Close() error {
+	close(w.quit)
+	<-w.done
+	return w.Flush()
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// recordingWriter is an EventWriter that remembers each batch it is given
+// and fails while failing is set.
+type recordingWriter struct {
+	mu      sync.Mutex
+	batches [][]models.RawEvent
+	failing bool
+}
+
+func (r *recordingWriter) InsertRawEvent(event models.RawEvent) error {
+	return r.InsertRawEvents([]models.RawEvent{event})
+}
+
+func (r *recordingWriter) InsertRawEvents(events []models.RawEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failing {
+		return errors.New("disk full")
+	}
+	r.batches = append(r.batches, append([]models.RawEvent(nil), events...))
+	return nil
+}
+
+// sizes returns the length of every batch written so far.
+func (r *recordingWriter) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sizes := make([]int, len(r.batches))
+	for i, batch := range r.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func event(app string) models.RawEvent {
+	return models.RawEvent{Timestamp: time.Unix(1700000000, 0), AppName: app, WindowTitle: "title"}
+}
+
+func TestBufferedEventWriterFlushesWhenFull(t *testing.T) {
+	dest := &recordingWriter{}
+	w := NewBufferedEventWriter(dest, 3, time.Hour)
+	defer w.Close()
+
+	w.InsertRawEvent(event("a"))
+	w.InsertRawEvent(event("b"))
+	if got := dest.sizes(); len(got) != 0 {
+		t.Fatalf("batches = %v before the buffer filled", got)
+	}
+	if err := w.InsertRawEvent(event("c")); err != nil {
+		t.Fatal(err)
+	}
+	if got := dest.sizes(); !slices.Equal(got, []int{3}) {
+		t.Errorf("batches = %v, want one of 3", got)
+	}
+}
+
+func TestBufferedEventWriterFlushesOnInterval(t *testing.T) {
+	dest := &recordingWriter{}
+	w := NewBufferedEventWriter(dest, 100, 5*time.Millisecond)
+	defer w.Close()
+
+	w.InsertRawEvents([]models.RawEvent{event("a"), event("b")})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(dest.sizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := dest.sizes(); !slices.Equal(got, []int{2}) {
+		t.Errorf("batches = %v, want one of 2 after the interval", got)
+	}
+}
+
+func TestBufferedEventWriterFlushesOnClose(t *testing.T) {
+	dest := &recordingWriter{}
+	w := NewBufferedEventWriter(dest, 100, time.Hour)
+
+	w.InsertRawEvents([]models.RawEvent{event("a"), event("b")})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := dest.sizes(); !slices.Equal(got, []int{2}) {
+		t.Errorf("batches = %v, want one of 2 on close", got)
+	}
+}
+
+func TestBufferedEventWriterRetriesFailedWrites(t *testing.T) {
+	dest := &recordingWriter{failing: true}
+	w := NewBufferedEventWriter(dest, 100, time.Hour)
+	defer w.Close()
+
+	w.InsertRawEvent(event("a"))
+	if err := w.Flush(); err == nil {
+		t.Fatal("Flush succeeded while the destination was failing")
+	}
+	dest.mu.Lock()
+	dest.failing = false
+	dest.mu.Unlock()
+
+	w.InsertRawEvent(event("b"))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := dest.sizes(); !slices.Equal(got, []int{2}) {
+		t.Errorf("batches = %v, want the failed event written with the next", got)
+	}
+}
+
+func TestBufferedEventWriterIntoStore(t *testing.T) {
+	s := newTestDB(t)
+	w := NewBufferedEventWriter(s, 2, time.Hour)
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i <= 6; i++ {
+		w.InsertRawEvent(models.RawEvent{Timestamp: start.Add(time.Duration(i) * 10 * time.Second), AppName: "Code", WindowTitle: "main.go"})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ProcessRawEvents(); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := s.GetUnclassifiedSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Duration != 60 {
+		t.Errorf("sessions = %+v, want one minute of Code", sessions)
+	}
+}
@@ -0,0 +1,668 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/imdawon/personalos/models"
+)
+
+var (
+	// ErrClassificationNotFound is returned for an unknown classification ID.
+	ErrClassificationNotFound = errors.New("classification not found")
+	// ErrClassificationExists is returned when creating or renaming a
+	// classification to a name that is already taken.
+	ErrClassificationExists = errors.New("a classification with this name already exists")
+	// ErrClassificationConflict is returned when a request names an existing
+	// classification but gives it different attributes. Edit the
+	// classification to change them.
+	ErrClassificationConflict = errors.New("classification exists with different settings")
+	// ErrInvalidClassification is returned for a classification request that
+	// cannot be applied, such as an empty name.
+	ErrInvalidClassification = errors.New("invalid classification")
+)
+
+// errMergeIntoDescendant is returned when merging a classification into one
+// nested under it, which would leave the target nested under itself.
+var errMergeIntoDescendant = fmt.Errorf("%w: cannot merge a classification into one nested under it", ErrInvalidClassification)
+
+// conflictError describes how an existing classification differs from a request.
+func conflictError(existing models.Classification) error {
+	return fmt.Errorf("%w: %q has is_helpful=%t and goal_context=%q",
+		ErrClassificationConflict, existing.UserDefinedName, existing.IsHelpful, existing.GoalContext)
+}
+
+// findOrCreateClassification returns the ID of the named classification,
+// creating it with the given attributes if it does not exist. Attributes left
+// nil default to not helpful and no goal context. An existing classification
+// fails with ErrClassificationConflict only if a given attribute differs.
+// Creations are logged to audit, if set.
+func findOrCreateClassification(tx *sql.Tx, name string, isHelpful *bool, goalContext *string, st stamp, audit *audit) (int64, error) {
+	existing := models.Classification{UserDefinedName: name}
+	err := tx.QueryRow("SELECT id, is_helpful, goal_context FROM classifications WHERE user_defined_name = $1", name).
+		Scan(&existing.ID, &existing.IsHelpful, &existing.GoalContext)
+	if err == nil {
+		if conflicts(existing, isHelpful, goalContext) {
+			return 0, conflictError(existing)
+		}
+		return existing.ID, nil
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	c := newClassification(name, isHelpful, goalContext)
+	id, err := insertClassification(tx, c, st)
+	if err == nil && audit != nil {
+		err = audit.classificationCreated(id, c)
+	}
+	return id, err
+}
+
+// conflicts reports whether an attribute a request gives differs from the
+// existing classification's.
+func conflicts(existing models.Classification, isHelpful *bool, goalContext *string) bool {
+	return (isHelpful != nil && *isHelpful != existing.IsHelpful) ||
+		(goalContext != nil && *goalContext != existing.GoalContext)
+}
+
+// newClassification returns the classification a request creates, with the
+// attributes it left nil at their defaults.
+func newClassification(name string, isHelpful *bool, goalContext *string) models.Classification {
+	c := models.Classification{UserDefinedName: name}
+	if isHelpful != nil {
+		c.IsHelpful = *isHelpful
+	}
+	if goalContext != nil {
+		c.GoalContext = *goalContext
+	}
+	return c
+}
+
+// insertClassification creates a classification. A name that was deleted
+// before replaces the deletion on other devices. A classification with an ID
+// keeps it, as when undoing its deletion.
+func insertClassification(tx *sql.Tx, c models.Classification, st stamp) (int64, error) {
+	id := c.ID
+	var err error
+	if id != 0 {
+		_, err = tx.Exec("INSERT INTO classifications (id, user_defined_name, is_helpful, goal_context, parent_id, seq, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			id, c.UserDefinedName, c.IsHelpful, c.GoalContext, c.ParentID, st.seq, st.at)
+	} else {
+		err = tx.QueryRow("INSERT INTO classifications (user_defined_name, is_helpful, goal_context, parent_id, seq, updated_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+			c.UserDefinedName, c.IsHelpful, c.GoalContext, c.ParentID, st.seq, st.at).Scan(&id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneClassification, c.UserDefinedName)
+	return id, err
+}
+
+func loadClassification(tx *sql.Tx, id int64) (models.Classification, error) {
+	c := models.Classification{ID: id}
+	err := tx.QueryRow("SELECT user_defined_name, is_helpful, goal_context, parent_id FROM classifications WHERE id = $1", id).
+		Scan(&c.UserDefinedName, &c.IsHelpful, &c.GoalContext, &c.ParentID)
+	if err == sql.ErrNoRows {
+		return c, fmt.Errorf("%w: %d", ErrClassificationNotFound, id)
+	}
+	return c, err
+}
+
+// checkNameFree fails with ErrClassificationExists if another classification
+// than id uses name.
+func checkNameFree(tx *sql.Tx, name string, id int64) error {
+	var taken bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM classifications WHERE user_defined_name = $1 AND id <> $2)", name, id).Scan(&taken)
+	if err == nil && taken {
+		err = fmt.Errorf("%w: %q", ErrClassificationExists, name)
+	}
+	return err
+}
+
+// touchClassified restamps the sessions, rules and child classifications of
+// a classification, so other devices pick up its new name. Sync refers to
+// classifications by name.
+func touchClassified(tx *sql.Tx, id int64, st stamp) error {
+	for _, table := range []string{"activity_sessions", "classification_rules"} {
+		if _, err := tx.Exec("UPDATE "+table+" SET seq = $1, updated_at = $2 WHERE classification_id = $3", st.seq, st.at, id); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("UPDATE classifications SET seq = $1, updated_at = $2 WHERE parent_id = $3", st.seq, st.at, id)
+	return err
+}
+
+// reparentChildren moves the classifications nested under from to parent and
+// logs each move.
+func reparentChildren(tx *sql.Tx, audit *audit, from int64, parent *int64, st stamp) error {
+	rows, err := tx.Query("SELECT id, user_defined_name, is_helpful, goal_context FROM classifications WHERE parent_id = $1", from)
+	if err != nil {
+		return err
+	}
+	var children []models.Classification
+	for rows.Next() {
+		child := models.Classification{ParentID: &from}
+		if err := rows.Scan(&child.ID, &child.UserDefinedName, &child.IsHelpful, &child.GoalContext); err != nil {
+			rows.Close()
+			return err
+		}
+		children = append(children, child)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, child := range children {
+		moved := child
+		moved.ParentID = parent
+		if err := audit.record(auditClassification, child.ID, snapshotOf(child), snapshotOf(moved)); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE classifications SET parent_id = $1, seq = $2, updated_at = $3 WHERE parent_id = $4", parent, st.seq, st.at, from)
+	return err
+}
+
+// updateClassification saves the new name, attributes and parent of old.
+func updateClassification(tx *sql.Tx, old, c models.Classification, st stamp) error {
+	_, err := tx.Exec("UPDATE classifications SET user_defined_name = $1, is_helpful = $2, goal_context = $3, parent_id = $4, seq = $5, updated_at = $6 WHERE id = $7",
+		c.UserDefinedName, c.IsHelpful, c.GoalContext, c.ParentID, st.seq, st.at, c.ID)
+	if err != nil || old.UserDefinedName == c.UserDefinedName {
+		return err
+	}
+	// Other devices see a rename as a new classification that the sessions,
+	// rules and children move to, and the old name being deleted.
+	if err := touchClassified(tx, c.ID, st); err != nil {
+		return err
+	}
+	if err := writeTombstone(tx, tombstoneClassification, old.UserDefinedName, st); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneClassification, c.UserDefinedName)
+	return err
+}
+
+// logSessionMoves logs moving the sessions classified as from to to, nil for
+// unclassified, ahead of the update that moves them. Sessions stay automatic
+// only if they keep a classification.
+func logSessionMoves(tx *sql.Tx, audit *audit, from int64, to *int64) error {
+	rows, err := tx.Query("SELECT id, auto_classified FROM activity_sessions WHERE classification_id = $1", from)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	var autos []bool
+	for rows.Next() {
+		var id int64
+		var auto bool
+		if err := rows.Scan(&id, &auto); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		autos = append(autos, auto)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i, id := range ids {
+		before := sessionSnapshot{ClassificationID: &from, AutoClassified: autos[i]}
+		after := sessionSnapshot{ClassificationID: to, AutoClassified: autos[i] && to != nil}
+		if err := audit.record(auditSession, id, before, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// classificationRules loads the rules of a classification as audit snapshots,
+// keyed by rule ID.
+func classificationRules(tx *sql.Tx, classID int64) (map[int64]ruleSnapshot, []int64, error) {
+	rows, err := tx.Query("SELECT id FROM classification_rules WHERE classification_id = $1 ORDER BY id", classID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	snaps := make(map[int64]ruleSnapshot, len(ids))
+	for _, id := range ids {
+		if snaps[id], err = loadRuleSnapshot(tx, id); err != nil {
+			return nil, nil, err
+		}
+	}
+	return snaps, ids, nil
+}
+
+// CreateClassification adds a classification.
+func (s *DBStore) CreateClassification(req models.CreateClassificationRequest) (models.Classification, error) {
+	c := models.Classification{
+		UserDefinedName: strings.TrimSpace(req.UserDefinedName),
+		IsHelpful:       req.IsHelpful,
+		GoalContext:     req.GoalContext,
+		ParentID:        req.ParentID,
+	}
+	if c.UserDefinedName == "" {
+		return c, fmt.Errorf("%w: a name is required", ErrInvalidClassification)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return c, err
+	}
+	defer tx.Rollback()
+
+	if err := checkNameFree(tx, c.UserDefinedName, 0); err != nil {
+		return c, err
+	}
+	parents, err := loadParents(tx)
+	if err != nil {
+		return c, err
+	}
+	if err := checkParent(parents, 0, c.ParentID); err != nil {
+		return c, err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return c, err
+	}
+	if c.ID, err = insertClassification(tx, c, st); err != nil {
+		return c, err
+	}
+	return c, tx.Commit()
+}
+
+// UpdateClassification renames a classification or changes its attributes.
+func (s *DBStore) UpdateClassification(req models.UpdateClassificationRequest) (models.Classification, error) {
+	c := models.Classification{
+		ID:              req.ID,
+		UserDefinedName: strings.TrimSpace(req.UserDefinedName),
+		IsHelpful:       req.IsHelpful,
+		GoalContext:     req.GoalContext,
+		ParentID:        req.ParentID,
+	}
+	if c.UserDefinedName == "" {
+		return c, fmt.Errorf("%w: a name is required", ErrInvalidClassification)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return c, err
+	}
+	defer tx.Rollback()
+
+	old, err := loadClassification(tx, c.ID)
+	if err != nil {
+		return c, err
+	}
+	if err := checkNameFree(tx, c.UserDefinedName, c.ID); err != nil {
+		return c, err
+	}
+	parents, err := loadParents(tx)
+	if err != nil {
+		return c, err
+	}
+	if err := checkParent(parents, c.ID, c.ParentID); err != nil {
+		return c, err
+	}
+	if snapshotOf(old).equal(snapshotOf(c)) {
+		return c, nil
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return c, err
+	}
+	audit := startAudit(tx, models.AuditUpdateClassification, nil)
+	if err := audit.record(auditClassification, c.ID, snapshotOf(old), snapshotOf(c)); err != nil {
+		return c, err
+	}
+	if err := updateClassification(tx, old, c, st); err != nil {
+		return c, err
+	}
+	return c, tx.Commit()
+}
+
+// DeleteClassification deletes a classification along with its rules. Its
+// sessions become unclassified again, and the classifications nested under it
+// move up to its parent.
+func (s *DBStore) DeleteClassification(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	c, err := loadClassification(tx, id)
+	if err != nil {
+		return err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return err
+	}
+	// The classification is logged last, so undoing brings it back before
+	// its sessions, rules and children return to it.
+	audit := startAudit(tx, models.AuditDeleteClassification, nil)
+	if err := logSessionMoves(tx, audit, id, nil); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE activity_sessions SET classification_id = NULL, auto_classified = FALSE, seq = $1, updated_at = $2 WHERE classification_id = $3",
+		st.seq, st.at, id); err != nil {
+		return err
+	}
+
+	rules, ruleIDs, err := classificationRules(tx, id)
+	if err != nil {
+		return err
+	}
+	for _, ruleID := range ruleIDs {
+		rule := rules[ruleID]
+		if err := audit.record(auditRule, ruleID, rule, nil); err != nil {
+			return err
+		}
+		if err := writeTombstone(tx, tombstoneRule, ruleKey(rule.AppName, rule.WindowTitleContains, rule.Condition), st); err != nil {
+			return err
+		}
+	}
+	if err := deleteRulesWhere(tx, "classification_id = $1", id); err != nil {
+		return err
+	}
+	if err := reparentChildren(tx, audit, id, c.ParentID, st); err != nil {
+		return err
+	}
+
+	if err := audit.record(auditClassification, id, snapshotOf(c), nil); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM classifications WHERE id = $1", id); err != nil {
+		return err
+	}
+	if err := writeTombstone(tx, tombstoneClassification, c.UserDefinedName, st); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MergeClassifications moves the sessions, rules and children of one
+// classification to another and deletes the first. It returns the target,
+// which cannot be nested under the source.
+func (s *DBStore) MergeClassifications(req models.MergeClassificationsRequest) (models.Classification, error) {
+	if req.SourceID == req.TargetID {
+		return models.Classification{}, fmt.Errorf("%w: cannot merge a classification into itself", ErrInvalidClassification)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.Classification{}, err
+	}
+	defer tx.Rollback()
+
+	source, err := loadClassification(tx, req.SourceID)
+	if err != nil {
+		return source, err
+	}
+	target, err := loadClassification(tx, req.TargetID)
+	if err != nil {
+		return target, err
+	}
+	parents, err := loadParents(tx)
+	if err != nil {
+		return target, err
+	}
+	if isDescendant(parents, target.ID, source.ID) {
+		return target, errMergeIntoDescendant
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return target, err
+	}
+	// As for a deletion, the source is logged last.
+	audit := startAudit(tx, models.AuditMergeClassifications, nil)
+	if err := logSessionMoves(tx, audit, source.ID, &target.ID); err != nil {
+		return target, err
+	}
+	rules, ruleIDs, err := classificationRules(tx, source.ID)
+	if err != nil {
+		return target, err
+	}
+	for _, ruleID := range ruleIDs {
+		moved := rules[ruleID]
+		moved.ClassificationID = target.ID
+		if err := audit.record(auditRule, ruleID, rules[ruleID], moved); err != nil {
+			return target, err
+		}
+	}
+	for _, table := range []string{"activity_sessions", "classification_rules"} {
+		_, err := tx.Exec("UPDATE "+table+" SET classification_id = $1, seq = $2, updated_at = $3 WHERE classification_id = $4",
+			target.ID, st.seq, st.at, source.ID)
+		if err != nil {
+			return target, err
+		}
+	}
+	if err := reparentChildren(tx, audit, source.ID, &target.ID, st); err != nil {
+		return target, err
+	}
+	if err := audit.record(auditClassification, source.ID, snapshotOf(source), nil); err != nil {
+		return target, err
+	}
+	if _, err := tx.Exec("DELETE FROM classifications WHERE id = $1", source.ID); err != nil {
+		return target, err
+	}
+	if err := writeTombstone(tx, tombstoneClassification, source.UserDefinedName, st); err != nil {
+		return target, err
+	}
+	return target, tx.Commit()
+}
+
+// findOrCreateClassification returns the ID of the named classification,
+// creating it if it does not exist. Callers must hold m.mu.
+func (m *MemoryStore) findOrCreateClassification(name string, isHelpful *bool, goalContext *string) (int64, error) {
+	for _, c := range m.classifications {
+		if c.UserDefinedName == name {
+			if conflicts(c, isHelpful, goalContext) {
+				return 0, conflictError(c)
+			}
+			return c.ID, nil
+		}
+	}
+	m.nextClassificationID++
+	c := newClassification(name, isHelpful, goalContext)
+	c.ID = m.nextClassificationID
+	m.classifications = append(m.classifications, c)
+	return c.ID, nil
+}
+
+// checkNameFree mirrors the DBStore check. Callers must hold m.mu.
+func (m *MemoryStore) checkNameFree(name string, id int64) error {
+	for _, c := range m.classifications {
+		if c.UserDefinedName == name && c.ID != id {
+			return fmt.Errorf("%w: %q", ErrClassificationExists, name)
+		}
+	}
+	return nil
+}
+
+// classificationIndex returns the position of a classification in
+// m.classifications. Callers must hold m.mu.
+func (m *MemoryStore) classificationIndex(id int64) (int, error) {
+	for i, c := range m.classifications {
+		if c.ID == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %d", ErrClassificationNotFound, id)
+}
+
+// reparentChildren moves the classifications nested under from to parent and
+// logs each move. Callers must hold m.mu.
+func (m *MemoryStore) reparentChildren(audit *memoryAudit, from int64, parent *int64) {
+	for k := range m.classifications {
+		child := &m.classifications[k]
+		if p := child.ParentID; p != nil && *p == from {
+			before := *child
+			child.ParentID = parent
+			audit.record(auditClassification, child.ID, before, *child)
+		}
+	}
+}
+
+// moveSessions moves and logs the sessions classified as from to to, nil for
+// unclassified. Sessions stay automatic only if they keep a classification.
+// Callers must hold m.mu.
+func (m *MemoryStore) moveSessions(audit *memoryAudit, from int64, to *int64) {
+	for k := range m.sessions {
+		session := &m.sessions[k]
+		if c := session.ClassificationID; c == nil || *c != from {
+			continue
+		}
+		before := sessionSnapshot{ClassificationID: session.ClassificationID, AutoClassified: session.AutoClassified}
+		if to == nil {
+			session.ClassificationID = nil
+			session.AutoClassified = false
+		} else {
+			id := *to
+			session.ClassificationID = &id
+		}
+		audit.record(auditSession, session.ID, before, sessionSnapshot{ClassificationID: session.ClassificationID, AutoClassified: session.AutoClassified})
+	}
+}
+
+// CreateClassification adds a classification.
+func (m *MemoryStore) CreateClassification(req models.CreateClassificationRequest) (models.Classification, error) {
+	c := models.Classification{
+		UserDefinedName: strings.TrimSpace(req.UserDefinedName),
+		IsHelpful:       req.IsHelpful,
+		GoalContext:     req.GoalContext,
+		ParentID:        req.ParentID,
+	}
+	if c.UserDefinedName == "" {
+		return c, fmt.Errorf("%w: a name is required", ErrInvalidClassification)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkNameFree(c.UserDefinedName, 0); err != nil {
+		return c, err
+	}
+	if err := checkParent(parentsOf(m.classifications), 0, c.ParentID); err != nil {
+		return c, err
+	}
+	m.nextClassificationID++
+	c.ID = m.nextClassificationID
+	m.classifications = append(m.classifications, c)
+	return c, nil
+}
+
+// UpdateClassification renames a classification or changes its attributes.
+func (m *MemoryStore) UpdateClassification(req models.UpdateClassificationRequest) (models.Classification, error) {
+	c := models.Classification{
+		ID:              req.ID,
+		UserDefinedName: strings.TrimSpace(req.UserDefinedName),
+		IsHelpful:       req.IsHelpful,
+		GoalContext:     req.GoalContext,
+		ParentID:        req.ParentID,
+	}
+	if c.UserDefinedName == "" {
+		return c, fmt.Errorf("%w: a name is required", ErrInvalidClassification)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, err := m.classificationIndex(c.ID)
+	if err != nil {
+		return c, err
+	}
+	if err := m.checkNameFree(c.UserDefinedName, c.ID); err != nil {
+		return c, err
+	}
+	if err := checkParent(parentsOf(m.classifications), c.ID, c.ParentID); err != nil {
+		return c, err
+	}
+	if old := m.classifications[i]; !snapshotOf(old).equal(snapshotOf(c)) {
+		m.startAudit(models.AuditUpdateClassification, nil).record(auditClassification, c.ID, old, c)
+		m.classifications[i] = c
+	}
+	return c, nil
+}
+
+// DeleteClassification deletes a classification along with its rules. Its
+// sessions become unclassified again, and the classifications nested under it
+// move up to its parent.
+func (m *MemoryStore) DeleteClassification(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, err := m.classificationIndex(id)
+	if err != nil {
+		return err
+	}
+	audit := m.startAudit(models.AuditDeleteClassification, nil)
+	m.moveSessions(audit, id, nil)
+	kept := m.rules[:0]
+	for _, rule := range m.rules {
+		if rule.ClassificationID != id {
+			kept = append(kept, rule)
+			continue
+		}
+		audit.record(auditRule, rule.ID, rule, nil)
+	}
+	m.rules = kept
+	c := m.classifications[i]
+	m.reparentChildren(audit, id, c.ParentID)
+	audit.record(auditClassification, id, c, nil)
+	m.classifications = append(m.classifications[:i], m.classifications[i+1:]...)
+	return nil
+}
+
+// MergeClassifications moves the sessions, rules and children of one
+// classification to another and deletes the first. It returns the target,
+// which cannot be nested under the source.
+func (m *MemoryStore) MergeClassifications(req models.MergeClassificationsRequest) (models.Classification, error) {
+	if req.SourceID == req.TargetID {
+		return models.Classification{}, fmt.Errorf("%w: cannot merge a classification into itself", ErrInvalidClassification)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, err := m.classificationIndex(req.SourceID)
+	if err != nil {
+		return models.Classification{}, err
+	}
+	j, err := m.classificationIndex(req.TargetID)
+	if err != nil {
+		return models.Classification{}, err
+	}
+	target := m.classifications[j]
+	if isDescendant(parentsOf(m.classifications), target.ID, req.SourceID) {
+		return target, errMergeIntoDescendant
+	}
+	audit := m.startAudit(models.AuditMergeClassifications, nil)
+	m.moveSessions(audit, req.SourceID, &target.ID)
+	for k := range m.rules {
+		if rule := &m.rules[k]; rule.ClassificationID == req.SourceID {
+			before := *rule
+			rule.ClassificationID = target.ID
+			audit.record(auditRule, rule.ID, before, *rule)
+		}
+	}
+	m.reparentChildren(audit, req.SourceID, &target.ID)
+	audit.record(auditClassification, req.SourceID, m.classifications[i], nil)
+	m.classifications = append(m.classifications[:i], m.classifications[i+1:]...)
+	return target, nil
+}
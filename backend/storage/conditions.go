@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// maxConditionDepth bounds how deeply conditions may nest.
+const maxConditionDepth = 16
+
+// condition reports whether a session satisfies a compiled RuleCondition.
+type condition func(s *models.ActivitySession) bool
+
+var conditionWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// compileCondition validates a condition and turns it into a function.
+func compileCondition(c models.RuleCondition, depth int) (condition, error) {
+	if depth > maxConditionDepth {
+		return nil, fmt.Errorf("%w: conditions nest deeper than %d levels", ErrInvalidRule, maxConditionDepth)
+	}
+
+	var kinds []string
+	if c.All != nil {
+		kinds = append(kinds, "all")
+	}
+	if c.Any != nil {
+		kinds = append(kinds, "any")
+	}
+	if c.Not != nil {
+		kinds = append(kinds, "not")
+	}
+	if c.Field != "" || c.Match != "" || c.Value != "" {
+		kinds = append(kinds, "field")
+	}
+	if c.Weekdays != nil {
+		kinds = append(kinds, "weekdays")
+	}
+	if c.From != "" || c.To != "" {
+		kinds = append(kinds, "from/to")
+	}
+	if c.MinSeconds != 0 || c.MaxSeconds != 0 {
+		kinds = append(kinds, "min_seconds/max_seconds")
+	}
+	if len(kinds) != 1 {
+		return nil, fmt.Errorf("%w: a condition must hold exactly one of all, any, not, field, weekdays, from/to or min_seconds/max_seconds, got %d",
+			ErrInvalidRule, len(kinds))
+	}
+
+	switch kinds[0] {
+	case "all", "any":
+		parts := c.All
+		if kinds[0] == "any" {
+			parts = c.Any
+		}
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("%w: %q needs at least one condition", ErrInvalidRule, kinds[0])
+		}
+		compiled := make([]condition, len(parts))
+		for i, part := range parts {
+			var err error
+			if compiled[i], err = compileCondition(part, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		if kinds[0] == "all" {
+			return func(s *models.ActivitySession) bool {
+				for _, c := range compiled {
+					if !c(s) {
+						return false
+					}
+				}
+				return true
+			}, nil
+		}
+		return func(s *models.ActivitySession) bool {
+			for _, c := range compiled {
+				if c(s) {
+					return true
+				}
+			}
+			return false
+		}, nil
+
+	case "not":
+		inner, err := compileCondition(*c.Not, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *models.ActivitySession) bool { return !inner(s) }, nil
+
+	case "field":
+		return compileFieldCondition(c)
+
+	case "weekdays":
+		if len(c.Weekdays) == 0 {
+			return nil, fmt.Errorf("%w: %q needs at least one day", ErrInvalidRule, kinds[0])
+		}
+		var days [7]bool
+		for _, day := range c.Weekdays {
+			name := strings.ToLower(day)
+			if len(name) > 3 {
+				name = name[:3]
+			}
+			wd, ok := conditionWeekdays[name]
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown day %q", ErrInvalidRule, day)
+			}
+			days[wd] = true
+		}
+		return func(s *models.ActivitySession) bool { return days[s.StartTime.Local().Weekday()] }, nil
+
+	case "from/to":
+		from, err := parseConditionClock(c.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseConditionClock(c.To)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *models.ActivitySession) bool {
+			start := s.StartTime.Local()
+			at := time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute + time.Duration(start.Second())*time.Second
+			if to > from {
+				return at >= from && at < to
+			}
+			return at >= from || at < to
+		}, nil
+
+	default:
+		if c.MinSeconds < 0 || c.MaxSeconds < 0 || (c.MaxSeconds != 0 && c.MaxSeconds < c.MinSeconds) {
+			return nil, fmt.Errorf("%w: invalid duration range %d to %d seconds", ErrInvalidRule, c.MinSeconds, c.MaxSeconds)
+		}
+		return func(s *models.ActivitySession) bool {
+			return s.Duration >= c.MinSeconds && (c.MaxSeconds == 0 || s.Duration <= c.MaxSeconds)
+		}, nil
+	}
+}
+
+// compileFieldCondition compiles a pattern test on one session field.
+func compileFieldCondition(c models.RuleCondition) (condition, error) {
+	var value func(s *models.ActivitySession) string
+	fallback := models.MatchContains
+	switch c.Field {
+	case models.ConditionFieldApp:
+		value = func(s *models.ActivitySession) string { return s.AppName }
+		fallback = models.MatchExact
+	case models.ConditionFieldTitle:
+		value = func(s *models.ActivitySession) string { return s.WindowTitle }
+	case models.ConditionFieldURL:
+		value = func(s *models.ActivitySession) string { return s.URL }
+	case models.ConditionFieldCwd:
+		value = func(s *models.ActivitySession) string { return s.Cwd }
+		fallback = models.MatchPrefix
+	case models.ConditionFieldDomain:
+		value = func(s *models.ActivitySession) string { return urlHost(s.URL) }
+		if c.Match == "" {
+			domain := strings.ToLower(strings.TrimPrefix(c.Value, "."))
+			if domain == "" {
+				return nil, fmt.Errorf("%w: a domain condition needs a value", ErrInvalidRule)
+			}
+			return func(s *models.ActivitySession) bool {
+				host := urlHost(s.URL)
+				return host == domain || strings.HasSuffix(host, "."+domain)
+			}, nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown condition field %q (want app, title, url, domain or cwd)", ErrInvalidRule, c.Field)
+	}
+
+	kind, err := normalizeMatch(c.Match, fallback)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike a rule's own patterns, a condition exists only to constrain its
+	// field, so an empty value is a mistake rather than "anything".
+	if c.Value == "" {
+		return nil, fmt.Errorf("%w: a %s condition needs a value", ErrInvalidRule, c.Field)
+	}
+	match, err := compileMatcher(kind, c.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Field, err)
+	}
+	return func(s *models.ActivitySession) bool { return match(value(s)) }, nil
+}
+
+// urlHost returns the lowercased host of a URL, or "" if it has none.
+func urlHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func parseConditionClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid time %q, expected HH:MM", ErrInvalidRule, value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// encodeCondition returns the JSON a rule condition is stored and synced as,
+// or "" for none. The encoding is canonical, so equal conditions compare equal.
+func encodeCondition(c *models.RuleCondition) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(c)
+	return string(data), err
+}
+
+// decodeCondition parses a stored condition. "" means none.
+func decodeCondition(data string) (*models.RuleCondition, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var c models.RuleCondition
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("%w: invalid condition: %v", ErrInvalidRule, err)
+	}
+	return &c, nil
+}
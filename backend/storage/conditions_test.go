@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// parseCondition decodes a condition written in JSON.
+func parseCondition(t *testing.T, text string) models.RuleCondition {
+	t.Helper()
+	var c models.RuleCondition
+	if err := json.Unmarshal([]byte(text), &c); err != nil {
+		t.Fatalf("decoding %s: %v", text, err)
+	}
+	return c
+}
+
+func TestCompileCondition(t *testing.T) {
+	// Monday 19 October 2026 at 10:30 local time.
+	monday := time.Date(2026, time.October, 19, 10, 30, 0, 0, time.Local)
+	session := func(start time.Time, seconds int64) *models.ActivitySession {
+		return &models.ActivitySession{
+			AppName: "Firefox", WindowTitle: "Go Tour - YouTube", URL: "https://www.youtube.com/watch?v=1",
+			Cwd: "/home/me/src/acme", StartTime: start, Duration: seconds,
+		}
+	}
+	tests := []struct {
+		name, condition string
+		session         *models.ActivitySession
+		want            bool
+	}{
+		{"app is exact by default", `{"field": "app", "value": "firefox"}`, session(monday, 60), false},
+		{"app iexact", `{"field": "app", "match": "iexact", "value": "firefox"}`, session(monday, 60), true},
+		{"title contains", `{"field": "title", "value": "youtube"}`, session(monday, 60), true},
+		{"url glob", `{"field": "url", "match": "glob", "value": "https://*.youtube.com/*"}`, session(monday, 60), true},
+		{"domain and its subdomains", `{"field": "domain", "value": "youtube.com"}`, session(monday, 60), true},
+		{"other domain", `{"field": "domain", "value": "tube.com"}`, session(monday, 60), false},
+		{"domain with a match type", `{"field": "domain", "match": "exact", "value": "youtube.com"}`, session(monday, 60), false},
+		{"cwd prefix", `{"field": "cwd", "value": "/home/me/src"}`, session(monday, 60), true},
+		{"weekday", `{"weekdays": ["Monday", "fri"]}`, session(monday, 60), true},
+		{"weekend", `{"weekdays": ["sat", "sun"]}`, session(monday, 60), false},
+		{"time window", `{"from": "09:00", "to": "17:00"}`, session(monday, 60), true},
+		{"window end is exclusive", `{"from": "09:00", "to": "10:30"}`, session(monday, 60), false},
+		{"window past midnight, evening", `{"from": "20:00", "to": "02:00"}`, session(monday.Add(11*time.Hour), 60), true},
+		{"window past midnight, night", `{"from": "20:00", "to": "02:00"}`, session(monday.Add(15*time.Hour), 60), true},
+		{"window past midnight, morning", `{"from": "20:00", "to": "02:00"}`, session(monday, 60), false},
+		{"long enough", `{"min_seconds": 60}`, session(monday, 60), true},
+		{"too long", `{"max_seconds": 59}`, session(monday, 60), false},
+		{"all", `{"all": [{"field": "domain", "value": "youtube.com"}, {"weekdays": ["mon"]}, {"from": "09:00", "to": "17:00"}]}`, session(monday, 60), true},
+		{"all with one failing", `{"all": [{"field": "domain", "value": "youtube.com"}, {"from": "20:00", "to": "00:00"}]}`, session(monday, 60), false},
+		{"any", `{"any": [{"field": "app", "value": "Safari"}, {"field": "app", "value": "Firefox"}]}`, session(monday, 60), true},
+		{"not", `{"not": {"weekdays": ["mon"]}}`, session(monday, 60), false},
+	}
+	for _, test := range tests {
+		cond, err := compileCondition(parseCondition(t, test.condition), 0)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := cond(test.session); got != test.want {
+			t.Errorf("%s: %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCompileConditionRejectsInvalid(t *testing.T) {
+	deep := `{"weekdays": ["mon"]}`
+	for i := 0; i <= maxConditionDepth; i++ {
+		deep = `{"not": ` + deep + `}`
+	}
+	invalid := map[string]string{
+		"empty":                `{}`,
+		"two kinds":            `{"weekdays": ["mon"], "from": "09:00", "to": "17:00"}`,
+		"empty all":            `{"all": []}`,
+		"empty any":            `{"any": []}`,
+		"no weekdays":          `{"weekdays": []}`,
+		"unknown weekday":      `{"weekdays": ["someday"]}`,
+		"unknown field":        `{"field": "host", "value": "x"}`,
+		"field without value":  `{"field": "title"}`,
+		"domain without value": `{"field": "domain", "value": "."}`,
+		"unknown match type":   `{"field": "title", "match": "fuzzy", "value": "x"}`,
+		"bad regex":            `{"field": "title", "match": "regex", "value": "("}`,
+		"bad time":             `{"from": "9am", "to": "17:00"}`,
+		"missing end":          `{"from": "09:00"}`,
+		"negative duration":    `{"min_seconds": -1}`,
+		"max below min":        `{"min_seconds": 60, "max_seconds": 30}`,
+		"invalid nested":       `{"all": [{"weekdays": ["mon"]}, {"weekdays": []}]}`,
+		"nested too deeply":    deep,
+	}
+	for name, text := range invalid {
+		if _, err := compileCondition(parseCondition(t, text), 0); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("%s: %v, want ErrInvalidRule", name, err)
+		}
+	}
+}
+
+func TestConditionRoundTrip(t *testing.T) {
+	c := parseCondition(t, `{"all": [{"field": "domain", "value": "youtube.com"}, {"not": {"weekdays": ["sat", "sun"]}}]}`)
+	encoded, err := encodeCondition(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeCondition(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := encodeCondition(decoded); again != encoded {
+		t.Errorf("round trip changed %s to %s", encoded, again)
+	}
+	if none, err := encodeCondition(nil); none != "" || err != nil {
+		t.Errorf("encodeCondition(nil) = %q, %v", none, err)
+	}
+	if _, err := decodeCondition("{"); !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("decoding bad JSON: %v, want ErrInvalidRule", err)
+	}
+}
+
+// visit returns the events of a minute on a web page starting at start.
+func visit(start time.Time, url string) []models.RawEvent {
+	var events []models.RawEvent
+	for i := 0; i <= 6; i++ {
+		events = append(events, models.RawEvent{Timestamp: start.Add(time.Duration(i) * 10 * time.Second), AppName: "Firefox", WindowTitle: url, URL: url})
+	}
+	return events
+}
+
+func TestConditionalRules(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		// YouTube on weekdays 9 to 5 is a distraction, but after 8pm it is
+		// relaxing.
+		youtube := models.RuleCondition{Field: models.ConditionFieldDomain, Value: "youtube.com"}
+		rules := []models.CreateClassificationRuleRequest{
+			{UserDefinedName: "Distraction", Condition: &models.RuleCondition{All: []models.RuleCondition{
+				youtube,
+				{Weekdays: []string{"mon", "tue", "wed", "thu", "fri"}},
+				{From: "09:00", To: "17:00"},
+			}}},
+			{UserDefinedName: "Relax", Condition: &models.RuleCondition{All: []models.RuleCondition{youtube, {From: "20:00", To: "00:00"}}}},
+		}
+		for _, req := range rules {
+			if _, err := s.CreateClassificationRule(req); err != nil {
+				t.Fatal(err)
+			}
+		}
+		invalid := models.CreateClassificationRuleRequest{UserDefinedName: "Never", Condition: &models.RuleCondition{Weekdays: []string{}}}
+		if _, err := s.CreateClassificationRule(invalid); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("creating a rule for no weekdays: %v, want ErrInvalidRule", err)
+		}
+
+		monday := time.Date(2026, time.October, 19, 0, 0, 0, 0, time.Local)
+		visits := []struct {
+			at   time.Time
+			url  string
+			want string
+		}{
+			{monday.Add(10 * time.Hour), "https://www.youtube.com/watch?v=1", "Distraction"},
+			{monday.Add(21 * time.Hour), "https://youtube.com/watch?v=2", "Relax"},
+			{monday.Add(18 * time.Hour), "https://youtube.com/watch?v=3", ""},
+			{monday.Add(5*24*time.Hour + 10*time.Hour), "https://youtube.com/watch?v=4", ""},
+			{monday.Add(11 * time.Hour), "https://go.dev/tour", ""},
+		}
+		// All the visits are processed as one batch.
+		var events []models.RawEvent
+		for _, v := range visits {
+			events = append(events, visit(v.at, v.url)...)
+		}
+		if err := s.InsertRawEvents(events); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ProcessRawEvents(); err != nil {
+			t.Fatal(err)
+		}
+
+		names := make(map[int64]string)
+		all, err := s.ListClassifications()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range all {
+			names[c.ID] = c.UserDefinedName
+		}
+		got := make(map[string]string)
+		for _, session := range allSessions(t, s) {
+			if session.ClassificationID != nil {
+				got[session.URL] = names[*session.ClassificationID]
+			}
+		}
+		for _, v := range visits {
+			if got[v.url] != v.want {
+				t.Errorf("%s at %s classified as %q, want %q", v.url, v.at.Format("Mon 15:04"), got[v.url], v.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,504 @@
+package storage
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+var (
+	// ErrSessionNotFound is returned when editing a session that does not exist.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionOverlap is returned when an edit or a new entry would overlap
+	// a session from the same device.
+	ErrSessionOverlap = errors.New("session would overlap another session")
+	// ErrInvalidSessionEdit is returned for edits that make no sense, such as
+	// a session ending before it starts.
+	ErrInvalidSessionEdit = errors.New("invalid session edit")
+)
+
+// editedSession is a stored session as read for an edit. The title is kept
+// as stored, so it never has to be decrypted.
+type editedSession struct {
+	id, start, end        int64
+	app, title, titleHash string
+	deviceID, source      string
+	classID               sql.NullInt64
+	auto                  bool
+	note                  string
+}
+
+func loadEditedSession(tx *sql.Tx, id int64) (editedSession, error) {
+	var e editedSession
+	err := tx.QueryRow(`
+		SELECT id, start_time, end_time, app_name, window_title, title_hash, device_id, source, classification_id, auto_classified, note
+		FROM activity_sessions WHERE id = $1 AND trashed_at IS NULL
+	`, id).Scan(&e.id, &e.start, &e.end, &e.app, &e.title, &e.titleHash, &e.deviceID, &e.source, &e.classID, &e.auto, &e.note)
+	if err == sql.ErrNoRows {
+		return e, fmt.Errorf("%w: %d", ErrSessionNotFound, id)
+	}
+	return e, err
+}
+
+// checkOverlap fails with ErrSessionOverlap if [start, end) overlaps a
+// session of the same device and source, other than the ones being edited.
+// Different sources, such as a window and an editor watcher, overlap by
+// design; an empty source checks against all of them.
+func checkOverlap(tx *sql.Tx, deviceID, source string, start, end int64, editing []int64) error {
+	query := `
+		SELECT COUNT(*) FROM activity_sessions
+		WHERE device_id = $1 AND ($2 = '' OR source = $2) AND end_time > $3 AND start_time < $4
+			AND trashed_at IS NULL
+	`
+	if len(editing) > 0 {
+		query += fmt.Sprintf(" AND id NOT IN (%s)", intSliceToString(editing))
+	}
+	var overlapping int
+	err := tx.QueryRow(query, deviceID, source, start, end).Scan(&overlapping)
+	if err != nil {
+		return err
+	}
+	if overlapping > 0 {
+		return ErrSessionOverlap
+	}
+	return nil
+}
+
+// sessionByID reads a session back after an edit.
+func (s *DBStore) sessionByID(tx *sql.Tx, id int64) (models.ActivitySession, error) {
+	var session models.ActivitySession
+	var start, end int64
+	err := tx.QueryRow(`
+		SELECT id, app_name, window_title, start_time, end_time, duration_seconds, classification_id, source, note, url, cwd, project_id
+		FROM activity_sessions WHERE id = $1
+	`, id).Scan(&session.ID, &session.AppName, &session.WindowTitle, &start, &end, &session.Duration, &session.ClassificationID, &session.Source,
+		&session.Note, &session.URL, &session.Cwd, &session.ProjectID)
+	if err != nil {
+		return session, err
+	}
+	session.StartTime = time.Unix(start, 0)
+	session.EndTime = time.Unix(end, 0)
+	if session.WindowTitle, err = s.openTitle(session.WindowTitle); err != nil {
+		return session, err
+	}
+	tags, err := loadTags(tx, "SELECT session_id, tag FROM session_tags WHERE session_id = $1 ORDER BY tag", id)
+	session.Tags = tags[id]
+	return session, err
+}
+
+// AdjustSession moves the start and end of a session.
+func (s *DBStore) AdjustSession(req models.AdjustSessionRequest) (models.ActivitySession, error) {
+	var session models.ActivitySession
+	if req.StartTime >= req.EndTime {
+		return session, fmt.Errorf("%w: start must be before end", ErrInvalidSessionEdit)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return session, err
+	}
+	defer tx.Rollback()
+
+	cur, err := loadEditedSession(tx, req.SessionID)
+	if err != nil {
+		return session, err
+	}
+	if err := checkOverlap(tx, cur.deviceID, cur.source, req.StartTime, req.EndTime, []int64{cur.id}); err != nil {
+		return session, err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return session, err
+	}
+	_, err = tx.Exec(`
+		UPDATE activity_sessions SET start_time = $1, end_time = $2, duration_seconds = $3, seq = $4, updated_at = $5
+		WHERE id = $6
+	`, req.StartTime, req.EndTime, req.EndTime-req.StartTime, st.seq, st.at, cur.id)
+	if err != nil {
+		return session, err
+	}
+
+	if session, err = s.sessionByID(tx, cur.id); err != nil {
+		return session, err
+	}
+	return session, tx.Commit()
+}
+
+// SplitSession splits a session in two at a point inside it and returns both
+// parts in order. The second part is a new session.
+func (s *DBStore) SplitSession(req models.SplitSessionRequest) ([]models.ActivitySession, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cur, err := loadEditedSession(tx, req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if req.At <= cur.start || req.At >= cur.end {
+		return nil, fmt.Errorf("%w: split point must be inside the session", ErrInvalidSessionEdit)
+	}
+
+	uid, err := newUID()
+	if err != nil {
+		return nil, err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return nil, err
+	}
+	var secondID int64
+	err = tx.QueryRow(`
+		INSERT INTO activity_sessions (app_name, window_title, title_hash, start_time, end_time, duration_seconds,
+			classification_id, auto_classified, device_id, source, note, url, cwd, project_id, uid, seq, updated_at)
+		SELECT app_name, window_title, title_hash, $1, end_time, end_time - $1,
+			classification_id, auto_classified, device_id, source, note, url, cwd, project_id, $2, $3, $4
+		FROM activity_sessions WHERE id = $5
+		RETURNING id
+	`, req.At, uid, st.seq, st.at, cur.id).Scan(&secondID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("INSERT INTO session_tags (session_id, tag) SELECT $1, tag FROM session_tags WHERE session_id = $2", secondID, cur.id); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE activity_sessions SET end_time = $1, duration_seconds = $2, seq = $3, updated_at = $4 WHERE id = $5",
+		req.At, req.At-cur.start, st.seq, st.at, cur.id)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]models.ActivitySession, 0, 2)
+	for _, id := range []int64{cur.id, secondID} {
+		part, err := s.sessionByID(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, tx.Commit()
+}
+
+// MergeSessions replaces adjacent sessions of the same app, device and source
+// with a single new session spanning them, which takes the title of the
+// longest one. Sessions are adjacent when no more than sessionGap separates
+// them, as for the events of one session, so the merge never counts idle
+// time. Classified sessions must agree on their classification; unclassified
+// ones adopt it. The merged session keeps the tags and notes of all of them.
+// The parts move to the trash, and the merge is logged so undo can swap them
+// back.
+func (s *DBStore) MergeSessions(req models.MergeSessionsRequest) (models.ActivitySession, error) {
+	var session models.ActivitySession
+	ids := uniqueIDs(req.SessionIDs)
+	if len(ids) < 2 {
+		return session, fmt.Errorf("%w: at least two sessions are needed", ErrInvalidSessionEdit)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return session, err
+	}
+	defer tx.Rollback()
+
+	parts := make([]editedSession, 0, len(ids))
+	for _, id := range ids {
+		part, err := loadEditedSession(tx, id)
+		if err != nil {
+			return session, err
+		}
+		parts = append(parts, part)
+	}
+	slices.SortStableFunc(parts, func(a, b editedSession) int { return cmp.Compare(a.start, b.start) })
+
+	first, longest := parts[0], parts[0]
+	classID := first.classID
+	end := first.end
+	for _, part := range parts[1:] {
+		if part.app != first.app || part.deviceID != first.deviceID || part.source != first.source {
+			return session, fmt.Errorf("%w: only sessions of the same app, device and source can be merged", ErrInvalidSessionEdit)
+		}
+		if part.classID.Valid {
+			if classID.Valid && classID.Int64 != part.classID.Int64 {
+				return session, fmt.Errorf("%w: sessions have different classifications; reclassify them first", ErrInvalidSessionEdit)
+			}
+			classID = part.classID
+		}
+		if part.start-end > int64(sessionGap/time.Second) {
+			return session, fmt.Errorf("%w: only adjacent sessions can be merged, but %d seconds separate two of them",
+				ErrInvalidSessionEdit, part.start-end)
+		}
+		if part.end-part.start > longest.end-longest.start {
+			longest = part
+		}
+		end = max(end, part.end)
+	}
+	if err := checkOverlap(tx, first.deviceID, first.source, first.start, end, ids); err != nil {
+		return session, err
+	}
+
+	// The merge is automatic only if every classified part was.
+	auto := classID.Valid
+	var notes []string
+	for _, part := range parts {
+		if part.classID.Valid && !part.auto {
+			auto = false
+		}
+		if part.note != "" && !slices.Contains(notes, part.note) {
+			notes = append(notes, part.note)
+		}
+	}
+	var mergedClassID *int64
+	if classID.Valid {
+		mergedClassID = &classID.Int64
+	}
+
+	uid, err := newUID()
+	if err != nil {
+		return session, err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return session, err
+	}
+	var mergedID int64
+	err = tx.QueryRow(`
+		INSERT INTO activity_sessions (app_name, window_title, title_hash, start_time, end_time, duration_seconds,
+			classification_id, auto_classified, device_id, source, note, url, cwd, project_id, uid, seq, updated_at)
+		SELECT app_name, $1, $2, start_time, $3, $3 - start_time,
+			$4, $5, device_id, source, $6, url, cwd, project_id, $7, $8, $9
+		FROM activity_sessions WHERE id = $10
+		RETURNING id
+	`, longest.title, longest.titleHash, end, mergedClassID, auto, strings.Join(notes, "\n"), uid, st.seq, st.at, first.id).Scan(&mergedID)
+	if err != nil {
+		return session, err
+	}
+	_, err = tx.Exec(fmt.Sprintf(
+		"INSERT INTO session_tags (session_id, tag) SELECT DISTINCT $1, tag FROM session_tags WHERE session_id IN (%s)",
+		intSliceToString(ids)), mergedID)
+	if err != nil {
+		return session, err
+	}
+
+	// Undo reverts the merged session first, moving it to the trash before
+	// the parts come back.
+	audit := startAudit(tx, models.AuditMergeSessions, nil)
+	now := time.Now().Unix()
+	for _, part := range parts {
+		before := sessionSnapshot{AutoClassified: part.auto}
+		if part.classID.Valid {
+			before.ClassificationID = &part.classID.Int64
+		}
+		trashed := before
+		trashed.TrashedAt = &now
+		if err := audit.record(auditSession, part.id, before, trashed); err != nil {
+			return session, err
+		}
+	}
+	merged := sessionSnapshot{ClassificationID: mergedClassID, AutoClassified: auto}
+	unmerged := merged
+	unmerged.TrashedAt = &now
+	if err := audit.record(auditSession, mergedID, unmerged, merged); err != nil {
+		return session, err
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE activity_sessions SET trashed_at = $1, seq = $2, updated_at = $3 WHERE id IN (%s)",
+		intSliceToString(ids)), now, st.seq, st.at)
+	if err != nil {
+		return session, err
+	}
+
+	if session, err = s.sessionByID(tx, mergedID); err != nil {
+		return session, err
+	}
+	return session, tx.Commit()
+}
+
+// uniqueIDs returns ids without duplicates, in their original order.
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	unique := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// sessionIndex returns the position of a session outside the trash in
+// m.sessions. Callers must hold m.mu.
+func (m *MemoryStore) sessionIndex(id int64) (int, error) {
+	for i, session := range m.sessions {
+		if session.ID == id && session.TrashedAt == nil {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %d", ErrSessionNotFound, id)
+}
+
+// checkOverlap mirrors the SQL overlap check; a MemoryStore holds a single
+// device. Callers must hold m.mu.
+func (m *MemoryStore) checkOverlap(source string, start, end int64, editing []int64) error {
+	skip := make(map[int64]bool, len(editing))
+	for _, id := range editing {
+		skip[id] = true
+	}
+	for _, session := range m.sessions {
+		if !skip[session.ID] && session.TrashedAt == nil && (source == "" || session.Source == source) &&
+			session.EndTime.Unix() > start && session.StartTime.Unix() < end {
+			return ErrSessionOverlap
+		}
+	}
+	return nil
+}
+
+// AdjustSession moves the start and end of a session.
+func (m *MemoryStore) AdjustSession(req models.AdjustSessionRequest) (models.ActivitySession, error) {
+	if req.StartTime >= req.EndTime {
+		return models.ActivitySession{}, fmt.Errorf("%w: start must be before end", ErrInvalidSessionEdit)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, err := m.sessionIndex(req.SessionID)
+	if err != nil {
+		return models.ActivitySession{}, err
+	}
+	session := &m.sessions[i]
+	if err := m.checkOverlap(session.Source, req.StartTime, req.EndTime, []int64{session.ID}); err != nil {
+		return models.ActivitySession{}, err
+	}
+	session.StartTime = time.Unix(req.StartTime, 0)
+	session.EndTime = time.Unix(req.EndTime, 0)
+	session.Duration = req.EndTime - req.StartTime
+	return *session, nil
+}
+
+// SplitSession splits a session in two at a point inside it and returns both
+// parts in order.
+func (m *MemoryStore) SplitSession(req models.SplitSessionRequest) ([]models.ActivitySession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, err := m.sessionIndex(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	first := m.sessions[i]
+	if req.At <= first.StartTime.Unix() || req.At >= first.EndTime.Unix() {
+		return nil, fmt.Errorf("%w: split point must be inside the session", ErrInvalidSessionEdit)
+	}
+
+	second := first
+	m.nextSessionID++
+	second.ID = m.nextSessionID
+	second.StartTime = time.Unix(req.At, 0)
+	second.Duration = first.EndTime.Unix() - req.At
+	first.EndTime = second.StartTime
+	first.Duration = req.At - first.StartTime.Unix()
+
+	m.sessions[i] = first
+	m.sessions = append(m.sessions, second)
+	return []models.ActivitySession{first, second}, nil
+}
+
+// MergeSessions replaces adjacent sessions of the same app and source with a
+// single new session spanning them, moving the parts to the trash.
+func (m *MemoryStore) MergeSessions(req models.MergeSessionsRequest) (models.ActivitySession, error) {
+	ids := uniqueIDs(req.SessionIDs)
+	if len(ids) < 2 {
+		return models.ActivitySession{}, fmt.Errorf("%w: at least two sessions are needed", ErrInvalidSessionEdit)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parts := make([]models.ActivitySession, 0, len(ids))
+	for _, id := range ids {
+		i, err := m.sessionIndex(id)
+		if err != nil {
+			return models.ActivitySession{}, err
+		}
+		parts = append(parts, m.sessions[i])
+	}
+	slices.SortStableFunc(parts, func(a, b models.ActivitySession) int { return a.StartTime.Compare(b.StartTime) })
+
+	first, longest := parts[0], parts[0]
+	classID := first.ClassificationID
+	end := first.EndTime
+	for _, part := range parts[1:] {
+		if part.AppName != first.AppName || part.Source != first.Source {
+			return models.ActivitySession{}, fmt.Errorf("%w: only sessions of the same app and source can be merged", ErrInvalidSessionEdit)
+		}
+		if part.ClassificationID != nil {
+			if classID != nil && *classID != *part.ClassificationID {
+				return models.ActivitySession{}, fmt.Errorf("%w: sessions have different classifications; reclassify them first", ErrInvalidSessionEdit)
+			}
+			classID = part.ClassificationID
+		}
+		if gap := part.StartTime.Sub(end); gap > sessionGap {
+			return models.ActivitySession{}, fmt.Errorf("%w: only adjacent sessions can be merged, but %d seconds separate two of them",
+				ErrInvalidSessionEdit, int64(gap/time.Second))
+		}
+		if part.EndTime.Sub(part.StartTime) > longest.EndTime.Sub(longest.StartTime) {
+			longest = part
+		}
+		if part.EndTime.After(end) {
+			end = part.EndTime
+		}
+	}
+	if err := m.checkOverlap(first.Source, first.StartTime.Unix(), end.Unix(), ids); err != nil {
+		return models.ActivitySession{}, err
+	}
+
+	merged := first
+	merged.EndTime = end
+	merged.Duration = end.Unix() - first.StartTime.Unix()
+	merged.WindowTitle = longest.WindowTitle
+	merged.ClassificationID = classID
+	merged.AutoClassified = classID != nil
+	merged.Tags = nil
+	var notes []string
+	for _, part := range parts {
+		if part.ClassificationID != nil && !part.AutoClassified {
+			merged.AutoClassified = false
+		}
+		if part.Note != "" && !slices.Contains(notes, part.Note) {
+			notes = append(notes, part.Note)
+		}
+		merged.Tags = mergeTags(merged.Tags, part.Tags)
+	}
+	merged.Note = strings.Join(notes, "\n")
+	m.nextSessionID++
+	merged.ID = m.nextSessionID
+
+	audit := m.startAudit(models.AuditMergeSessions, nil)
+	now := time.Unix(time.Now().Unix(), 0)
+	for i := range m.sessions {
+		session := &m.sessions[i]
+		if !slices.Contains(ids, session.ID) {
+			continue
+		}
+		before := sessionSnapshot{ClassificationID: session.ClassificationID, AutoClassified: session.AutoClassified}
+		trashedAt := now
+		session.TrashedAt = &trashedAt
+		after := before
+		after.TrashedAt = unixPtr(session.TrashedAt)
+		audit.record(auditSession, session.ID, before, after)
+	}
+	snap := sessionSnapshot{ClassificationID: merged.ClassificationID, AutoClassified: merged.AutoClassified}
+	unmerged := snap
+	unmerged.TrashedAt = unixPtr(&now)
+	audit.record(auditSession, merged.ID, unmerged, snap)
+	m.sessions = append(m.sessions, merged)
+	return merged, nil
+}
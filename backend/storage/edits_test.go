@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// sessionsOf returns the sessions of an app outside the trash, oldest first.
+func sessionsOf(t *testing.T, s Store, app string) []models.ActivitySession {
+	t.Helper()
+	var sessions []models.ActivitySession
+	for _, session := range allSessions(t, s) {
+		if session.AppName == app {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+func TestAdjustSession(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		track(t, s, start, "Code", "main.go")
+		track(t, s, start.Add(10*time.Minute), "Slack", "general")
+		code, slack := sessionsOf(t, s, "Code")[0], sessionsOf(t, s, "Slack")[0]
+
+		tests := []struct {
+			name       string
+			id         int64
+			start, end time.Time
+			want       error
+		}{
+			{"end before start", code.ID, start.Add(time.Minute), start, ErrInvalidSessionEdit},
+			{"empty", code.ID, start, start, ErrInvalidSessionEdit},
+			{"missing session", 999, start, start.Add(time.Minute), ErrSessionNotFound},
+			{"overlaps another session", code.ID, start, slack.StartTime.Add(time.Second), ErrSessionOverlap},
+		}
+		for _, test := range tests {
+			_, err := s.AdjustSession(models.AdjustSessionRequest{SessionID: test.id, StartTime: test.start.Unix(), EndTime: test.end.Unix()})
+			if !errors.Is(err, test.want) {
+				t.Errorf("%s: %v, want %v", test.name, err, test.want)
+			}
+		}
+
+		// Ending exactly where the next session starts is not an overlap.
+		adjusted, err := s.AdjustSession(models.AdjustSessionRequest{
+			SessionID: code.ID, StartTime: start.Add(-time.Minute).Unix(), EndTime: slack.StartTime.Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if adjusted.Duration != 660 || !adjusted.StartTime.Equal(start.Add(-time.Minute)) || adjusted.WindowTitle != "main.go" {
+			t.Errorf("adjusted session = %+v", adjusted)
+		}
+		if got := sessionsOf(t, s, "Code")[0]; got.Duration != 660 || !got.EndTime.Equal(slack.StartTime) {
+			t.Errorf("stored session = %+v", got)
+		}
+	})
+}
+
+func TestSplitSession(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		track(t, s, start, "Code", "main.go")
+		err := s.ApplyClassification(models.ClassificationRequest{AppName: "Code", WindowTitle: "main.go", UserDefinedName: "Go"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		code := sessionsOf(t, s, "Code")[0]
+		if _, err := s.TagSessions(models.TagSessionsRequest{SessionIDs: []int64{code.ID}, Add: []string{"client"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, at := range []time.Time{start, start.Add(time.Minute), start.Add(-time.Minute)} {
+			if _, err := s.SplitSession(models.SplitSessionRequest{SessionID: code.ID, At: at.Unix()}); !errors.Is(err, ErrInvalidSessionEdit) {
+				t.Errorf("splitting at %s: %v, want ErrInvalidSessionEdit", at.Sub(start), err)
+			}
+		}
+		if _, err := s.SplitSession(models.SplitSessionRequest{SessionID: 999, At: start.Add(time.Second).Unix()}); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("splitting a missing session: %v, want ErrSessionNotFound", err)
+		}
+
+		parts, err := s.SplitSession(models.SplitSessionRequest{SessionID: code.ID, At: start.Add(20 * time.Second).Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parts) != 2 || parts[0].ID != code.ID || parts[1].ID == code.ID {
+			t.Fatalf("parts = %+v", parts)
+		}
+		if parts[0].Duration != 20 || parts[1].Duration != 40 || !parts[0].EndTime.Equal(parts[1].StartTime) {
+			t.Errorf("parts last %d and %d seconds, meeting at %s and %s", parts[0].Duration, parts[1].Duration, parts[0].EndTime, parts[1].StartTime)
+		}
+		goID := classificationID(t, s, "Go")
+		for _, part := range allSessions(t, s) {
+			if part.WindowTitle != "main.go" || part.ClassificationID == nil || *part.ClassificationID != goID || !slices.Equal(part.Tags, []string{"client"}) {
+				t.Errorf("part %+v lost the session's title, classification or tags", part)
+			}
+		}
+	})
+}
+
+func TestMergeSessions(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		track(t, s, start, "Code", "main.go")
+		track(t, s, start.Add(80*time.Second), "Code", "store.go")
+		track(t, s, start.Add(10*time.Minute), "Code", "api.go")
+		track(t, s, start.Add(10*time.Minute+65*time.Second), "Slack", "general")
+		track(t, s, start.Add(10*time.Minute+80*time.Second), "Code", "lib.go")
+		code := sessionsOf(t, s, "Code")
+		slack := sessionsOf(t, s, "Slack")[0]
+
+		// Make the second session the longest, so the merge takes its title,
+		// and leave Slack ten seconds between api.go and lib.go.
+		adjust := []models.AdjustSessionRequest{
+			{SessionID: code[1].ID, StartTime: code[1].StartTime.Unix(), EndTime: code[1].StartTime.Add(2 * time.Minute).Unix()},
+			{SessionID: slack.ID, StartTime: slack.StartTime.Unix(), EndTime: slack.StartTime.Add(10 * time.Second).Unix()},
+		}
+		for _, req := range adjust {
+			if _, err := s.AdjustSession(req); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := s.ReclassifySession(models.ReclassifyRequest{SessionID: code[1].ID, UserDefinedName: "Go"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.ReclassifySession(models.ReclassifyRequest{SessionID: code[2].ID, UserDefinedName: "Review"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.TagSessions(models.TagSessionsRequest{SessionIDs: []int64{code[0].ID}, Add: []string{"a"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.TagSessions(models.TagSessionsRequest{SessionIDs: []int64{code[1].ID}, Add: []string{"a", "b"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, note := range []string{"first", "second"} {
+			if _, err := s.SetSessionNote(models.SessionNoteRequest{SessionID: code[i].ID, Note: note}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			name string
+			ids  []int64
+			want error
+		}{
+			{"one session", []int64{code[0].ID}, ErrInvalidSessionEdit},
+			{"the same session twice", []int64{code[0].ID, code[0].ID}, ErrInvalidSessionEdit},
+			{"missing session", []int64{code[0].ID, 999}, ErrSessionNotFound},
+			{"different apps", []int64{code[2].ID, slack.ID}, ErrInvalidSessionEdit},
+			{"different classifications", []int64{code[1].ID, code[2].ID}, ErrInvalidSessionEdit},
+			{"not adjacent", []int64{code[0].ID, code[3].ID}, ErrInvalidSessionEdit},
+			{"spanning another session", []int64{code[2].ID, code[3].ID}, ErrSessionOverlap},
+		}
+		for _, test := range tests {
+			if _, err := s.MergeSessions(models.MergeSessionsRequest{SessionIDs: test.ids}); !errors.Is(err, test.want) {
+				t.Errorf("%s: %v, want %v", test.name, err, test.want)
+			}
+		}
+
+		// The unclassified session adopts the other's classification, and the
+		// merge spans the twenty seconds between them.
+		merged, err := s.MergeSessions(models.MergeSessionsRequest{SessionIDs: []int64{code[1].ID, code[0].ID}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		goID := classificationID(t, s, "Go")
+		if merged.ID == code[0].ID || merged.ID == code[1].ID || merged.Duration != 200 || merged.WindowTitle != "store.go" ||
+			merged.ClassificationID == nil || *merged.ClassificationID != goID || !slices.Equal(merged.Tags, []string{"a", "b"}) ||
+			merged.Note != "first\nsecond" {
+			t.Errorf("merged session = %+v", merged)
+		}
+		titles := func() []string {
+			var got []string
+			for _, session := range sessionsOf(t, s, "Code") {
+				got = append(got, session.WindowTitle)
+			}
+			return got
+		}
+		if got, want := titles(), []string{"store.go", "api.go", "lib.go"}; !slices.Equal(got, want) {
+			t.Errorf("sessions = %q, want %q", got, want)
+		}
+		trash, err := s.GetTrash()
+		if err != nil || len(trash) != 2 {
+			t.Errorf("trash = %+v, %v; want the two parts", trash, err)
+		}
+
+		// Undo brings the parts back and trashes the merged session.
+		if ops := operations(t, s); ops[len(ops)-1] != models.AuditMergeSessions {
+			t.Fatalf("log = %q, want it to end with the merge", ops)
+		}
+		if ops := undo(t, s, 1); len(ops) != 1 || ops[0].Skipped != 0 {
+			t.Errorf("undo = %+v", ops)
+		}
+		if got, want := titles(), []string{"main.go", "store.go", "api.go", "lib.go"}; !slices.Equal(got, want) {
+			t.Errorf("sessions after undo = %q, want %q", got, want)
+		}
+		if trash, err = s.GetTrash(); err != nil || len(trash) != 1 || trash[0].ID != merged.ID {
+			t.Errorf("trash after undo = %+v, %v; want the merged session", trash, err)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// unixRange converts an export range to Unix bounds. Zero times leave that
+// side of the range open.
+func unixRange(start, end time.Time) (int64, int64) {
+	from, to := int64(math.MinInt64), int64(math.MaxInt64)
+	if !start.IsZero() {
+		from = start.Unix()
+	}
+	if !end.IsZero() {
+		to = end.Unix()
+	}
+	return from, to
+}
+
+// EachSession calls fn for every session matching the filter, oldest first,
+// without loading them all into memory. Only the tags of the range are
+// loaded up front.
+func (s *DBStore) EachSession(filter SessionFilter, fn func(models.ActivitySession) error) error {
+	from, to := unixRange(filter.Start, filter.End)
+	tags, err := loadTags(s.db, `
+		SELECT t.session_id, t.tag
+		FROM session_tags t
+		JOIN activity_sessions s ON s.id = t.session_id
+		WHERE s.start_time >= $1 AND s.start_time < $2
+		ORDER BY t.tag
+	`, from, to)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT s.id, s.app_name, s.window_title, s.start_time, s.end_time, s.duration_seconds, s.classification_id, s.source, s.note,
+			s.url, s.cwd, s.project_id
+		FROM activity_sessions s
+		WHERE s.start_time >= $1 AND s.start_time < $2 AND s.trashed_at IS NULL
+			AND ($3 = '' OR EXISTS (SELECT 1 FROM session_tags t WHERE t.session_id = s.id AND t.tag = $3))
+		ORDER BY s.start_time ASC, s.id ASC
+	`, from, to, filter.Tag)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session models.ActivitySession
+		var startTimeUnix, endTimeUnix int64
+		if err := rows.Scan(&session.ID, &session.AppName, &session.WindowTitle, &startTimeUnix, &endTimeUnix, &session.Duration, &session.ClassificationID, &session.Source, &session.Note,
+			&session.URL, &session.Cwd, &session.ProjectID); err != nil {
+			return err
+		}
+		if session.WindowTitle, err = s.openTitle(session.WindowTitle); err != nil {
+			return err
+		}
+		session.StartTime = time.Unix(startTimeUnix, 0)
+		session.EndTime = time.Unix(endTimeUnix, 0)
+		session.Tags = tags[session.ID]
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListClassifications returns every classification ordered by ID.
+func (s *DBStore) ListClassifications() ([]models.Classification, error) {
+	rows, err := s.db.Query("SELECT id, user_defined_name, is_helpful, goal_context, parent_id FROM classifications ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	classifications := make([]models.Classification, 0)
+	for rows.Next() {
+		var c models.Classification
+		if err := rows.Scan(&c.ID, &c.UserDefinedName, &c.IsHelpful, &c.GoalContext, &c.ParentID); err != nil {
+			return nil, err
+		}
+		classifications = append(classifications, c)
+	}
+	return classifications, rows.Err()
+}
+
+// ListRules returns every classification rule ordered by ID.
+func (s *DBStore) ListRules() ([]models.ClassificationRule, error) {
+	rows, err := s.db.Query(`
+		SELECT id, app_name, app_match, window_title_contains, title_match, match_condition, classification_id, priority, note
+		FROM classification_rules ORDER BY id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]models.ClassificationRule, 0)
+	for rows.Next() {
+		var r models.ClassificationRule
+		var condition string
+		if err := rows.Scan(&r.ID, &r.AppName, &r.AppMatch, &r.WindowTitleContains, &r.TitleMatch, &condition, &r.ClassificationID, &r.Priority, &r.Note); err != nil {
+			return nil, err
+		}
+		if r.Condition, err = decodeCondition(condition); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	tags, err := loadTags(s.db, "SELECT rule_id, tag FROM rule_tags ORDER BY tag")
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rules[i].Tags = tags[rules[i].ID]
+	}
+	return rules, nil
+}
+
+// EachSession calls fn for every session matching the filter, oldest first.
+func (m *MemoryStore) EachSession(filter SessionFilter, fn func(models.ActivitySession) error) error {
+	from, to := unixRange(filter.Start, filter.End)
+
+	m.mu.Lock()
+	var sessions []models.ActivitySession
+	for _, session := range m.sessions {
+		if unix := session.StartTime.Unix(); unix >= from && unix < to && session.TrashedAt == nil && (filter.Tag == "" || hasTag(session, filter.Tag)) {
+			sessions = append(sessions, session)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if !sessions[i].StartTime.Equal(sessions[j].StartTime) {
+			return sessions[i].StartTime.Before(sessions[j].StartTime)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	for _, session := range sessions {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListClassifications returns every classification ordered by ID.
+func (m *MemoryStore) ListClassifications() ([]models.Classification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append(make([]models.Classification, 0, len(m.classifications)), m.classifications...), nil
+}
+
+// ListRules returns every classification rule ordered by ID.
+func (m *MemoryStore) ListRules() ([]models.ClassificationRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append(make([]models.ClassificationRule, 0, len(m.rules)), m.rules...), nil
+}
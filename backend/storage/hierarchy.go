@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// checkParent fails with ErrInvalidClassification unless parent can become
+// the parent of classification id: it must exist and must not be id or one
+// of its descendants. parents maps every classification to its parent; id is
+// 0 for a classification that does not exist yet.
+func checkParent(parents map[int64]*int64, id int64, parent *int64) error {
+	if parent == nil {
+		return nil
+	}
+	if _, ok := parents[*parent]; !ok {
+		return fmt.Errorf("%w: parent classification %d does not exist", ErrInvalidClassification, *parent)
+	}
+	if id != 0 && isDescendant(parents, *parent, id) {
+		return fmt.Errorf("%w: a classification cannot be nested under itself", ErrInvalidClassification)
+	}
+	return nil
+}
+
+// isDescendant reports whether id is ancestor itself or nested somewhere
+// under it.
+func isDescendant(parents map[int64]*int64, id, ancestor int64) bool {
+	seen := make(map[int64]bool)
+	for !seen[id] {
+		if id == ancestor {
+			return true
+		}
+		seen[id] = true
+		parent := parents[id]
+		if parent == nil {
+			return false
+		}
+		id = *parent
+	}
+	return false
+}
+
+// loadParents maps every classification ID to its parent.
+func loadParents(tx *sql.Tx) (map[int64]*int64, error) {
+	rows, err := tx.Query("SELECT id, parent_id FROM classifications")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parents := make(map[int64]*int64)
+	for rows.Next() {
+		var id int64
+		var parent *int64
+		if err := rows.Scan(&id, &parent); err != nil {
+			return nil, err
+		}
+		parents[id] = parent
+	}
+	return parents, rows.Err()
+}
+
+// parentsOf maps every classification ID to its parent.
+func parentsOf(classifications []models.Classification) map[int64]*int64 {
+	parents := make(map[int64]*int64, len(classifications))
+	for _, c := range classifications {
+		parents[c.ID] = c.ParentID
+	}
+	return parents
+}
+
+// classificationTreeNode is a classification with the time classified as it
+// and as everything nested under it.
+type classificationTreeNode struct {
+	models.Classification
+	own, total int64
+	// used reports whether any session in the subtree was counted.
+	used     bool
+	children []*classificationTreeNode
+}
+
+// buildClassificationTree arranges classifications into trees and rolls the
+// durations in own up to every ancestor. Edits made concurrently on two
+// devices can leave a cycle behind after syncing; the classifications in one
+// are shown at the top level.
+func buildClassificationTree(classifications []models.Classification, own map[int64]int64) []*classificationTreeNode {
+	parents := parentsOf(classifications)
+	nodes := make(map[int64]*classificationTreeNode, len(classifications))
+	for _, c := range classifications {
+		seconds, used := own[c.ID]
+		nodes[c.ID] = &classificationTreeNode{Classification: c, own: seconds, used: used}
+	}
+
+	var roots []*classificationTreeNode
+	for _, c := range classifications {
+		var parent *classificationTreeNode
+		if c.ParentID != nil && !isDescendant(parents, *c.ParentID, c.ID) {
+			parent = nodes[*c.ParentID]
+		}
+		if parent != nil {
+			parent.children = append(parent.children, nodes[c.ID])
+		} else {
+			roots = append(roots, nodes[c.ID])
+		}
+	}
+
+	var rollUp func(node *classificationTreeNode)
+	rollUp = func(node *classificationTreeNode) {
+		node.total = node.own
+		for _, child := range node.children {
+			rollUp(child)
+			node.total += child.total
+			node.used = node.used || child.used
+		}
+	}
+	for _, root := range roots {
+		rollUp(root)
+	}
+	return roots
+}
+
+// ClassificationTree nests classifications under their parents, sorted by
+// name at every level.
+func ClassificationTree(classifications []models.Classification) []models.ClassificationNode {
+	var convert func(nodes []*classificationTreeNode) []models.ClassificationNode
+	convert = func(nodes []*classificationTreeNode) []models.ClassificationNode {
+		tree := make([]models.ClassificationNode, 0, len(nodes))
+		for _, node := range nodes {
+			tree = append(tree, models.ClassificationNode{Classification: node.Classification, Children: convert(node.children)})
+		}
+		sort.Slice(tree, func(i, j int) bool { return tree[i].UserDefinedName < tree[j].UserDefinedName })
+		if len(tree) == 0 {
+			return nil
+		}
+		return tree
+	}
+	tree := convert(buildClassificationTree(classifications, nil))
+	if tree == nil {
+		return make([]models.ClassificationNode, 0)
+	}
+	return tree
+}
+
+// summaryTree converts rolled-up durations into summary items, leaving out
+// classifications without any time. Items are sorted by total time, longest
+// first.
+func summaryTree(nodes []*classificationTreeNode) []TodaySummaryItem {
+	var items []TodaySummaryItem
+	for _, node := range nodes {
+		if node.total <= 0 {
+			continue
+		}
+		items = append(items, TodaySummaryItem{
+			ID:              node.ID,
+			UserDefinedName: node.UserDefinedName,
+			TotalDuration:   node.total,
+			OwnDuration:     node.own,
+			Children:        summaryTree(node.children),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].TotalDuration != items[j].TotalDuration {
+			return items[i].TotalDuration > items[j].TotalDuration
+		}
+		return items[i].UserDefinedName < items[j].UserDefinedName
+	})
+	return items
+}
+
+// skillTree converts rolled-up durations into skill progress, leaving out
+// skills never practised. Skills are sorted by name.
+func skillTree(nodes []*classificationTreeNode) []models.SkillProgress {
+	var skills []models.SkillProgress
+	for _, node := range nodes {
+		if !node.used {
+			continue
+		}
+		// XP conversion: 1 minute = 1 XP
+		totalXP := node.total / 60
+		level, currentXP, xpNext := computeLevel(totalXP)
+		skills = append(skills, models.SkillProgress{
+			ID:              node.ID,
+			UserDefinedName: node.UserDefinedName,
+			Level:           level,
+			CurrentXP:       currentXP,
+			XPForNextLevel:  xpNext,
+			TotalXP:         totalXP,
+			OwnXP:           node.own / 60,
+			Children:        skillTree(node.children),
+		})
+	}
+	sort.Slice(skills, func(i, j int) bool { return skills[i].UserDefinedName < skills[j].UserDefinedName })
+	return skills
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func ptr(id int64) *int64 { return &id }
+
+func TestCheckParent(t *testing.T) {
+	// 1 <- 2 <- 3, and 4 on its own.
+	parents := map[int64]*int64{1: nil, 2: ptr(1), 3: ptr(2), 4: nil}
+	tests := []struct {
+		name   string
+		id     int64
+		parent *int64
+		// reason is part of the error message, or empty if the parent is valid.
+		reason string
+	}{
+		{"top level", 3, nil, ""},
+		{"new classification", 0, ptr(3), ""},
+		{"move to another branch", 2, ptr(4), ""},
+		{"missing parent", 2, ptr(9), "does not exist"},
+		{"itself", 2, ptr(2), "under itself"},
+		{"own child", 1, ptr(2), "under itself"},
+		{"own grandchild", 1, ptr(3), "under itself"},
+	}
+	for _, test := range tests {
+		err := checkParent(parents, test.id, test.parent)
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("%s: %v, want no error", test.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidClassification) || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%s: %v, want ErrInvalidClassification saying %q", test.name, err, test.reason)
+		}
+	}
+}
+
+func TestClassificationTree(t *testing.T) {
+	classifications := []models.Classification{
+		{ID: 1, UserDefinedName: "Work"},
+		{ID: 2, UserDefinedName: "Go", ParentID: ptr(1)},
+		{ID: 3, UserDefinedName: "Admin", ParentID: ptr(1)},
+		{ID: 4, UserDefinedName: "Generics", ParentID: ptr(2)},
+		// A cycle left behind by concurrent edits on two devices.
+		{ID: 5, UserDefinedName: "Ping", ParentID: ptr(6)},
+		{ID: 6, UserDefinedName: "Pong", ParentID: ptr(5)},
+	}
+	node := func(i int, children ...models.ClassificationNode) models.ClassificationNode {
+		return models.ClassificationNode{Classification: classifications[i], Children: children}
+	}
+	want := []models.ClassificationNode{
+		node(4),
+		node(5),
+		node(0, node(2), node(1, node(3))),
+	}
+	if got := ClassificationTree(classifications); !reflect.DeepEqual(got, want) {
+		t.Errorf("ClassificationTree =\n%+v\nwant\n%+v", got, want)
+	}
+	if got := ClassificationTree(nil); got == nil || len(got) != 0 {
+		t.Errorf("ClassificationTree(nil) = %#v, want an empty slice", got)
+	}
+}
+
+func TestClassificationHierarchy(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		create := func(name string, parent *int64) models.Classification {
+			t.Helper()
+			c, err := s.CreateClassification(models.CreateClassificationRequest{UserDefinedName: name, ParentID: parent})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return c
+		}
+		work := create("Work", nil)
+		golang := create("Go", &work.ID)
+		generics := create("Generics", &golang.ID)
+		create("Rust", nil)
+
+		if _, err := s.CreateClassification(models.CreateClassificationRequest{UserDefinedName: "Orphan", ParentID: ptr(99)}); !errors.Is(err, ErrInvalidClassification) {
+			t.Errorf("creating under a missing parent: %v, want ErrInvalidClassification", err)
+		}
+		if _, err := s.UpdateClassification(models.UpdateClassificationRequest{ID: work.ID, UserDefinedName: "Work", ParentID: &generics.ID}); !errors.Is(err, ErrInvalidClassification) {
+			t.Errorf("nesting a classification under its grandchild: %v, want ErrInvalidClassification", err)
+		}
+		if _, err := s.MergeClassifications(models.MergeClassificationsRequest{SourceID: work.ID, TargetID: generics.ID}); !errors.Is(err, ErrInvalidClassification) {
+			t.Errorf("merging into a descendant: %v, want ErrInvalidClassification", err)
+		}
+
+		// A minute each of Generics, Go and Work today.
+		start := time.Now().UTC().Truncate(24 * time.Hour).Add(time.Minute)
+		for i, name := range []string{"Generics", "Go", "Work"} {
+			track(t, s, start.Add(time.Duration(i)*10*time.Minute), "App", name)
+			if err := s.ApplyClassification(models.ClassificationRequest{AppName: "App", WindowTitle: name, UserDefinedName: name}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		summary, err := s.GetTodaySummary(ReportFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantSummary := []TodaySummaryItem{{
+			ID: work.ID, UserDefinedName: "Work", TotalDuration: 180, OwnDuration: 60,
+			Children: []TodaySummaryItem{{
+				ID: golang.ID, UserDefinedName: "Go", TotalDuration: 120, OwnDuration: 60,
+				Children: []TodaySummaryItem{{ID: generics.ID, UserDefinedName: "Generics", TotalDuration: 60, OwnDuration: 60}},
+			}},
+		}}
+		if !reflect.DeepEqual(summary, wantSummary) {
+			t.Errorf("summary =\n%+v\nwant\n%+v", summary, wantSummary)
+		}
+
+		skills, err := s.GetSkillProgress(ReportFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(skills) != 1 || skills[0].UserDefinedName != "Work" || skills[0].TotalXP != 3 || skills[0].OwnXP != 1 ||
+			len(skills[0].Children) != 1 || skills[0].Children[0].TotalXP != 2 || len(skills[0].Children[0].Children) != 1 {
+			t.Errorf("skills = %+v, want Rust left out and XP rolled up to Work", skills)
+		}
+
+		// Deleting Go moves Generics up to Work, which keeps all the time.
+		if err := s.DeleteClassification(golang.ID); err != nil {
+			t.Fatal(err)
+		}
+		all, err := s.ListClassifications()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree := ClassificationTree(all)
+		if len(tree) != 2 || tree[1].UserDefinedName != "Work" || len(tree[1].Children) != 1 || tree[1].Children[0].ID != generics.ID {
+			t.Errorf("tree after deleting Go = %+v", tree)
+		}
+		if summary, err = s.GetTodaySummary(ReportFilter{}); err != nil || len(summary) != 1 || summary[0].TotalDuration != 120 {
+			t.Errorf("summary after deleting Go = %+v, %v", summary, err)
+		}
+	})
+}
+
+func TestComputeLevel(t *testing.T) {
+	tests := []struct {
+		xp              int64
+		level           int
+		current, toNext int64
+	}{
+		{0, 1, 0, 100},
+		{99, 1, 99, 100},
+		{100, 2, 0, 282},
+		{382, 3, 0, 519},
+		{400, 3, 18, 519},
+	}
+	for _, test := range tests {
+		level, current, toNext := computeLevel(test.xp)
+		if level != test.level || current != test.current || toNext != test.toNext {
+			t.Errorf("computeLevel(%d) = %d, %d, %d; want %d, %d, %d", test.xp, level, current, toNext, test.level, test.current, test.toNext)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// historySession is a session that applying a rule to history reclassifies.
+type historySession struct {
+	id     int64
+	before sessionSnapshot
+}
+
+// applyRuleToHistory classifies the existing sessions that rule ruleID is now
+// the first to match, adding its tags and note as saveSession would, and logs
+// each change. Unclassified sessions are considered, and sessions another
+// rule classified when overrideAutomatic is set; trashed sessions and sessions
+// classified by hand never are. It returns the number of sessions changed and
+// their total duration.
+func (s *DBStore) applyRuleToHistory(tx *sql.Tx, ruleID int64, overrideAutomatic bool, st stamp) (int, int64, error) {
+	matchers, err := loadRuleMatchers(tx)
+	if err != nil {
+		return 0, 0, err
+	}
+	rows, err := tx.Query(`
+		SELECT id, app_name, window_title, start_time, end_time, duration_seconds, classification_id, auto_classified, url, cwd
+		FROM activity_sessions
+		WHERE trashed_at IS NULL AND (classification_id IS NULL OR ($1 AND auto_classified))
+	`, overrideAutomatic)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var rule models.ClassificationRule
+	var changed []historySession
+	var seconds int64
+	for rows.Next() {
+		var session models.ActivitySession
+		var start, end int64
+		if err := rows.Scan(&session.ID, &session.AppName, &session.WindowTitle, &start, &end, &session.Duration,
+			&session.ClassificationID, &session.AutoClassified, &session.URL, &session.Cwd); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if session.WindowTitle, err = s.openTitle(session.WindowTitle); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		session.StartTime = time.Unix(start, 0)
+		session.EndTime = time.Unix(end, 0)
+
+		match, ok := firstMatch(matchers, &session)
+		if !ok || match.ID != ruleID || (session.ClassificationID != nil && *session.ClassificationID == match.ClassificationID) {
+			continue
+		}
+		rule = match
+		changed = append(changed, historySession{
+			id: session.ID, before: sessionSnapshot{ClassificationID: session.ClassificationID, AutoClassified: session.AutoClassified},
+		})
+		seconds += session.Duration
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	audit := startAudit(tx, models.AuditApplyRule, nil)
+	tags, err := loadTags(tx, "SELECT rule_id, tag FROM rule_tags WHERE rule_id = $1 ORDER BY tag", ruleID)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, c := range changed {
+		classID := rule.ClassificationID
+		if err := audit.record(auditSession, c.id, c.before, sessionSnapshot{ClassificationID: &classID, AutoClassified: true}); err != nil {
+			return 0, 0, err
+		}
+		_, err := tx.Exec(`
+			UPDATE activity_sessions
+			SET classification_id = $1, auto_classified = TRUE, note = CASE WHEN note = '' THEN $2 ELSE note END, seq = $3, updated_at = $4
+			WHERE id = $5
+		`, classID, rule.Note, st.seq, st.at, c.id)
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := addSessionTags(tx, c.id, tags[ruleID]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(changed), seconds, nil
+}
+
+// applyRuleToHistory mirrors DBStore.applyRuleToHistory for rule, matching
+// sessions against rules, which must include it. Sessions are only changed
+// when write is set. Callers must hold m.mu.
+func (m *MemoryStore) applyRuleToHistory(rules []models.ClassificationRule, rule models.ClassificationRule, overrideAutomatic, write bool) (int, int64) {
+	var audit *memoryAudit
+	if write {
+		audit = m.startAudit(models.AuditApplyRule, nil)
+	}
+	var n int
+	var seconds int64
+	for i := range m.sessions {
+		session := &m.sessions[i]
+		if session.TrashedAt != nil || (session.ClassificationID != nil && !(overrideAutomatic && session.AutoClassified)) {
+			continue
+		}
+		match, ok := matchRules(rules, session)
+		if !ok || match.ID != rule.ID || (session.ClassificationID != nil && *session.ClassificationID == rule.ClassificationID) {
+			continue
+		}
+		n++
+		seconds += session.Duration
+		if !write {
+			continue
+		}
+		classID := rule.ClassificationID
+		audit.record(auditSession, session.ID, sessionSnapshot{ClassificationID: session.ClassificationID, AutoClassified: session.AutoClassified},
+			sessionSnapshot{ClassificationID: &classID, AutoClassified: true})
+		session.ClassificationID = &classID
+		session.AutoClassified = true
+		applyRuleTags(session, rule.Tags, rule.Note)
+	}
+	return n, seconds
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// ImportOptions control how imported sessions are stored.
+type ImportOptions struct {
+	// ApplyRules classifies imported sessions with the classification rules,
+	// as the processor does for tracked ones.
+	ApplyRules bool
+	// DryRun reports what would be imported without writing anything.
+	DryRun bool
+}
+
+// ImportResult reports the outcome of an import.
+type ImportResult struct {
+	Imported   int
+	Trimmed    int // sessions shortened because part of them was already recorded
+	Duplicates int // sessions skipped because their whole range was already recorded
+	Classified int
+}
+
+// interval is a span of time in Unix seconds, [start, end).
+type interval struct {
+	start, end int64
+}
+
+// mergeIntervals sorts intervals and joins the overlapping ones.
+func mergeIntervals(intervals []interval) []interval {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].start < intervals[j].start })
+	var merged []interval
+	for _, iv := range intervals {
+		if n := len(merged); n > 0 && iv.start <= merged[n-1].end {
+			if iv.end > merged[n-1].end {
+				merged[n-1].end = iv.end
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// sessionSpan returns the time range covered by sessions.
+func sessionSpan(sessions []models.ActivitySession) (time.Time, time.Time) {
+	var start, end time.Time
+	for i, s := range sessions {
+		if i == 0 || s.StartTime.Before(start) {
+			start = s.StartTime
+		}
+		if i == 0 || s.EndTime.After(end) {
+			end = s.EndTime
+		}
+	}
+	return start, end
+}
+
+// dedupeImported drops the parts of incoming sessions that overlap time
+// already recorded, or an earlier session of the same import, so imported
+// history never counts the same time twice. occupied must be sorted and
+// disjoint. Pieces shorter than minSessionSeconds are discarded.
+func dedupeImported(incoming []models.ActivitySession, occupied []interval, result *ImportResult) []models.ActivitySession {
+	sorted := append([]models.ActivitySession(nil), incoming...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].StartTime.Before(sorted[j].StartTime) })
+
+	var kept []models.ActivitySession
+	covered := int64(math.MinInt64) // end of time already taken by this import
+	next := 0                       // first occupied interval that may still overlap
+	for _, session := range sorted {
+		start, end := session.StartTime.Unix(), session.EndTime.Unix()
+		if start < covered {
+			start = covered
+		}
+		if end > covered {
+			covered = end
+		}
+		for next < len(occupied) && occupied[next].end <= start {
+			next++
+		}
+
+		var pieces []interval
+		cursor := start
+		for i := next; cursor < end; i++ {
+			if i == len(occupied) || occupied[i].start >= end {
+				pieces = append(pieces, interval{cursor, end})
+				break
+			}
+			if occupied[i].start > cursor {
+				pieces = append(pieces, interval{cursor, occupied[i].start})
+			}
+			if occupied[i].end > cursor {
+				cursor = occupied[i].end
+			}
+		}
+
+		trimmed := len(pieces) != 1 || pieces[0] != interval{session.StartTime.Unix(), session.EndTime.Unix()}
+		before := len(kept)
+		for _, p := range pieces {
+			if p.end-p.start < minSessionSeconds {
+				continue
+			}
+			piece := session
+			piece.StartTime = time.Unix(p.start, 0)
+			piece.EndTime = time.Unix(p.end, 0)
+			piece.Duration = p.end - p.start
+			kept = append(kept, piece)
+		}
+		switch {
+		case len(kept) == before:
+			result.Duplicates++
+		case trimmed:
+			result.Trimmed++
+		}
+	}
+	return kept
+}
+
+// ImportSessions stores sessions from another tool. Time already covered by
+// existing sessions is skipped, so re-running an import is harmless.
+func (s *DBStore) ImportSessions(sessions []models.ActivitySession, opts ImportOptions) (ImportResult, error) {
+	var result ImportResult
+	if len(sessions) == 0 {
+		return result, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	start, end := sessionSpan(sessions)
+	rows, err := tx.Query("SELECT start_time, end_time FROM activity_sessions WHERE end_time > $1 AND start_time < $2 AND trashed_at IS NULL",
+		start.Unix(), end.Unix())
+	if err != nil {
+		return result, err
+	}
+	var occupied []interval
+	for rows.Next() {
+		var iv interval
+		if err := rows.Scan(&iv.start, &iv.end); err != nil {
+			rows.Close()
+			return result, err
+		}
+		occupied = append(occupied, iv)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	var rules *sessionRules
+	if opts.ApplyRules {
+		if rules, err = loadSessionRules(tx); err != nil {
+			return result, err
+		}
+	}
+	// A dry run writes inside the transaction too, so rule matches are
+	// counted exactly, and then rolls back.
+	for _, session := range dedupeImported(sessions, mergeIntervals(occupied), &result) {
+		if err := s.saveSession(tx, &session, rules); err != nil {
+			return result, err
+		}
+		result.Imported++
+		if session.ClassificationID != nil {
+			result.Classified++
+		}
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// ImportSessions stores sessions from another tool, skipping time already
+// covered by existing sessions.
+func (m *MemoryStore) ImportSessions(sessions []models.ActivitySession, opts ImportOptions) (ImportResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result ImportResult
+	start, end := sessionSpan(sessions)
+	var occupied []interval
+	for _, session := range m.sessions {
+		if session.TrashedAt == nil && session.EndTime.Unix() > start.Unix() && session.StartTime.Unix() < end.Unix() {
+			occupied = append(occupied, interval{session.StartTime.Unix(), session.EndTime.Unix()})
+		}
+	}
+
+	for _, session := range dedupeImported(sessions, mergeIntervals(occupied), &result) {
+		if opts.ApplyRules {
+			if rule, ok := m.matchRule(&session); ok {
+				classID := rule.ClassificationID
+				session.ClassificationID = &classID
+				session.AutoClassified = true
+				applyRuleTags(&session, rule.Tags, rule.Note)
+			}
+			if session.ProjectID == nil {
+				session.ProjectID = matchProjectRule(m.projectRules, session)
+			}
+		}
+		result.Imported++
+		if session.ClassificationID != nil {
+			result.Classified++
+		}
+		if opts.DryRun {
+			continue
+		}
+		m.nextSessionID++
+		session.ID = m.nextSessionID
+		m.sessions = append(m.sessions, session)
+	}
+	return result, nil
+}
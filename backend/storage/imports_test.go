@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func imported(app string, start, end time.Time) models.ActivitySession {
+	return models.ActivitySession{AppName: app, WindowTitle: app, StartTime: start, EndTime: end,
+		Duration: int64(end.Sub(start).Seconds()), Source: models.SourceActivityWatch}
+}
+
+func TestDedupeImported(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0) }
+	occupied := []interval{{100, 200}, {300, 400}}
+	incoming := []models.ActivitySession{
+		imported("split", at(250), at(450)),
+		imported("new", at(0), at(50)),
+		imported("covered", at(310), at(390)),
+		imported("tail", at(150), at(250)),
+		imported("overlaps import", at(440), at(460)),
+		imported("sliver", at(195), at(202)),
+	}
+
+	var result ImportResult
+	kept := dedupeImported(incoming, occupied, &result)
+
+	want := []interval{{0, 50}, {200, 250}, {250, 300}, {400, 450}, {450, 460}}
+	if len(kept) != len(want) {
+		t.Fatalf("kept %+v, want %v", kept, want)
+	}
+	for i, w := range want {
+		if got := (interval{kept[i].StartTime.Unix(), kept[i].EndTime.Unix()}); got != w || kept[i].Duration != w.end-w.start {
+			t.Errorf("piece %d = %v (%ds), want %v", i, got, kept[i].Duration, w)
+		}
+	}
+	// Pieces too short to be a session are dropped with what covers them.
+	if result.Trimmed != 3 || result.Duplicates != 2 {
+		t.Errorf("result = %+v, want 3 trimmed and 2 duplicates", result)
+	}
+}
+
+func TestImportSessions(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		t0 := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+		track(t, s, t0, "Code", "main.go")
+		if _, err := s.CreateClassificationRule(models.CreateClassificationRuleRequest{AppName: "Slack", UserDefinedName: "Chat"}); err != nil {
+			t.Fatal(err)
+		}
+
+		incoming := []models.ActivitySession{
+			imported("Slack", t0.Add(-30*time.Second), t0.Add(30*time.Second)),
+			imported("Slack", t0.Add(2*time.Minute), t0.Add(3*time.Minute)),
+			imported("Code", t0.Add(10*time.Second), t0.Add(50*time.Second)),
+		}
+
+		result, err := s.ImportSessions(incoming, ImportOptions{ApplyRules: true, DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (ImportResult{Imported: 2, Trimmed: 1, Duplicates: 1, Classified: 2}); result != want {
+			t.Errorf("dry run = %+v, want %+v", result, want)
+		}
+		if n := len(allSessions(t, s)); n != 1 {
+			t.Fatalf("a dry run stored sessions: %d", n)
+		}
+
+		result, err = s.ImportSessions(incoming, ImportOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (ImportResult{Imported: 2, Trimmed: 1, Duplicates: 1}); result != want {
+			t.Errorf("import = %+v, want %+v", result, want)
+		}
+		sessions := allSessions(t, s)
+		if len(sessions) != 3 {
+			t.Fatalf("%d sessions after importing, want 3", len(sessions))
+		}
+		first := sessions[0]
+		if first.Source != models.SourceActivityWatch || !first.EndTime.Equal(t0) || first.Duration != 30 || first.ClassificationID != nil {
+			t.Errorf("trimmed import = %+v, want 30s unclassified from activitywatch", first)
+		}
+
+		// Importing the same history again adds nothing.
+		result, err = s.ImportSessions(incoming, ImportOptions{ApplyRules: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Imported != 0 || result.Duplicates != 3 {
+			t.Errorf("re-import = %+v, want only duplicates", result)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// ErrInvalidManualEntry is returned for manual entries without a
+// classification or a valid time span.
+var ErrInvalidManualEntry = errors.New("invalid manual entry")
+
+// defaultManualApp labels manual entries that do not name an activity.
+const defaultManualApp = "Manual"
+
+// manualSpan resolves the start and end of a manual entry. A duration
+// without a start ends the entry at now, and no entry may end after it.
+func manualSpan(req models.ManualEntryRequest, now time.Time) (start, end int64, err error) {
+	start, end = req.StartTime, req.EndTime
+	if end == 0 && req.DurationSeconds > 0 {
+		if start == 0 {
+			end = now.Unix()
+			start = end - req.DurationSeconds
+		} else {
+			end = start + req.DurationSeconds
+		}
+	}
+	if req.UserDefinedName == "" {
+		return 0, 0, fmt.Errorf("%w: a classification is required", ErrInvalidManualEntry)
+	}
+	if start <= 0 || start >= end {
+		return 0, 0, fmt.Errorf("%w: give a start and end, or a positive duration", ErrInvalidManualEntry)
+	}
+	if end > now.Unix() {
+		return 0, 0, fmt.Errorf("%w: the entry ends in the future", ErrInvalidManualEntry)
+	}
+	return start, end, nil
+}
+
+// CreateManualSession records a classified session for an activity the
+// tracker cannot see. Entries overlapping this device's sessions are
+// rejected with ErrSessionOverlap unless forced.
+func (s *DBStore) CreateManualSession(req models.ManualEntryRequest, now time.Time) (models.ActivitySession, error) {
+	var session models.ActivitySession
+	start, end, err := manualSpan(req, now)
+	if err != nil {
+		return session, err
+	}
+	if req.AppName == "" {
+		req.AppName = defaultManualApp
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return session, err
+	}
+	defer tx.Rollback()
+
+	if !req.Force {
+		if err := checkOverlap(tx, s.deviceID, "", start, end, nil); err != nil {
+			return session, err
+		}
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return session, err
+	}
+	classID, err := findOrCreateClassification(tx, req.UserDefinedName, req.IsHelpful, req.GoalContext, st, nil)
+	if err != nil {
+		return session, err
+	}
+	uid, err := newUID()
+	if err != nil {
+		return session, err
+	}
+	title, hash, err := s.sealTitle("")
+	if err != nil {
+		return session, err
+	}
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO activity_sessions (app_name, window_title, title_hash, start_time, end_time, duration_seconds,
+			classification_id, device_id, source, note, uid, seq, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id
+	`, req.AppName, title, hash, start, end, end-start, classID, s.deviceID, models.SourceManual, req.Note, uid, st.seq, st.at).Scan(&id)
+	if err != nil {
+		return session, err
+	}
+	if err := addSessionTags(tx, id, normalizeTags(req.Tags)); err != nil {
+		return session, err
+	}
+
+	if session, err = s.sessionByID(tx, id); err != nil {
+		return session, err
+	}
+	return session, tx.Commit()
+}
+
+// CreateManualSession records a classified session for an activity the
+// tracker cannot see.
+func (m *MemoryStore) CreateManualSession(req models.ManualEntryRequest, now time.Time) (models.ActivitySession, error) {
+	start, end, err := manualSpan(req, now)
+	if err != nil {
+		return models.ActivitySession{}, err
+	}
+	if req.AppName == "" {
+		req.AppName = defaultManualApp
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !req.Force {
+		if err := m.checkOverlap("", start, end, nil); err != nil {
+			return models.ActivitySession{}, err
+		}
+	}
+	classID, err := m.findOrCreateClassification(req.UserDefinedName, req.IsHelpful, req.GoalContext)
+	if err != nil {
+		return models.ActivitySession{}, err
+	}
+	m.nextSessionID++
+	session := models.ActivitySession{
+		ID:               m.nextSessionID,
+		AppName:          req.AppName,
+		StartTime:        time.Unix(start, 0),
+		EndTime:          time.Unix(end, 0),
+		Duration:         end - start,
+		ClassificationID: &classID,
+		Source:           models.SourceManual,
+		Note:             req.Note,
+		Tags:             normalizeTags(req.Tags),
+	}
+	m.sessions = append(m.sessions, session)
+	return session, nil
+}
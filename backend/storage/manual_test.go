@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func TestManualSpan(t *testing.T) {
+	now := time.Unix(10_000, 0)
+	tests := []struct {
+		name       string
+		req        models.ManualEntryRequest
+		start, end int64
+		err        bool
+	}{
+		{"start and end", models.ManualEntryRequest{StartTime: 1000, EndTime: 4600}, 1000, 4600, false},
+		{"start and duration", models.ManualEntryRequest{StartTime: 1000, DurationSeconds: 600}, 1000, 1600, false},
+		{"duration just ended", models.ManualEntryRequest{DurationSeconds: 600}, 9400, 10_000, false},
+		{"end wins over duration", models.ManualEntryRequest{StartTime: 1000, EndTime: 2000, DurationSeconds: 600}, 1000, 2000, false},
+		{"nothing", models.ManualEntryRequest{}, 0, 0, true},
+		{"end before start", models.ManualEntryRequest{StartTime: 2000, EndTime: 1000}, 0, 0, true},
+		{"empty span", models.ManualEntryRequest{StartTime: 1000, EndTime: 1000}, 0, 0, true},
+		{"negative duration", models.ManualEntryRequest{StartTime: 1000, DurationSeconds: -60}, 0, 0, true},
+		{"end without start", models.ManualEntryRequest{EndTime: 1000}, 0, 0, true},
+		{"ends now", models.ManualEntryRequest{StartTime: 9000, EndTime: 10_000}, 9000, 10_000, false},
+		{"ends in the future", models.ManualEntryRequest{StartTime: 9000, EndTime: 10_001}, 0, 0, true},
+		{"duration into the future", models.ManualEntryRequest{StartTime: 9500, DurationSeconds: 600}, 0, 0, true},
+	}
+	for _, test := range tests {
+		test.req.UserDefinedName = "Reading"
+		start, end, err := manualSpan(test.req, now)
+		if test.err {
+			if !errors.Is(err, ErrInvalidManualEntry) {
+				t.Errorf("%s: %v, want ErrInvalidManualEntry", test.name, err)
+			}
+			continue
+		}
+		if err != nil || start != test.start || end != test.end {
+			t.Errorf("%s: %d-%d, %v; want %d-%d", test.name, start, end, err, test.start, test.end)
+		}
+	}
+
+	_, _, err := manualSpan(models.ManualEntryRequest{DurationSeconds: 60}, now)
+	if !errors.Is(err, ErrInvalidManualEntry) {
+		t.Errorf("entry without a classification: %v, want ErrInvalidManualEntry", err)
+	}
+}
+
+func TestCreateManualSession(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		now := time.Now().Truncate(time.Second)
+		track(t, s, now.Add(-2*time.Hour), "Code", "main.go")
+
+		req := models.ManualEntryRequest{
+			DurationSeconds: 1800, UserDefinedName: "Reading", IsHelpful: boolPtr(true), GoalContext: stringPtr("Learn"),
+			Note: "Chapter 3", Tags: []string{" books ", "paper", "books"},
+		}
+		session, err := s.CreateManualSession(req, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.AppName != "Manual" || session.Source != models.SourceManual || session.Duration != 1800 ||
+			!session.EndTime.Equal(now) || session.Note != "Chapter 3" || !slices.Equal(session.Tags, []string{"books", "paper"}) {
+			t.Errorf("manual session = %+v", session)
+		}
+		readingID := classificationID(t, s, "Reading")
+		if session.ClassificationID == nil || *session.ClassificationID != readingID {
+			t.Errorf("manual session classified as %v, want Reading (%d)", session.ClassificationID, readingID)
+		}
+		if stored := allSessions(t, s); len(stored) != 2 || stored[1].ID != session.ID || stored[1].Note != "Chapter 3" {
+			t.Errorf("stored sessions = %+v", stored)
+		}
+
+		// Overlapping recorded time, from the tracker or another entry, needs
+		// Force.
+		overlapping := models.ManualEntryRequest{
+			AppName: "Phone call", StartTime: now.Add(-2 * time.Hour).Unix(), DurationSeconds: 600, UserDefinedName: "Calls",
+		}
+		if _, err := s.CreateManualSession(overlapping, now); !errors.Is(err, ErrSessionOverlap) {
+			t.Errorf("overlapping a tracked session: %v, want ErrSessionOverlap", err)
+		}
+		if _, err := s.CreateManualSession(models.ManualEntryRequest{DurationSeconds: 60, UserDefinedName: "Reading"}, now); !errors.Is(err, ErrSessionOverlap) {
+			t.Errorf("overlapping a manual entry: %v, want ErrSessionOverlap", err)
+		}
+		overlapping.Force = true
+		forced, err := s.CreateManualSession(overlapping, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if forced.AppName != "Phone call" || forced.Duration != 600 {
+			t.Errorf("forced session = %+v", forced)
+		}
+
+	})
+}
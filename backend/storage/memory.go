@@ -0,0 +1,539 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// MemoryStore is a Store kept entirely in memory. It mirrors the behaviour of
+// DBStore and is meant for tests and ephemeral runs; nothing is persisted.
+type MemoryStore struct {
+	mu              sync.Mutex
+	rawEvents       []models.RawEvent
+	sessions        []models.ActivitySession
+	classifications []models.Classification
+	rules           []models.ClassificationRule
+	projects        []models.Project
+	projectRules    []models.ProjectRule
+	awayPeriods     []models.AwayPeriod
+	buckets         map[string]models.Bucket
+	auditLog        []memoryAuditEntry
+
+	nextSessionID        int64
+	nextClassificationID int64
+	nextRuleID           int64
+	nextProjectID        int64
+	nextProjectRuleID    int64
+	nextAwayID           int64
+	nextAuditID          int64
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Close is a no-op for the in-memory store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// InsertRawEvent adds a new event to the store.
+func (m *MemoryStore) InsertRawEvent(event models.RawEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rawEvents = append(m.rawEvents, event)
+	return nil
+}
+
+// InsertRawEvents adds a batch of events to the store.
+func (m *MemoryStore) InsertRawEvents(events []models.RawEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rawEvents = append(m.rawEvents, events...)
+	return nil
+}
+
+// ProcessRawEvents aggregates all pending raw events into sessions.
+func (m *MemoryStore) ProcessRawEvents() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := m.rawEvents
+	m.rawEvents = nil
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+
+	for _, session := range sessionizeBySource(events) {
+		if rule, ok := m.matchRule(&session); ok {
+			classID := rule.ClassificationID
+			session.ClassificationID = &classID
+			session.AutoClassified = true
+			applyRuleTags(&session, rule.Tags, rule.Note)
+		}
+		session.ProjectID = matchProjectRule(m.projectRules, session)
+		m.nextSessionID++
+		session.ID = m.nextSessionID
+		m.sessions = append(m.sessions, session)
+	}
+	return nil
+}
+
+// matchRule mirrors the DBStore rule lookup. Callers must hold m.mu.
+func (m *MemoryStore) matchRule(session *models.ActivitySession) (models.ClassificationRule, bool) {
+	return matchRules(m.rules, session)
+}
+
+// matchRules returns the highest priority, then newest, of rules that
+// matches a session.
+func matchRules(rules []models.ClassificationRule, session *models.ActivitySession) (models.ClassificationRule, bool) {
+	var best models.ClassificationRule
+	found := false
+	for _, rule := range rules {
+		matcher, err := newRuleMatcher(rule)
+		if err != nil || !matcher.matches(session) {
+			continue
+		}
+		if !found || rule.Priority > best.Priority || (rule.Priority == best.Priority && rule.ID > best.ID) {
+			best = rule
+			found = true
+		}
+	}
+	return best, found
+}
+
+// GetUnclassifiedSessions returns sessions without a classification, newest first.
+func (m *MemoryStore) GetUnclassifiedSessions() ([]models.ActivitySession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := make([]models.ActivitySession, 0)
+	for _, session := range m.sessions {
+		if session.ClassificationID == nil && session.TrashedAt == nil {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].StartTime.After(sessions[j].StartTime) })
+	return sessions, nil
+}
+
+// classificationByID looks up a classification. Callers must hold m.mu.
+func (m *MemoryStore) classificationByID(id int64) (models.Classification, bool) {
+	for _, c := range m.classifications {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return models.Classification{}, false
+}
+
+// ApplyClassification assigns a classification to all matching unclassified sessions.
+func (m *MemoryStore) ApplyClassification(req models.ClassificationRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	audit, classID, err := m.startClassifying(models.AuditClassify, req.UserDefinedName, req.IsHelpful, req.GoalContext)
+	if err != nil {
+		return err
+	}
+	for i := range m.sessions {
+		session := &m.sessions[i]
+		if session.ClassificationID == nil && session.TrashedAt == nil && session.AppName == req.AppName && session.WindowTitle == req.WindowTitle {
+			id := classID
+			session.ClassificationID = &id
+			session.AutoClassified = false
+			audit.record(auditSession, session.ID, sessionSnapshot{}, sessionSnapshot{ClassificationID: &id})
+		}
+	}
+	return nil
+}
+
+// ApplyClassificationBatch assigns a classification to a batch of unclassified sessions.
+func (m *MemoryStore) ApplyClassificationBatch(req models.BatchClassificationRequest) error {
+	if len(req.Sessions) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[models.SessionIdentifier]bool, len(req.Sessions))
+	for _, ident := range req.Sessions {
+		wanted[ident] = true
+	}
+
+	audit, classID, err := m.startClassifying(models.AuditClassifyBatch, req.UserDefinedName, req.IsHelpful, req.GoalContext)
+	if err != nil {
+		return err
+	}
+	for i := range m.sessions {
+		session := &m.sessions[i]
+		ident := models.SessionIdentifier{AppName: session.AppName, WindowTitle: session.WindowTitle}
+		if session.ClassificationID == nil && session.TrashedAt == nil && wanted[ident] {
+			id := classID
+			session.ClassificationID = &id
+			session.AutoClassified = false
+			audit.record(auditSession, session.ID, sessionSnapshot{}, sessionSnapshot{ClassificationID: &id})
+		}
+	}
+	return nil
+}
+
+// ReclassifySession updates the classification of an existing session.
+func (m *MemoryStore) ReclassifySession(req models.ReclassifyRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	audit, classID, err := m.startClassifying(models.AuditReclassify, req.UserDefinedName, req.IsHelpful, req.GoalContext)
+	if err != nil {
+		return err
+	}
+	for i := range m.sessions {
+		if m.sessions[i].ID == req.SessionID && m.sessions[i].TrashedAt == nil {
+			audit.record(auditSession, req.SessionID,
+				sessionSnapshot{ClassificationID: m.sessions[i].ClassificationID, AutoClassified: m.sessions[i].AutoClassified},
+				sessionSnapshot{ClassificationID: &classID})
+			m.sessions[i].ClassificationID = &classID
+			m.sessions[i].AutoClassified = false
+		}
+	}
+	return nil
+}
+
+// DeleteSession moves a session to the trash.
+func (m *MemoryStore) DeleteSession(sessionID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.moveToTrash(models.AuditDeleteSession, func(session models.ActivitySession) bool { return session.ID == sessionID })
+	return nil
+}
+
+// GetRecentClassifiedSessions returns the 50 most recently classified sessions.
+func (m *MemoryStore) GetRecentClassifiedSessions() ([]models.RecentActivityInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	activities := make([]models.RecentActivityInfo, 0)
+	for _, session := range m.sessions {
+		if session.ClassificationID == nil || session.TrashedAt != nil {
+			continue
+		}
+		c, ok := m.classificationByID(*session.ClassificationID)
+		if !ok {
+			continue
+		}
+		activities = append(activities, models.RecentActivityInfo{
+			SessionID:       session.ID,
+			AppName:         session.AppName,
+			WindowTitle:     session.WindowTitle,
+			UserDefinedName: c.UserDefinedName,
+			StartTime:       session.StartTime.Unix(),
+			IsAuto:          session.AutoClassified,
+		})
+	}
+	sort.SliceStable(activities, func(i, j int) bool { return activities[i].StartTime > activities[j].StartTime })
+	if len(activities) > 50 {
+		activities = activities[:50]
+	}
+	return activities, nil
+}
+
+// CreateClassificationRule creates a new rule for automatic classification,
+// applying it to existing sessions if asked.
+func (m *MemoryStore) CreateClassificationRule(req models.CreateClassificationRuleRequest) (models.RuleApplyResult, error) {
+	matcher, err := newRuleMatcher(models.ClassificationRule{
+		AppName: req.AppName, AppMatch: req.AppMatch, WindowTitleContains: req.WindowTitleContains, TitleMatch: req.TitleMatch,
+		Condition: req.Condition,
+	})
+	if err != nil {
+		return models.RuleApplyResult{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	classID, err := m.ruleClassification(req)
+	if err != nil {
+		return models.RuleApplyResult{}, err
+	}
+	rule := models.ClassificationRule{
+		ID:                  m.nextRuleID + 1,
+		AppName:             req.AppName,
+		AppMatch:            matcher.rule.AppMatch,
+		WindowTitleContains: req.WindowTitleContains,
+		TitleMatch:          matcher.rule.TitleMatch,
+		Condition:           req.Condition,
+		ClassificationID:    classID,
+		Tags:                normalizeTags(req.Tags),
+		Note:                strings.TrimSpace(req.Note),
+	}
+	rules := append(slices.Clip(m.rules), rule)
+	result := m.finishRuleSave(rules, rule, req.ApplyRuleOptions)
+	if req.DryRun {
+		result.RuleID = 0
+		return result, nil
+	}
+	m.nextRuleID++
+	m.rules = rules
+	return result, nil
+}
+
+// UpdateClassificationRule replaces the patterns, classification, tags and
+// note of a rule, applying it to existing sessions if asked.
+func (m *MemoryStore) UpdateClassificationRule(req models.UpdateClassificationRuleRequest) (models.RuleApplyResult, error) {
+	matcher, err := newRuleMatcher(models.ClassificationRule{
+		AppName: req.AppName, AppMatch: req.AppMatch, WindowTitleContains: req.WindowTitleContains, TitleMatch: req.TitleMatch,
+		Condition: req.Condition,
+	})
+	if err != nil {
+		return models.RuleApplyResult{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.rules, func(rule models.ClassificationRule) bool { return rule.ID == req.ID })
+	if i < 0 {
+		return models.RuleApplyResult{}, fmt.Errorf("%w: %d", ErrRuleNotFound, req.ID)
+	}
+	classID, err := m.ruleClassification(req.CreateClassificationRuleRequest)
+	if err != nil {
+		return models.RuleApplyResult{}, err
+	}
+	rule := m.rules[i]
+	rule.AppName, rule.AppMatch = req.AppName, matcher.rule.AppMatch
+	rule.WindowTitleContains, rule.TitleMatch = req.WindowTitleContains, matcher.rule.TitleMatch
+	rule.Condition = req.Condition
+	rule.ClassificationID = classID
+	rule.Tags = normalizeTags(req.Tags)
+	rule.Note = strings.TrimSpace(req.Note)
+	rules := slices.Clone(m.rules)
+	rules[i] = rule
+	result := m.finishRuleSave(rules, rule, req.ApplyRuleOptions)
+	if !req.DryRun {
+		m.rules = rules
+	}
+	return result, nil
+}
+
+// ruleClassification finds or creates the classification a rule assigns. On
+// a dry run a classification it had to create is removed again, and the ID
+// returned only serves to tell it apart from existing ones. Callers must hold
+// m.mu.
+func (m *MemoryStore) ruleClassification(req models.CreateClassificationRuleRequest) (int64, error) {
+	last := m.nextClassificationID
+	classID, err := m.findOrCreateClassification(req.UserDefinedName, req.IsHelpful, req.GoalContext)
+	if err == nil && req.DryRun && m.nextClassificationID != last {
+		m.classifications = m.classifications[:len(m.classifications)-1]
+		m.nextClassificationID = last
+	}
+	return classID, err
+}
+
+// finishRuleSave mirrors DBStore.finishRuleSave for rule, to be saved as
+// part of rules. Callers must hold m.mu.
+func (m *MemoryStore) finishRuleSave(rules []models.ClassificationRule, rule models.ClassificationRule, opts models.ApplyRuleOptions) models.RuleApplyResult {
+	result := models.RuleApplyResult{RuleID: rule.ID, DryRun: opts.DryRun}
+	if opts.ApplyToHistory {
+		result.Sessions, result.Seconds = m.applyRuleToHistory(rules, rule, opts.OverrideAutomatic, !opts.DryRun)
+	}
+	return result
+}
+
+// GetClassificationRules returns all rules with their classification names, newest first.
+func (m *MemoryStore) GetClassificationRules() ([]models.RuleInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rules := make([]models.RuleInfo, 0, len(m.rules))
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		rule := m.rules[i]
+		c, ok := m.classificationByID(rule.ClassificationID)
+		if !ok {
+			continue
+		}
+		rules = append(rules, models.RuleInfo{
+			ID:                  rule.ID,
+			AppName:             rule.AppName,
+			AppMatch:            rule.AppMatch,
+			WindowTitleContains: rule.WindowTitleContains,
+			TitleMatch:          rule.TitleMatch,
+			Condition:           rule.Condition,
+			UserDefinedName:     c.UserDefinedName,
+			Tags:                rule.Tags,
+			Note:                rule.Note,
+		})
+	}
+	return rules, nil
+}
+
+// DeleteClassificationRule removes a rule by its ID.
+func (m *MemoryStore) DeleteClassificationRule(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.rules[:0]
+	for _, rule := range m.rules {
+		if rule.ID != id {
+			kept = append(kept, rule)
+			continue
+		}
+		m.startAudit(models.AuditDeleteRule, nil).record(auditRule, rule.ID, rule, nil)
+	}
+	m.rules = kept
+	return nil
+}
+
+// durationByClassification sums session durations per classification ID for
+// sessions starting at or after since and carrying tag, if set. Callers must
+// hold m.mu.
+func (m *MemoryStore) durationByClassification(since time.Time, tag string) map[int64]int64 {
+	totals := make(map[int64]int64)
+	for _, session := range m.sessions {
+		if session.ClassificationID == nil || session.TrashedAt != nil || session.StartTime.Before(since) || !hasTag(session, tag) {
+			continue
+		}
+		totals[*session.ClassificationID] += session.Duration
+	}
+	return totals
+}
+
+// GetTodaySummary returns classified time per classification for the current
+// UTC day, rolled up into parents. A MemoryStore only holds one device's
+// history, so only the filter's tag has an effect.
+func (m *MemoryStore) GetTodaySummary(filter ReportFilter) ([]TodaySummaryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	startOfDay := time.Now().UTC().Truncate(24 * time.Hour)
+	summary := summaryTree(buildClassificationTree(m.classifications, m.durationByClassification(startOfDay, filter.Tag)))
+	if summary == nil {
+		return make([]TodaySummaryItem, 0), nil
+	}
+	return summary, nil
+}
+
+// GetExistingClassifications returns all classifications ordered by name.
+func (m *MemoryStore) GetExistingClassifications() ([]models.ExistingClassification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	classifications := make([]models.ExistingClassification, 0, len(m.classifications))
+	for _, c := range m.classifications {
+		classifications = append(classifications, models.ExistingClassification{
+			ID:              c.ID,
+			UserDefinedName: c.UserDefinedName,
+			GoalContext:     c.GoalContext,
+			IsHelpful:       c.IsHelpful,
+			ParentID:        c.ParentID,
+		})
+	}
+	sort.Slice(classifications, func(i, j int) bool {
+		return classifications[i].UserDefinedName < classifications[j].UserDefinedName
+	})
+	return classifications, nil
+}
+
+// GetSkillProgress returns the XP/level progress per classification, nested
+// like the classifications.
+func (m *MemoryStore) GetSkillProgress(filter ReportFilter) ([]models.SkillProgress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	skills := skillTree(buildClassificationTree(m.classifications, m.durationByClassification(time.Time{}, filter.Tag)))
+	if skills == nil {
+		return make([]models.SkillProgress, 0), nil
+	}
+	return skills, nil
+}
+
+// ApplyRetention enforces the policy relative to now.
+func (m *MemoryStore) ApplyRetention(policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result RetentionResult
+	olderThan := func(t time.Time, d time.Duration) bool { return d > 0 && t.Before(now.Add(-d)) }
+
+	kept := m.sessions[:0]
+	for _, session := range m.sessions {
+		if session.TrashedAt != nil && olderThan(*session.TrashedAt, policy.EmptyTrashAfter) {
+			result.TrashEmptied++
+			continue
+		}
+		if session.ClassificationID == nil && olderThan(session.StartTime, policy.DeleteUnclassifiedAfter) {
+			result.UnclassifiedDeleted++
+			continue
+		}
+		if session.ClassificationID != nil && olderThan(session.StartTime, policy.DeleteClassifiedAfter) {
+			result.ClassifiedDeleted++
+			continue
+		}
+		if (session.WindowTitle != "" || session.URL != "" || session.Cwd != "") && olderThan(session.StartTime, policy.DropTitlesAfter) {
+			session.WindowTitle, session.URL, session.Cwd = "", "", ""
+			result.TitlesDropped++
+		}
+		kept = append(kept, session)
+	}
+	m.sessions = kept
+
+	for i := range m.rawEvents {
+		if olderThan(m.rawEvents[i].Timestamp, policy.DropTitlesAfter) {
+			m.rawEvents[i].WindowTitle, m.rawEvents[i].URL, m.rawEvents[i].Cwd = "", "", ""
+		}
+	}
+	return result, nil
+}
+
+// PurgeHistory deletes every session and pending raw event matching the request.
+func (m *MemoryStore) PurgeHistory(req models.PurgeRequest) (models.PurgeResult, error) {
+	result := models.PurgeResult{DryRun: req.DryRun}
+	if req.AppName == "" && req.TitlePattern == "" {
+		return result, ErrEmptyPurgeFilter
+	}
+
+	var title *regexp.Regexp
+	if req.TitlePattern != "" {
+		title = likePattern(req.TitlePattern)
+	}
+	matches := func(appName, windowTitle string) bool {
+		return (req.AppName == "" || appName == req.AppName) && (title == nil || title.MatchString(windowTitle))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keptSessions := make([]models.ActivitySession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		if matches(session.AppName, session.WindowTitle) {
+			result.Sessions++
+			result.TotalDuration += session.Duration
+			continue
+		}
+		keptSessions = append(keptSessions, session)
+	}
+
+	keptEvents := make([]models.RawEvent, 0, len(m.rawEvents))
+	for _, event := range m.rawEvents {
+		if matches(event.AppName, event.WindowTitle) {
+			result.RawEvents++
+			continue
+		}
+		keptEvents = append(keptEvents, event)
+	}
+
+	if !req.DryRun {
+		m.sessions = keptSessions
+		m.rawEvents = keptEvents
+	}
+	return result, nil
+}
@@ -0,0 +1,608 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer build
+// than the one currently running.
+var ErrSchemaTooNew = errors.New("database schema is newer than this build supports")
+
+// migrationLockID is the PostgreSQL advisory lock key held while migrating.
+const migrationLockID = 0x706f73 // "pos"
+
+// migration is a single versioned schema change. Migrations are applied in
+// ascending version order and must never be edited once released; add a new
+// one instead.
+type migration struct {
+	version int
+	name    string
+	sql     string
+	// postgres overrides sql on PostgreSQL when the DDL differs.
+	postgres string
+}
+
+// statement returns the DDL for the given dialect.
+func (m migration) statement(d dialect) string {
+	if d == dialectPostgres && m.postgres != "" {
+		return m.postgres
+	}
+	return m.sql
+}
+
+// migrations is the ordered list of schema changes shared by every DBStore.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		// IF NOT EXISTS keeps this safe for databases created before
+		// migrations were tracked.
+		sql: `
+        CREATE TABLE IF NOT EXISTS raw_events (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            timestamp INTEGER NOT NULL,
+            app_name TEXT NOT NULL,
+            window_title TEXT NOT NULL
+        );
+        CREATE TABLE IF NOT EXISTS activity_sessions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            app_name TEXT NOT NULL,
+            window_title TEXT NOT NULL,
+            start_time INTEGER NOT NULL,
+            end_time INTEGER NOT NULL,
+            duration_seconds INTEGER NOT NULL,
+            classification_id INTEGER,
+			FOREIGN KEY(classification_id) REFERENCES classifications(id)
+        );
+        CREATE TABLE IF NOT EXISTS classifications (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_defined_name TEXT NOT NULL UNIQUE,
+            is_helpful BOOLEAN NOT NULL,
+            goal_context TEXT NOT NULL
+        );
+        CREATE TABLE IF NOT EXISTS classification_rules (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            app_name TEXT NOT NULL,
+            window_title_contains TEXT NOT NULL,
+            classification_id INTEGER NOT NULL,
+            priority INTEGER NOT NULL DEFAULT 0,
+            FOREIGN KEY(classification_id) REFERENCES classifications(id) ON DELETE CASCADE
+        );
+    `,
+		postgres: `
+        CREATE TABLE IF NOT EXISTS classifications (
+            id BIGSERIAL PRIMARY KEY,
+            user_defined_name TEXT NOT NULL UNIQUE,
+            is_helpful BOOLEAN NOT NULL,
+            goal_context TEXT NOT NULL
+        );
+        CREATE TABLE IF NOT EXISTS raw_events (
+            id BIGSERIAL PRIMARY KEY,
+            timestamp BIGINT NOT NULL,
+            app_name TEXT NOT NULL,
+            window_title TEXT NOT NULL
+        );
+        CREATE TABLE IF NOT EXISTS activity_sessions (
+            id BIGSERIAL PRIMARY KEY,
+            app_name TEXT NOT NULL,
+            window_title TEXT NOT NULL,
+            start_time BIGINT NOT NULL,
+            end_time BIGINT NOT NULL,
+            duration_seconds BIGINT NOT NULL,
+            classification_id BIGINT REFERENCES classifications(id)
+        );
+        CREATE TABLE IF NOT EXISTS classification_rules (
+            id BIGSERIAL PRIMARY KEY,
+            app_name TEXT NOT NULL,
+            window_title_contains TEXT NOT NULL,
+            classification_id BIGINT NOT NULL REFERENCES classifications(id) ON DELETE CASCADE,
+            priority INTEGER NOT NULL DEFAULT 0
+        );
+    `,
+	},
+	{
+		version: 2,
+		name:    "device id on events and sessions",
+		sql: `
+        ALTER TABLE raw_events ADD COLUMN device_id TEXT NOT NULL DEFAULT '';
+        ALTER TABLE activity_sessions ADD COLUMN device_id TEXT NOT NULL DEFAULT '';
+    `,
+	},
+	{
+		version: 3,
+		name:    "indexes for session and rule lookups",
+		sql: `
+        CREATE INDEX IF NOT EXISTS idx_raw_events_device_timestamp ON raw_events(device_id, timestamp);
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_start_time ON activity_sessions(start_time);
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_app_title ON activity_sessions(app_name, window_title);
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_classification ON activity_sessions(classification_id);
+        CREATE INDEX IF NOT EXISTS idx_classification_rules_app ON classification_rules(app_name);
+    `,
+	},
+	{
+		version: 4,
+		name:    "title lookup hashes and settings",
+		// title_hash lets sessions be matched by title when titles are
+		// encrypted; it is backfilled in Go on startup.
+		sql: `
+        ALTER TABLE activity_sessions ADD COLUMN title_hash TEXT NOT NULL DEFAULT '';
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_app_title_hash ON activity_sessions(app_name, title_hash);
+        CREATE TABLE IF NOT EXISTS settings (
+            key TEXT PRIMARY KEY,
+            value TEXT NOT NULL
+        );
+    `,
+	},
+	{
+		version: 5,
+		name:    "away periods",
+		sql: `
+        CREATE TABLE IF NOT EXISTS away_periods (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            start_time INTEGER NOT NULL,
+            end_time INTEGER NOT NULL,
+            reason TEXT NOT NULL,
+            device_id TEXT NOT NULL DEFAULT ''
+        );
+        CREATE INDEX IF NOT EXISTS idx_away_periods_start_time ON away_periods(start_time);
+    `,
+		postgres: `
+        CREATE TABLE IF NOT EXISTS away_periods (
+            id BIGSERIAL PRIMARY KEY,
+            start_time BIGINT NOT NULL,
+            end_time BIGINT NOT NULL,
+            reason TEXT NOT NULL,
+            device_id TEXT NOT NULL DEFAULT ''
+        );
+        CREATE INDEX IF NOT EXISTS idx_away_periods_start_time ON away_periods(start_time);
+    `,
+	},
+	{
+		version: 6,
+		name:    "session source",
+		sql: `
+        ALTER TABLE activity_sessions ADD COLUMN source TEXT NOT NULL DEFAULT 'tracker';
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_end_time ON activity_sessions(end_time);
+    `,
+	},
+	{
+		version: 7,
+		name:    "event sources and ActivityWatch buckets",
+		sql: `
+        ALTER TABLE raw_events ADD COLUMN source TEXT NOT NULL DEFAULT 'tracker';
+        CREATE TABLE IF NOT EXISTS aw_buckets (
+            id TEXT PRIMARY KEY,
+            type TEXT NOT NULL,
+            client TEXT NOT NULL,
+            hostname TEXT NOT NULL,
+            created INTEGER NOT NULL
+        );
+    `,
+		postgres: `
+        ALTER TABLE raw_events ADD COLUMN source TEXT NOT NULL DEFAULT 'tracker';
+        CREATE TABLE IF NOT EXISTS aw_buckets (
+            id TEXT PRIMARY KEY,
+            type TEXT NOT NULL,
+            client TEXT NOT NULL,
+            hostname TEXT NOT NULL,
+            created BIGINT NOT NULL
+        );
+    `,
+	},
+	{
+		version: 8,
+		name:    "sync sequence numbers, uids and tombstones",
+		// Existing rows get sequence 1 and updated_at 0, so they are sent on
+		// the first sync and lose to any real change.
+		sql: `
+        ALTER TABLE activity_sessions ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+        ALTER TABLE activity_sessions ADD COLUMN seq INTEGER NOT NULL DEFAULT 1;
+        ALTER TABLE activity_sessions ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+        ALTER TABLE classifications ADD COLUMN seq INTEGER NOT NULL DEFAULT 1;
+        ALTER TABLE classifications ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+        ALTER TABLE classification_rules ADD COLUMN seq INTEGER NOT NULL DEFAULT 1;
+        ALTER TABLE classification_rules ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+        UPDATE activity_sessions SET uid = lower(hex(randomblob(16))) WHERE uid = '';
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_activity_sessions_uid ON activity_sessions(uid);
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_seq ON activity_sessions(seq);
+        CREATE TABLE IF NOT EXISTS sync_clock (
+            id INTEGER PRIMARY KEY CHECK (id = 1),
+            seq INTEGER NOT NULL
+        );
+        INSERT INTO sync_clock (id, seq) VALUES (1, 1);
+        CREATE TABLE IF NOT EXISTS sync_tombstones (
+            kind TEXT NOT NULL,
+            uid TEXT NOT NULL,
+            seq INTEGER NOT NULL,
+            deleted_at INTEGER NOT NULL,
+            PRIMARY KEY (kind, uid)
+        );
+        CREATE TABLE IF NOT EXISTS sync_peers (
+            peer TEXT PRIMARY KEY,
+            pulled INTEGER NOT NULL DEFAULT 0,
+            pushed INTEGER NOT NULL DEFAULT 0
+        );
+    `,
+		postgres: `
+        ALTER TABLE activity_sessions ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+        ALTER TABLE activity_sessions ADD COLUMN seq BIGINT NOT NULL DEFAULT 1;
+        ALTER TABLE activity_sessions ADD COLUMN updated_at BIGINT NOT NULL DEFAULT 0;
+        ALTER TABLE classifications ADD COLUMN seq BIGINT NOT NULL DEFAULT 1;
+        ALTER TABLE classifications ADD COLUMN updated_at BIGINT NOT NULL DEFAULT 0;
+        ALTER TABLE classification_rules ADD COLUMN seq BIGINT NOT NULL DEFAULT 1;
+        ALTER TABLE classification_rules ADD COLUMN updated_at BIGINT NOT NULL DEFAULT 0;
+        UPDATE activity_sessions SET uid = md5(random()::text || clock_timestamp()::text || id::text) WHERE uid = '';
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_activity_sessions_uid ON activity_sessions(uid);
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_seq ON activity_sessions(seq);
+        CREATE TABLE IF NOT EXISTS sync_clock (
+            id INTEGER PRIMARY KEY CHECK (id = 1),
+            seq BIGINT NOT NULL
+        );
+        INSERT INTO sync_clock (id, seq) VALUES (1, 1);
+        CREATE TABLE IF NOT EXISTS sync_tombstones (
+            kind TEXT NOT NULL,
+            uid TEXT NOT NULL,
+            seq BIGINT NOT NULL,
+            deleted_at BIGINT NOT NULL,
+            PRIMARY KEY (kind, uid)
+        );
+        CREATE TABLE IF NOT EXISTS sync_peers (
+            peer TEXT PRIMARY KEY,
+            pulled BIGINT NOT NULL DEFAULT 0,
+            pushed BIGINT NOT NULL DEFAULT 0
+        );
+    `,
+	},
+	{
+		version: 9,
+		name:    "session notes",
+		sql: `
+        ALTER TABLE activity_sessions ADD COLUMN note TEXT NOT NULL DEFAULT '';
+    `,
+	},
+	{
+		version: 10,
+		name:    "audit log",
+		sql: `
+        CREATE TABLE IF NOT EXISTS audit_log (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            operation TEXT NOT NULL,
+            created_at INTEGER NOT NULL,
+            reverts INTEGER REFERENCES audit_log(id)
+        );
+        CREATE TABLE IF NOT EXISTS audit_changes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            log_id INTEGER NOT NULL REFERENCES audit_log(id),
+            entity TEXT NOT NULL,
+            entity_id INTEGER NOT NULL,
+            before_state TEXT,
+            after_state TEXT
+        );
+        CREATE INDEX IF NOT EXISTS idx_audit_log_reverts ON audit_log(reverts);
+        CREATE INDEX IF NOT EXISTS idx_audit_changes_log_id ON audit_changes(log_id);
+        CREATE INDEX IF NOT EXISTS idx_audit_changes_entity ON audit_changes(entity, entity_id);
+    `,
+		postgres: `
+        CREATE TABLE IF NOT EXISTS audit_log (
+            id BIGSERIAL PRIMARY KEY,
+            operation TEXT NOT NULL,
+            created_at BIGINT NOT NULL,
+            reverts BIGINT REFERENCES audit_log(id)
+        );
+        CREATE TABLE IF NOT EXISTS audit_changes (
+            id BIGSERIAL PRIMARY KEY,
+            log_id BIGINT NOT NULL REFERENCES audit_log(id),
+            entity TEXT NOT NULL,
+            entity_id BIGINT NOT NULL,
+            before_state TEXT,
+            after_state TEXT
+        );
+        CREATE INDEX IF NOT EXISTS idx_audit_log_reverts ON audit_log(reverts);
+        CREATE INDEX IF NOT EXISTS idx_audit_changes_log_id ON audit_changes(log_id);
+        CREATE INDEX IF NOT EXISTS idx_audit_changes_entity ON audit_changes(entity, entity_id);
+    `,
+	},
+	{
+		version: 11,
+		name:    "session trash",
+		// Deleted sessions used to be logged whole so undo could reinsert
+		// them; they now stay in the table until the trash is emptied.
+		sql: `
+        ALTER TABLE activity_sessions ADD COLUMN trashed_at INTEGER;
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_trashed_at ON activity_sessions(trashed_at);
+        DELETE FROM audit_changes WHERE entity = 'session' AND (before_state IS NULL OR after_state IS NULL);
+    `,
+		postgres: `
+        ALTER TABLE activity_sessions ADD COLUMN trashed_at BIGINT;
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_trashed_at ON activity_sessions(trashed_at);
+        DELETE FROM audit_changes WHERE entity = 'session' AND (before_state IS NULL OR after_state IS NULL);
+    `,
+	},
+	{
+		version: 12,
+		name:    "classification hierarchy",
+		sql: `
+        ALTER TABLE classifications ADD COLUMN parent_id INTEGER REFERENCES classifications(id);
+        CREATE INDEX IF NOT EXISTS idx_classifications_parent_id ON classifications(parent_id);
+    `,
+		postgres: `
+        ALTER TABLE classifications ADD COLUMN parent_id BIGINT REFERENCES classifications(id);
+        CREATE INDEX IF NOT EXISTS idx_classifications_parent_id ON classifications(parent_id);
+    `,
+	},
+	{
+		version: 13,
+		name:    "tags",
+		sql: `
+        CREATE TABLE IF NOT EXISTS session_tags (
+            session_id INTEGER NOT NULL REFERENCES activity_sessions(id),
+            tag TEXT NOT NULL,
+            PRIMARY KEY (session_id, tag)
+        );
+        CREATE INDEX IF NOT EXISTS idx_session_tags_tag ON session_tags(tag);
+        CREATE TABLE IF NOT EXISTS rule_tags (
+            rule_id INTEGER NOT NULL REFERENCES classification_rules(id),
+            tag TEXT NOT NULL,
+            PRIMARY KEY (rule_id, tag)
+        );
+        ALTER TABLE classification_rules ADD COLUMN note TEXT NOT NULL DEFAULT '';
+    `,
+		postgres: `
+        CREATE TABLE IF NOT EXISTS session_tags (
+            session_id BIGINT NOT NULL REFERENCES activity_sessions(id),
+            tag TEXT NOT NULL,
+            PRIMARY KEY (session_id, tag)
+        );
+        CREATE INDEX IF NOT EXISTS idx_session_tags_tag ON session_tags(tag);
+        CREATE TABLE IF NOT EXISTS rule_tags (
+            rule_id BIGINT NOT NULL REFERENCES classification_rules(id),
+            tag TEXT NOT NULL,
+            PRIMARY KEY (rule_id, tag)
+        );
+        ALTER TABLE classification_rules ADD COLUMN note TEXT NOT NULL DEFAULT '';
+    `,
+	},
+	{
+		version: 14,
+		name:    "projects, URLs and working directories",
+		sql: `
+        CREATE TABLE IF NOT EXISTS projects (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL UNIQUE,
+            client TEXT NOT NULL DEFAULT '',
+            hourly_rate INTEGER NOT NULL DEFAULT 0,
+            currency TEXT NOT NULL DEFAULT '',
+            seq INTEGER NOT NULL DEFAULT 1,
+            updated_at INTEGER NOT NULL DEFAULT 0
+        );
+        CREATE TABLE IF NOT EXISTS project_rules (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            project_id INTEGER NOT NULL REFERENCES projects(id),
+            field TEXT NOT NULL,
+            contains TEXT NOT NULL,
+            seq INTEGER NOT NULL DEFAULT 1,
+            updated_at INTEGER NOT NULL DEFAULT 0
+        );
+        ALTER TABLE activity_sessions ADD COLUMN project_id INTEGER REFERENCES projects(id);
+        ALTER TABLE activity_sessions ADD COLUMN url TEXT NOT NULL DEFAULT '';
+        ALTER TABLE activity_sessions ADD COLUMN cwd TEXT NOT NULL DEFAULT '';
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_project_id ON activity_sessions(project_id);
+        ALTER TABLE raw_events ADD COLUMN url TEXT NOT NULL DEFAULT '';
+        ALTER TABLE raw_events ADD COLUMN cwd TEXT NOT NULL DEFAULT '';
+    `,
+		postgres: `
+        CREATE TABLE IF NOT EXISTS projects (
+            id BIGSERIAL PRIMARY KEY,
+            name TEXT NOT NULL UNIQUE,
+            client TEXT NOT NULL DEFAULT '',
+            hourly_rate BIGINT NOT NULL DEFAULT 0,
+            currency TEXT NOT NULL DEFAULT '',
+            seq BIGINT NOT NULL DEFAULT 1,
+            updated_at BIGINT NOT NULL DEFAULT 0
+        );
+        CREATE TABLE IF NOT EXISTS project_rules (
+            id BIGSERIAL PRIMARY KEY,
+            project_id BIGINT NOT NULL REFERENCES projects(id),
+            field TEXT NOT NULL,
+            contains TEXT NOT NULL,
+            seq BIGINT NOT NULL DEFAULT 1,
+            updated_at BIGINT NOT NULL DEFAULT 0
+        );
+        ALTER TABLE activity_sessions ADD COLUMN project_id BIGINT REFERENCES projects(id);
+        ALTER TABLE activity_sessions ADD COLUMN url TEXT NOT NULL DEFAULT '';
+        ALTER TABLE activity_sessions ADD COLUMN cwd TEXT NOT NULL DEFAULT '';
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_project_id ON activity_sessions(project_id);
+        ALTER TABLE raw_events ADD COLUMN url TEXT NOT NULL DEFAULT '';
+        ALTER TABLE raw_events ADD COLUMN cwd TEXT NOT NULL DEFAULT '';
+    `,
+	},
+	{
+		version: 15,
+		name:    "rule match types",
+		sql: `
+        ALTER TABLE classification_rules ADD COLUMN app_match TEXT NOT NULL DEFAULT 'exact';
+        ALTER TABLE classification_rules ADD COLUMN title_match TEXT NOT NULL DEFAULT 'contains';
+    `,
+	},
+	{
+		version: 16,
+		name:    "rule conditions",
+		sql: `
+        ALTER TABLE classification_rules ADD COLUMN match_condition TEXT NOT NULL DEFAULT '';
+    `,
+	},
+	{
+		// Sessions classified before this migration are treated as
+		// classified by hand, so applying a rule never overrides them.
+		version: 17,
+		name:    "automatic classifications",
+		sql: `
+        ALTER TABLE activity_sessions ADD COLUMN auto_classified BOOLEAN NOT NULL DEFAULT FALSE;
+    `,
+	},
+	{
+		// Nearly every session has a NULL trashed_at, but a full index made
+		// the planner prefer it for "trashed_at IS NULL" over the start time
+		// and classification indexes. Only the trash needs an index.
+		version: 18,
+		name:    "partial trash index",
+		sql: `
+        DROP INDEX IF EXISTS idx_activity_sessions_trashed_at;
+        CREATE INDEX IF NOT EXISTS idx_activity_sessions_trashed ON activity_sessions(trashed_at) WHERE trashed_at IS NOT NULL;
+    `,
+	},
+}
+
+// latestSchemaVersion is the highest migration version known to this build.
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate brings the schema up to date. Each pending migration runs in its own
+// transaction, and a backup of the database is taken before the first one is
+// applied to an existing database.
+func (s *DBStore) migrate() error {
+	if err := s.createMigrationsTable(); err != nil {
+		return fmt.Errorf("could not create schema_migrations: %w", err)
+	}
+
+	current, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latestSchemaVersion() {
+		return fmt.Errorf("%w: database is at version %d, this build supports up to %d",
+			ErrSchemaTooNew, current, latestSchemaVersion())
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > current {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := s.backupBeforeMigration(current); err != nil {
+		return fmt.Errorf("pre-migration backup failed: %w", err)
+	}
+
+	for _, m := range pending {
+		if err := s.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		log.Printf("Applied schema migration %d: %s", m.version, m.name)
+	}
+	return nil
+}
+
+func (s *DBStore) createMigrationsTable() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if s.dialect == dialectPostgres {
+		// CREATE TABLE IF NOT EXISTS is not safe against a concurrent
+		// create on PostgreSQL, so take the migration lock for it too.
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at BIGINT NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// schemaVersion returns the highest applied migration version, or 0 for a new
+// or pre-migration database.
+func (s *DBStore) schemaVersion() (int, error) {
+	var version sql.NullInt64
+	if err := s.db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("could not read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+func (s *DBStore) applyMigration(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if s.dialect == dialectPostgres {
+		// Several machines may share one server and start at the same time;
+		// serialise them and skip migrations another instance already applied.
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		var applied bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.version).Scan(&applied)
+		if err != nil || applied {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(m.statement(s.dialect)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+		m.version, m.name, time.Now().Unix())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// backupBeforeMigration copies the database next to the original file before
+// any migration touches it. Fresh and in-memory databases have nothing worth
+// saving and are skipped.
+func (s *DBStore) backupBeforeMigration(fromVersion int) error {
+	if s.dialect == dialectPostgres {
+		log.Println("Migrating PostgreSQL schema; take a pg_dump first if you need a restore point")
+		return nil
+	}
+	if s.path == "" || s.path == ":memory:" {
+		return nil
+	}
+
+	var tables int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')
+	`).Scan(&tables)
+	if err != nil {
+		return err
+	}
+	if tables == 0 {
+		return nil
+	}
+
+	dest := fmt.Sprintf("%s.pre-v%d-%s.bak", s.path, fromVersion, time.Now().Format("20060102-150405"))
+	if err := s.backupTo(dest); err != nil {
+		return err
+	}
+	log.Printf("Backed up database to %s before migrating", dest)
+	return nil
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version != migrations[i-1].version+1 {
+			t.Errorf("migration %d follows %d; versions must be consecutive", migrations[i].version, migrations[i-1].version)
+		}
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	s := newTestDB(t)
+
+	version, err := s.schemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestSchemaVersion() {
+		t.Errorf("schema version = %d, want %d", version, latestSchemaVersion())
+	}
+	var applied int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("%d migrations recorded, want %d", applied, len(migrations))
+	}
+	backups, _ := filepath.Glob(s.path + ".pre-v*.bak")
+	if len(backups) != 0 {
+		t.Errorf("a fresh database was backed up: %v", backups)
+	}
+}
+
+func TestMigrateReopenAppliesNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("INSERT INTO classifications (user_defined_name, is_helpful, goal_context) VALUES ('Go', TRUE, '')"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewDBStore(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer s.Close()
+	var applied int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("%d migrations recorded after reopening, want %d", applied, len(migrations))
+	}
+	if backups, _ := filepath.Glob(path + ".pre-v*.bak"); len(backups) != 0 {
+		t.Errorf("an up-to-date database was backed up: %v", backups)
+	}
+}
+
+// TestMigrateLegacyDatabase opens a database created by the schema used
+// before migrations were tracked.
+func TestMigrateLegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(migrations[0].sql); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		INSERT INTO classifications (id, user_defined_name, is_helpful, goal_context) VALUES (1, 'Go', 1, 'learn');
+		INSERT INTO activity_sessions (app_name, window_title, start_time, end_time, duration_seconds, classification_id)
+		VALUES ('Code', 'main.go', 100, 160, 60, 1);
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewDBStore(path)
+	if err != nil {
+		t.Fatalf("NewDBStore: %v", err)
+	}
+	defer s.Close()
+
+	var app, title, hash, uid string
+	var classID int64
+	err = s.db.QueryRow("SELECT app_name, window_title, title_hash, uid, classification_id FROM activity_sessions").
+		Scan(&app, &title, &hash, &uid, &classID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app != "Code" || title != "main.go" || classID != 1 {
+		t.Errorf("session = %q %q %d after migrating", app, title, classID)
+	}
+	if hash != s.titleHash("main.go") {
+		t.Errorf("title_hash = %q, want the backfilled hash", hash)
+	}
+	if uid == "" {
+		t.Error("session was not given a UID")
+	}
+
+	backups, _ := filepath.Glob(path + ".pre-v0-*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want one", backups)
+	}
+	if err := VerifyIntegrity(backups[0]); err != nil {
+		t.Errorf("backup: %v", err)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, 'from the future', 0)", latestSchemaVersion()+1)
+	s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDBStore(path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("NewDBStore = %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestFailedMigrationIsRolledBack(t *testing.T) {
+	s := newTestDB(t)
+
+	bad := migration{version: latestSchemaVersion() + 1, name: "broken", sql: `
+		CREATE TABLE half_done (id INTEGER);
+		THIS IS NOT SQL;
+	`}
+	if err := s.applyMigration(bad); err == nil {
+		t.Fatal("applyMigration succeeded")
+	}
+	var tables int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Error("the failed migration left its table behind")
+	}
+	if version, _ := s.schemaVersion(); version != latestSchemaVersion() {
+		t.Errorf("schema version = %d after a failed migration, want %d", version, latestSchemaVersion())
+	}
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq" // PostgreSQL driver
+)
+
+// NewPostgresStore connects to a PostgreSQL database and brings the schema up to
+// date. It shares its queries and migrations with the SQLite store.
+//
+// deviceID identifies this machine. Several machines can point at the same
+// database; each only aggregates the raw events it captured itself.
+func NewPostgresStore(dsn, deviceID string, opts ...Option) (*DBStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not connect to PostgreSQL: %w", err)
+	}
+
+	store := &DBStore{db: db, dialect: dialectPostgres, deviceID: deviceID}
+	if err := store.init(opts); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// postgresDSNEnv names a PostgreSQL server to test against, e.g.
+// "postgres://postgres@localhost/postgres?sslmode=disable". The tests are
+// skipped when it is unset.
+const postgresDSNEnv = "PERSONALOS_TEST_POSTGRES_DSN"
+
+// newTestPostgresDSN creates an empty schema for one test and returns a DSN
+// whose connections use it, so tests never see each other's tables.
+func newTestPostgresDSN(t *testing.T) string {
+	t.Helper()
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	schema := "personalos_test_" + hex.EncodeToString(suffix)
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() { db.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	// lib/pq sends unknown settings to the server as run-time parameters.
+	switch {
+	case !strings.Contains(dsn, "://"):
+		return dsn + " search_path=" + schema
+	case strings.Contains(dsn, "?"):
+		return dsn + "&search_path=" + schema
+	default:
+		return dsn + "?search_path=" + schema
+	}
+}
+
+func newTestPostgres(t *testing.T) *DBStore {
+	t.Helper()
+	s, err := NewPostgresStore(newTestPostgresDSN(t), "test-device")
+	if err != nil {
+		t.Fatalf("NewPostgresStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	s := newTestPostgres(t)
+
+	version, err := s.schemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestSchemaVersion() {
+		t.Errorf("schema version = %d, want %d", version, latestSchemaVersion())
+	}
+	if err := s.Backup(t.TempDir() + "/backup.db"); !errors.Is(err, ErrBackupUnsupported) {
+		t.Errorf("Backup = %v, want ErrBackupUnsupported", err)
+	}
+}
+
+// TestPostgresConcurrentMigrations starts several stores on an empty schema at
+// once, as machines sharing a server might; the advisory lock must let each
+// migration run exactly once.
+func TestPostgresConcurrentMigrations(t *testing.T) {
+	dsn := newTestPostgresDSN(t)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := NewPostgresStore(dsn, "device")
+			if err == nil {
+				s.Close()
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("NewPostgresStore: %v", err)
+		}
+	}
+
+	s, err := NewPostgresStore(dsn, "device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var applied int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("%d migrations recorded, want %d", applied, len(migrations))
+	}
+}
+
+func TestPostgresStoreBasics(t *testing.T) {
+	exerciseStore(t, newTestPostgres(t))
+}
+
+// TestPostgresForeignKeys checks the constraints PostgreSQL enforces, unlike
+// SQLite without PRAGMA foreign_keys.
+func TestPostgresForeignKeys(t *testing.T) {
+	s := newTestPostgres(t)
+	_, err := s.db.Exec(`
+		INSERT INTO activity_sessions (app_name, window_title, start_time, end_time, duration_seconds, classification_id)
+		VALUES ('Code', 'main.go', 0, 60, 60, 12345)
+	`)
+	if err == nil {
+		t.Error("a session referencing a missing classification was accepted")
+	}
+}
@@ -0,0 +1,546 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/imdawon/personalos/models"
+)
+
+var (
+	// ErrProjectNotFound is returned for an unknown project or project rule ID.
+	ErrProjectNotFound = errors.New("project not found")
+	// ErrProjectExists is returned when creating or renaming a project to a
+	// name that is already taken.
+	ErrProjectExists = errors.New("a project with this name already exists")
+	// ErrInvalidProject is returned for a project or project rule request
+	// that cannot be applied, such as an empty name or a negative rate.
+	ErrInvalidProject = errors.New("invalid project")
+)
+
+// newProject validates and normalizes the fields of a project request.
+func newProject(id int64, name, client string, hourlyRate int64, currency string) (models.Project, error) {
+	p := models.Project{
+		ID:         id,
+		Name:       strings.TrimSpace(name),
+		Client:     strings.TrimSpace(client),
+		HourlyRate: hourlyRate,
+		Currency:   strings.ToUpper(strings.TrimSpace(currency)),
+	}
+	if p.Name == "" {
+		return p, fmt.Errorf("%w: a name is required", ErrInvalidProject)
+	}
+	if p.HourlyRate < 0 {
+		return p, fmt.Errorf("%w: the hourly rate cannot be negative", ErrInvalidProject)
+	}
+	return p, nil
+}
+
+// newProjectRule validates and normalizes a project rule request.
+func newProjectRule(req models.CreateProjectRuleRequest) (models.ProjectRule, error) {
+	r := models.ProjectRule{ProjectID: req.ProjectID, Field: strings.ToLower(strings.TrimSpace(req.Field)), Contains: strings.TrimSpace(req.Contains)}
+	switch r.Field {
+	case models.ProjectFieldTitle, models.ProjectFieldCwd, models.ProjectFieldURL:
+	default:
+		return r, fmt.Errorf("%w: unknown rule field %q (want title, cwd or url)", ErrInvalidProject, req.Field)
+	}
+	if r.Contains == "" {
+		return r, fmt.Errorf("%w: a rule needs text to match", ErrInvalidProject)
+	}
+	return r, nil
+}
+
+// projectRuleKey identifies a project rule across devices.
+func projectRuleKey(field, contains string) string {
+	return field + "\x1f" + contains
+}
+
+// matchProjectRule returns the project of the newest rule matching the
+// session, or nil. rules are ordered by ID.
+func matchProjectRule(rules []models.ProjectRule, session models.ActivitySession) *int64 {
+	for i := len(rules) - 1; i >= 0; i-- {
+		var value string
+		switch rules[i].Field {
+		case models.ProjectFieldTitle:
+			value = session.WindowTitle
+		case models.ProjectFieldCwd:
+			value = session.Cwd
+		case models.ProjectFieldURL:
+			value = session.URL
+		}
+		if value != "" && strings.Contains(strings.ToLower(value), strings.ToLower(rules[i].Contains)) {
+			id := rules[i].ProjectID
+			return &id
+		}
+	}
+	return nil
+}
+
+// loadProjectRules returns every project rule ordered by ID.
+func loadProjectRules(q queryer) ([]models.ProjectRule, error) {
+	rows, err := q.Query("SELECT id, project_id, field, contains FROM project_rules ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]models.ProjectRule, 0)
+	for rows.Next() {
+		var r models.ProjectRule
+		if err := rows.Scan(&r.ID, &r.ProjectID, &r.Field, &r.Contains); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func loadProject(tx *sql.Tx, id int64) (models.Project, error) {
+	p := models.Project{ID: id}
+	err := tx.QueryRow("SELECT name, client, hourly_rate, currency FROM projects WHERE id = $1", id).
+		Scan(&p.Name, &p.Client, &p.HourlyRate, &p.Currency)
+	if err == sql.ErrNoRows {
+		return p, fmt.Errorf("%w: %d", ErrProjectNotFound, id)
+	}
+	return p, err
+}
+
+// checkProjectNameFree fails with ErrProjectExists if another project than id
+// uses name.
+func checkProjectNameFree(tx *sql.Tx, name string, id int64) error {
+	var taken bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM projects WHERE name = $1 AND id <> $2)", name, id).Scan(&taken)
+	if err == nil && taken {
+		err = fmt.Errorf("%w: %q", ErrProjectExists, name)
+	}
+	return err
+}
+
+// CreateProject adds a project.
+func (s *DBStore) CreateProject(req models.CreateProjectRequest) (models.Project, error) {
+	p, err := newProject(0, req.Name, req.Client, req.HourlyRate, req.Currency)
+	if err != nil {
+		return p, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+
+	if err := checkProjectNameFree(tx, p.Name, 0); err != nil {
+		return p, err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return p, err
+	}
+	err = tx.QueryRow("INSERT INTO projects (name, client, hourly_rate, currency, seq, updated_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		p.Name, p.Client, p.HourlyRate, p.Currency, st.seq, st.at).Scan(&p.ID)
+	if err != nil {
+		return p, err
+	}
+	// A name that was deleted before replaces the deletion on other devices.
+	if _, err := tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneProject, p.Name); err != nil {
+		return p, err
+	}
+	return p, tx.Commit()
+}
+
+// UpdateProject renames a project or changes its client or rate. A new rate
+// applies to every timesheet, including past ones.
+func (s *DBStore) UpdateProject(req models.UpdateProjectRequest) (models.Project, error) {
+	p, err := newProject(req.ID, req.Name, req.Client, req.HourlyRate, req.Currency)
+	if err != nil {
+		return p, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+
+	old, err := loadProject(tx, p.ID)
+	if err != nil {
+		return p, err
+	}
+	if err := checkProjectNameFree(tx, p.Name, p.ID); err != nil {
+		return p, err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return p, err
+	}
+	_, err = tx.Exec("UPDATE projects SET name = $1, client = $2, hourly_rate = $3, currency = $4, seq = $5, updated_at = $6 WHERE id = $7",
+		p.Name, p.Client, p.HourlyRate, p.Currency, st.seq, st.at, p.ID)
+	if err != nil {
+		return p, err
+	}
+
+	if old.Name != p.Name {
+		// As with classifications, other devices see a rename as a new
+		// project that the sessions and rules move to.
+		for _, table := range []string{"activity_sessions", "project_rules"} {
+			if _, err := tx.Exec("UPDATE "+table+" SET seq = $1, updated_at = $2 WHERE project_id = $3", st.seq, st.at, p.ID); err != nil {
+				return p, err
+			}
+		}
+		if err := writeTombstone(tx, tombstoneProject, old.Name, st); err != nil {
+			return p, err
+		}
+		if _, err := tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneProject, p.Name); err != nil {
+			return p, err
+		}
+	}
+	return p, tx.Commit()
+}
+
+// DeleteProject deletes a project along with its rules. Its sessions are no
+// longer attributed to any project.
+func (s *DBStore) DeleteProject(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p, err := loadProject(tx, id)
+	if err != nil {
+		return err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE activity_sessions SET project_id = NULL, seq = $1, updated_at = $2 WHERE project_id = $3",
+		st.seq, st.at, id); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT field, contains FROM project_rules WHERE project_id = $1", id)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for rows.Next() {
+		var field, contains string
+		if err := rows.Scan(&field, &contains); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, projectRuleKey(field, contains))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := writeTombstone(tx, tombstoneProjectRule, key, st); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM project_rules WHERE project_id = $1", id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM projects WHERE id = $1", id); err != nil {
+		return err
+	}
+	if err := writeTombstone(tx, tombstoneProject, p.Name, st); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListProjects returns every project ordered by ID.
+func (s *DBStore) ListProjects() ([]models.Project, error) {
+	rows, err := s.db.Query("SELECT id, name, client, hourly_rate, currency FROM projects ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := make([]models.Project, 0)
+	for rows.Next() {
+		var p models.Project
+		if err := rows.Scan(&p.ID, &p.Name, &p.Client, &p.HourlyRate, &p.Currency); err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// CreateProjectRule adds a rule attributing new sessions to a project.
+func (s *DBStore) CreateProjectRule(req models.CreateProjectRuleRequest) (models.ProjectRule, error) {
+	r, err := newProjectRule(req)
+	if err != nil {
+		return r, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return r, err
+	}
+	defer tx.Rollback()
+
+	if _, err := loadProject(tx, r.ProjectID); err != nil {
+		return r, err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return r, err
+	}
+	err = tx.QueryRow("INSERT INTO project_rules (project_id, field, contains, seq, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		r.ProjectID, r.Field, r.Contains, st.seq, st.at).Scan(&r.ID)
+	if err != nil {
+		return r, err
+	}
+	if _, err := tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneProjectRule, projectRuleKey(r.Field, r.Contains)); err != nil {
+		return r, err
+	}
+	return r, tx.Commit()
+}
+
+// ListProjectRules returns every project rule ordered by ID.
+func (s *DBStore) ListProjectRules() ([]models.ProjectRule, error) {
+	return loadProjectRules(s.db)
+}
+
+// DeleteProjectRule removes a project rule by its ID.
+func (s *DBStore) DeleteProjectRule(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var field, contains string
+	err = tx.QueryRow("SELECT field, contains FROM project_rules WHERE id = $1", id).Scan(&field, &contains)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: no rule %d", ErrProjectNotFound, id)
+	} else if err != nil {
+		return err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM project_rules WHERE id = $1", id); err != nil {
+		return err
+	}
+	if err := writeTombstone(tx, tombstoneProjectRule, projectRuleKey(field, contains), st); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AssignProject attributes sessions to a project, or removes their project,
+// and returns how many sessions were changed.
+func (s *DBStore) AssignProject(req models.AssignProjectRequest) (int64, error) {
+	ids := uniqueIDs(req.SessionIDs)
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("%w: no sessions given", ErrInvalidSessionEdit)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if req.ProjectID != nil {
+		if _, err := loadProject(tx, *req.ProjectID); err != nil {
+			return 0, err
+		}
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if _, err := loadEditedSession(tx, id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("UPDATE activity_sessions SET project_id = $1, seq = $2, updated_at = $3 WHERE id = $4",
+			req.ProjectID, st.seq, st.at, id); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(ids)), tx.Commit()
+}
+
+// projectIndex returns the position of a project in m.projects. Callers must
+// hold m.mu.
+func (m *MemoryStore) projectIndex(id int64) (int, error) {
+	for i, p := range m.projects {
+		if p.ID == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %d", ErrProjectNotFound, id)
+}
+
+// checkProjectNameFree mirrors the DBStore check. Callers must hold m.mu.
+func (m *MemoryStore) checkProjectNameFree(name string, id int64) error {
+	for _, p := range m.projects {
+		if p.Name == name && p.ID != id {
+			return fmt.Errorf("%w: %q", ErrProjectExists, name)
+		}
+	}
+	return nil
+}
+
+// CreateProject adds a project.
+func (m *MemoryStore) CreateProject(req models.CreateProjectRequest) (models.Project, error) {
+	p, err := newProject(0, req.Name, req.Client, req.HourlyRate, req.Currency)
+	if err != nil {
+		return p, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkProjectNameFree(p.Name, 0); err != nil {
+		return p, err
+	}
+	m.nextProjectID++
+	p.ID = m.nextProjectID
+	m.projects = append(m.projects, p)
+	return p, nil
+}
+
+// UpdateProject renames a project or changes its client or rate.
+func (m *MemoryStore) UpdateProject(req models.UpdateProjectRequest) (models.Project, error) {
+	p, err := newProject(req.ID, req.Name, req.Client, req.HourlyRate, req.Currency)
+	if err != nil {
+		return p, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, err := m.projectIndex(p.ID)
+	if err != nil {
+		return p, err
+	}
+	if err := m.checkProjectNameFree(p.Name, p.ID); err != nil {
+		return p, err
+	}
+	m.projects[i] = p
+	return p, nil
+}
+
+// DeleteProject deletes a project along with its rules. Its sessions are no
+// longer attributed to any project.
+func (m *MemoryStore) DeleteProject(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, err := m.projectIndex(id)
+	if err != nil {
+		return err
+	}
+	for j := range m.sessions {
+		if p := m.sessions[j].ProjectID; p != nil && *p == id {
+			m.sessions[j].ProjectID = nil
+		}
+	}
+	kept := m.projectRules[:0]
+	for _, rule := range m.projectRules {
+		if rule.ProjectID != id {
+			kept = append(kept, rule)
+		}
+	}
+	m.projectRules = kept
+	m.projects = append(m.projects[:i], m.projects[i+1:]...)
+	return nil
+}
+
+// ListProjects returns every project ordered by ID.
+func (m *MemoryStore) ListProjects() ([]models.Project, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append(make([]models.Project, 0, len(m.projects)), m.projects...), nil
+}
+
+// CreateProjectRule adds a rule attributing new sessions to a project.
+func (m *MemoryStore) CreateProjectRule(req models.CreateProjectRuleRequest) (models.ProjectRule, error) {
+	r, err := newProjectRule(req)
+	if err != nil {
+		return r, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.projectIndex(r.ProjectID); err != nil {
+		return r, err
+	}
+	m.nextProjectRuleID++
+	r.ID = m.nextProjectRuleID
+	m.projectRules = append(m.projectRules, r)
+	return r, nil
+}
+
+// ListProjectRules returns every project rule ordered by ID.
+func (m *MemoryStore) ListProjectRules() ([]models.ProjectRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append(make([]models.ProjectRule, 0, len(m.projectRules)), m.projectRules...), nil
+}
+
+// DeleteProjectRule removes a project rule by its ID.
+func (m *MemoryStore) DeleteProjectRule(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, rule := range m.projectRules {
+		if rule.ID == id {
+			m.projectRules = append(m.projectRules[:i], m.projectRules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no rule %d", ErrProjectNotFound, id)
+}
+
+// AssignProject attributes sessions to a project, or removes their project,
+// and returns how many sessions were changed.
+func (m *MemoryStore) AssignProject(req models.AssignProjectRequest) (int64, error) {
+	ids := uniqueIDs(req.SessionIDs)
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("%w: no sessions given", ErrInvalidSessionEdit)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if req.ProjectID != nil {
+		if _, err := m.projectIndex(*req.ProjectID); err != nil {
+			return 0, err
+		}
+	}
+	indexes := make([]int, 0, len(ids))
+	for _, id := range ids {
+		i, err := m.sessionIndex(id)
+		if err != nil {
+			return 0, err
+		}
+		indexes = append(indexes, i)
+	}
+	for _, i := range indexes {
+		var project *int64
+		if req.ProjectID != nil {
+			id := *req.ProjectID
+			project = &id
+		}
+		m.sessions[i].ProjectID = project
+	}
+	return int64(len(indexes)), nil
+}
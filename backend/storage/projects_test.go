@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func TestMatchProjectRule(t *testing.T) {
+	rules := []models.ProjectRule{
+		{ID: 1, ProjectID: 1, Field: models.ProjectFieldCwd, Contains: "/src/acme"},
+		{ID: 2, ProjectID: 2, Field: models.ProjectFieldTitle, Contains: "ACME"},
+		{ID: 3, ProjectID: 3, Field: models.ProjectFieldURL, Contains: "github.com/initech"},
+	}
+	tests := []struct {
+		name    string
+		session models.ActivitySession
+		want    int64
+	}{
+		{"cwd", models.ActivitySession{Cwd: "/home/me/src/acme/api"}, 1},
+		{"title, case-insensitively", models.ActivitySession{WindowTitle: "acme dashboard"}, 2},
+		{"newest rule wins", models.ActivitySession{WindowTitle: "Acme", Cwd: "/src/acme"}, 2},
+		{"url", models.ActivitySession{URL: "https://github.com/initech/tps"}, 3},
+		{"no match", models.ActivitySession{WindowTitle: "general", URL: "https://example.com"}, 0},
+	}
+	for _, test := range tests {
+		var got int64
+		if id := matchProjectRule(rules, test.session); id != nil {
+			got = *id
+		}
+		if got != test.want {
+			t.Errorf("%s: project %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProjects(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		acme, err := s.CreateProject(models.CreateProjectRequest{Name: " ACME ", Client: "Acme Corp", HourlyRate: 9000, Currency: "usd"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acme.Name != "ACME" || acme.Currency != "USD" {
+			t.Errorf("project = %+v, want a trimmed name and upper-case currency", acme)
+		}
+		initech, err := s.CreateProject(models.CreateProjectRequest{Name: "Initech"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		invalid := []struct {
+			name string
+			do   func() error
+			want error
+		}{
+			{"empty name", func() error {
+				_, err := s.CreateProject(models.CreateProjectRequest{Name: " "})
+				return err
+			}, ErrInvalidProject},
+			{"negative rate", func() error {
+				_, err := s.CreateProject(models.CreateProjectRequest{Name: "Globex", HourlyRate: -1})
+				return err
+			}, ErrInvalidProject},
+			{"duplicate name", func() error {
+				_, err := s.CreateProject(models.CreateProjectRequest{Name: "ACME"})
+				return err
+			}, ErrProjectExists},
+			{"rename to a taken name", func() error {
+				_, err := s.UpdateProject(models.UpdateProjectRequest{ID: initech.ID, Name: "ACME"})
+				return err
+			}, ErrProjectExists},
+			{"update a missing project", func() error {
+				_, err := s.UpdateProject(models.UpdateProjectRequest{ID: 99, Name: "Globex"})
+				return err
+			}, ErrProjectNotFound},
+			{"delete a missing project", func() error { return s.DeleteProject(99) }, ErrProjectNotFound},
+			{"rule on an unknown field", func() error {
+				_, err := s.CreateProjectRule(models.CreateProjectRuleRequest{ProjectID: acme.ID, Field: "app", Contains: "x"})
+				return err
+			}, ErrInvalidProject},
+			{"rule without text", func() error {
+				_, err := s.CreateProjectRule(models.CreateProjectRuleRequest{ProjectID: acme.ID, Field: "title"})
+				return err
+			}, ErrInvalidProject},
+			{"rule for a missing project", func() error {
+				_, err := s.CreateProjectRule(models.CreateProjectRuleRequest{ProjectID: 99, Field: "title", Contains: "x"})
+				return err
+			}, ErrProjectNotFound},
+			{"delete a missing rule", func() error { return s.DeleteProjectRule(99) }, ErrProjectNotFound},
+		}
+		for _, test := range invalid {
+			if err := test.do(); !errors.Is(err, test.want) {
+				t.Errorf("%s: %v, want %v", test.name, err, test.want)
+			}
+		}
+
+		renamed, err := s.UpdateProject(models.UpdateProjectRequest{ID: acme.ID, Name: "Acme", Client: "Acme Corp", HourlyRate: 12000, Currency: "EUR"})
+		if err != nil || renamed.Name != "Acme" || renamed.HourlyRate != 12000 {
+			t.Fatalf("UpdateProject = %+v, %v", renamed, err)
+		}
+		if projects, err := s.ListProjects(); err != nil || len(projects) != 2 || projects[0] != renamed {
+			t.Errorf("ListProjects = %+v, %v", projects, err)
+		}
+
+		// Rules attribute new sessions; the newest matching rule wins.
+		if _, err := s.CreateProjectRule(models.CreateProjectRuleRequest{ProjectID: acme.ID, Field: " Title ", Contains: "acme"}); err != nil {
+			t.Fatal(err)
+		}
+		rule, err := s.CreateProjectRule(models.CreateProjectRuleRequest{ProjectID: initech.ID, Field: "title", Contains: "tps report"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		track(t, s, start, "Docs", "ACME TPS report")
+		track(t, s, start.Add(10*time.Minute), "Docs", "acme roadmap")
+		track(t, s, start.Add(20*time.Minute), "Slack", "general")
+		projectOf := func(session models.ActivitySession) int64 {
+			if session.ProjectID == nil {
+				return 0
+			}
+			return *session.ProjectID
+		}
+		docs, slack := sessionsOf(t, s, "Docs"), sessionsOf(t, s, "Slack")
+		if projectOf(docs[0]) != initech.ID || projectOf(docs[1]) != acme.ID || projectOf(slack[0]) != 0 {
+			t.Errorf("projects = %d, %d, %d; want %d, %d, none", projectOf(docs[0]), projectOf(docs[1]), projectOf(slack[0]), initech.ID, acme.ID)
+		}
+		if err := s.DeleteProjectRule(rule.ID); err != nil {
+			t.Fatal(err)
+		}
+		if rules, err := s.ListProjectRules(); err != nil || len(rules) != 1 || rules[0].Field != models.ProjectFieldTitle {
+			t.Errorf("ListProjectRules = %+v, %v", rules, err)
+		}
+
+		// Assigning by hand overrides the rules, and nil removes the project.
+		if _, err := s.AssignProject(models.AssignProjectRequest{SessionIDs: []int64{slack[0].ID}, ProjectID: &initech.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := s.AssignProject(models.AssignProjectRequest{SessionIDs: []int64{docs[0].ID}}); err != nil || n != 1 {
+			t.Fatalf("AssignProject = %d, %v", n, err)
+		}
+		if _, err := s.AssignProject(models.AssignProjectRequest{SessionIDs: []int64{docs[0].ID}, ProjectID: ptr(99)}); !errors.Is(err, ErrProjectNotFound) {
+			t.Errorf("assigning a missing project: %v, want ErrProjectNotFound", err)
+		}
+		if _, err := s.AssignProject(models.AssignProjectRequest{}); !errors.Is(err, ErrInvalidSessionEdit) {
+			t.Errorf("assigning no sessions: %v, want ErrInvalidSessionEdit", err)
+		}
+		docs, slack = sessionsOf(t, s, "Docs"), sessionsOf(t, s, "Slack")
+		if projectOf(docs[0]) != 0 || projectOf(slack[0]) != initech.ID {
+			t.Errorf("projects after assigning = %d, %d; want none, %d", projectOf(docs[0]), projectOf(slack[0]), initech.ID)
+		}
+
+		// Deleting a project takes its rules and leaves its sessions without one.
+		if err := s.DeleteProject(acme.ID); err != nil {
+			t.Fatal(err)
+		}
+		if rules, err := s.ListProjectRules(); err != nil || len(rules) != 0 {
+			t.Errorf("rules after deleting the project = %+v, %v", rules, err)
+		}
+		if docs = sessionsOf(t, s, "Docs"); projectOf(docs[1]) != 0 {
+			t.Errorf("session still attributed to deleted project %d", projectOf(docs[1]))
+		}
+	})
+}
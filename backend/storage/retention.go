@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// ErrEmptyPurgeFilter is returned when a purge does not name an app or title
+// pattern, which would otherwise delete all history.
+var ErrEmptyPurgeFilter = errors.New("purge needs an app name or a title pattern")
+
+// RetentionPolicy limits how long history is kept. A zero duration disables
+// that part of the policy.
+type RetentionPolicy struct {
+	// DropTitlesAfter blanks window titles, URLs and working directories of
+	// older sessions while keeping their app and duration for reports.
+	DropTitlesAfter time.Duration
+	// DeleteUnclassifiedAfter and DeleteClassifiedAfter delete whole
+	// sessions, so classified history can be kept longer than noise.
+	DeleteUnclassifiedAfter time.Duration
+	DeleteClassifiedAfter   time.Duration
+	// EmptyTrashAfter permanently deletes sessions that have been in the
+	// trash this long.
+	EmptyTrashAfter time.Duration
+}
+
+// RetentionResult counts the rows changed by one retention pass.
+type RetentionResult struct {
+	TitlesDropped       int64
+	UnclassifiedDeleted int64
+	ClassifiedDeleted   int64
+	TrashEmptied        int64
+}
+
+// ApplyRetention enforces the policy relative to now in a single transaction.
+func (s *DBStore) ApplyRetention(policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	var result RetentionResult
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, err
+	}
+
+	if policy.EmptyTrashAfter > 0 {
+		result.TrashEmptied, err = emptyTrash(tx, now.Add(-policy.EmptyTrashAfter).Unix())
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+
+	if policy.DeleteUnclassifiedAfter > 0 {
+		cutoff := now.Add(-policy.DeleteUnclassifiedAfter).Unix()
+		result.UnclassifiedDeleted, err = deleteSessionsWhere(tx, "classification_id IS NULL AND start_time < $1", cutoff)
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+
+	if policy.DeleteClassifiedAfter > 0 {
+		cutoff := now.Add(-policy.DeleteClassifiedAfter).Unix()
+		result.ClassifiedDeleted, err = deleteSessionsWhere(tx, "classification_id IS NOT NULL AND start_time < $1", cutoff)
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+
+	if policy.DropTitlesAfter > 0 {
+		cutoff := now.Add(-policy.DropTitlesAfter).Unix()
+		res, err := tx.Exec(`
+			UPDATE activity_sessions SET window_title = '', title_hash = $2, url = '', cwd = ''
+			WHERE start_time < $1 AND (window_title <> '' OR url <> '' OR cwd <> '')
+		`, cutoff, s.titleHash(""))
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+		result.TitlesDropped, _ = res.RowsAffected()
+		if _, err := tx.Exec("UPDATE raw_events SET window_title = '', url = '', cwd = '' WHERE timestamp < $1", cutoff); err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+
+	return result, tx.Commit()
+}
+
+// PurgeHistory permanently deletes every session and pending raw event that
+// matches the request. With DryRun set, it only counts what would be removed.
+// Titles may be encrypted, so the title pattern is matched in Go.
+func (s *DBStore) PurgeHistory(req models.PurgeRequest) (models.PurgeResult, error) {
+	result := models.PurgeResult{DryRun: req.DryRun}
+	if req.AppName == "" && req.TitlePattern == "" {
+		return result, ErrEmptyPurgeFilter
+	}
+
+	var title *regexp.Regexp
+	if req.TitlePattern != "" {
+		title = likePattern(req.TitlePattern)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, err
+	}
+
+	sessionIDs, err := s.purgeMatches(tx, "activity_sessions", req.AppName, title, &result.TotalDuration)
+	if err != nil {
+		tx.Rollback()
+		return result, err
+	}
+	eventIDs, err := s.purgeMatches(tx, "raw_events", req.AppName, title, nil)
+	if err != nil {
+		tx.Rollback()
+		return result, err
+	}
+	result.Sessions = int64(len(sessionIDs))
+	result.RawEvents = int64(len(eventIDs))
+
+	if req.DryRun {
+		return result, tx.Rollback()
+	}
+
+	if len(sessionIDs) > 0 {
+		// Purges reach synced devices too; retention is each device's own policy.
+		st, err := nextStamp(tx)
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+		if err := tombstoneSessions(tx, sessionIDs, st); err != nil {
+			tx.Rollback()
+			return result, err
+		}
+		if err := deleteSessions(tx, sessionIDs); err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+	if len(eventIDs) > 0 {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM raw_events WHERE id IN (%s)", intSliceToString(eventIDs))); err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+	return result, tx.Commit()
+}
+
+// purgeMatches returns the IDs of rows in table matching the app name (if
+// set) and title pattern (if set). For sessions, it also adds their durations
+// to totalDuration.
+func (s *DBStore) purgeMatches(tx *sql.Tx, table, appName string, title *regexp.Regexp, totalDuration *int64) ([]int64, error) {
+	duration := "0"
+	if table == "activity_sessions" {
+		duration = "duration_seconds"
+	}
+	query := fmt.Sprintf("SELECT id, window_title, %s FROM %s", duration, table)
+	var args []interface{}
+	if appName != "" {
+		query += " WHERE app_name = $1"
+		args = append(args, appName)
+	}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id, seconds int64
+		var stored string
+		if err := rows.Scan(&id, &stored, &seconds); err != nil {
+			return nil, err
+		}
+		if title != nil {
+			plain, err := s.openTitle(stored)
+			if err != nil {
+				return nil, err
+			}
+			if !title.MatchString(plain) {
+				continue
+			}
+		}
+		ids = append(ids, id)
+		if totalDuration != nil {
+			*totalDuration += seconds
+		}
+	}
+	return ids, rows.Err()
+}
+
+// likePattern compiles a SQL LIKE pattern into an equivalent case-insensitive
+// regular expression.
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
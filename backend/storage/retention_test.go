@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func TestApplyRetention(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		now := time.Now()
+		day := 24 * time.Hour
+		track(t, s, now.Add(-40*day), "Code", "old.go")
+		track(t, s, now.Add(-40*day+time.Hour), "Slack", "old chat")
+		track(t, s, now.Add(-10*day), "Slack", "standup")
+		track(t, s, now.Add(-3*day), "Video", "cat video")
+		track(t, s, now.Add(-2*day), "Code", "new.go")
+
+		err := s.ApplyClassification(models.ClassificationRequest{AppName: "Code", WindowTitle: "old.go", UserDefinedName: "Go"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, session := range allSessions(t, s) {
+			if session.AppName == "Video" {
+				if err := s.DeleteSession(session.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		policy := RetentionPolicy{DropTitlesAfter: 7 * day, DeleteUnclassifiedAfter: 30 * day, EmptyTrashAfter: time.Hour}
+		result, err := s.ApplyRetention(policy, now.Add(2*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := RetentionResult{TitlesDropped: 2, UnclassifiedDeleted: 1, TrashEmptied: 1}
+		if result != want {
+			t.Errorf("ApplyRetention = %+v, want %+v", result, want)
+		}
+		if trash, err := s.GetTrash(); err != nil || len(trash) != 0 {
+			t.Errorf("trash = %+v, %v after emptying it", trash, err)
+		}
+
+		// Old sessions keep their app and duration for reports.
+		var got []string
+		for _, session := range allSessions(t, s) {
+			got = append(got, session.AppName+":"+session.WindowTitle)
+			if session.Duration != 60 {
+				t.Errorf("%s lost its duration: %d", session.AppName, session.Duration)
+			}
+		}
+		if want := []string{"Code:", "Slack:", "Code:new.go"}; !slices.Equal(got, want) {
+			t.Errorf("sessions = %q, want %q", got, want)
+		}
+
+		// Classified history has its own, separately enabled limit.
+		result, err = s.ApplyRetention(RetentionPolicy{DeleteClassifiedAfter: 30 * day}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != (RetentionResult{ClassifiedDeleted: 1}) {
+			t.Errorf("ApplyRetention = %+v, want one classified session deleted", result)
+		}
+		if n := len(allSessions(t, s)); n != 2 {
+			t.Errorf("%d sessions left, want 2", n)
+		}
+	})
+}
+
+func TestPurgeHistory(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		if _, err := s.PurgeHistory(models.PurgeRequest{DryRun: true}); !errors.Is(err, ErrEmptyPurgeFilter) {
+			t.Fatalf("purging without a filter: %v, want ErrEmptyPurgeFilter", err)
+		}
+
+		start := time.Now().Add(-time.Hour)
+		track(t, s, start, "Browser", "Bank - Statement")
+		track(t, s, start.Add(2*time.Minute), "Browser", "bank login")
+		track(t, s, start.Add(4*time.Minute), "Browser", "News")
+		track(t, s, start.Add(6*time.Minute), "Code", "bank.go")
+
+		// Title patterns use LIKE syntax, ignore case and span apps.
+		result, err := s.PurgeHistory(models.PurgeRequest{TitlePattern: "%BANK%", DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Sessions != 3 || result.TotalDuration != 180 || !result.DryRun {
+			t.Errorf("dry run = %+v, want 3 sessions and 180s", result)
+		}
+		if n := len(allSessions(t, s)); n != 4 {
+			t.Fatalf("a dry run deleted sessions: %d left", n)
+		}
+
+		result, err = s.PurgeHistory(models.PurgeRequest{AppName: "Browser", TitlePattern: "bank%"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Sessions != 2 || result.TotalDuration != 120 || result.DryRun {
+			t.Errorf("purge = %+v, want 2 sessions and 120s", result)
+		}
+		var got []string
+		for _, session := range allSessions(t, s) {
+			got = append(got, session.WindowTitle)
+		}
+		if want := []string{"News", "bank.go"}; !slices.Equal(got, want) {
+			t.Errorf("sessions after the purge = %q, want %q", got, want)
+		}
+
+		// Pending raw events are purged too, so they never become sessions.
+		if err := s.InsertRawEvent(models.RawEvent{Timestamp: time.Now(), AppName: "Browser", WindowTitle: "bank transfer"}); err != nil {
+			t.Fatal(err)
+		}
+		dry, err := s.PurgeHistory(models.PurgeRequest{AppName: "Browser", DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err = s.PurgeHistory(models.PurgeRequest{AppName: "Browser"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.RawEvents == 0 || result.RawEvents != dry.RawEvents || result.Sessions != 1 {
+			t.Errorf("purge = %+v after dry run %+v, want the News session and the pending events", result, dry)
+		}
+		if err := s.ProcessRawEvents(); err != nil {
+			t.Fatal(err)
+		}
+		for _, session := range allSessions(t, s) {
+			if session.AppName == "Browser" {
+				t.Errorf("purged events became session %+v", session)
+			}
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/imdawon/personalos/models"
+)
+
+var (
+	// ErrInvalidRule is returned for a classification rule whose match type
+	// or pattern cannot be used.
+	ErrInvalidRule = errors.New("invalid rule")
+	// ErrRuleNotFound is returned when updating a rule that does not exist.
+	ErrRuleNotFound = errors.New("rule not found")
+)
+
+// matcher reports whether a value matches a rule pattern.
+type matcher func(value string) bool
+
+// regexpCache holds the compiled glob and regex patterns of rules, keyed by
+// match type and pattern, so matching a session does not recompile them.
+// Rules are few, so it is never pruned.
+var regexpCache sync.Map
+
+// normalizeMatch validates a match type, defaulting an empty one.
+func normalizeMatch(kind, fallback string) (string, error) {
+	switch kind = strings.ToLower(strings.TrimSpace(kind)); kind {
+	case "":
+		return fallback, nil
+	case models.MatchExact, models.MatchExactFold, models.MatchContains, models.MatchPrefix, models.MatchGlob, models.MatchRegex:
+		return kind, nil
+	default:
+		return "", fmt.Errorf("%w: unknown match type %q (want exact, iexact, contains, prefix, glob or regex)", ErrInvalidRule, kind)
+	}
+}
+
+// defaultMatch fills in an empty match type without validating it, for rules
+// recorded before match types existed or synced from other builds.
+func defaultMatch(kind, fallback string) string {
+	if kind == "" {
+		return fallback
+	}
+	return kind
+}
+
+// compileMatcher returns a matcher for a pattern of the given match type. An
+// empty pattern matches any value, so that a rule can leave its app or title
+// unconstrained; newRuleMatcher makes sure a rule constrains something.
+func compileMatcher(kind, pattern string) (matcher, error) {
+	lower := strings.ToLower(pattern)
+	if pattern == "" {
+		if _, err := normalizeMatch(kind, ""); err != nil {
+			return nil, err
+		}
+		return func(string) bool { return true }, nil
+	}
+	switch kind {
+	case models.MatchExact:
+		return func(value string) bool { return value == pattern }, nil
+	case models.MatchExactFold:
+		return func(value string) bool { return strings.EqualFold(value, pattern) }, nil
+	case models.MatchContains:
+		return func(value string) bool { return strings.Contains(strings.ToLower(value), lower) }, nil
+	case models.MatchPrefix:
+		return func(value string) bool { return strings.HasPrefix(strings.ToLower(value), lower) }, nil
+	case models.MatchGlob, models.MatchRegex:
+		re, err := cachedRegexp(kind, pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown match type %q", ErrInvalidRule, kind)
+	}
+}
+
+func cachedRegexp(kind, pattern string) (*regexp.Regexp, error) {
+	key := kind + "\x00" + pattern
+	if re, ok := regexpCache.Load(key); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	expr := pattern
+	if kind == models.MatchGlob {
+		expr = globRegexp(pattern)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad %s pattern %q: %v", ErrInvalidRule, kind, pattern, err)
+	}
+	regexpCache.Store(key, re)
+	return re, nil
+}
+
+// globRegexp translates a glob into a case-insensitive regular expression
+// matching whole values.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// ruleMatcher matches sessions against one classification rule.
+type ruleMatcher struct {
+	rule       models.ClassificationRule
+	app, title matcher
+	cond       condition // nil when the rule has no condition
+}
+
+// newRuleMatcher compiles the patterns and condition of a rule, filling in
+// its default match types.
+func newRuleMatcher(rule models.ClassificationRule) (ruleMatcher, error) {
+	if rule.AppName == "" && rule.WindowTitleContains == "" && rule.Condition == nil {
+		return ruleMatcher{}, fmt.Errorf("%w: a rule needs an app or title pattern or a condition", ErrInvalidRule)
+	}
+	var err error
+	if rule.AppMatch, err = normalizeMatch(rule.AppMatch, models.MatchExact); err != nil {
+		return ruleMatcher{}, err
+	}
+	if rule.TitleMatch, err = normalizeMatch(rule.TitleMatch, models.MatchContains); err != nil {
+		return ruleMatcher{}, err
+	}
+	m := ruleMatcher{rule: rule}
+	if m.app, err = compileMatcher(rule.AppMatch, rule.AppName); err != nil {
+		return ruleMatcher{}, fmt.Errorf("app: %w", err)
+	}
+	if m.title, err = compileMatcher(rule.TitleMatch, rule.WindowTitleContains); err != nil {
+		return ruleMatcher{}, fmt.Errorf("title: %w", err)
+	}
+	if rule.Condition != nil {
+		if m.cond, err = compileCondition(*rule.Condition, 0); err != nil {
+			return ruleMatcher{}, fmt.Errorf("condition: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (m ruleMatcher) matches(s *models.ActivitySession) bool {
+	return m.app(s.AppName) && m.title(s.WindowTitle) && (m.cond == nil || m.cond(s))
+}
+
+// loadRuleMatchers returns the classification rules in the order they are
+// tried: highest priority first, then newest. A rule whose patterns no longer
+// compile, e.g. one synced from a newer build, is logged and skipped.
+func loadRuleMatchers(q queryer) ([]ruleMatcher, error) {
+	rows, err := q.Query(`
+		SELECT id, app_name, app_match, window_title_contains, title_match, match_condition, classification_id, priority, note
+		FROM classification_rules
+		ORDER BY priority DESC, id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matchers []ruleMatcher
+	for rows.Next() {
+		var r models.ClassificationRule
+		var cond string
+		if err := rows.Scan(&r.ID, &r.AppName, &r.AppMatch, &r.WindowTitleContains, &r.TitleMatch, &cond, &r.ClassificationID, &r.Priority, &r.Note); err != nil {
+			return nil, err
+		}
+		r.Condition, err = decodeCondition(cond)
+		var m ruleMatcher
+		if err == nil {
+			m, err = newRuleMatcher(r)
+		}
+		if err != nil {
+			log.Printf("Skipping classification rule %d: %v", r.ID, err)
+			continue
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, rows.Err()
+}
+
+// firstMatch returns the first rule in matchers that matches a session.
+func firstMatch(matchers []ruleMatcher, s *models.ActivitySession) (models.ClassificationRule, bool) {
+	for _, m := range matchers {
+		if m.matches(s) {
+			return m.rule, true
+		}
+	}
+	return models.ClassificationRule{}, false
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func TestCompileMatcher(t *testing.T) {
+	tests := []struct {
+		kind, pattern, value string
+		want                 bool
+	}{
+		{models.MatchExact, "Code", "Code", true},
+		{models.MatchExact, "Code", "code", false},
+		{models.MatchExact, "Code", "Code Insiders", false},
+		{models.MatchExactFold, "Code", "CODE", true},
+		{models.MatchExactFold, "Code", "Code Insiders", false},
+		{models.MatchContains, "readme", "README.md - Code", true},
+		{models.MatchContains, "readme", "main.go", false},
+		{models.MatchPrefix, "GoLand", "goland 2026.2", true},
+		{models.MatchPrefix, "GoLand", "JetBrains GoLand", false},
+		{models.MatchGlob, "*.go", "MAIN.GO", true},
+		{models.MatchGlob, "*.go", "main.go - Code", false},
+		{models.MatchGlob, "?oLand", "GoLand", true},
+		{models.MatchGlob, "a+b*", "a+b c", true},
+		{models.MatchGlob, "a+b*", "aab", false},
+		{models.MatchRegex, `\.go\b`, "main.go - Code", true},
+		{models.MatchRegex, `^Go`, "go", false},
+		{models.MatchRegex, `(?i)^Go`, "go", true},
+		// An empty pattern leaves the field unconstrained.
+		{models.MatchExact, "", "anything", true},
+		{models.MatchRegex, "", "", true},
+	}
+	for _, test := range tests {
+		match, err := compileMatcher(test.kind, test.pattern)
+		if err != nil {
+			t.Fatalf("compileMatcher(%q, %q): %v", test.kind, test.pattern, err)
+		}
+		if got := match(test.value); got != test.want {
+			t.Errorf("%s %q matching %q = %t, want %t", test.kind, test.pattern, test.value, got, test.want)
+		}
+	}
+
+	for _, test := range []struct{ kind, pattern string }{
+		{models.MatchRegex, "("},
+		{"fuzzy", "x"},
+		{"fuzzy", ""},
+	} {
+		if _, err := compileMatcher(test.kind, test.pattern); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("compileMatcher(%q, %q): %v, want ErrInvalidRule", test.kind, test.pattern, err)
+		}
+	}
+}
+
+func TestNewRuleMatcher(t *testing.T) {
+	m, err := newRuleMatcher(models.ClassificationRule{AppName: "Code", WindowTitleContains: ".GO", TitleMatch: " Contains "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.rule.AppMatch != models.MatchExact || m.rule.TitleMatch != models.MatchContains {
+		t.Errorf("match types = %q, %q; want exact and contains", m.rule.AppMatch, m.rule.TitleMatch)
+	}
+	if !m.matches(&models.ActivitySession{AppName: "Code", WindowTitle: "main.go"}) || m.matches(&models.ActivitySession{AppName: "code", WindowTitle: "main.go"}) {
+		t.Error("rule should match its app exactly and its title in any case")
+	}
+
+	invalid := []models.ClassificationRule{
+		{},
+		{AppName: "Code", AppMatch: "fuzzy"},
+		{WindowTitleContains: "[", TitleMatch: models.MatchRegex},
+		{Condition: &models.RuleCondition{}},
+		{Condition: &models.RuleCondition{Field: models.ConditionFieldTitle, Match: models.MatchExact}},
+	}
+	for _, rule := range invalid {
+		if _, err := newRuleMatcher(rule); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("newRuleMatcher(%+v): %v, want ErrInvalidRule", rule, err)
+		}
+	}
+}
+
+func TestRuleMatchTypes(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		track(t, s, start, "GoLand", "main.go")
+		track(t, s, start.Add(10*time.Minute), "goland", "README.md")
+		track(t, s, start.Add(20*time.Minute), "IntelliJ IDEA", "Main.java")
+
+		for _, req := range []models.CreateClassificationRuleRequest{
+			{AppName: "Code", AppMatch: "fuzzy", UserDefinedName: "Editing"},
+			{AppName: "Code", WindowTitleContains: "(", TitleMatch: models.MatchRegex, UserDefinedName: "Editing"},
+			{UserDefinedName: "Editing"},
+		} {
+			if _, err := s.CreateClassificationRule(req); !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("creating %+v: %v, want ErrInvalidRule", req, err)
+			}
+		}
+
+		apply := models.ApplyRuleOptions{ApplyToHistory: true}
+		rules := []models.CreateClassificationRuleRequest{
+			{AppName: "GoLand", UserDefinedName: "Go", ApplyRuleOptions: apply},
+			{AppName: "*idea*", AppMatch: models.MatchGlob, UserDefinedName: "Java", ApplyRuleOptions: apply},
+		}
+		for _, req := range rules {
+			if _, err := s.CreateClassificationRule(req); err != nil {
+				t.Fatal(err)
+			}
+		}
+		left := unclassified(t, s)
+		if len(left) != 1 || left[0].AppName != "goland" {
+			t.Fatalf("unclassified = %+v, want only the lower-case goland session", left)
+		}
+
+		if _, err := s.CreateClassificationRule(models.CreateClassificationRuleRequest{
+			AppName: "GOLAND", AppMatch: models.MatchExactFold, UserDefinedName: "Go", ApplyRuleOptions: apply,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if left := unclassified(t, s); len(left) != 0 {
+			t.Errorf("unclassified = %+v after an iexact rule", left)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+const (
+	// sessionGap is the longest pause between two events of the same activity
+	// before they are split into separate sessions.
+	sessionGap = 30 * time.Second
+	// minSessionSeconds drops sessions too short to be meaningful.
+	minSessionSeconds = 5
+)
+
+// sessionize groups consecutive raw events, ordered by timestamp, into sessions.
+// A new session starts whenever the activity, including its URL or working
+// directory, changes or there is a gap longer than sessionGap. Sessions of
+// minSessionSeconds or less are discarded.
+func sessionize(events []models.RawEvent) []models.ActivitySession {
+	var sessions []models.ActivitySession
+	var currentSession *models.ActivitySession
+	var lastEventTime time.Time
+
+	closeSession := func() {
+		currentSession.EndTime = lastEventTime
+		currentSession.Duration = int64(currentSession.EndTime.Sub(currentSession.StartTime).Seconds())
+		if currentSession.Duration > minSessionSeconds {
+			sessions = append(sessions, *currentSession)
+		}
+	}
+
+	for _, event := range events {
+		if currentSession != nil && (currentSession.AppName != event.AppName ||
+			currentSession.Source != eventSource(event) ||
+			currentSession.WindowTitle != event.WindowTitle ||
+			currentSession.URL != event.URL ||
+			currentSession.Cwd != event.Cwd ||
+			event.Timestamp.Sub(lastEventTime) > sessionGap) {
+			closeSession()
+			currentSession = nil
+		}
+		if currentSession == nil {
+			currentSession = &models.ActivitySession{
+				AppName:     event.AppName,
+				WindowTitle: event.WindowTitle,
+				URL:         event.URL,
+				Cwd:         event.Cwd,
+				StartTime:   event.Timestamp,
+				Source:      eventSource(event),
+			}
+		}
+		lastEventTime = event.Timestamp
+	}
+
+	// Close the very last session
+	if currentSession != nil {
+		closeSession()
+	}
+	return sessions
+}
+
+// sessionizeBySource sessionizes the events of each source separately, so
+// that watchers reporting concurrently, e.g. a window watcher and an editor
+// watcher, do not break up each other's sessions.
+func sessionizeBySource(events []models.RawEvent) []models.ActivitySession {
+	bySource := make(map[string][]models.RawEvent)
+	var sources []string
+	for _, event := range events {
+		source := eventSource(event)
+		if _, ok := bySource[source]; !ok {
+			sources = append(sources, source)
+		}
+		bySource[source] = append(bySource[source], event)
+	}
+
+	var sessions []models.ActivitySession
+	for _, source := range sources {
+		sessions = append(sessions, sessionize(bySource[source])...)
+	}
+	return sessions
+}
+
+func eventSource(event models.RawEvent) string {
+	if event.Source == "" {
+		return models.SourceTracker
+	}
+	return event.Source
+}
@@ -2,85 +2,238 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"math"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/imdawon/personalos/encryption"
 	"github.com/imdawon/personalos/models"
 
 	_ "modernc.org/sqlite" // SQLite driver
 )
 
+// ErrBackupUnsupported is returned when backing up a non-SQLite store.
+var ErrBackupUnsupported = errors.New("online backups are only supported for SQLite")
+
+// dialect identifies the SQL database behind a DBStore. Queries are written to
+// run unchanged on both; only schema DDL and maintenance differ.
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+// sqlitePragmas are applied to every pooled SQLite connection. WAL lets the
+// API read while the logger and processor write, and busy_timeout makes
+// concurrent writers wait instead of failing with SQLITE_BUSY.
+const sqlitePragmas = "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+
+// Queries on hot paths, prepared once and cached by stmt.
+const (
+	insertRawEventQuery = "INSERT INTO raw_events (timestamp, app_name, window_title, device_id, source, url, cwd) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	insertSessionQuery  = `
+		INSERT INTO activity_sessions (app_name, window_title, title_hash, start_time, end_time, duration_seconds, classification_id, device_id, source, note,
+			url, cwd, project_id, auto_classified, uid, seq, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING id
+	`
+)
+
 // DBStore handles database operations.
 type DBStore struct {
-	db *sql.DB
+	db       *sql.DB
+	dialect  dialect
+	path     string
+	deviceID string
+
+	stmtMu sync.Mutex
+	stmts  map[string]*sql.Stmt
+
+	// titleKey is the configured title encryption key, if any, and cipher the
+	// resulting Cipher. A nil cipher stores titles in plaintext.
+	titleKey *encryption.KeySource
+	cipher   *encryption.Cipher
 }
 
-// NewDBStore initializes the database connection and schema.
-func NewDBStore(filepath string) (*DBStore, error) {
-	db, err := sql.Open("sqlite", filepath)
+// NewDBStore initializes the database connection and brings the schema up to date.
+func NewDBStore(filepath string, opts ...Option) (*DBStore, error) {
+	dsn := filepath
+	if filepath != ":memory:" {
+		dsn += sqlitePragmas
+	}
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	store := &DBStore{db: db}
-	return store, store.initSchema()
-}
-
-// initSchema creates the necessary tables if they don't exist.
-func (s *DBStore) initSchema() error {
-	schema := `
-        CREATE TABLE IF NOT EXISTS raw_events (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            timestamp INTEGER NOT NULL,
-            app_name TEXT NOT NULL,
-            window_title TEXT NOT NULL
-        );
-        CREATE TABLE IF NOT EXISTS activity_sessions (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            app_name TEXT NOT NULL,
-            window_title TEXT NOT NULL,
-            start_time INTEGER NOT NULL,
-            end_time INTEGER NOT NULL,
-            duration_seconds INTEGER NOT NULL,
-            classification_id INTEGER,
-			FOREIGN KEY(classification_id) REFERENCES classifications(id)
-        );
-        CREATE TABLE IF NOT EXISTS classifications (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            user_defined_name TEXT NOT NULL UNIQUE,
-            is_helpful BOOLEAN NOT NULL,
-            goal_context TEXT NOT NULL
-        );
-        CREATE TABLE IF NOT EXISTS classification_rules (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            app_name TEXT NOT NULL,
-            window_title_contains TEXT NOT NULL,
-            classification_id INTEGER NOT NULL,
-            priority INTEGER NOT NULL DEFAULT 0,
-            FOREIGN KEY(classification_id) REFERENCES classifications(id) ON DELETE CASCADE
-        );
-    `
-	_, err := s.db.Exec(schema)
+	store := &DBStore{db: db, dialect: dialectSQLite, path: filepath}
+	if err := store.init(opts); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// init applies options, migrates the schema and sets up title encryption.
+func (s *DBStore) init(opts []Option) error {
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := s.migrate(); err != nil {
+		return err
+	}
+	if err := s.adoptUntaggedRows(); err != nil {
+		return err
+	}
+	if err := s.optimize(); err != nil {
+		return err
+	}
+	return s.initTitleEncryption()
+}
+
+// optimize refreshes SQLite's planner statistics where they are missing or
+// stale. Without them the planner guesses, and over a year of sessions it
+// picks the classification index over start_time for the summary queries.
+// PostgreSQL keeps its own statistics through autovacuum.
+func (s *DBStore) optimize() error {
+	if s.dialect != dialectSQLite {
+		return nil
+	}
+	_, err := s.db.Exec("PRAGMA optimize=0x10002")
 	return err
 }
 
+// Close releases cached statements and closes the underlying database connection.
+func (s *DBStore) Close() error {
+	s.stmtMu.Lock()
+	for _, stmt := range s.stmts {
+		stmt.Close()
+	}
+	s.stmts = nil
+	s.stmtMu.Unlock()
+
+	return s.db.Close()
+}
+
+// stmt returns a cached prepared statement for query, preparing it on first use.
+func (s *DBStore) stmt(query string) (*sql.Stmt, error) {
+	s.stmtMu.Lock()
+	defer s.stmtMu.Unlock()
+
+	if stmt, ok := s.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if s.stmts == nil {
+		s.stmts = make(map[string]*sql.Stmt)
+	}
+	s.stmts[query] = stmt
+	return stmt, nil
+}
+
+// backupTo writes a consistent copy of the live database to dest.
+func (s *DBStore) backupTo(dest string) error {
+	_, err := s.db.Exec("VACUUM INTO $1", dest)
+	return err
+}
+
+// Backup takes an online snapshot of the database into dest, which must not
+// exist yet. Only SQLite databases can be backed up this way.
+func (s *DBStore) Backup(dest string) error {
+	if s.dialect != dialectSQLite {
+		return ErrBackupUnsupported
+	}
+	return s.backupTo(dest)
+}
+
+// VerifyIntegrity runs PRAGMA integrity_check against the SQLite file at path
+// and returns an error describing the first problems found.
+func VerifyIntegrity(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("integrity check of %s failed: %w", path, err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check of %s failed: %s", path, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
 // InsertRawEvent adds a new event to the database.
 func (s *DBStore) InsertRawEvent(event models.RawEvent) error {
-	_, err := s.db.Exec("INSERT INTO raw_events (timestamp, app_name, window_title) VALUES (?, ?, ?)",
-		event.Timestamp.Unix(), event.AppName, event.WindowTitle)
-	return err
+	return s.InsertRawEvents([]models.RawEvent{event})
+}
+
+// InsertRawEvents adds a batch of events in a single transaction.
+func (s *DBStore) InsertRawEvents(events []models.RawEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	insert, err := s.stmt(insertRawEventQuery)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	txInsert := tx.Stmt(insert)
+	for _, event := range events {
+		title, err := s.cipher.Seal(event.WindowTitle)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := txInsert.Exec(event.Timestamp.Unix(), event.AppName, title, s.deviceID, eventSource(event), event.URL, event.Cwd); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 // GetUnclassifiedSessions fetches distinct activities that haven't been labeled.
 // This returns individual sessions with their start and end times.
 func (s *DBStore) GetUnclassifiedSessions() ([]models.ActivitySession, error) {
 	rows, err := s.db.Query(`
-		SELECT id, app_name, window_title, start_time, end_time, duration_seconds
+		SELECT id, app_name, window_title, start_time, end_time, duration_seconds, note
 		FROM activity_sessions
-		WHERE classification_id IS NULL
+		WHERE classification_id IS NULL AND trashed_at IS NULL
 		ORDER BY start_time DESC
 	`)
 	if err != nil {
@@ -92,14 +245,20 @@ func (s *DBStore) GetUnclassifiedSessions() ([]models.ActivitySession, error) {
 	for rows.Next() {
 		var session models.ActivitySession
 		var startTimeUnix, endTimeUnix int64
-		if err := rows.Scan(&session.ID, &session.AppName, &session.WindowTitle, &startTimeUnix, &endTimeUnix, &session.Duration); err != nil {
+		if err := rows.Scan(&session.ID, &session.AppName, &session.WindowTitle, &startTimeUnix, &endTimeUnix, &session.Duration, &session.Note); err != nil {
+			return nil, err
+		}
+		if session.WindowTitle, err = s.openTitle(session.WindowTitle); err != nil {
 			return nil, err
 		}
 		session.StartTime = time.Unix(startTimeUnix, 0)
 		session.EndTime = time.Unix(endTimeUnix, 0)
 		sessions = append(sessions, session)
 	}
-	return sessions, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, attachSessionTags(s.db, sessions)
 }
 
 // ApplyClassification assigns a classification to all matching sessions.
@@ -110,27 +269,24 @@ func (s *DBStore) ApplyClassification(req models.ClassificationRequest) error {
 	}
 
 	// 1. Find or create the classification
-	var classID int64
-	err = tx.QueryRow("SELECT id FROM classifications WHERE user_defined_name = ?", req.UserDefinedName).Scan(&classID)
-	if err == sql.ErrNoRows {
-		res, err := tx.Exec("INSERT INTO classifications (user_defined_name, is_helpful, goal_context) VALUES (?, ?, ?)",
-			req.UserDefinedName, req.IsHelpful, req.GoalContext)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		classID, _ = res.LastInsertId()
-	} else if err != nil {
+	st, err := nextStamp(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	audit := startAudit(tx, models.AuditClassify, nil)
+	classID, err := findOrCreateClassification(tx, req.UserDefinedName, req.IsHelpful, req.GoalContext, st, audit)
+	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	// 2. Update all matching sessions
-	_, err = tx.Exec(`
+	_, err = audit.classifySessions(`
 		UPDATE activity_sessions
-		SET classification_id = ?
-		WHERE app_name = ? AND window_title = ? AND classification_id IS NULL
-	`, classID, req.AppName, req.WindowTitle)
+		SET classification_id = $1, auto_classified = FALSE, seq = $4, updated_at = $5
+		WHERE app_name = $2 AND title_hash = $3 AND classification_id IS NULL AND trashed_at IS NULL
+	`, []interface{}{classID, req.AppName, s.titleHash(req.WindowTitle), st.seq, st.at}, classID)
 
 	if err != nil {
 		tx.Rollback()
@@ -152,177 +308,322 @@ func (s *DBStore) ApplyClassificationBatch(req models.BatchClassificationRequest
 	}
 
 	// 1. Find or create the classification ID.
-	var classID int64
-	err = tx.QueryRow("SELECT id FROM classifications WHERE user_defined_name = ?", req.UserDefinedName).Scan(&classID)
-	if err == sql.ErrNoRows {
-		res, err := tx.Exec("INSERT INTO classifications (user_defined_name, is_helpful, goal_context) VALUES (?, ?, ?)",
-			req.UserDefinedName, req.IsHelpful, req.GoalContext)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		classID, _ = res.LastInsertId()
-	} else if err != nil {
+	st, err := nextStamp(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	audit := startAudit(tx, models.AuditClassifyBatch, nil)
+	classID, err := findOrCreateClassification(tx, req.UserDefinedName, req.IsHelpful, req.GoalContext, st, audit)
+	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	// 2. Build a single UPDATE query for all sessions in the batch.
-	query := "UPDATE activity_sessions SET classification_id = ? WHERE classification_id IS NULL AND ("
-	args := []interface{}{classID}
+	query := "UPDATE activity_sessions SET classification_id = $1, auto_classified = FALSE, seq = $2, updated_at = $3 WHERE classification_id IS NULL AND trashed_at IS NULL AND ("
+	args := []interface{}{classID, st.seq, st.at}
 	placeholders := []string{}
 
 	for _, session := range req.Sessions {
-		placeholders = append(placeholders, "(app_name = ? AND window_title = ?)")
-		args = append(args, session.AppName, session.WindowTitle)
+		placeholders = append(placeholders, fmt.Sprintf("(app_name = $%d AND title_hash = $%d)", len(args)+1, len(args)+2))
+		args = append(args, session.AppName, s.titleHash(session.WindowTitle))
 	}
 
 	query += strings.Join(placeholders, " OR ") + ")"
 
 	// 3. Execute the batch update.
-	res, err := tx.Exec(query, args...)
+	rowsAffected, err := audit.classifySessions(query, args, classID)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	rowsAffected, _ := res.RowsAffected()
 	log.Printf("ApplyClassificationBatch updated %d rows", rowsAffected)
 
 	return tx.Commit()
 }
 
-// CreateClassificationRule creates a new rule for automatic classification.
-func (s *DBStore) CreateClassificationRule(req models.CreateClassificationRuleRequest) error {
+// CreateClassificationRule creates a new rule for automatic classification,
+// applying it to existing sessions if asked.
+func (s *DBStore) CreateClassificationRule(req models.CreateClassificationRuleRequest) (models.RuleApplyResult, error) {
+	var result models.RuleApplyResult
+	m, err := newRuleMatcher(models.ClassificationRule{
+		AppName: req.AppName, AppMatch: req.AppMatch, WindowTitleContains: req.WindowTitleContains, TitleMatch: req.TitleMatch,
+		Condition: req.Condition,
+	})
+	if err != nil {
+		return result, err
+	}
+	condition, err := encodeCondition(req.Condition)
+	if err != nil {
+		return result, err
+	}
+
 	tx, err := s.db.Begin()
 	if err != nil {
-		return err
+		return result, err
 	}
+	defer tx.Rollback()
 
 	// 1. Find or create the classification ID.
-	var classID int64
-	err = tx.QueryRow("SELECT id FROM classifications WHERE user_defined_name = ?", req.UserDefinedName).Scan(&classID)
+	st, err := nextStamp(tx)
+	if err != nil {
+		return result, err
+	}
+	classID, err := findOrCreateClassification(tx, req.UserDefinedName, req.IsHelpful, req.GoalContext, st, nil)
+	if err != nil {
+		return result, err
+	}
+
+	// 2. Insert the new rule.
+	err = tx.QueryRow(`
+		INSERT INTO classification_rules (app_name, app_match, window_title_contains, title_match, match_condition, classification_id, note, seq, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`, req.AppName, m.rule.AppMatch, req.WindowTitleContains, m.rule.TitleMatch, condition, classID, strings.TrimSpace(req.Note), st.seq, st.at).Scan(&result.RuleID)
+	if err != nil {
+		return result, err
+	}
+	if err := setRuleTags(tx, result.RuleID, normalizeTags(req.Tags)); err != nil {
+		return result, err
+	}
+
+	// A rule recreated after a deletion replaces the deletion on other devices.
+	_, err = tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneRule, ruleKey(req.AppName, req.WindowTitleContains, condition))
+	if err != nil {
+		return result, err
+	}
+
+	// 3. Classify the sessions recorded before the rule existed.
+	if err := s.finishRuleSave(tx, req.ApplyRuleOptions, st, &result); err != nil {
+		return result, err
+	}
+	if result.DryRun {
+		result.RuleID = 0
+	}
+	return result, nil
+}
+
+// UpdateClassificationRule replaces the patterns, classification, tags and
+// note of a rule, applying it to existing sessions if asked.
+func (s *DBStore) UpdateClassificationRule(req models.UpdateClassificationRuleRequest) (models.RuleApplyResult, error) {
+	var result models.RuleApplyResult
+	m, err := newRuleMatcher(models.ClassificationRule{
+		AppName: req.AppName, AppMatch: req.AppMatch, WindowTitleContains: req.WindowTitleContains, TitleMatch: req.TitleMatch,
+		Condition: req.Condition,
+	})
+	if err != nil {
+		return result, err
+	}
+	condition, err := encodeCondition(req.Condition)
+	if err != nil {
+		return result, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	var oldApp, oldContains, oldCondition string
+	err = tx.QueryRow("SELECT app_name, window_title_contains, match_condition FROM classification_rules WHERE id = $1", req.ID).
+		Scan(&oldApp, &oldContains, &oldCondition)
 	if err == sql.ErrNoRows {
-		res, err := tx.Exec("INSERT INTO classifications (user_defined_name, is_helpful, goal_context) VALUES (?, ?, ?)",
-			req.UserDefinedName, req.IsHelpful, req.GoalContext)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		classID, _ = res.LastInsertId()
+		return result, fmt.Errorf("%w: %d", ErrRuleNotFound, req.ID)
 	} else if err != nil {
-		tx.Rollback()
-		return err
+		return result, err
 	}
 
-	// 2. Insert the new rule.
-	_, err = tx.Exec(`
-		INSERT INTO classification_rules (app_name, window_title_contains, classification_id)
-		VALUES (?, ?, ?)
-	`, req.AppName, req.WindowTitleContains, classID)
+	result.RuleID = req.ID
 
+	st, err := nextStamp(tx)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return result, err
+	}
+	classID, err := findOrCreateClassification(tx, req.UserDefinedName, req.IsHelpful, req.GoalContext, st, nil)
+	if err != nil {
+		return result, err
+	}
+	_, err = tx.Exec(`
+		UPDATE classification_rules
+		SET app_name = $1, app_match = $2, window_title_contains = $3, title_match = $4, match_condition = $5, classification_id = $6,
+			note = $7, seq = $8, updated_at = $9
+		WHERE id = $10
+	`, req.AppName, m.rule.AppMatch, req.WindowTitleContains, m.rule.TitleMatch, condition, classID, strings.TrimSpace(req.Note),
+		st.seq, st.at, req.ID)
+	if err != nil {
+		return result, err
+	}
+	if err := setRuleTags(tx, req.ID, normalizeTags(req.Tags)); err != nil {
+		return result, err
 	}
 
+	// Rules are synced by their patterns, so changing them replaces the old
+	// rule with a new one on other devices.
+	if oldKey, key := ruleKey(oldApp, oldContains, oldCondition), ruleKey(req.AppName, req.WindowTitleContains, condition); oldKey != key {
+		if err := writeTombstone(tx, tombstoneRule, oldKey, st); err != nil {
+			return result, err
+		}
+		if _, err := tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneRule, key); err != nil {
+			return result, err
+		}
+	}
+
+	err = s.finishRuleSave(tx, req.ApplyRuleOptions, st, &result)
+	return result, err
+}
+
+// finishRuleSave applies the rule result.RuleID to history if asked, then
+// commits the transaction saving it, or leaves it to be rolled back on a dry
+// run.
+func (s *DBStore) finishRuleSave(tx *sql.Tx, opts models.ApplyRuleOptions, st stamp, result *models.RuleApplyResult) error {
+	result.DryRun = opts.DryRun
+	if opts.ApplyToHistory {
+		var err error
+		if result.Sessions, result.Seconds, err = s.applyRuleToHistory(tx, result.RuleID, opts.OverrideAutomatic, st); err != nil {
+			return err
+		}
+	}
+	if opts.DryRun {
+		return nil
+	}
 	return tx.Commit()
 }
 
 // ProcessRawEvents is called by the processor to aggregate events into sessions.
 func (s *DBStore) ProcessRawEvents() error {
-	// This is a simplified but effective v0 processor logic.
-	// It groups consecutive raw events into sessions.
-	rows, err := s.db.Query("SELECT id, timestamp, app_name, window_title FROM raw_events ORDER BY timestamp ASC")
+	// Only this device's events are processed, so machines sharing a database
+	// never merge each other's activity into one session.
+	rows, err := s.db.Query("SELECT id, timestamp, app_name, window_title, source, url, cwd FROM raw_events WHERE device_id = $1 ORDER BY timestamp ASC", s.deviceID)
 	if err != nil {
 		return fmt.Errorf("could not query raw events: %w", err)
 	}
-	defer rows.Close()
 
-	var currentSession *models.ActivitySession
-	var lastEventTime time.Time
+	var events []models.RawEvent
 	var idsToDelete []int64
-
 	for rows.Next() {
 		var event models.RawEvent
 		var eventID int64
 		var ts int64
-		if err := rows.Scan(&eventID, &ts, &event.AppName, &event.WindowTitle); err != nil {
+		if err := rows.Scan(&eventID, &ts, &event.AppName, &event.WindowTitle, &event.Source, &event.URL, &event.Cwd); err != nil {
 			// Log error and continue
 			continue
 		}
+		if event.WindowTitle, err = s.openTitle(event.WindowTitle); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not decrypt raw event %d: %w", eventID, err)
+		}
 		event.Timestamp = time.Unix(ts, 0)
+		events = append(events, event)
 		idsToDelete = append(idsToDelete, eventID)
+	}
+	rows.Close()
 
-		if currentSession == nil {
-			// Start the first session
-			currentSession = &models.ActivitySession{
-				AppName:     event.AppName,
-				WindowTitle: event.WindowTitle,
-				StartTime:   event.Timestamp,
-			}
-		} else if currentSession.AppName != event.AppName || currentSession.WindowTitle != event.WindowTitle || event.Timestamp.Sub(lastEventTime).Seconds() > 30 {
-			// If activity changes or there's a >30s gap, end the current session and save it.
-			currentSession.EndTime = lastEventTime
-			currentSession.Duration = int64(currentSession.EndTime.Sub(currentSession.StartTime).Seconds())
-
-			if currentSession.Duration > 5 { // Only save sessions longer than 5 seconds
-				s.saveSession(currentSession)
-			}
-
-			// Start a new session
-			currentSession = &models.ActivitySession{
-				AppName:     event.AppName,
-				WindowTitle: event.WindowTitle,
-				StartTime:   event.Timestamp,
-			}
-		}
-		lastEventTime = event.Timestamp
+	if len(events) == 0 {
+		return nil
 	}
 
-	// Save the very last session
-	if currentSession != nil {
-		currentSession.EndTime = lastEventTime
-		currentSession.Duration = int64(currentSession.EndTime.Sub(currentSession.StartTime).Seconds())
-		if currentSession.Duration > 5 {
-			s.saveSession(currentSession)
+	// Sessions are saved and their raw events removed in one transaction, so a
+	// failure never leaves events both aggregated and pending.
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	rules, err := loadSessionRules(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, session := range sessionizeBySource(events) {
+		if err := s.saveSession(tx, &session, rules); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not save session: %w", err)
 		}
 	}
 
-	// Clean up processed raw events
-	if len(idsToDelete) > 0 {
-		// In a real app, you might archive these instead of deleting.
-		// For v0, deleting is fine.
-		s.db.Exec(fmt.Sprintf("DELETE FROM raw_events WHERE id IN (%s)", intSliceToString(idsToDelete)))
+	// Clean up processed raw events. In a real app, you might archive these
+	// instead of deleting. For v0, deleting is fine.
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM raw_events WHERE id IN (%s)", intSliceToString(idsToDelete))); err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (s *DBStore) saveSession(session *models.ActivitySession) error {
-	// Check for a matching rule before saving.
-	var matchingClassID sql.NullInt64
-	err := s.db.QueryRow(`
-		SELECT classification_id FROM classification_rules
-		WHERE app_name = ? AND ? LIKE '%' || window_title_contains || '%'
-		ORDER BY priority DESC, id DESC LIMIT 1
-	`, session.AppName, session.WindowTitle).Scan(&matchingClassID)
-
-	// If a rule is found, apply its classification ID to the session.
-	if err == nil && matchingClassID.Valid {
-		session.ClassificationID = &matchingClassID.Int64
-		log.Printf("Automatically classified session for '%s' using a rule.", session.AppName)
-	} else if err != nil && err != sql.ErrNoRows {
-		// Log the error but don't block saving the session.
-		log.Printf("Error checking classification rules: %v", err)
-	}
-	// If no rule is found, ClassificationID remains nil (NULL in database)
-
-	_, err = s.db.Exec(`
-		INSERT INTO activity_sessions (app_name, window_title, start_time, end_time, duration_seconds, classification_id)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, session.AppName, session.WindowTitle, session.StartTime.Unix(), session.EndTime.Unix(), session.Duration, session.ClassificationID)
-	return err
+// sessionRules are the rules saveSession applies to new sessions, loaded once
+// per batch of sessions.
+type sessionRules struct {
+	matchers []ruleMatcher
+	tags     map[int64][]string
+	projects []models.ProjectRule
+}
+
+// loadSessionRules loads the classification rules, their tags and the project
+// rules.
+func loadSessionRules(tx *sql.Tx) (*sessionRules, error) {
+	var r sessionRules
+	var err error
+	if r.matchers, err = loadRuleMatchers(tx); err != nil {
+		return nil, err
+	}
+	if r.tags, err = loadTags(tx, "SELECT rule_id, tag FROM rule_tags ORDER BY tag"); err != nil {
+		return nil, err
+	}
+	if r.projects, err = loadProjectRules(tx); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// saveSession inserts a session, first classifying and tagging it with the
+// matching rule and attributing it to a project, unless rules is nil.
+func (s *DBStore) saveSession(tx *sql.Tx, session *models.ActivitySession, rules *sessionRules) error {
+	insertSession, err := s.stmt(insertSessionQuery)
+	if err != nil {
+		return err
+	}
+
+	if rules != nil {
+		// If a rule is found, apply its classification ID, tags and note to the session.
+		if rule, ok := firstMatch(rules.matchers, session); ok {
+			classID := rule.ClassificationID
+			session.ClassificationID = &classID
+			session.AutoClassified = true
+			applyRuleTags(session, rules.tags[rule.ID], rule.Note)
+			log.Printf("Automatically classified session for '%s' using a rule.", session.AppName)
+		}
+		// If no rule is found, ClassificationID remains nil (NULL in database)
+
+		if session.ProjectID == nil {
+			session.ProjectID = matchProjectRule(rules.projects, *session)
+		}
+	}
+
+	if session.Source == "" {
+		session.Source = models.SourceTracker
+	}
+	title, hash, err := s.sealTitle(session.WindowTitle)
+	if err != nil {
+		return err
+	}
+	uid, err := newUID()
+	if err != nil {
+		return err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return err
+	}
+	err = tx.Stmt(insertSession).QueryRow(session.AppName, title, hash, session.StartTime.Unix(), session.EndTime.Unix(),
+		session.Duration, session.ClassificationID, s.deviceID, session.Source, session.Note, session.URL, session.Cwd, session.ProjectID,
+		session.AutoClassified, uid, st.seq, st.at).Scan(&session.ID)
+	if err != nil {
+		return err
+	}
+	return addSessionTags(tx, session.ID, normalizeTags(session.Tags))
 }
 
 // Helper to format integer slice for SQL IN clause
@@ -341,63 +642,82 @@ func intSliceToString(ids []int64) string {
 // In storage/sqlite.go
 
 // TodaySummaryItem represents a single aggregated activity for the dashboard.
+// TotalDuration includes the classifications nested under it, listed in
+// Children; OwnDuration is the time classified as the item itself.
 type TodaySummaryItem struct {
-	UserDefinedName string `json:"user_defined_name"`
-	TotalDuration   int64  `json:"total_duration_seconds"`
+	ID              int64              `json:"id"`
+	UserDefinedName string             `json:"user_defined_name"`
+	TotalDuration   int64              `json:"total_duration_seconds"`
+	OwnDuration     int64              `json:"own_duration_seconds"`
+	Children        []TodaySummaryItem `json:"children,omitempty"`
 }
 
-// GetTodaySummary fetches aggregated, classified data for the current day.
-func (s *DBStore) GetTodaySummary() ([]TodaySummaryItem, error) {
-	// Get the Unix timestamp for the start of the current day in UTC.
-	// NOTE: For more complex timezone handling, this would need adjustment.
-	// For v0, UTC is fine.
-	startOfDay := time.Now().UTC().Truncate(24 * time.Hour)
+// ReportFilter selects the sessions a report covers.
+type ReportFilter struct {
+	// AllDevices includes sessions synced from other devices; by default
+	// reports cover only this one.
+	AllDevices bool
+	// Tag limits the report to sessions carrying the tag, if set.
+	Tag string
+}
 
+// durationByClassification sums the durations of classified sessions
+// starting at or after since, per classification ID.
+func (s *DBStore) durationByClassification(since time.Time, filter ReportFilter) (map[int64]int64, error) {
 	rows, err := s.db.Query(`
-        SELECT
-            c.user_defined_name,
-            SUM(s.duration_seconds) as total_duration
-        FROM activity_sessions s
-        JOIN classifications c ON s.classification_id = c.id
-        WHERE s.start_time >= ? AND s.classification_id IS NOT NULL 
-        GROUP BY c.user_defined_name
-        HAVING total_duration > 0 
-        ORDER BY total_duration DESC;
-    `, startOfDay.Unix()) // Make sure start_time is compared correctly
+		SELECT s.classification_id, SUM(s.duration_seconds)
+		FROM activity_sessions s
+		WHERE s.start_time >= $1 AND s.classification_id IS NOT NULL AND s.trashed_at IS NULL
+			AND ($2 OR s.device_id = $3)
+			AND ($4 = '' OR EXISTS (SELECT 1 FROM session_tags t WHERE t.session_id = s.id AND t.tag = $4))
+		GROUP BY s.classification_id
+	`, since.Unix(), filter.AllDevices, s.deviceID, filter.Tag)
 	if err != nil {
-		log.Printf("Error querying today summary: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	// Initialize as an empty slice, not nil, to ensure JSON [] for no results
-	summary := make([]TodaySummaryItem, 0)
+	totals := make(map[int64]int64)
 	for rows.Next() {
-		var item TodaySummaryItem
-		if err := rows.Scan(&item.UserDefinedName, &item.TotalDuration); err != nil {
-			log.Printf("Error scanning today summary item: %v", err)
-			// Decide if you want to return partial results or error out
+		var id, total int64
+		if err := rows.Scan(&id, &total); err != nil {
 			return nil, err
 		}
-		summary = append(summary, item)
+		totals[id] = total
+	}
+	return totals, rows.Err()
+}
+
+// GetTodaySummary fetches aggregated, classified data for the current day,
+// with time rolled up into parent classifications.
+func (s *DBStore) GetTodaySummary(filter ReportFilter) ([]TodaySummaryItem, error) {
+	// Get the Unix timestamp for the start of the current day in UTC.
+	// NOTE: For more complex timezone handling, this would need adjustment.
+	// For v0, UTC is fine.
+	startOfDay := time.Now().UTC().Truncate(24 * time.Hour)
+
+	totals, err := s.durationByClassification(startOfDay, filter)
+	if err != nil {
+		log.Printf("Error querying today summary: %v", err)
+		return nil, err
 	}
-	if err := rows.Err(); err != nil { // Check for errors during iteration
-		log.Printf("Error during rows iteration for today summary: %v", err)
+	classifications, err := s.ListClassifications()
+	if err != nil {
 		return nil, err
 	}
 
-	// This is defensive. If summary is still nil, return an empty slice.
+	// Initialize as an empty slice, not nil, to ensure JSON [] for no results
+	summary := summaryTree(buildClassificationTree(classifications, totals))
 	if summary == nil {
 		return make([]TodaySummaryItem, 0), nil
 	}
-
 	return summary, nil
 }
 
 // GetClassificationRules retrieves all rules, joined with their classification names.
 func (s *DBStore) GetClassificationRules() ([]models.RuleInfo, error) {
 	rows, err := s.db.Query(`
-		SELECT r.id, r.app_name, r.window_title_contains, c.user_defined_name
+		SELECT r.id, r.app_name, r.app_match, r.window_title_contains, r.title_match, r.match_condition, c.user_defined_name, r.note
 		FROM classification_rules r
 		JOIN classifications c ON r.classification_id = c.id
 		ORDER BY r.id DESC
@@ -410,11 +730,25 @@ func (s *DBStore) GetClassificationRules() ([]models.RuleInfo, error) {
 	var rules []models.RuleInfo
 	for rows.Next() {
 		var rule models.RuleInfo
-		if err := rows.Scan(&rule.ID, &rule.AppName, &rule.WindowTitleContains, &rule.UserDefinedName); err != nil {
+		var condition string
+		if err := rows.Scan(&rule.ID, &rule.AppName, &rule.AppMatch, &rule.WindowTitleContains, &rule.TitleMatch, &condition, &rule.UserDefinedName, &rule.Note); err != nil {
+			return nil, err
+		}
+		if rule.Condition, err = decodeCondition(condition); err != nil {
 			return nil, err
 		}
 		rules = append(rules, rule)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	tags, err := loadTags(s.db, "SELECT rule_id, tag FROM rule_tags ORDER BY tag")
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rules[i].Tags = tags[rules[i].ID]
+	}
 	if rules == nil {
 		return make([]models.RuleInfo, 0), nil
 	}
@@ -423,8 +757,33 @@ func (s *DBStore) GetClassificationRules() ([]models.RuleInfo, error) {
 
 // DeleteClassificationRule removes a rule by its ID.
 func (s *DBStore) DeleteClassificationRule(id int64) error {
-	_, err := s.db.Exec("DELETE FROM classification_rules WHERE id = ?", id)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	snap, err := loadRuleSnapshot(tx, id)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	audit := startAudit(tx, models.AuditDeleteRule, nil)
+	if err := audit.record(auditRule, id, snap, nil); err != nil {
+		return err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return err
+	}
+	if err := writeTombstone(tx, tombstoneRule, ruleKey(snap.AppName, snap.WindowTitleContains, snap.Condition), st); err != nil {
+		return err
+	}
+	if err := deleteRulesWhere(tx, "id = $1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetRecentClassifiedSessions retrieves the 50 most recently classified sessions.
@@ -435,10 +794,11 @@ func (s *DBStore) GetRecentClassifiedSessions() ([]models.RecentActivityInfo, er
 			s.app_name,
 			s.window_title,
 			c.user_defined_name,
-			s.start_time
+			s.start_time,
+			s.auto_classified
 		FROM activity_sessions s
 		JOIN classifications c ON s.classification_id = c.id
-		WHERE s.classification_id IS NOT NULL
+		WHERE s.classification_id IS NOT NULL AND s.trashed_at IS NULL
 		ORDER BY s.start_time DESC
 		LIMIT 50
 	`)
@@ -450,12 +810,12 @@ func (s *DBStore) GetRecentClassifiedSessions() ([]models.RecentActivityInfo, er
 	var activities []models.RecentActivityInfo
 	for rows.Next() {
 		var activity models.RecentActivityInfo
-		if err := rows.Scan(&activity.SessionID, &activity.AppName, &activity.WindowTitle, &activity.UserDefinedName, &activity.StartTime); err != nil {
+		if err := rows.Scan(&activity.SessionID, &activity.AppName, &activity.WindowTitle, &activity.UserDefinedName, &activity.StartTime, &activity.IsAuto); err != nil {
+			return nil, err
+		}
+		if activity.WindowTitle, err = s.openTitle(activity.WindowTitle); err != nil {
 			return nil, err
 		}
-		// NOTE: is_auto is not yet implemented in the DB, defaulting to false for now.
-		// This can be enhanced later if we add a column to track how a session was classified.
-		activity.IsAuto = false
 		activities = append(activities, activity)
 	}
 
@@ -473,27 +833,37 @@ func (s *DBStore) ReclassifySession(req models.ReclassifyRequest) error {
 	}
 
 	// 1. Find or create the classification
-	var classID int64
-	err = tx.QueryRow("SELECT id FROM classifications WHERE user_defined_name = ?", req.UserDefinedName).Scan(&classID)
-	if err == sql.ErrNoRows {
-		res, err := tx.Exec("INSERT INTO classifications (user_defined_name, is_helpful, goal_context) VALUES (?, ?, ?)",
-			req.UserDefinedName, req.IsHelpful, req.GoalContext)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		classID, _ = res.LastInsertId()
-	} else if err != nil {
+	st, err := nextStamp(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	audit := startAudit(tx, models.AuditReclassify, nil)
+	classID, err := findOrCreateClassification(tx, req.UserDefinedName, req.IsHelpful, req.GoalContext, st, audit)
+	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	// 2. Update the specific session
+	var before sessionSnapshot
+	err = tx.QueryRow("SELECT classification_id, auto_classified FROM activity_sessions WHERE id = $1 AND trashed_at IS NULL", req.SessionID).
+		Scan(&before.ClassificationID, &before.AutoClassified)
+	if err == sql.ErrNoRows {
+		return tx.Commit()
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := audit.record(auditSession, req.SessionID, before, sessionSnapshot{ClassificationID: &classID}); err != nil {
+		tx.Rollback()
+		return err
+	}
 	_, err = tx.Exec(`
 		UPDATE activity_sessions
-		SET classification_id = ?
-		WHERE id = ?
-	`, classID, req.SessionID)
+		SET classification_id = $1, auto_classified = FALSE, seq = $3, updated_at = $4
+		WHERE id = $2
+	`, classID, req.SessionID, st.seq, st.at)
 
 	if err != nil {
 		tx.Rollback()
@@ -503,16 +873,16 @@ func (s *DBStore) ReclassifySession(req models.ReclassifyRequest) error {
 	return tx.Commit()
 }
 
-// DeleteSession removes a session by its ID.
+// DeleteSession moves a session to the trash.
 func (s *DBStore) DeleteSession(sessionID int64) error {
-	_, err := s.db.Exec("DELETE FROM activity_sessions WHERE id = ?", sessionID)
+	_, err := s.moveToTrash(models.AuditDeleteSession, "id = $1", sessionID)
 	return err
 }
 
 // GetExistingClassifications retrieves all existing classifications for dropdown options.
 func (s *DBStore) GetExistingClassifications() ([]models.ExistingClassification, error) {
 	rows, err := s.db.Query(`
-		SELECT DISTINCT user_defined_name, goal_context, is_helpful
+		SELECT id, user_defined_name, goal_context, is_helpful, parent_id
 		FROM classifications
 		ORDER BY user_defined_name ASC
 	`)
@@ -524,7 +894,7 @@ func (s *DBStore) GetExistingClassifications() ([]models.ExistingClassification,
 	var classifications []models.ExistingClassification
 	for rows.Next() {
 		var classification models.ExistingClassification
-		if err := rows.Scan(&classification.UserDefinedName, &classification.GoalContext, &classification.IsHelpful); err != nil {
+		if err := rows.Scan(&classification.ID, &classification.UserDefinedName, &classification.GoalContext, &classification.IsHelpful, &classification.ParentID); err != nil {
 			return nil, err
 		}
 		classifications = append(classifications, classification)
@@ -559,41 +929,19 @@ func computeLevel(totalXP int64) (level int, currentXP int64, xpForNextLevel int
 }
 
 // GetSkillProgress aggregates all classified activity and returns the XP/level progress per skill (classification).
-// XP is calculated as 1 XP per minute of classified time.
-func (s *DBStore) GetSkillProgress() ([]models.SkillProgress, error) {
-	rows, err := s.db.Query(`
-		SELECT c.user_defined_name, SUM(s.duration_seconds) as total_duration
-		FROM activity_sessions s
-		JOIN classifications c ON s.classification_id = c.id
-		GROUP BY c.user_defined_name
-	`)
+// XP is calculated as 1 XP per minute of classified time. Parent skills level up from the time of the skills nested
+// under them, which are returned as their children.
+func (s *DBStore) GetSkillProgress(filter ReportFilter) ([]models.SkillProgress, error) {
+	totals, err := s.durationByClassification(time.Time{}, filter)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	skills := make([]models.SkillProgress, 0)
-
-	for rows.Next() {
-		var name string
-		var totalSeconds int64
-		if err := rows.Scan(&name, &totalSeconds); err != nil {
-			return nil, err
-		}
-
-		// XP conversion: 1 minute = 1 XP
-		totalXP := totalSeconds / 60
-		level, currentXP, xpNext := computeLevel(totalXP)
-
-		skills = append(skills, models.SkillProgress{
-			UserDefinedName: name,
-			Level:           level,
-			CurrentXP:       currentXP,
-			XPForNextLevel:  xpNext,
-			TotalXP:         totalXP,
-		})
+	classifications, err := s.ListClassifications()
+	if err != nil {
+		return nil, err
 	}
 
+	skills := skillTree(buildClassificationTree(classifications, totals))
 	if skills == nil {
 		return make([]models.SkillProgress, 0), nil
 	}
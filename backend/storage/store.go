@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// EventWriter persists raw events captured by the tracker.
+type EventWriter interface {
+	InsertRawEvent(event models.RawEvent) error
+	InsertRawEvents(events []models.RawEvent) error
+}
+
+// SessionStore turns raw events into sessions and manages their classification.
+type SessionStore interface {
+	ProcessRawEvents() error
+	GetUnclassifiedSessions() ([]models.ActivitySession, error)
+	GetRecentClassifiedSessions() ([]models.RecentActivityInfo, error)
+	ApplyClassification(req models.ClassificationRequest) error
+	ApplyClassificationBatch(req models.BatchClassificationRequest) error
+	ReclassifySession(req models.ReclassifyRequest) error
+	DeleteSession(sessionID int64) error
+}
+
+// SessionEditor corrects the boundaries of recorded sessions. Edits that
+// would make sessions from the same device and source overlap are rejected.
+type SessionEditor interface {
+	AdjustSession(req models.AdjustSessionRequest) (models.ActivitySession, error)
+	SplitSession(req models.SplitSessionRequest) ([]models.ActivitySession, error)
+	MergeSessions(req models.MergeSessionsRequest) (models.ActivitySession, error)
+}
+
+// ManualEntryWriter records activities the tracker cannot see.
+type ManualEntryWriter interface {
+	CreateManualSession(req models.ManualEntryRequest, now time.Time) (models.ActivitySession, error)
+}
+
+// ClassificationStore edits classifications directly. Classifications are
+// also created implicitly by the first request that names them.
+type ClassificationStore interface {
+	CreateClassification(req models.CreateClassificationRequest) (models.Classification, error)
+	UpdateClassification(req models.UpdateClassificationRequest) (models.Classification, error)
+	DeleteClassification(id int64) error
+	MergeClassifications(req models.MergeClassificationsRequest) (models.Classification, error)
+}
+
+// TrashStore manages sessions moved to the trash by DeleteSession or a bulk
+// delete. Trashed sessions are left out of every other query until they are
+// restored or the retention policy empties the trash.
+type TrashStore interface {
+	TrashSessions(req models.BulkDeleteRequest) (int64, error)
+	GetTrash() ([]models.ActivitySession, error)
+	RestoreSessions(ids []int64) (int64, error)
+}
+
+// ProjectStore manages the projects that sessions are billed to and the rules
+// that attribute new sessions to them.
+type ProjectStore interface {
+	CreateProject(req models.CreateProjectRequest) (models.Project, error)
+	UpdateProject(req models.UpdateProjectRequest) (models.Project, error)
+	DeleteProject(id int64) error
+	CreateProjectRule(req models.CreateProjectRuleRequest) (models.ProjectRule, error)
+	DeleteProjectRule(id int64) error
+	AssignProject(req models.AssignProjectRequest) (int64, error)
+}
+
+// AuditStore exposes the change log of classification changes and deletions
+// and reverts logged operations.
+type AuditStore interface {
+	GetAuditLog(limit int) ([]models.AuditOperation, error)
+	Undo(count int) ([]models.AuditOperation, error)
+	GetSessionHistory(sessionID int64) ([]models.SessionHistoryEntry, error)
+}
+
+// TagStore tags and annotates sessions and searches them by text and tag.
+type TagStore interface {
+	TagSessions(req models.TagSessionsRequest) (int64, error)
+	SetSessionNote(req models.SessionNoteRequest) (models.ActivitySession, error)
+	SearchSessions(search SessionSearch) ([]models.ActivitySession, error)
+}
+
+// SessionImporter stores sessions imported from other tracking tools.
+type SessionImporter interface {
+	ImportSessions(sessions []models.ActivitySession, opts ImportOptions) (ImportResult, error)
+}
+
+// RuleStore manages the rules used for automatic classification.
+type RuleStore interface {
+	CreateClassificationRule(req models.CreateClassificationRuleRequest) (models.RuleApplyResult, error)
+	UpdateClassificationRule(req models.UpdateClassificationRuleRequest) (models.RuleApplyResult, error)
+	GetClassificationRules() ([]models.RuleInfo, error)
+	DeleteClassificationRule(id int64) error
+}
+
+// StatsReader serves the aggregated, read-only views used by the dashboard.
+type StatsReader interface {
+	GetTodaySummary(filter ReportFilter) ([]TodaySummaryItem, error)
+	GetExistingClassifications() ([]models.ExistingClassification, error)
+	GetSkillProgress(filter ReportFilter) ([]models.SkillProgress, error)
+	GetTagSummary(filter ReportFilter) ([]TagSummaryItem, error)
+}
+
+// RetentionStore enforces retention policies and purges history on request.
+type RetentionStore interface {
+	ApplyRetention(policy RetentionPolicy, now time.Time) (RetentionResult, error)
+	PurgeHistory(req models.PurgeRequest) (models.PurgeResult, error)
+}
+
+// AwayStore records periods in which tracking was deliberately paused.
+type AwayStore interface {
+	RecordAwayPeriod(period models.AwayPeriod) error
+	GetAwayPeriods(start, end time.Time) ([]models.AwayPeriod, error)
+}
+
+// ExportReader reads complete history for exports. Sessions are streamed so
+// large ranges do not have to fit in memory; zero times leave a range open.
+type ExportReader interface {
+	EachSession(filter SessionFilter, fn func(models.ActivitySession) error) error
+	ListClassifications() ([]models.Classification, error)
+	ListRules() ([]models.ClassificationRule, error)
+	ListProjects() ([]models.Project, error)
+	ListProjectRules() ([]models.ProjectRule, error)
+	GetAwayPeriods(start, end time.Time) ([]models.AwayPeriod, error)
+}
+
+// BucketStore keeps the ActivityWatch buckets registered by external watchers.
+type BucketStore interface {
+	CreateBucket(bucket models.Bucket) (bool, error)
+	GetBuckets() ([]models.Bucket, error)
+	GetBucket(id string) (models.Bucket, error)
+}
+
+// SyncStore exchanges changes with another PersonalOS instance. Only the
+// database stores support it.
+type SyncStore interface {
+	DeviceID() string
+	ChangesSince(seq int64) (models.SyncBatch, error)
+	MergeChanges(batch models.SyncBatch) (models.SyncResult, error)
+	SyncCursor(peer string) (pulled, pushed int64, err error)
+	SetSyncCursor(peer string, pulled, pushed int64) error
+}
+
+// Store is the full storage backend used by the application.
+type Store interface {
+	EventWriter
+	SessionStore
+	SessionEditor
+	ManualEntryWriter
+	TagStore
+	TrashStore
+	AuditStore
+	SessionImporter
+	RuleStore
+	ClassificationStore
+	ProjectStore
+	StatsReader
+	RetentionStore
+	AwayStore
+	ExportReader
+	BucketStore
+	Close() error
+}
+
+var (
+	_ Store = (*DBStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+
+	// MemoryStore keeps no sequence numbers or tombstones, so it does not
+	// implement SyncStore; sync is tested against DBStore instead.
+	_ SyncStore = (*DBStore)(nil)
+)
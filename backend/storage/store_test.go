@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func TestMain(m *testing.M) {
+	// The stores log every migration and automatic classification.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestDB opens a migrated SQLite store in a temporary directory.
+func newTestDB(t *testing.T, opts ...Option) *DBStore {
+	t.Helper()
+	s, err := NewDBStore(filepath.Join(t.TempDir(), "test.db"), opts...)
+	if err != nil {
+		t.Fatalf("NewDBStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// eachStore runs a test against a SQLite and an in-memory store.
+func eachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("sqlite", func(t *testing.T) { test(t, newTestDB(t)) })
+	t.Run("memory", func(t *testing.T) { test(t, NewMemoryStore()) })
+}
+
+// track records a minute of activity starting at start, one event every ten
+// seconds, and processes it into a session.
+func track(t *testing.T, s Store, start time.Time, app, title string) {
+	t.Helper()
+	var events []models.RawEvent
+	for i := 0; i <= 6; i++ {
+		events = append(events, models.RawEvent{Timestamp: start.Add(time.Duration(i) * 10 * time.Second), AppName: app, WindowTitle: title})
+	}
+	if err := s.InsertRawEvents(events); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ProcessRawEvents(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// classificationID looks up a classification by name.
+func classificationID(t *testing.T, s Store, name string) int64 {
+	t.Helper()
+	all, err := s.ListClassifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range all {
+		if c.UserDefinedName == name {
+			return c.ID
+		}
+	}
+	t.Fatalf("no classification %q", name)
+	return 0
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+func stringPtr(s string) *string { return &s }
+
+// unclassified returns the unclassified sessions, failing the test on error.
+func unclassified(t *testing.T, s Store) []models.ActivitySession {
+	t.Helper()
+	sessions, err := s.GetUnclassifiedSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessions
+}
+
+// allSessions returns every session outside the trash, oldest first.
+func allSessions(t *testing.T, s Store) []models.ActivitySession {
+	t.Helper()
+	var sessions []models.ActivitySession
+	err := s.EachSession(SessionFilter{}, func(session models.ActivitySession) error {
+		sessions = append(sessions, session)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessions
+}
+
+func TestStoreBasics(t *testing.T) {
+	eachStore(t, exerciseStore)
+}
+
+// exerciseStore runs the core operations every Store must support the same
+// way: processing events, classifying sessions manually and by rule,
+// summaries, deleting classifications, and the trash.
+func exerciseStore(t *testing.T, s Store) {
+	today := time.Now().UTC().Truncate(24 * time.Hour).Add(time.Minute)
+	track(t, s, today, "Code", "main.go")
+	track(t, s, today.Add(time.Hour), "Slack", "general")
+
+	if n := len(unclassified(t, s)); n != 2 {
+		t.Fatalf("%d unclassified sessions, want 2", n)
+	}
+
+	err := s.ApplyClassification(models.ClassificationRequest{AppName: "Code", WindowTitle: "main.go", UserDefinedName: "Go", IsHelpful: boolPtr(true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := unclassified(t, s)
+	if len(left) != 1 || left[0].AppName != "Slack" {
+		t.Fatalf("unclassified = %+v, want the Slack session", left)
+	}
+
+	for _, filter := range []ReportFilter{{}, {AllDevices: true}} {
+		summary, err := s.GetTodaySummary(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(summary) != 1 || summary[0].UserDefinedName != "Go" || summary[0].TotalDuration != 60 {
+			t.Errorf("summary(%+v) = %+v, want 60s of Go", filter, summary)
+		}
+	}
+
+	result, err := s.CreateClassificationRule(models.CreateClassificationRuleRequest{
+		AppName: "Slack", UserDefinedName: "Chat", ApplyRuleOptions: models.ApplyRuleOptions{ApplyToHistory: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Sessions != 1 || result.Seconds != 60 {
+		t.Errorf("applying the rule = %+v, want one 60s session", result)
+	}
+	if n := len(unclassified(t, s)); n != 0 {
+		t.Errorf("%d unclassified sessions after applying the rule", n)
+	}
+
+	// Deleting a classification unclassifies its sessions and drops its rules.
+	if err := s.DeleteClassification(classificationID(t, s, "Chat")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(unclassified(t, s)); n != 1 {
+		t.Errorf("%d unclassified sessions after deleting Chat, want 1", n)
+	}
+	rules, err := s.GetClassificationRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 0 {
+		t.Errorf("rules = %+v after deleting their classification", rules)
+	}
+
+	// The trash hides sessions until they are restored.
+	slack := unclassified(t, s)[0]
+	if err := s.DeleteSession(slack.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(unclassified(t, s)); n != 0 {
+		t.Errorf("a trashed session is still listed")
+	}
+	if restored, err := s.RestoreSessions([]int64{slack.ID}); err != nil || restored != 1 {
+		t.Errorf("RestoreSessions = %d, %v", restored, err)
+	}
+	if n := len(unclassified(t, s)); n != 1 {
+		t.Errorf("%d unclassified sessions after restoring, want 1", n)
+	}
+}
+
+func TestClassificationSettings(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		track(t, s, time.Now().Add(-time.Hour), "Code", "main.go")
+		session := allSessions(t, s)[0]
+
+		// A classification created without settings gets the defaults.
+		if err := s.ApplyClassification(models.ClassificationRequest{AppName: "Code", WindowTitle: "main.go", UserDefinedName: "Go"}); err != nil {
+			t.Fatal(err)
+		}
+		batch := models.BatchClassificationRequest{
+			Sessions:        []models.SessionIdentifier{{AppName: "Code", WindowTitle: "main.go"}},
+			UserDefinedName: "Docs", IsHelpful: boolPtr(true), GoalContext: stringPtr("Learn"),
+		}
+		if err := s.ApplyClassificationBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		all, err := s.ListClassifications()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]models.Classification{
+			"Go":   {UserDefinedName: "Go"},
+			"Docs": {UserDefinedName: "Docs", IsHelpful: true, GoalContext: "Learn"},
+		}
+		for _, c := range all {
+			c.ID = 0
+			if c != want[c.UserDefinedName] {
+				t.Errorf("classification %+v, want %+v", c, want[c.UserDefinedName])
+			}
+		}
+		if len(all) != len(want) {
+			t.Errorf("%d classifications, want %d", len(all), len(want))
+		}
+
+		// Naming an existing classification conflicts only over the settings
+		// a request gives.
+		tests := []struct {
+			isHelpful   *bool
+			goalContext *string
+			conflict    bool
+		}{
+			{nil, nil, false},
+			{boolPtr(true), nil, false},
+			{nil, stringPtr("Learn"), false},
+			{boolPtr(true), stringPtr("Learn"), false},
+			{boolPtr(false), nil, true},
+			{nil, stringPtr("Work"), true},
+			{boolPtr(true), stringPtr(""), true},
+		}
+		for _, test := range tests {
+			req := models.ReclassifyRequest{SessionID: session.ID, UserDefinedName: "Docs", IsHelpful: test.isHelpful, GoalContext: test.goalContext}
+			err := s.ReclassifySession(req)
+			if test.conflict != errors.Is(err, ErrClassificationConflict) || (!test.conflict && err != nil) {
+				t.Errorf("reclassifying with %+v: %v, want conflict %t", req, err, test.conflict)
+			}
+		}
+		_, err = s.CreateClassificationRule(models.CreateClassificationRuleRequest{AppName: "Code", UserDefinedName: "Go", GoalContext: stringPtr("Work")})
+		if !errors.Is(err, ErrClassificationConflict) {
+			t.Errorf("rule with another goal context: %v, want ErrClassificationConflict", err)
+		}
+	})
+}
+
+func TestRecentClassifiedSessions(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		track(t, s, start, "Code", "main.go")
+		if _, err := s.CreateClassificationRule(models.CreateClassificationRuleRequest{AppName: "Slack", UserDefinedName: "Chat"}); err != nil {
+			t.Fatal(err)
+		}
+		track(t, s, start.Add(10*time.Minute), "Slack", "general")
+		track(t, s, start.Add(20*time.Minute), "Mail", "inbox")
+		if err := s.ApplyClassification(models.ClassificationRequest{AppName: "Code", WindowTitle: "main.go", UserDefinedName: "Go"}); err != nil {
+			t.Fatal(err)
+		}
+
+		recent, err := s.GetRecentClassifiedSessions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recent) != 2 {
+			t.Fatalf("recent = %+v, want the Slack and Code sessions", recent)
+		}
+		if slack := recent[0]; slack.AppName != "Slack" || slack.UserDefinedName != "Chat" || !slack.IsAuto || slack.StartTime != start.Add(10*time.Minute).Unix() {
+			t.Errorf("recent[0] = %+v, want Slack classified by its rule", slack)
+		}
+		if code := recent[1]; code.AppName != "Code" || code.UserDefinedName != "Go" || code.IsAuto {
+			t.Errorf("recent[1] = %+v, want Code classified by hand", code)
+		}
+
+		// Reclassifying by hand makes it manual.
+		if err := s.ReclassifySession(models.ReclassifyRequest{SessionID: recent[0].SessionID, UserDefinedName: "Chat"}); err != nil {
+			t.Fatal(err)
+		}
+		if recent, err = s.GetRecentClassifiedSessions(); err != nil || recent[0].IsAuto {
+			t.Errorf("recent after reclassifying = %+v, %v", recent, err)
+		}
+	})
+}
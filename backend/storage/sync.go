@@ -0,0 +1,929 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// ErrSyncSelf is returned when a device is asked to merge its own changes,
+// usually because a peer URL points back at this instance.
+var ErrSyncSelf = errors.New("cannot sync a device with itself")
+
+// Kinds of rows recorded in sync_tombstones.
+const (
+	tombstoneSession        = "session"
+	tombstoneRule           = "rule"
+	tombstoneClassification = "classification"
+	tombstoneProject        = "project"
+	tombstoneProjectRule    = "project_rule"
+)
+
+// stamp marks a write for sync. seq orders it among this device's changes
+// and at, in Unix milliseconds, decides conflicts: the later write wins.
+type stamp struct {
+	seq int64
+	at  int64
+}
+
+// WithDeviceID names this machine. Sessions and raw events are tagged with
+// it, so reports can be limited to one device after syncing with another.
+func WithDeviceID(id string) Option {
+	return func(s *DBStore) {
+		s.deviceID = id
+	}
+}
+
+// DeviceID returns the name this store tags its own sessions with.
+func (s *DBStore) DeviceID() string {
+	return s.deviceID
+}
+
+// adoptUntaggedRows tags rows written before the device ID was configured
+// with it, so they keep being processed and reported as this device's.
+func (s *DBStore) adoptUntaggedRows() error {
+	if s.deviceID == "" {
+		return nil
+	}
+	for _, table := range []string{"raw_events", "activity_sessions", "away_periods"} {
+		if _, err := s.db.Exec("UPDATE "+table+" SET device_id = $1 WHERE device_id = ''", s.deviceID); err != nil {
+			return fmt.Errorf("could not tag %s with the device ID: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// nextStamp takes the next sequence number for a local write. The clock row
+// stays locked until tx ends, so writes become visible in sequence order.
+func nextStamp(tx *sql.Tx) (stamp, error) {
+	st := stamp{at: time.Now().UnixMilli()}
+	err := tx.QueryRow("UPDATE sync_clock SET seq = seq + 1 WHERE id = 1 RETURNING seq").Scan(&st.seq)
+	return st, err
+}
+
+// newUID returns a random identifier for a new session.
+func newUID() (string, error) {
+	return newSalt()
+}
+
+// ruleKey identifies a rule across devices by its patterns and, if it has
+// one, its encoded condition.
+func ruleKey(appName, windowTitleContains, condition string) string {
+	key := appName + "\x1f" + windowTitleContains
+	if condition != "" {
+		key += "\x1f" + condition
+	}
+	return key
+}
+
+// syncRuleCondition decodes a stored rule condition. Stored conditions were
+// written by encodeCondition, so they always decode.
+func syncRuleCondition(condition string) *models.RuleCondition {
+	c, _ := decodeCondition(condition)
+	return c
+}
+
+// writeTombstone records that a row was deleted, so the deletion is sent to
+// peers and older copies of the row are not synced back.
+func writeTombstone(tx *sql.Tx, kind, uid string, st stamp) error {
+	_, err := tx.Exec(`
+		INSERT INTO sync_tombstones (kind, uid, seq, deleted_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (kind, uid) DO UPDATE SET seq = excluded.seq, deleted_at = excluded.deleted_at
+	`, kind, uid, st.seq, st.at)
+	return err
+}
+
+// tombstoneAt returns when a row was deleted, or false if it never was.
+func tombstoneAt(tx *sql.Tx, kind, uid string) (int64, bool, error) {
+	var at int64
+	err := tx.QueryRow("SELECT deleted_at FROM sync_tombstones WHERE kind = $1 AND uid = $2", kind, uid).Scan(&at)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return at, err == nil, err
+}
+
+// tombstoneSessions records tombstones for the sessions with the given IDs,
+// ahead of deleting them.
+func tombstoneSessions(tx *sql.Tx, ids []int64, st stamp) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := tx.Query(fmt.Sprintf("SELECT uid FROM activity_sessions WHERE id IN (%s)", intSliceToString(ids)))
+	if err != nil {
+		return err
+	}
+	var uids []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			rows.Close()
+			return err
+		}
+		uids = append(uids, uid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if err := writeTombstone(tx, tombstoneSession, uid, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncSessionQuery selects sessions in the shape scanSyncSession reads.
+const syncSessionQuery = `
+	SELECT s.id, s.uid, s.device_id, s.app_name, s.window_title, s.start_time, s.end_time,
+		s.duration_seconds, COALESCE(c.user_defined_name, ''), s.source, s.note, s.url, s.cwd, COALESCE(p.name, ''),
+		s.auto_classified, s.trashed_at, s.updated_at
+	FROM activity_sessions s
+	LEFT JOIN classifications c ON s.classification_id = c.id
+	LEFT JOIN projects p ON s.project_id = p.id
+`
+
+// scanSyncSession reads a row selected by syncSessionQuery, decrypting its
+// title. The local ID is returned for loading the session's tags.
+func (s *DBStore) scanSyncSession(row interface{ Scan(...interface{}) error }) (models.SyncSession, int64, error) {
+	var session models.SyncSession
+	var id int64
+	err := row.Scan(&id, &session.UID, &session.DeviceID, &session.AppName, &session.WindowTitle, &session.StartTime,
+		&session.EndTime, &session.Duration, &session.Classification, &session.Source, &session.Note, &session.URL, &session.Cwd,
+		&session.Project, &session.AutoClassified, &session.TrashedAt, &session.UpdatedAt)
+	if err != nil {
+		return session, id, err
+	}
+	session.WindowTitle, err = s.openTitle(session.WindowTitle)
+	return session, id, err
+}
+
+// ChangesSince returns every classification, rule and session written after
+// sequence number since, along with deletions. Titles are sent decrypted; the
+// receiving store encrypts them with its own key.
+func (s *DBStore) ChangesSince(since int64) (models.SyncBatch, error) {
+	batch := models.SyncBatch{
+		DeviceID:        s.deviceID,
+		Classifications: make([]models.SyncClassification, 0),
+		Rules:           make([]models.SyncRule, 0),
+		Projects:        make([]models.SyncProject, 0),
+		ProjectRules:    make([]models.SyncProjectRule, 0),
+		Sessions:        make([]models.SyncSession, 0),
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return batch, err
+	}
+	defer tx.Rollback()
+
+	// Every query is bounded by the clock read first, so writes that commit
+	// while the batch is read are left for the next one.
+	if err := tx.QueryRow("SELECT seq FROM sync_clock WHERE id = 1").Scan(&batch.Seq); err != nil {
+		return batch, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT c.user_defined_name, c.is_helpful, c.goal_context, COALESCE(p.user_defined_name, ''), c.updated_at
+		FROM classifications c
+		LEFT JOIN classifications p ON c.parent_id = p.id
+		WHERE c.seq > $1 AND c.seq <= $2
+		ORDER BY c.seq
+	`, since, batch.Seq)
+	if err != nil {
+		return batch, err
+	}
+	for rows.Next() {
+		var c models.SyncClassification
+		if err := rows.Scan(&c.UserDefinedName, &c.IsHelpful, &c.GoalContext, &c.ParentName, &c.UpdatedAt); err != nil {
+			rows.Close()
+			return batch, err
+		}
+		batch.Classifications = append(batch.Classifications, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return batch, err
+	}
+
+	rows, err = tx.Query(`
+		SELECT r.id, r.app_name, r.app_match, r.window_title_contains, r.title_match, r.match_condition, c.user_defined_name, r.priority,
+			r.note, r.updated_at
+		FROM classification_rules r
+		JOIN classifications c ON r.classification_id = c.id
+		WHERE r.seq > $1 AND r.seq <= $2
+		ORDER BY r.seq
+	`, since, batch.Seq)
+	if err != nil {
+		return batch, err
+	}
+	ruleTags, err := loadTags(tx, `
+		SELECT t.rule_id, t.tag
+		FROM rule_tags t
+		JOIN classification_rules r ON r.id = t.rule_id
+		WHERE r.seq > $1 AND r.seq <= $2
+		ORDER BY t.tag
+	`, since, batch.Seq)
+	if err != nil {
+		rows.Close()
+		return batch, err
+	}
+	for rows.Next() {
+		var id int64
+		var r models.SyncRule
+		var condition string
+		if err := rows.Scan(&id, &r.AppName, &r.AppMatch, &r.WindowTitleContains, &r.TitleMatch, &condition, &r.Classification, &r.Priority,
+			&r.Note, &r.UpdatedAt); err != nil {
+			rows.Close()
+			return batch, err
+		}
+		r.Condition = syncRuleCondition(condition)
+		r.Tags = ruleTags[id]
+		batch.Rules = append(batch.Rules, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return batch, err
+	}
+
+	rows, err = tx.Query("SELECT name, client, hourly_rate, currency, updated_at FROM projects WHERE seq > $1 AND seq <= $2 ORDER BY seq", since, batch.Seq)
+	if err != nil {
+		return batch, err
+	}
+	for rows.Next() {
+		var p models.SyncProject
+		if err := rows.Scan(&p.Name, &p.Client, &p.HourlyRate, &p.Currency, &p.UpdatedAt); err != nil {
+			rows.Close()
+			return batch, err
+		}
+		batch.Projects = append(batch.Projects, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return batch, err
+	}
+
+	rows, err = tx.Query(`
+		SELECT r.field, r.contains, p.name, r.updated_at
+		FROM project_rules r
+		JOIN projects p ON r.project_id = p.id
+		WHERE r.seq > $1 AND r.seq <= $2
+		ORDER BY r.seq
+	`, since, batch.Seq)
+	if err != nil {
+		return batch, err
+	}
+	for rows.Next() {
+		var r models.SyncProjectRule
+		if err := rows.Scan(&r.Field, &r.Contains, &r.Project, &r.UpdatedAt); err != nil {
+			rows.Close()
+			return batch, err
+		}
+		batch.ProjectRules = append(batch.ProjectRules, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return batch, err
+	}
+
+	sessionTags, err := loadTags(tx, `
+		SELECT t.session_id, t.tag
+		FROM session_tags t
+		JOIN activity_sessions s ON s.id = t.session_id
+		WHERE s.seq > $1 AND s.seq <= $2
+		ORDER BY t.tag
+	`, since, batch.Seq)
+	if err != nil {
+		return batch, err
+	}
+	rows, err = tx.Query(syncSessionQuery+" WHERE s.seq > $1 AND s.seq <= $2 ORDER BY s.seq", since, batch.Seq)
+	if err != nil {
+		return batch, err
+	}
+	for rows.Next() {
+		session, id, err := s.scanSyncSession(rows)
+		if err != nil {
+			rows.Close()
+			return batch, err
+		}
+		session.Tags = sessionTags[id]
+		batch.Sessions = append(batch.Sessions, session)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return batch, err
+	}
+
+	rows, err = tx.Query("SELECT kind, uid, deleted_at FROM sync_tombstones WHERE seq > $1 AND seq <= $2 ORDER BY seq", since, batch.Seq)
+	if err != nil {
+		return batch, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, uid string
+		var at int64
+		if err := rows.Scan(&kind, &uid, &at); err != nil {
+			return batch, err
+		}
+		switch kind {
+		case tombstoneSession:
+			batch.Sessions = append(batch.Sessions, models.SyncSession{UID: uid, UpdatedAt: at, Deleted: true})
+		case tombstoneRule:
+			parts := strings.SplitN(uid, "\x1f", 3)
+			r := models.SyncRule{AppName: parts[0], UpdatedAt: at, Deleted: true}
+			if len(parts) > 1 {
+				r.WindowTitleContains = parts[1]
+			}
+			if len(parts) > 2 {
+				r.Condition = syncRuleCondition(parts[2])
+			}
+			batch.Rules = append(batch.Rules, r)
+		case tombstoneClassification:
+			batch.Classifications = append(batch.Classifications, models.SyncClassification{UserDefinedName: uid, UpdatedAt: at, Deleted: true})
+		case tombstoneProject:
+			batch.Projects = append(batch.Projects, models.SyncProject{Name: uid, UpdatedAt: at, Deleted: true})
+		case tombstoneProjectRule:
+			field, contains, _ := strings.Cut(uid, "\x1f")
+			batch.ProjectRules = append(batch.ProjectRules, models.SyncProjectRule{Field: field, Contains: contains, UpdatedAt: at, Deleted: true})
+		}
+	}
+	return batch, rows.Err()
+}
+
+// wins reports whether an incoming write replaces the local one: the later
+// write wins, and ties go to the greater content so every device agrees.
+func wins(incomingAt, localAt int64, incoming, local string) bool {
+	if incomingAt != localAt {
+		return incomingAt > localAt
+	}
+	return incoming > local
+}
+
+// MergeChanges applies a batch from another device. Each row is kept or
+// replaced by last-writer-wins, so merging the same batch twice, or merging
+// in either order, gives the same result. Applied rows keep their original
+// timestamp but take a local sequence number, which forwards them to this
+// device's other peers.
+func (s *DBStore) MergeChanges(batch models.SyncBatch) (models.SyncResult, error) {
+	var result models.SyncResult
+	if batch.DeviceID != "" && batch.DeviceID == s.deviceID {
+		return result, ErrSyncSelf
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	st, err := nextStamp(tx)
+	if err != nil {
+		return result, err
+	}
+
+	var deletions []models.SyncClassification
+	for _, c := range batch.Classifications {
+		if c.Deleted {
+			deletions = append(deletions, c)
+			continue
+		}
+		applied, err := mergeClassification(tx, c, st.seq)
+		if err != nil {
+			return result, fmt.Errorf("classification %q: %w", c.UserDefinedName, err)
+		}
+		if applied {
+			result.Classifications++
+		} else {
+			result.Skipped++
+		}
+	}
+	for _, r := range batch.Rules {
+		applied, err := mergeRule(tx, r, st.seq)
+		if err != nil {
+			return result, fmt.Errorf("rule for %q: %w", r.AppName, err)
+		}
+		switch {
+		case !applied:
+			result.Skipped++
+		case r.Deleted:
+			result.Deleted++
+		default:
+			result.Rules++
+		}
+	}
+	var projectDeletions []models.SyncProject
+	for _, p := range batch.Projects {
+		if p.Deleted {
+			projectDeletions = append(projectDeletions, p)
+			continue
+		}
+		applied, err := mergeProject(tx, p, st.seq)
+		if err != nil {
+			return result, fmt.Errorf("project %q: %w", p.Name, err)
+		}
+		if applied {
+			result.Projects++
+		} else {
+			result.Skipped++
+		}
+	}
+	for _, r := range batch.ProjectRules {
+		applied, err := mergeProjectRule(tx, r, st.seq)
+		if err != nil {
+			return result, fmt.Errorf("project rule for %s %q: %w", r.Field, r.Contains, err)
+		}
+		switch {
+		case !applied:
+			result.Skipped++
+		case r.Deleted:
+			result.Deleted++
+		default:
+			result.Rules++
+		}
+	}
+	for _, session := range batch.Sessions {
+		applied, err := s.mergeSession(tx, session, st.seq)
+		if err != nil {
+			return result, fmt.Errorf("session %s: %w", session.UID, err)
+		}
+		switch {
+		case !applied:
+			result.Skipped++
+		case session.Deleted:
+			result.Deleted++
+		default:
+			result.Sessions++
+		}
+	}
+	for _, c := range deletions {
+		applied, err := mergeClassificationDeletion(tx, c, st.seq)
+		if err != nil {
+			return result, fmt.Errorf("classification %q: %w", c.UserDefinedName, err)
+		}
+		if applied {
+			result.Deleted++
+		} else {
+			result.Skipped++
+		}
+	}
+	for _, p := range projectDeletions {
+		applied, err := mergeProjectDeletion(tx, p, st.seq)
+		if err != nil {
+			return result, fmt.Errorf("project %q: %w", p.Name, err)
+		}
+		if applied {
+			result.Deleted++
+		} else {
+			result.Skipped++
+		}
+	}
+	return result, tx.Commit()
+}
+
+// mergeClassification applies an incoming classification by name.
+func mergeClassification(tx *sql.Tx, c models.SyncClassification, seq int64) (bool, error) {
+	deletedAt, deleted, err := tombstoneAt(tx, tombstoneClassification, c.UserDefinedName)
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		if deletedAt >= c.UpdatedAt {
+			return false, nil
+		}
+		if _, err := tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneClassification, c.UserDefinedName); err != nil {
+			return false, err
+		}
+	}
+
+	var local models.SyncClassification
+	err = tx.QueryRow(`
+		SELECT c.is_helpful, c.goal_context, COALESCE(p.user_defined_name, ''), c.updated_at
+		FROM classifications c
+		LEFT JOIN classifications p ON c.parent_id = p.id
+		WHERE c.user_defined_name = $1
+	`, c.UserDefinedName).Scan(&local.IsHelpful, &local.GoalContext, &local.ParentName, &local.UpdatedAt)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	content := func(c models.SyncClassification) string {
+		return fmt.Sprintf("%t\x1f%s\x1f%s", c.IsHelpful, c.GoalContext, c.ParentName)
+	}
+	if exists && !wins(c.UpdatedAt, local.UpdatedAt, content(c), content(local)) {
+		return false, nil
+	}
+
+	// A parent this device has not seen yet is created as a placeholder
+	// until its own definition arrives, possibly later in the same batch.
+	var parentID *int64
+	if c.ParentName != c.UserDefinedName {
+		if parentID, err = syncClassificationID(tx, c.ParentName, seq); err != nil {
+			return false, err
+		}
+	}
+	if !exists {
+		_, err = tx.Exec("INSERT INTO classifications (user_defined_name, is_helpful, goal_context, parent_id, seq, updated_at) VALUES ($1, $2, $3, $4, $5, $6)",
+			c.UserDefinedName, c.IsHelpful, c.GoalContext, parentID, seq, c.UpdatedAt)
+		return err == nil, err
+	}
+	_, err = tx.Exec("UPDATE classifications SET is_helpful = $1, goal_context = $2, parent_id = $3, seq = $4, updated_at = $5 WHERE user_defined_name = $6",
+		c.IsHelpful, c.GoalContext, parentID, seq, c.UpdatedAt, c.UserDefinedName)
+	return err == nil, err
+}
+
+// mergeClassificationDeletion applies an incoming classification deletion.
+// It runs after the rest of the batch, which moves the sessions, rules and
+// children of a renamed or merged classification away first. A classification changed
+// after the deletion, or still used here, is kept.
+func mergeClassificationDeletion(tx *sql.Tx, c models.SyncClassification, seq int64) (bool, error) {
+	deletedAt, deleted, err := tombstoneAt(tx, tombstoneClassification, c.UserDefinedName)
+	if err != nil || (deleted && deletedAt >= c.UpdatedAt) {
+		return false, err
+	}
+
+	var id, updatedAt int64
+	err = tx.QueryRow("SELECT id, updated_at FROM classifications WHERE user_defined_name = $1", c.UserDefinedName).Scan(&id, &updatedAt)
+	if err == nil {
+		if updatedAt > c.UpdatedAt {
+			return false, nil
+		}
+		res, err := tx.Exec(`
+			DELETE FROM classifications WHERE id = $1
+				AND NOT EXISTS (SELECT 1 FROM activity_sessions WHERE classification_id = $1)
+				AND NOT EXISTS (SELECT 1 FROM classification_rules WHERE classification_id = $1)
+				AND NOT EXISTS (SELECT 1 FROM classifications WHERE parent_id = $1)
+		`, id)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return false, nil
+		}
+	} else if err != sql.ErrNoRows {
+		return false, err
+	}
+	return true, writeTombstone(tx, tombstoneClassification, c.UserDefinedName, stamp{seq: seq, at: c.UpdatedAt})
+}
+
+// syncClassificationID returns the ID of the named classification. A name
+// this device has not seen yet is created with a zero timestamp, so its real
+// definition wins as soon as it arrives.
+func syncClassificationID(tx *sql.Tx, name string, seq int64) (*int64, error) {
+	if name == "" {
+		return nil, nil
+	}
+	var id int64
+	err := tx.QueryRow("SELECT id FROM classifications WHERE user_defined_name = $1", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow("INSERT INTO classifications (user_defined_name, is_helpful, goal_context, seq, updated_at) VALUES ($1, $2, '', $3, 0) RETURNING id",
+			name, false, seq).Scan(&id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// mergeRule applies an incoming rule or rule deletion by app and title
+// pattern and condition. Match types are part of the rule's content, and a
+// peer that does not send them means the defaults.
+func mergeRule(tx *sql.Tx, r models.SyncRule, seq int64) (bool, error) {
+	r.AppMatch = defaultMatch(r.AppMatch, models.MatchExact)
+	r.TitleMatch = defaultMatch(r.TitleMatch, models.MatchContains)
+	condition, err := encodeCondition(r.Condition)
+	if err != nil {
+		return false, err
+	}
+	key := ruleKey(r.AppName, r.WindowTitleContains, condition)
+	deletedAt, deleted, err := tombstoneAt(tx, tombstoneRule, key)
+	if err != nil {
+		return false, err
+	}
+
+	var localID int64
+	var local models.SyncRule
+	err = tx.QueryRow(`
+		SELECT r.id, r.app_match, r.title_match, c.user_defined_name, r.priority, r.note, r.updated_at
+		FROM classification_rules r
+		JOIN classifications c ON r.classification_id = c.id
+		WHERE r.app_name = $1 AND r.window_title_contains = $2 AND r.match_condition = $3
+		ORDER BY r.updated_at DESC LIMIT 1
+	`, r.AppName, r.WindowTitleContains, condition).Scan(&localID, &local.AppMatch, &local.TitleMatch, &local.Classification, &local.Priority, &local.Note, &local.UpdatedAt)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if exists {
+		tags, err := loadTags(tx, "SELECT rule_id, tag FROM rule_tags WHERE rule_id = $1 ORDER BY tag", localID)
+		if err != nil {
+			return false, err
+		}
+		local.Tags = tags[localID]
+	}
+
+	if r.Deleted {
+		if (exists && local.UpdatedAt > r.UpdatedAt) || (deleted && deletedAt >= r.UpdatedAt) {
+			return false, nil
+		}
+		if err := deleteRulesWhere(tx, "app_name = $1 AND window_title_contains = $2 AND match_condition = $3",
+			r.AppName, r.WindowTitleContains, condition); err != nil {
+			return false, err
+		}
+		return true, writeTombstone(tx, tombstoneRule, key, stamp{seq: seq, at: r.UpdatedAt})
+	}
+
+	if deleted && deletedAt >= r.UpdatedAt {
+		return false, nil
+	}
+	content := func(r models.SyncRule) string {
+		return fmt.Sprintf("%s\x1f%d\x1f%s\x1f%s\x1f%s\x1f%s", r.Classification, r.Priority, strings.Join(r.Tags, ","), r.Note, r.AppMatch, r.TitleMatch)
+	}
+	if exists && !wins(r.UpdatedAt, local.UpdatedAt, content(r), content(local)) {
+		return false, nil
+	}
+
+	classID, err := syncClassificationID(tx, r.Classification, seq)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		_, err = tx.Exec(`
+			UPDATE classification_rules
+			SET app_match = $1, title_match = $2, classification_id = $3, priority = $4, note = $5, seq = $6, updated_at = $7
+			WHERE app_name = $8 AND window_title_contains = $9 AND match_condition = $10
+		`, r.AppMatch, r.TitleMatch, classID, r.Priority, r.Note, seq, r.UpdatedAt, r.AppName, r.WindowTitleContains, condition)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO classification_rules (app_name, app_match, window_title_contains, title_match, match_condition, classification_id,
+				priority, note, seq, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`, r.AppName, r.AppMatch, r.WindowTitleContains, r.TitleMatch, condition, classID, r.Priority, r.Note, seq, r.UpdatedAt)
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := setRuleTagsByKey(tx, r.AppName, r.WindowTitleContains, condition, normalizeTags(r.Tags)); err != nil {
+		return false, err
+	}
+	if deleted {
+		_, err = tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneRule, key)
+	}
+	return err == nil, err
+}
+
+// mergeProject applies an incoming project by name.
+func mergeProject(tx *sql.Tx, p models.SyncProject, seq int64) (bool, error) {
+	deletedAt, deleted, err := tombstoneAt(tx, tombstoneProject, p.Name)
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		if deletedAt >= p.UpdatedAt {
+			return false, nil
+		}
+		if _, err := tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneProject, p.Name); err != nil {
+			return false, err
+		}
+	}
+
+	var local models.SyncProject
+	err = tx.QueryRow("SELECT client, hourly_rate, currency, updated_at FROM projects WHERE name = $1", p.Name).
+		Scan(&local.Client, &local.HourlyRate, &local.Currency, &local.UpdatedAt)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	content := func(p models.SyncProject) string {
+		return fmt.Sprintf("%s\x1f%d\x1f%s", p.Client, p.HourlyRate, p.Currency)
+	}
+	if exists && !wins(p.UpdatedAt, local.UpdatedAt, content(p), content(local)) {
+		return false, nil
+	}
+
+	if !exists {
+		_, err = tx.Exec("INSERT INTO projects (name, client, hourly_rate, currency, seq, updated_at) VALUES ($1, $2, $3, $4, $5, $6)",
+			p.Name, p.Client, p.HourlyRate, p.Currency, seq, p.UpdatedAt)
+		return err == nil, err
+	}
+	_, err = tx.Exec("UPDATE projects SET client = $1, hourly_rate = $2, currency = $3, seq = $4, updated_at = $5 WHERE name = $6",
+		p.Client, p.HourlyRate, p.Currency, seq, p.UpdatedAt, p.Name)
+	return err == nil, err
+}
+
+// mergeProjectDeletion applies an incoming project deletion after the rest of
+// the batch, like mergeClassificationDeletion. A project changed after the
+// deletion, or still used here, is kept.
+func mergeProjectDeletion(tx *sql.Tx, p models.SyncProject, seq int64) (bool, error) {
+	deletedAt, deleted, err := tombstoneAt(tx, tombstoneProject, p.Name)
+	if err != nil || (deleted && deletedAt >= p.UpdatedAt) {
+		return false, err
+	}
+
+	var id, updatedAt int64
+	err = tx.QueryRow("SELECT id, updated_at FROM projects WHERE name = $1", p.Name).Scan(&id, &updatedAt)
+	if err == nil {
+		if updatedAt > p.UpdatedAt {
+			return false, nil
+		}
+		res, err := tx.Exec(`
+			DELETE FROM projects WHERE id = $1
+				AND NOT EXISTS (SELECT 1 FROM activity_sessions WHERE project_id = $1)
+				AND NOT EXISTS (SELECT 1 FROM project_rules WHERE project_id = $1)
+		`, id)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return false, nil
+		}
+	} else if err != sql.ErrNoRows {
+		return false, err
+	}
+	return true, writeTombstone(tx, tombstoneProject, p.Name, stamp{seq: seq, at: p.UpdatedAt})
+}
+
+// syncProjectID returns the ID of the named project, creating a placeholder
+// that loses to any real definition if this device has not seen it yet. An
+// empty name means no project.
+func syncProjectID(tx *sql.Tx, name string, seq int64) (*int64, error) {
+	if name == "" {
+		return nil, nil
+	}
+	var id int64
+	err := tx.QueryRow("SELECT id FROM projects WHERE name = $1", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow("INSERT INTO projects (name, seq, updated_at) VALUES ($1, $2, 0) RETURNING id", name, seq).Scan(&id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// mergeProjectRule applies an incoming project rule or rule deletion by field
+// and pattern.
+func mergeProjectRule(tx *sql.Tx, r models.SyncProjectRule, seq int64) (bool, error) {
+	key := projectRuleKey(r.Field, r.Contains)
+	deletedAt, deleted, err := tombstoneAt(tx, tombstoneProjectRule, key)
+	if err != nil {
+		return false, err
+	}
+
+	var local models.SyncProjectRule
+	err = tx.QueryRow(`
+		SELECT p.name, r.updated_at
+		FROM project_rules r
+		JOIN projects p ON r.project_id = p.id
+		WHERE r.field = $1 AND r.contains = $2
+		ORDER BY r.updated_at DESC LIMIT 1
+	`, r.Field, r.Contains).Scan(&local.Project, &local.UpdatedAt)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	if r.Deleted {
+		if (exists && local.UpdatedAt > r.UpdatedAt) || (deleted && deletedAt >= r.UpdatedAt) {
+			return false, nil
+		}
+		if _, err := tx.Exec("DELETE FROM project_rules WHERE field = $1 AND contains = $2", r.Field, r.Contains); err != nil {
+			return false, err
+		}
+		return true, writeTombstone(tx, tombstoneProjectRule, key, stamp{seq: seq, at: r.UpdatedAt})
+	}
+
+	if deleted && deletedAt >= r.UpdatedAt {
+		return false, nil
+	}
+	if exists && !wins(r.UpdatedAt, local.UpdatedAt, r.Project, local.Project) {
+		return false, nil
+	}
+
+	projectID, err := syncProjectID(tx, r.Project, seq)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		_, err = tx.Exec("UPDATE project_rules SET project_id = $1, seq = $2, updated_at = $3 WHERE field = $4 AND contains = $5",
+			projectID, seq, r.UpdatedAt, r.Field, r.Contains)
+	} else {
+		_, err = tx.Exec("INSERT INTO project_rules (project_id, field, contains, seq, updated_at) VALUES ($1, $2, $3, $4, $5)",
+			projectID, r.Field, r.Contains, seq, r.UpdatedAt)
+	}
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		_, err = tx.Exec("DELETE FROM sync_tombstones WHERE kind = $1 AND uid = $2", tombstoneProjectRule, key)
+	}
+	return err == nil, err
+}
+
+// mergeSession applies an incoming session or session deletion by UID.
+func (s *DBStore) mergeSession(tx *sql.Tx, in models.SyncSession, seq int64) (bool, error) {
+	_, deleted, err := tombstoneAt(tx, tombstoneSession, in.UID)
+	if err != nil {
+		return false, err
+	}
+	local, id, err := s.scanSyncSession(tx.QueryRow(syncSessionQuery+" WHERE s.uid = $1", in.UID))
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if exists {
+		tags, err := loadTags(tx, "SELECT session_id, tag FROM session_tags WHERE session_id = $1 ORDER BY tag", id)
+		if err != nil {
+			return false, err
+		}
+		local.Tags = tags[id]
+	}
+
+	// Sessions are never recreated under the same UID, so a deletion always
+	// wins, even over edits made on another device before it heard of it.
+	if deleted {
+		return false, nil
+	}
+	if in.Deleted {
+		if _, err := deleteSessionsWhere(tx, "uid = $1", in.UID); err != nil {
+			return false, err
+		}
+		return true, writeTombstone(tx, tombstoneSession, in.UID, stamp{seq: seq, at: in.UpdatedAt})
+	}
+	content := func(s models.SyncSession) string {
+		var trashedAt int64
+		if s.TrashedAt != nil {
+			trashedAt = *s.TrashedAt
+		}
+		return fmt.Sprintf("%s\x1f%s\x1f%s\x1f%d\x1f%d\x1f%d\x1f%s\x1f%s\x1f%s\x1f%d\x1f%s\x1f%s\x1f%s\x1f%s\x1f%t",
+			s.DeviceID, s.AppName, s.WindowTitle, s.StartTime, s.EndTime, s.Duration, s.Classification, s.Source, s.Note, trashedAt,
+			strings.Join(s.Tags, ","), s.URL, s.Cwd, s.Project, s.AutoClassified)
+	}
+	if exists && !wins(in.UpdatedAt, local.UpdatedAt, content(in), content(local)) {
+		return false, nil
+	}
+
+	classID, err := syncClassificationID(tx, in.Classification, seq)
+	if err != nil {
+		return false, err
+	}
+	projectID, err := syncProjectID(tx, in.Project, seq)
+	if err != nil {
+		return false, err
+	}
+	title, hash, err := s.sealTitle(in.WindowTitle)
+	if err != nil {
+		return false, err
+	}
+	if in.Source == "" {
+		in.Source = models.SourceTracker
+	}
+	if exists {
+		_, err = tx.Exec(`
+			UPDATE activity_sessions
+			SET device_id = $1, app_name = $2, window_title = $3, title_hash = $4, start_time = $5, end_time = $6,
+				duration_seconds = $7, classification_id = $8, source = $9, note = $10, trashed_at = $11, url = $12, cwd = $13,
+				project_id = $14, auto_classified = $15, seq = $16, updated_at = $17
+			WHERE uid = $18
+		`, in.DeviceID, in.AppName, title, hash, in.StartTime, in.EndTime, in.Duration, classID, in.Source, in.Note, in.TrashedAt,
+			in.URL, in.Cwd, projectID, in.AutoClassified, seq, in.UpdatedAt, in.UID)
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM session_tags WHERE session_id = $1", id)
+		}
+	} else {
+		err = tx.QueryRow(`
+			INSERT INTO activity_sessions (uid, device_id, app_name, window_title, title_hash, start_time, end_time,
+				duration_seconds, classification_id, source, note, trashed_at, url, cwd, project_id, auto_classified, seq, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+			RETURNING id
+		`, in.UID, in.DeviceID, in.AppName, title, hash, in.StartTime, in.EndTime, in.Duration, classID, in.Source, in.Note,
+			in.TrashedAt, in.URL, in.Cwd, projectID, in.AutoClassified, seq, in.UpdatedAt).Scan(&id)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, addSessionTags(tx, id, normalizeTags(in.Tags))
+}
+
+// SyncCursor returns how far this device has pulled from and pushed to a
+// peer, as sequence numbers of the peer and of this device respectively.
+func (s *DBStore) SyncCursor(peer string) (pulled, pushed int64, err error) {
+	err = s.db.QueryRow("SELECT pulled, pushed FROM sync_peers WHERE peer = $1", peer).Scan(&pulled, &pushed)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	return pulled, pushed, err
+}
+
+// SetSyncCursor records a completed sync with a peer.
+func (s *DBStore) SetSyncCursor(peer string, pulled, pushed int64) error {
+	_, err := s.db.Exec(`
+		INSERT INTO sync_peers (peer, pulled, pushed) VALUES ($1, $2, $3)
+		ON CONFLICT (peer) DO UPDATE SET pulled = excluded.pulled, pushed = excluded.pushed
+	`, peer, pulled, pushed)
+	return err
+}
@@ -0,0 +1,477 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// defaultSearchLimit caps search results when no limit is given.
+const defaultSearchLimit = 100
+
+// SessionFilter selects sessions by start time and tag. Zero times leave that
+// side of the range open, and an empty Tag matches every session.
+type SessionFilter struct {
+	Start, End time.Time
+	Tag        string
+}
+
+// SessionSearch selects sessions for SearchSessions.
+type SessionSearch struct {
+	SessionFilter
+	// Text matches the app name, title or note, case-insensitively.
+	Text string
+	// Limit caps the results, newest first. Zero means defaultSearchLimit.
+	Limit int
+}
+
+// limit returns the effective result limit.
+func (search SessionSearch) limit() int {
+	if search.Limit <= 0 {
+		return defaultSearchLimit
+	}
+	return search.Limit
+}
+
+// matches reports whether a session's app name, title or note contains the
+// search text.
+func (search SessionSearch) matches(session models.ActivitySession) bool {
+	if search.Text == "" {
+		return true
+	}
+	text := strings.ToLower(search.Text)
+	for _, field := range []string{session.AppName, session.WindowTitle, session.Note} {
+		if strings.Contains(strings.ToLower(field), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// TagSummaryItem is the time recorded under one tag. A session with several
+// tags counts towards each of them.
+type TagSummaryItem struct {
+	Tag           string `json:"tag"`
+	TotalDuration int64  `json:"total_duration_seconds"`
+}
+
+// normalizeTags trims tags and drops empty and repeated ones. The result is
+// sorted.
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// mergeTags returns the union of two normalized tag lists.
+func mergeTags(a, b []string) []string {
+	return normalizeTags(append(slices.Clone(a), b...))
+}
+
+// loadTags runs a query selecting (owner ID, tag) pairs and groups the tags
+// by owner, sorted.
+func loadTags(q queryer, query string, args ...interface{}) (map[int64][]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[int64][]string)
+	for rows.Next() {
+		var id int64
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	return tags, rows.Err()
+}
+
+// attachSessionTags fills in the tags of sessions.
+func attachSessionTags(q queryer, sessions []models.ActivitySession) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.ID
+	}
+	tags, err := loadTags(q, fmt.Sprintf("SELECT session_id, tag FROM session_tags WHERE session_id IN (%s) ORDER BY tag", intSliceToString(ids)))
+	if err != nil {
+		return err
+	}
+	for i := range sessions {
+		sessions[i].Tags = tags[sessions[i].ID]
+	}
+	return nil
+}
+
+// applyRuleTags adds the tags of a matching rule to a session and fills in
+// its note if it has none.
+func applyRuleTags(session *models.ActivitySession, tags []string, note string) {
+	session.Tags = mergeTags(session.Tags, tags)
+	if session.Note == "" {
+		session.Note = note
+	}
+}
+
+// addSessionTags tags a session, skipping tags it already has.
+func addSessionTags(tx *sql.Tx, sessionID int64, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT INTO session_tags (session_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING", sessionID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSessions permanently deletes sessions along with their tags.
+func deleteSessions(tx *sql.Tx, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	list := intSliceToString(ids)
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM session_tags WHERE session_id IN (%s)", list)); err != nil {
+		return err
+	}
+	_, err := tx.Exec(fmt.Sprintf("DELETE FROM activity_sessions WHERE id IN (%s)", list))
+	return err
+}
+
+// deleteSessionsWhere permanently deletes the sessions matching a condition
+// along with their tags, and returns how many were deleted.
+func deleteSessionsWhere(tx *sql.Tx, where string, args ...interface{}) (int64, error) {
+	if _, err := tx.Exec("DELETE FROM session_tags WHERE session_id IN (SELECT id FROM activity_sessions WHERE "+where+")", args...); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM activity_sessions WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// setRuleTags replaces the tags of a rule.
+func setRuleTags(tx *sql.Tx, ruleID int64, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM rule_tags WHERE rule_id = $1", ruleID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT INTO rule_tags (rule_id, tag) VALUES ($1, $2)", ruleID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setRuleTagsByKey replaces the tags of every rule with the given app and
+// title pattern and condition.
+func setRuleTagsByKey(tx *sql.Tx, appName, windowTitleContains, condition string, tags []string) error {
+	rows, err := tx.Query("SELECT id FROM classification_rules WHERE app_name = $1 AND window_title_contains = $2 AND match_condition = $3",
+		appName, windowTitleContains, condition)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := setRuleTags(tx, id, tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRulesWhere deletes the rules matching a condition along with their
+// tags.
+func deleteRulesWhere(tx *sql.Tx, where string, args ...interface{}) error {
+	if _, err := tx.Exec("DELETE FROM rule_tags WHERE rule_id IN (SELECT id FROM classification_rules WHERE "+where+")", args...); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM classification_rules WHERE "+where, args...)
+	return err
+}
+
+// tagRequest validates a TagSessionsRequest and normalizes its IDs and tags.
+func tagRequest(req models.TagSessionsRequest) (ids []int64, add, remove []string, err error) {
+	ids = uniqueIDs(req.SessionIDs)
+	add, remove = normalizeTags(req.Add), normalizeTags(req.Remove)
+	if len(ids) == 0 {
+		return nil, nil, nil, fmt.Errorf("%w: no sessions given", ErrInvalidSessionEdit)
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		return nil, nil, nil, fmt.Errorf("%w: no tags to add or remove", ErrInvalidSessionEdit)
+	}
+	return ids, add, remove, nil
+}
+
+// TagSessions adds and removes tags on sessions and returns how many sessions
+// were changed.
+func (s *DBStore) TagSessions(req models.TagSessionsRequest) (int64, error) {
+	ids, add, remove, err := tagRequest(req)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	st, err := nextStamp(tx)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if _, err := loadEditedSession(tx, id); err != nil {
+			return 0, err
+		}
+		if err := addSessionTags(tx, id, add); err != nil {
+			return 0, err
+		}
+		for _, tag := range remove {
+			if _, err := tx.Exec("DELETE FROM session_tags WHERE session_id = $1 AND tag = $2", id, tag); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := tx.Exec("UPDATE activity_sessions SET seq = $1, updated_at = $2 WHERE id = $3", st.seq, st.at, id); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(ids)), tx.Commit()
+}
+
+// SetSessionNote replaces the note of a session.
+func (s *DBStore) SetSessionNote(req models.SessionNoteRequest) (models.ActivitySession, error) {
+	var session models.ActivitySession
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return session, err
+	}
+	defer tx.Rollback()
+
+	if _, err := loadEditedSession(tx, req.SessionID); err != nil {
+		return session, err
+	}
+	st, err := nextStamp(tx)
+	if err != nil {
+		return session, err
+	}
+	_, err = tx.Exec("UPDATE activity_sessions SET note = $1, seq = $2, updated_at = $3 WHERE id = $4",
+		strings.TrimSpace(req.Note), st.seq, st.at, req.SessionID)
+	if err != nil {
+		return session, err
+	}
+
+	if session, err = s.sessionByID(tx, req.SessionID); err != nil {
+		return session, err
+	}
+	return session, tx.Commit()
+}
+
+// GetTagSummary returns the time recorded under each tag for the current UTC
+// day, longest first. Untagged time is left out.
+func (s *DBStore) GetTagSummary(filter ReportFilter) ([]TagSummaryItem, error) {
+	startOfDay := time.Now().UTC().Truncate(24 * time.Hour)
+	rows, err := s.db.Query(`
+		SELECT t.tag, SUM(s.duration_seconds) AS total_duration
+		FROM session_tags t
+		JOIN activity_sessions s ON t.session_id = s.id
+		WHERE s.start_time >= $1 AND s.trashed_at IS NULL AND ($2 OR s.device_id = $3)
+			AND ($4 = '' OR EXISTS (SELECT 1 FROM session_tags f WHERE f.session_id = s.id AND f.tag = $4))
+		GROUP BY t.tag
+		HAVING SUM(s.duration_seconds) > 0
+		ORDER BY total_duration DESC, t.tag ASC
+	`, startOfDay.Unix(), filter.AllDevices, s.deviceID, filter.Tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summary := make([]TagSummaryItem, 0)
+	for rows.Next() {
+		var item TagSummaryItem
+		if err := rows.Scan(&item.Tag, &item.TotalDuration); err != nil {
+			return nil, err
+		}
+		summary = append(summary, item)
+	}
+	return summary, rows.Err()
+}
+
+// SearchSessions returns the sessions matching a search, newest first. Titles
+// may be encrypted, so the text is matched in Go.
+func (s *DBStore) SearchSessions(search SessionSearch) ([]models.ActivitySession, error) {
+	from, to := unixRange(search.Start, search.End)
+	rows, err := s.db.Query(`
+		SELECT s.id, s.app_name, s.window_title, s.start_time, s.end_time, s.duration_seconds, s.classification_id, s.source, s.note,
+			s.url, s.cwd, s.project_id
+		FROM activity_sessions s
+		WHERE s.start_time >= $1 AND s.start_time < $2 AND s.trashed_at IS NULL
+			AND ($3 = '' OR EXISTS (SELECT 1 FROM session_tags t WHERE t.session_id = s.id AND t.tag = $3))
+		ORDER BY s.start_time DESC, s.id DESC
+	`, from, to, search.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]models.ActivitySession, 0)
+	for rows.Next() && len(sessions) < search.limit() {
+		var session models.ActivitySession
+		var startTimeUnix, endTimeUnix int64
+		if err := rows.Scan(&session.ID, &session.AppName, &session.WindowTitle, &startTimeUnix, &endTimeUnix, &session.Duration, &session.ClassificationID, &session.Source, &session.Note,
+			&session.URL, &session.Cwd, &session.ProjectID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if session.WindowTitle, err = s.openTitle(session.WindowTitle); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		session.StartTime = time.Unix(startTimeUnix, 0)
+		session.EndTime = time.Unix(endTimeUnix, 0)
+		if search.matches(session) {
+			sessions = append(sessions, session)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, attachSessionTags(s.db, sessions)
+}
+
+// TagSessions adds and removes tags on sessions and returns how many sessions
+// were changed.
+func (m *MemoryStore) TagSessions(req models.TagSessionsRequest) (int64, error) {
+	ids, add, remove, err := tagRequest(req)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	indexes := make([]int, 0, len(ids))
+	for _, id := range ids {
+		i, err := m.sessionIndex(id)
+		if err != nil {
+			return 0, err
+		}
+		indexes = append(indexes, i)
+	}
+	for _, i := range indexes {
+		tags := mergeTags(m.sessions[i].Tags, add)
+		tags = slices.DeleteFunc(tags, func(tag string) bool { return slices.Contains(remove, tag) })
+		if len(tags) == 0 {
+			tags = nil
+		}
+		m.sessions[i].Tags = tags
+	}
+	return int64(len(indexes)), nil
+}
+
+// SetSessionNote replaces the note of a session.
+func (m *MemoryStore) SetSessionNote(req models.SessionNoteRequest) (models.ActivitySession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, err := m.sessionIndex(req.SessionID)
+	if err != nil {
+		return models.ActivitySession{}, err
+	}
+	m.sessions[i].Note = strings.TrimSpace(req.Note)
+	return m.sessions[i], nil
+}
+
+// GetTagSummary returns the time recorded under each tag for the current UTC
+// day, longest first.
+func (m *MemoryStore) GetTagSummary(filter ReportFilter) ([]TagSummaryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	startOfDay := time.Now().UTC().Truncate(24 * time.Hour)
+	totals := make(map[string]int64)
+	for _, session := range m.sessions {
+		if session.TrashedAt != nil || session.StartTime.Before(startOfDay) || !hasTag(session, filter.Tag) {
+			continue
+		}
+		for _, tag := range session.Tags {
+			totals[tag] += session.Duration
+		}
+	}
+
+	summary := make([]TagSummaryItem, 0, len(totals))
+	for tag, total := range totals {
+		if total > 0 {
+			summary = append(summary, TagSummaryItem{Tag: tag, TotalDuration: total})
+		}
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].TotalDuration != summary[j].TotalDuration {
+			return summary[i].TotalDuration > summary[j].TotalDuration
+		}
+		return summary[i].Tag < summary[j].Tag
+	})
+	return summary, nil
+}
+
+// SearchSessions returns the sessions matching a search, newest first.
+func (m *MemoryStore) SearchSessions(search SessionSearch) ([]models.ActivitySession, error) {
+	from, to := unixRange(search.Start, search.End)
+
+	m.mu.Lock()
+	var sessions []models.ActivitySession
+	for _, session := range m.sessions {
+		if unix := session.StartTime.Unix(); unix >= from && unix < to && session.TrashedAt == nil &&
+			hasTag(session, search.Tag) && search.matches(session) {
+			sessions = append(sessions, session)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if !sessions[i].StartTime.Equal(sessions[j].StartTime) {
+			return sessions[i].StartTime.After(sessions[j].StartTime)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+	if len(sessions) > search.limit() {
+		sessions = sessions[:search.limit()]
+	}
+	if sessions == nil {
+		return make([]models.ActivitySession, 0), nil
+	}
+	return sessions, nil
+}
+
+// hasTag reports whether a session carries tag. An empty tag matches every
+// session.
+func hasTag(session models.ActivitySession, tag string) bool {
+	return tag == "" || slices.Contains(session.Tags, tag)
+}
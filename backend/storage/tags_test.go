@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got := normalizeTags([]string{" client:acme", "billable", "", "billable ", "  "})
+	if want := []string{"billable", "client:acme"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeTags = %q, want %q", got, want)
+	}
+	if got := normalizeTags(nil); got != nil {
+		t.Errorf("normalizeTags(nil) = %q, want nil", got)
+	}
+}
+
+func TestTagSessions(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().UTC().Truncate(24 * time.Hour).Add(time.Minute)
+		track(t, s, start, "Code", "main.go")
+		track(t, s, start.Add(10*time.Minute), "Slack", "acme")
+		track(t, s, start.Add(20*time.Minute), "Slack", "general")
+		code := sessionsOf(t, s, "Code")[0]
+		slack := sessionsOf(t, s, "Slack")
+
+		invalid := []struct {
+			name string
+			req  models.TagSessionsRequest
+			want error
+		}{
+			{"no sessions", models.TagSessionsRequest{Add: []string{"billable"}}, ErrInvalidSessionEdit},
+			{"no tags", models.TagSessionsRequest{SessionIDs: []int64{code.ID}, Add: []string{" "}}, ErrInvalidSessionEdit},
+			{"missing session", models.TagSessionsRequest{SessionIDs: []int64{code.ID, 999}, Add: []string{"billable"}}, ErrSessionNotFound},
+		}
+		for _, test := range invalid {
+			if _, err := s.TagSessions(test.req); !errors.Is(err, test.want) {
+				t.Errorf("%s: %v, want %v", test.name, err, test.want)
+			}
+		}
+		if tags := sessionsOf(t, s, "Code")[0].Tags; len(tags) != 0 {
+			t.Errorf("a failed request tagged Code with %q", tags)
+		}
+
+		n, err := s.TagSessions(models.TagSessionsRequest{
+			SessionIDs: []int64{code.ID, slack[0].ID, code.ID},
+			Add:        []string{"client:acme", " billable"},
+		})
+		if err != nil || n != 2 {
+			t.Fatalf("TagSessions = %d, %v; want 2 sessions", n, err)
+		}
+		if _, err := s.TagSessions(models.TagSessionsRequest{SessionIDs: []int64{slack[0].ID}, Remove: []string{"billable"}}); err != nil {
+			t.Fatal(err)
+		}
+		if got := sessionsOf(t, s, "Code")[0].Tags; !reflect.DeepEqual(got, []string{"billable", "client:acme"}) {
+			t.Errorf("Code tags = %q", got)
+		}
+		if got := sessionsOf(t, s, "Slack")[0].Tags; !reflect.DeepEqual(got, []string{"client:acme"}) {
+			t.Errorf("Slack tags = %q", got)
+		}
+
+		session, err := s.SetSessionNote(models.SessionNoteRequest{SessionID: slack[1].ID, Note: "  Standup with ACME  "})
+		if err != nil || session.Note != "Standup with ACME" {
+			t.Fatalf("SetSessionNote = %q, %v", session.Note, err)
+		}
+		if _, err := s.SetSessionNote(models.SessionNoteRequest{SessionID: 999, Note: "x"}); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("noting a missing session: %v, want ErrSessionNotFound", err)
+		}
+
+		summary, err := s.GetTagSummary(ReportFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []TagSummaryItem{{Tag: "client:acme", TotalDuration: 120}, {Tag: "billable", TotalDuration: 60}}
+		if !reflect.DeepEqual(summary, want) {
+			t.Errorf("tag summary = %+v, want %+v", summary, want)
+		}
+		summary, err = s.GetTagSummary(ReportFilter{Tag: "billable"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = []TagSummaryItem{{Tag: "billable", TotalDuration: 60}, {Tag: "client:acme", TotalDuration: 60}}
+		if !reflect.DeepEqual(summary, want) {
+			t.Errorf("billable tag summary = %+v, want %+v", summary, want)
+		}
+	})
+}
+
+func TestSearchSessions(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		track(t, s, start, "Code", "main.go")
+		track(t, s, start.Add(10*time.Minute), "Slack", "acme")
+		track(t, s, start.Add(20*time.Minute), "Slack", "general")
+		slack := sessionsOf(t, s, "Slack")
+		if _, err := s.SetSessionNote(models.SessionNoteRequest{SessionID: slack[1].ID, Note: "Standup with ACME"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.TagSessions(models.TagSessionsRequest{SessionIDs: []int64{slack[0].ID}, Add: []string{"billable"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name   string
+			search SessionSearch
+			want   []string
+		}{
+			{"everything, newest first", SessionSearch{}, []string{"general", "acme", "main.go"}},
+			{"app name", SessionSearch{Text: "slack"}, []string{"general", "acme"}},
+			{"title and note", SessionSearch{Text: "Acme"}, []string{"general", "acme"}},
+			{"tag", SessionSearch{SessionFilter: SessionFilter{Tag: "billable"}}, []string{"acme"}},
+			{"text and tag", SessionSearch{SessionFilter: SessionFilter{Tag: "billable"}, Text: "code"}, nil},
+			{"start", SessionSearch{SessionFilter: SessionFilter{Start: start.Add(5 * time.Minute)}}, []string{"general", "acme"}},
+			{"end", SessionSearch{SessionFilter: SessionFilter{End: start.Add(5 * time.Minute)}}, []string{"main.go"}},
+			{"limit", SessionSearch{Limit: 1}, []string{"general"}},
+		}
+		for _, test := range tests {
+			sessions, err := s.SearchSessions(test.search)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if sessions == nil {
+				t.Errorf("%s: nil result, want an empty slice", test.name)
+			}
+			var titles []string
+			for _, session := range sessions {
+				titles = append(titles, session.WindowTitle)
+			}
+			if !reflect.DeepEqual(titles, test.want) {
+				t.Errorf("%s: found %q, want %q", test.name, titles, test.want)
+			}
+		}
+		sessions, err := s.SearchSessions(SessionSearch{Text: "acme", Limit: 1})
+		if err != nil || len(sessions) != 1 || sessions[0].Note != "Standup with ACME" {
+			t.Fatalf("SearchSessions = %+v, %v; want the noted session", sessions, err)
+		}
+		if sessions, _ = s.SearchSessions(SessionSearch{SessionFilter: SessionFilter{Tag: "billable"}}); !reflect.DeepEqual(sessions[0].Tags, []string{"billable"}) {
+			t.Errorf("search result tags = %q, want billable", sessions[0].Tags)
+		}
+	})
+}
+
+func TestRuleTags(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		track(t, s, start, "Slack", "acme")
+		_, err := s.CreateClassificationRule(models.CreateClassificationRuleRequest{
+			AppName: "Slack", WindowTitleContains: "acme", UserDefinedName: "Client work",
+			Tags: []string{"client:acme", "billable"}, Note: "ACME support",
+			ApplyRuleOptions: models.ApplyRuleOptions{ApplyToHistory: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		track(t, s, start.Add(10*time.Minute), "Slack", "acme")
+
+		for _, session := range sessionsOf(t, s, "Slack") {
+			if !reflect.DeepEqual(session.Tags, []string{"billable", "client:acme"}) || session.Note != "ACME support" {
+				t.Errorf("session at %v: tags %q, note %q", session.StartTime, session.Tags, session.Note)
+			}
+		}
+	})
+}
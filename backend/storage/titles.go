@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/imdawon/personalos/encryption"
+)
+
+// Settings used for window title encryption.
+const (
+	titleSaltSetting     = "title_kdf_salt"
+	titleKeyCheckSetting = "title_key_check"
+)
+
+var (
+	// ErrTitleKeyRequired is returned when opening an encrypted database
+	// without configuring its key.
+	ErrTitleKeyRequired = errors.New("window titles in this database are encrypted; configure the title key")
+	// ErrWrongTitleKey is returned when the configured key did not encrypt
+	// this database.
+	ErrWrongTitleKey = errors.New("configured title key does not match the one this database was encrypted with")
+)
+
+// Option configures a DBStore at construction time.
+type Option func(*DBStore)
+
+// WithTitleKey encrypts window titles at rest with a key from the given
+// source. The first start with a key encrypts all existing history.
+func WithTitleKey(key encryption.KeySource) Option {
+	return func(s *DBStore) {
+		s.titleKey = &key
+	}
+}
+
+// sealTitle encrypts a title for storage and returns it with its lookup hash.
+func (s *DBStore) sealTitle(title string) (stored string, hash string, err error) {
+	stored, err = s.cipher.Seal(title)
+	return stored, s.cipher.Hash(title), err
+}
+
+// openTitle decrypts a stored title.
+func (s *DBStore) openTitle(stored string) (string, error) {
+	return s.cipher.Open(stored)
+}
+
+// titleHash returns the lookup hash used to match sessions by title.
+func (s *DBStore) titleHash(title string) string {
+	return s.cipher.Hash(title)
+}
+
+// initTitleEncryption validates the configured key against the database and
+// makes sure every session has a lookup hash.
+func (s *DBStore) initTitleEncryption() error {
+	check, err := s.getSetting(s.db, titleKeyCheckSetting)
+	if err != nil {
+		return err
+	}
+
+	if s.titleKey == nil {
+		if check != "" {
+			return ErrTitleKeyRequired
+		}
+		return s.backfillTitleHashes()
+	}
+
+	salt, err := s.getSetting(s.db, titleSaltSetting)
+	if err != nil {
+		return err
+	}
+	if salt == "" {
+		if salt, err = newSalt(); err != nil {
+			return err
+		}
+	}
+	saltBytes, err := hex.DecodeString(salt)
+	if err != nil {
+		return fmt.Errorf("invalid %s setting: %w", titleSaltSetting, err)
+	}
+	c, err := s.titleKey.NewCipher(saltBytes)
+	if err != nil {
+		return err
+	}
+
+	if check == "" {
+		log.Println("Encrypting existing window titles with the configured key...")
+		return s.reencryptTitles(c, salt)
+	}
+	if check != c.KeyCheck() {
+		return ErrWrongTitleKey
+	}
+	s.cipher = c
+	return s.backfillTitleHashes()
+}
+
+// RotateTitleKey re-encrypts every stored title with a new key in a single
+// transaction. A nil key decrypts everything and disables encryption.
+func (s *DBStore) RotateTitleKey(key *encryption.KeySource) error {
+	var next *encryption.Cipher
+	salt := ""
+	if key != nil {
+		var err error
+		if salt, err = newSalt(); err != nil {
+			return err
+		}
+		saltBytes, _ := hex.DecodeString(salt)
+		if next, err = key.NewCipher(saltBytes); err != nil {
+			return err
+		}
+	}
+	if err := s.reencryptTitles(next, salt); err != nil {
+		return err
+	}
+	s.titleKey = key
+	return nil
+}
+
+// storedTitle is a row whose title is being rewritten.
+type storedTitle struct {
+	id    int64
+	title string
+}
+
+// reencryptTitles decrypts all titles with the current cipher, stores them
+// under next and records next's salt and key check. On success next becomes
+// the current cipher.
+func (s *DBStore) reencryptTitles(next *encryption.Cipher, salt string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"activity_sessions", "raw_events"} {
+		rows, err := readTitles(tx, "SELECT id, window_title FROM "+table)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, row := range rows {
+			plain, err := s.openTitle(row.title)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("%s %d: %w", table, row.id, err)
+			}
+			sealed, err := next.Seal(plain)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			if table == "activity_sessions" {
+				_, err = tx.Exec("UPDATE activity_sessions SET window_title = $1, title_hash = $2 WHERE id = $3", sealed, next.Hash(plain), row.id)
+			} else {
+				_, err = tx.Exec("UPDATE raw_events SET window_title = $1 WHERE id = $2", sealed, row.id)
+			}
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	if err := s.setSetting(tx, titleSaltSetting, salt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := s.setSetting(tx, titleKeyCheckSetting, next.KeyCheck()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.cipher = next
+	return nil
+}
+
+// backfillTitleHashes fills in lookup hashes for sessions stored before
+// hashes existed.
+func (s *DBStore) backfillTitleHashes() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	rows, err := readTitles(tx, "SELECT id, window_title FROM activity_sessions WHERE title_hash = ''")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, row := range rows {
+		plain, err := s.openTitle(row.title)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec("UPDATE activity_sessions SET title_hash = $1 WHERE id = $2", s.titleHash(plain), row.id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func readTitles(tx *sql.Tx, query string) ([]storedTitle, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var titles []storedTitle
+	for rows.Next() {
+		var row storedTitle
+		if err := rows.Scan(&row.id, &row.title); err != nil {
+			return nil, err
+		}
+		titles = append(titles, row)
+	}
+	return titles, rows.Err()
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getSetting returns a stored setting, or "" if it has never been set.
+func (s *DBStore) getSetting(q queryer, key string) (string, error) {
+	var value string
+	err := q.QueryRow("SELECT value FROM settings WHERE key = $1", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// setSetting stores a setting, replacing any previous value.
+func (s *DBStore) setSetting(q queryer, key, value string) error {
+	_, err := q.Exec(`
+		INSERT INTO settings (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value
+	`, key, value)
+	return err
+}
+
+func newSalt() (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt), nil
+}
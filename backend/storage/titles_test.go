@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/encryption"
+	"github.com/imdawon/personalos/models"
+)
+
+// newKeyFile writes a random title key and returns its source.
+func newKeyFile(t *testing.T) encryption.KeySource {
+	t.Helper()
+	key, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "title.key")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return encryption.KeySource{KeyFile: path}
+}
+
+// openAt opens the store at path, closing it when the test ends.
+func openAt(t *testing.T, path string, opts ...Option) (*DBStore, error) {
+	t.Helper()
+	s, err := NewDBStore(path, opts...)
+	if err == nil {
+		t.Cleanup(func() { s.Close() })
+	}
+	return s, err
+}
+
+// storedTitles returns the titles as written to activity_sessions and raw_events.
+func storedTitles(t *testing.T, s *DBStore) []string {
+	t.Helper()
+	var titles []string
+	for _, table := range []string{"activity_sessions", "raw_events"} {
+		rows, err := s.db.Query("SELECT window_title FROM " + table + " WHERE window_title <> ''")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var title string
+			if err := rows.Scan(&title); err != nil {
+				t.Fatal(err)
+			}
+			titles = append(titles, title)
+		}
+		rows.Close()
+	}
+	if len(titles) == 0 {
+		t.Fatal("no titles stored")
+	}
+	return titles
+}
+
+func assertSealed(t *testing.T, s *DBStore, sealed bool) {
+	t.Helper()
+	for _, title := range storedTitles(t, s) {
+		if strings.HasPrefix(title, "enc:") != sealed {
+			t.Errorf("stored title %q, want sealed=%v", title, sealed)
+		}
+	}
+}
+
+func TestTitlesEncryptedAtRest(t *testing.T) {
+	s := newTestDB(t, WithTitleKey(newKeyFile(t)))
+	start := time.Now().Add(-time.Hour)
+	track(t, s, start, "Mail", "Offer letter - Acme")
+	track(t, s, start.Add(2*time.Minute), "Mail", "Inbox")
+	if err := s.InsertRawEvent(models.RawEvent{Timestamp: time.Now(), AppName: "Mail", WindowTitle: "Draft"}); err != nil {
+		t.Fatal(err)
+	}
+	assertSealed(t, s, true)
+
+	sessions := unclassified(t, s)
+	if len(sessions) != 2 || sessions[1].WindowTitle != "Offer letter - Acme" {
+		t.Fatalf("unclassified = %+v, want decrypted titles", sessions)
+	}
+
+	// Classification matches titles through their keyed hash.
+	err := s.ApplyClassification(models.ClassificationRequest{AppName: "Mail", WindowTitle: "Offer letter - Acme", UserDefinedName: "Job search"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := unclassified(t, s); len(left) != 1 || left[0].WindowTitle != "Inbox" {
+		t.Errorf("unclassified = %+v, want only Inbox", left)
+	}
+
+	// Purges match the decrypted titles.
+	result, err := s.PurgeHistory(models.PurgeRequest{TitlePattern: "offer%", DryRun: true})
+	if err != nil || result.Sessions != 1 {
+		t.Errorf("purge dry run = %+v, %v, want one session", result, err)
+	}
+}
+
+func TestEnablingTitleKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := openAt(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	track(t, s, time.Now().Add(-time.Hour), "Code", "main.go")
+	assertSealed(t, s, false)
+	s.Close()
+
+	// The first start with a key encrypts existing history.
+	key := newKeyFile(t)
+	if s, err = openAt(t, path, WithTitleKey(key)); err != nil {
+		t.Fatal(err)
+	}
+	assertSealed(t, s, true)
+	if sessions := unclassified(t, s); len(sessions) != 1 || sessions[0].WindowTitle != "main.go" {
+		t.Errorf("unclassified = %+v after encrypting", sessions)
+	}
+	s.Close()
+
+	if _, err := openAt(t, path); !errors.Is(err, ErrTitleKeyRequired) {
+		t.Errorf("opening without the key: %v, want ErrTitleKeyRequired", err)
+	}
+	if _, err := openAt(t, path, WithTitleKey(newKeyFile(t))); !errors.Is(err, ErrWrongTitleKey) {
+		t.Errorf("opening with another key: %v, want ErrWrongTitleKey", err)
+	}
+	if _, err := openAt(t, path, WithTitleKey(key)); err != nil {
+		t.Errorf("reopening with the key: %v", err)
+	}
+}
+
+func TestRotateTitleKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	oldKey := encryption.KeySource{Passphrase: "old passphrase"}
+	s, err := openAt(t, path, WithTitleKey(oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	track(t, s, time.Now().Add(-time.Hour), "Code", "main.go")
+	before := storedTitles(t, s)
+
+	newKey := newKeyFile(t)
+	if err := s.RotateTitleKey(&newKey); err != nil {
+		t.Fatal(err)
+	}
+	after := storedTitles(t, s)
+	if after[0] == before[0] || !strings.HasPrefix(after[0], "enc:") {
+		t.Errorf("stored title %q after rotating from %q", after[0], before[0])
+	}
+	err = s.ApplyClassification(models.ClassificationRequest{AppName: "Code", WindowTitle: "main.go", UserDefinedName: "Go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(unclassified(t, s)); n != 0 {
+		t.Errorf("classifying by title failed after rotation: %d unclassified", n)
+	}
+	s.Close()
+
+	if _, err := openAt(t, path, WithTitleKey(oldKey)); !errors.Is(err, ErrWrongTitleKey) {
+		t.Errorf("opening with the old key: %v, want ErrWrongTitleKey", err)
+	}
+	if s, err = openAt(t, path, WithTitleKey(newKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotating to no key decrypts everything.
+	if err := s.RotateTitleKey(nil); err != nil {
+		t.Fatal(err)
+	}
+	assertSealed(t, s, false)
+	s.Close()
+	if _, err := openAt(t, path); err != nil {
+		t.Errorf("opening after disabling encryption: %v", err)
+	}
+}
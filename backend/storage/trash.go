@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// ErrEmptyBulkDelete is returned when a bulk delete does not name an app,
+// which would otherwise trash all history.
+var ErrEmptyBulkDelete = errors.New("bulk delete needs an app name")
+
+// bulkDeleteRange returns the start time range of a bulk delete, with open
+// ends widened.
+func bulkDeleteRange(req models.BulkDeleteRequest) (from, to int64) {
+	from, to = req.StartTime, req.EndTime
+	if to == 0 {
+		to = math.MaxInt64
+	}
+	return from, to
+}
+
+// moveToTrash trashes the sessions outside the trash that match where, and
+// logs them as one operation. Trashed sessions keep syncing, so the trash and
+// restores reach other devices.
+func (s *DBStore) moveToTrash(operation, where string, args ...interface{}) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, classification_id, auto_classified FROM activity_sessions WHERE trashed_at IS NULL AND "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	var snaps []sessionSnapshot
+	for rows.Next() {
+		var id int64
+		var snap sessionSnapshot
+		if err := rows.Scan(&id, &snap.ClassificationID, &snap.AutoClassified); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		snaps = append(snaps, snap)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	st, err := nextStamp(tx)
+	if err != nil {
+		return 0, err
+	}
+	audit := startAudit(tx, operation, nil)
+	now := time.Now().Unix()
+	for i, id := range ids {
+		trashed := snaps[i]
+		trashed.TrashedAt = &now
+		if err := audit.record(auditSession, id, snaps[i], trashed); err != nil {
+			return 0, err
+		}
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE activity_sessions SET trashed_at = $1, seq = $2, updated_at = $3 WHERE id IN (%s)",
+		intSliceToString(ids)), now, st.seq, st.at)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), tx.Commit()
+}
+
+// TrashSessions moves every session of an app starting in the requested
+// range to the trash and returns how many were moved.
+func (s *DBStore) TrashSessions(req models.BulkDeleteRequest) (int64, error) {
+	if req.AppName == "" {
+		return 0, ErrEmptyBulkDelete
+	}
+	from, to := bulkDeleteRange(req)
+	return s.moveToTrash(models.AuditDeleteSessions, "app_name = $1 AND start_time >= $2 AND start_time < $3",
+		req.AppName, from, to)
+}
+
+// GetTrash returns the sessions in the trash, most recently trashed first.
+func (s *DBStore) GetTrash() ([]models.ActivitySession, error) {
+	rows, err := s.db.Query(`
+		SELECT id, app_name, window_title, start_time, end_time, duration_seconds, classification_id, source, note, project_id, trashed_at
+		FROM activity_sessions
+		WHERE trashed_at IS NOT NULL
+		ORDER BY trashed_at DESC, start_time DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]models.ActivitySession, 0)
+	for rows.Next() {
+		var session models.ActivitySession
+		var startTimeUnix, endTimeUnix, trashedAtUnix int64
+		if err := rows.Scan(&session.ID, &session.AppName, &session.WindowTitle, &startTimeUnix, &endTimeUnix, &session.Duration,
+			&session.ClassificationID, &session.Source, &session.Note, &session.ProjectID, &trashedAtUnix); err != nil {
+			return nil, err
+		}
+		if session.WindowTitle, err = s.openTitle(session.WindowTitle); err != nil {
+			return nil, err
+		}
+		session.StartTime = time.Unix(startTimeUnix, 0)
+		session.EndTime = time.Unix(endTimeUnix, 0)
+		trashedAt := time.Unix(trashedAtUnix, 0)
+		session.TrashedAt = &trashedAt
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, attachSessionTags(s.db, sessions)
+}
+
+// RestoreSessions takes sessions out of the trash and returns how many were
+// restored. IDs that are not in the trash are ignored.
+func (s *DBStore) RestoreSessions(ids []int64) (int64, error) {
+	ids = uniqueIDs(ids)
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(fmt.Sprintf("SELECT id, classification_id, auto_classified, trashed_at FROM activity_sessions WHERE trashed_at IS NOT NULL AND id IN (%s)",
+		intSliceToString(ids)))
+	if err != nil {
+		return 0, err
+	}
+	var trashed []int64
+	var snaps []sessionSnapshot
+	for rows.Next() {
+		var id int64
+		var snap sessionSnapshot
+		if err := rows.Scan(&id, &snap.ClassificationID, &snap.AutoClassified, &snap.TrashedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		trashed = append(trashed, id)
+		snaps = append(snaps, snap)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(trashed) == 0 {
+		return 0, nil
+	}
+
+	st, err := nextStamp(tx)
+	if err != nil {
+		return 0, err
+	}
+	audit := startAudit(tx, models.AuditRestore, nil)
+	for i, id := range trashed {
+		restored := snaps[i]
+		restored.TrashedAt = nil
+		if err := audit.record(auditSession, id, snaps[i], restored); err != nil {
+			return 0, err
+		}
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE activity_sessions SET trashed_at = NULL, seq = $1, updated_at = $2 WHERE id IN (%s)",
+		intSliceToString(trashed)), st.seq, st.at)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(trashed)), tx.Commit()
+}
+
+// emptyTrash permanently deletes sessions trashed before cutoff. Unlike
+// retention deletes, these reach synced devices.
+func emptyTrash(tx *sql.Tx, cutoff int64) (int64, error) {
+	rows, err := tx.Query("SELECT id FROM activity_sessions WHERE trashed_at < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	st, err := nextStamp(tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := tombstoneSessions(tx, ids, st); err != nil {
+		return 0, err
+	}
+	if err := deleteSessions(tx, ids); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// unixPtr and timePtr convert optional timestamps between the MemoryStore and
+// the audit log.
+func unixPtr(t *time.Time) *int64 {
+	if t == nil {
+		return nil
+	}
+	unix := t.Unix()
+	return &unix
+}
+
+func timePtr(unix *int64) *time.Time {
+	if unix == nil {
+		return nil
+	}
+	t := time.Unix(*unix, 0)
+	return &t
+}
+
+// moveToTrash mirrors the DBStore method for sessions outside the trash that
+// match. Callers must hold m.mu.
+func (m *MemoryStore) moveToTrash(operation string, match func(models.ActivitySession) bool) int64 {
+	audit := m.startAudit(operation, nil)
+	now := time.Unix(time.Now().Unix(), 0)
+	var moved int64
+	for i := range m.sessions {
+		session := &m.sessions[i]
+		if session.TrashedAt != nil || !match(*session) {
+			continue
+		}
+		before := sessionSnapshot{ClassificationID: session.ClassificationID, AutoClassified: session.AutoClassified}
+		trashedAt := now
+		session.TrashedAt = &trashedAt
+		after := before
+		after.TrashedAt = unixPtr(session.TrashedAt)
+		audit.record(auditSession, session.ID, before, after)
+		moved++
+	}
+	return moved
+}
+
+// TrashSessions moves every session of an app starting in the requested
+// range to the trash.
+func (m *MemoryStore) TrashSessions(req models.BulkDeleteRequest) (int64, error) {
+	if req.AppName == "" {
+		return 0, ErrEmptyBulkDelete
+	}
+	from, to := bulkDeleteRange(req)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.moveToTrash(models.AuditDeleteSessions, func(session models.ActivitySession) bool {
+		unix := session.StartTime.Unix()
+		return session.AppName == req.AppName && unix >= from && unix < to
+	}), nil
+}
+
+// GetTrash returns the sessions in the trash, most recently trashed first.
+func (m *MemoryStore) GetTrash() ([]models.ActivitySession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := make([]models.ActivitySession, 0)
+	for _, session := range m.sessions {
+		if session.TrashedAt != nil {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if !sessions[i].TrashedAt.Equal(*sessions[j].TrashedAt) {
+			return sessions[i].TrashedAt.After(*sessions[j].TrashedAt)
+		}
+		return sessions[i].StartTime.After(sessions[j].StartTime)
+	})
+	return sessions, nil
+}
+
+// RestoreSessions takes sessions out of the trash.
+func (m *MemoryStore) RestoreSessions(ids []int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	audit := m.startAudit(models.AuditRestore, nil)
+	var restored int64
+	for i := range m.sessions {
+		session := &m.sessions[i]
+		if session.TrashedAt == nil || !wanted[session.ID] {
+			continue
+		}
+		after := sessionSnapshot{ClassificationID: session.ClassificationID, AutoClassified: session.AutoClassified}
+		before := after
+		before.TrashedAt = unixPtr(session.TrashedAt)
+		audit.record(auditSession, session.ID, before, after)
+		session.TrashedAt = nil
+		restored++
+	}
+	return restored, nil
+}
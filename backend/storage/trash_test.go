@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+)
+
+// trashedApps returns the apps of the sessions in the trash, in GetTrash
+// order.
+func trashedApps(t *testing.T, s Store) []string {
+	t.Helper()
+	trash, err := s.GetTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := make([]string, 0, len(trash))
+	for _, session := range trash {
+		if session.TrashedAt == nil {
+			t.Errorf("session %d in the trash has no TrashedAt", session.ID)
+		}
+		apps = append(apps, session.AppName+":"+session.WindowTitle)
+	}
+	return apps
+}
+
+func TestTrash(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		start := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+		track(t, s, start, "Code", "old.go")
+		track(t, s, start.Add(30*time.Minute), "Slack", "general")
+		track(t, s, start.Add(time.Hour), "Code", "new.go")
+		sessions := allSessions(t, s)
+		old, slack, recent := sessions[0], sessions[1], sessions[2]
+
+		if _, err := s.TrashSessions(models.BulkDeleteRequest{StartTime: start.Unix()}); !errors.Is(err, ErrEmptyBulkDelete) {
+			t.Errorf("bulk delete without an app: %v, want ErrEmptyBulkDelete", err)
+		}
+		// The range is half-open: a session starting at its end stays.
+		trashed, err := s.TrashSessions(models.BulkDeleteRequest{AppName: "Code", StartTime: start.Unix(), EndTime: recent.StartTime.Unix()})
+		if err != nil || trashed != 1 {
+			t.Fatalf("TrashSessions = %d, %v; want 1", trashed, err)
+		}
+		if err := s.DeleteSession(recent.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := trashedApps(t, s), []string{"Code:new.go", "Code:old.go"}; !slices.Equal(got, want) {
+			t.Errorf("trash = %q, want %q", got, want)
+		}
+
+		// Trashed sessions are out of every other view and cannot be edited
+		// or classified.
+		if got := allSessions(t, s); len(got) != 1 || got[0].ID != slack.ID {
+			t.Errorf("sessions outside the trash = %+v", got)
+		}
+		if got := unclassified(t, s); len(got) != 1 || got[0].ID != slack.ID {
+			t.Errorf("unclassified = %+v", got)
+		}
+		if err := s.ApplyClassification(models.ClassificationRequest{AppName: "Code", WindowTitle: "old.go", UserDefinedName: "Go"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.AdjustSession(models.AdjustSessionRequest{SessionID: old.ID, StartTime: start.Unix(), EndTime: start.Add(time.Minute).Unix()}); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("adjusting a trashed session: %v, want ErrSessionNotFound", err)
+		}
+
+		// Restoring ignores sessions that are not in the trash.
+		restored, err := s.RestoreSessions([]int64{old.ID, old.ID, slack.ID, 999})
+		if err != nil || restored != 1 {
+			t.Fatalf("RestoreSessions = %d, %v; want 1", restored, err)
+		}
+		if got, want := trashedApps(t, s), []string{"Code:new.go"}; !slices.Equal(got, want) {
+			t.Errorf("trash = %q, want %q", got, want)
+		}
+		restoredOld := allSessions(t, s)[0]
+		if restoredOld.ID != old.ID || restoredOld.ClassificationID != nil {
+			t.Errorf("restored session = %+v, want it unclassified as it was trashed", restoredOld)
+		}
+
+		// Deletes and restores are logged and can be undone. The classify only
+		// created its classification.
+		want := []string{models.AuditDeleteSessions, models.AuditDeleteSession, models.AuditClassify, models.AuditRestore}
+		if ops := operations(t, s); !slices.Equal(ops, want) {
+			t.Errorf("log = %q, want %q", ops, want)
+		}
+		undo(t, s, 3)
+		if got, want := trashedApps(t, s), []string{"Code:old.go"}; !slices.Equal(got, want) {
+			t.Errorf("trash after undoing the restore and the delete = %q, want %q", got, want)
+		}
+	})
+}
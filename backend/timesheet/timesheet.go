@@ -0,0 +1,135 @@
+package timesheet
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+// Options select what a timesheet covers.
+type Options struct {
+	// Start and End bound sessions by start time. Zero leaves that side open.
+	Start, End time.Time
+	// RoundingMinutes rounds each project's time per day up to a multiple of
+	// it, e.g. 6 or 15. Zero keeps exact seconds.
+	RoundingMinutes int
+	// Client limits the timesheet to that client's projects. ProjectID, when
+	// set, limits it to one project.
+	Client    string
+	ProjectID int64
+	// Location decides which day a session falls on. Nil means local time.
+	Location *time.Location
+}
+
+// Build sums the time of sessions assigned to a project per project and day,
+// by the day each session started. Sessions without a project are left out.
+func Build(src storage.ExportReader, opts Options) (models.Timesheet, error) {
+	sheet := models.Timesheet{
+		RoundingMinutes: opts.RoundingMinutes,
+		Entries:         make([]models.TimesheetEntry, 0),
+		Totals:          make([]models.TimesheetTotal, 0),
+	}
+	if opts.RoundingMinutes < 0 {
+		return sheet, fmt.Errorf("rounding must not be negative, got %d minutes", opts.RoundingMinutes)
+	}
+	if !opts.Start.IsZero() {
+		sheet.Start = opts.Start.Unix()
+	}
+	if !opts.End.IsZero() {
+		sheet.End = opts.End.Unix()
+	}
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	list, err := src.ListProjects()
+	if err != nil {
+		return sheet, err
+	}
+	projects := make(map[int64]models.Project)
+	for _, p := range list {
+		if opts.Client != "" && p.Client != opts.Client {
+			continue
+		}
+		if opts.ProjectID != 0 && p.ID != opts.ProjectID {
+			continue
+		}
+		projects[p.ID] = p
+	}
+
+	type key struct {
+		date      string
+		projectID int64
+	}
+	seconds := make(map[key]int64)
+	err = src.EachSession(storage.SessionFilter{Start: opts.Start, End: opts.End}, func(s models.ActivitySession) error {
+		if s.ProjectID == nil {
+			return nil
+		}
+		if _, ok := projects[*s.ProjectID]; !ok {
+			return nil
+		}
+		seconds[key{s.StartTime.In(loc).Format("2006-01-02"), *s.ProjectID}] += s.Duration
+		return nil
+	})
+	if err != nil {
+		return sheet, err
+	}
+
+	totals := make(map[int64]*models.TimesheetTotal)
+	for k, secs := range seconds {
+		p := projects[k.projectID]
+		entry := models.TimesheetEntry{
+			Date:           k.date,
+			ProjectID:      p.ID,
+			Project:        p.Name,
+			Client:         p.Client,
+			Seconds:        secs,
+			RoundedSeconds: roundUp(secs, int64(opts.RoundingMinutes)*60),
+		}
+		entry.Amount = amount(entry.RoundedSeconds, p.HourlyRate)
+		sheet.Entries = append(sheet.Entries, entry)
+
+		total := totals[p.ID]
+		if total == nil {
+			total = &models.TimesheetTotal{ProjectID: p.ID, Project: p.Name, Client: p.Client, HourlyRate: p.HourlyRate, Currency: p.Currency}
+			totals[p.ID] = total
+		}
+		total.Seconds += entry.Seconds
+		total.RoundedSeconds += entry.RoundedSeconds
+		total.Amount += entry.Amount
+	}
+
+	sort.Slice(sheet.Entries, func(i, j int) bool {
+		a, b := sheet.Entries[i], sheet.Entries[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		return a.Project < b.Project
+	})
+	for _, total := range totals {
+		sheet.Totals = append(sheet.Totals, *total)
+	}
+	sort.Slice(sheet.Totals, func(i, j int) bool {
+		return sheet.Totals[i].Project < sheet.Totals[j].Project
+	})
+	return sheet, nil
+}
+
+// roundUp rounds seconds up to a multiple of step. A step of zero leaves
+// them as they are.
+func roundUp(seconds, step int64) int64 {
+	if step <= 0 || seconds%step == 0 {
+		return seconds
+	}
+	return (seconds/step + 1) * step
+}
+
+// amount prices seconds at an hourly rate, rounded to the nearest minor unit.
+func amount(seconds, hourlyRate int64) int64 {
+	return (seconds*hourlyRate + 1800) / 3600
+}
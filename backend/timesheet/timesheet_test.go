@@ -0,0 +1,164 @@
+package timesheet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/imdawon/personalos/models"
+	"github.com/imdawon/personalos/storage"
+)
+
+// history is an ExportReader over fixed projects and sessions.
+type history struct {
+	projects []models.Project
+	sessions []models.ActivitySession
+}
+
+func (h history) EachSession(filter storage.SessionFilter, fn func(models.ActivitySession) error) error {
+	for _, s := range h.sessions {
+		if (!filter.Start.IsZero() && s.StartTime.Before(filter.Start)) || (!filter.End.IsZero() && !s.StartTime.Before(filter.End)) {
+			continue
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h history) ListProjects() ([]models.Project, error) { return h.projects, nil }
+
+func (history) ListClassifications() ([]models.Classification, error) { return nil, nil }
+func (history) ListRules() ([]models.ClassificationRule, error)       { return nil, nil }
+func (history) ListProjectRules() ([]models.ProjectRule, error)       { return nil, nil }
+func (history) GetAwayPeriods(start, end time.Time) ([]models.AwayPeriod, error) {
+	return nil, nil
+}
+
+// at returns the given hour of a day of October 2026 in UTC.
+func at(day, hour int) time.Time {
+	return time.Date(2026, time.October, day, hour, 0, 0, 0, time.UTC)
+}
+
+func session(projectID int64, start time.Time, seconds int64) models.ActivitySession {
+	s := models.ActivitySession{StartTime: start, EndTime: start.Add(time.Duration(seconds) * time.Second), Duration: seconds}
+	if projectID != 0 {
+		s.ProjectID = &projectID
+	}
+	return s
+}
+
+func newHistory() history {
+	return history{
+		projects: []models.Project{
+			{ID: 1, Name: "Website", Client: "Acme", HourlyRate: 10000, Currency: "USD"},
+			{ID: 2, Name: "API", Client: "Acme", HourlyRate: 12000, Currency: "USD"},
+			{ID: 3, Name: "Support", Client: "Initech"},
+		},
+		sessions: []models.ActivitySession{
+			session(1, at(1, 9), 1000),
+			session(1, at(1, 14), 200),
+			session(2, at(1, 23), 600),
+			session(3, at(2, 10), 3600),
+			session(0, at(2, 11), 7200),
+			session(1, at(3, 9), 1800),
+		},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	sheet, err := Build(newHistory(), Options{RoundingMinutes: 15, Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEntries := []models.TimesheetEntry{
+		{Date: "2026-10-01", ProjectID: 2, Project: "API", Client: "Acme", Seconds: 600, RoundedSeconds: 900, Amount: 3000},
+		{Date: "2026-10-01", ProjectID: 1, Project: "Website", Client: "Acme", Seconds: 1200, RoundedSeconds: 1800, Amount: 5000},
+		{Date: "2026-10-02", ProjectID: 3, Project: "Support", Client: "Initech", Seconds: 3600, RoundedSeconds: 3600},
+		{Date: "2026-10-03", ProjectID: 1, Project: "Website", Client: "Acme", Seconds: 1800, RoundedSeconds: 1800, Amount: 5000},
+	}
+	if !reflect.DeepEqual(sheet.Entries, wantEntries) {
+		t.Errorf("entries =\n%+v\nwant\n%+v", sheet.Entries, wantEntries)
+	}
+	wantTotals := []models.TimesheetTotal{
+		{ProjectID: 2, Project: "API", Client: "Acme", HourlyRate: 12000, Currency: "USD", Seconds: 600, RoundedSeconds: 900, Amount: 3000},
+		{ProjectID: 3, Project: "Support", Client: "Initech", Seconds: 3600, RoundedSeconds: 3600},
+		{ProjectID: 1, Project: "Website", Client: "Acme", HourlyRate: 10000, Currency: "USD", Seconds: 3000, RoundedSeconds: 3600, Amount: 10000},
+	}
+	if !reflect.DeepEqual(sheet.Totals, wantTotals) {
+		t.Errorf("totals =\n%+v\nwant\n%+v", sheet.Totals, wantTotals)
+	}
+}
+
+func TestBuildOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		projects []string
+		seconds  int64
+	}{
+		{"exact seconds", Options{Location: time.UTC}, []string{"API", "Support", "Website"}, 7200},
+		{"client", Options{Client: "Acme", Location: time.UTC}, []string{"API", "Website"}, 3600},
+		{"project", Options{ProjectID: 3, Location: time.UTC}, []string{"Support"}, 3600},
+		{"range", Options{Start: at(2, 0), End: at(3, 0), Location: time.UTC}, []string{"Support"}, 3600},
+	}
+	for _, test := range tests {
+		sheet, err := Build(newHistory(), test.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var projects []string
+		var seconds int64
+		for _, total := range sheet.Totals {
+			projects = append(projects, total.Project)
+			seconds += total.RoundedSeconds
+		}
+		if !reflect.DeepEqual(projects, test.projects) || seconds != test.seconds {
+			t.Errorf("%s: %q for %ds, want %q for %ds", test.name, projects, seconds, test.projects, test.seconds)
+		}
+	}
+
+	// Days are split in the timesheet's location: 23:00 UTC is the next
+	// day two hours east.
+	sheet, err := Build(newHistory(), Options{ProjectID: 2, Location: time.FixedZone("UTC+2", 2*60*60)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sheet.Entries) != 1 || sheet.Entries[0].Date != "2026-10-02" {
+		t.Errorf("entries = %+v, want one on 2026-10-02", sheet.Entries)
+	}
+
+	sheet, err = Build(history{}, Options{Start: at(1, 0)})
+	if err != nil || sheet.Entries == nil || sheet.Totals == nil || sheet.Start != at(1, 0).Unix() || sheet.End != 0 {
+		t.Errorf("empty timesheet = %+v, %v; want empty lists and an open end", sheet, err)
+	}
+	if _, err := Build(newHistory(), Options{RoundingMinutes: -1}); err == nil {
+		t.Error("negative rounding was accepted")
+	}
+}
+
+func TestRoundingAndAmounts(t *testing.T) {
+	for _, test := range []struct{ seconds, step, want int64 }{
+		{0, 900, 0},
+		{1, 900, 900},
+		{900, 900, 900},
+		{901, 360, 1080},
+		{901, 0, 901},
+	} {
+		if got := roundUp(test.seconds, test.step); got != test.want {
+			t.Errorf("roundUp(%d, %d) = %d, want %d", test.seconds, test.step, got, test.want)
+		}
+	}
+	for _, test := range []struct{ seconds, rate, want int64 }{
+		{3600, 10000, 10000},
+		{60, 100, 2}, // 1.67 cents
+		{18, 100, 1}, // half a cent rounds up
+		{17, 100, 0}, // just under half a cent
+		{3600, 0, 0}, // not billable
+	} {
+		if got := amount(test.seconds, test.rate); got != test.want {
+			t.Errorf("amount(%d, %d) = %d, want %d", test.seconds, test.rate, got, test.want)
+		}
+	}
+}